@@ -0,0 +1,182 @@
+package mgi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bertinatto/mgi/diff"
+)
+
+// Apply applies a unified diff, such as one written by Diff, to the working tree, and to the
+// index too if index is set, in which case the files it changes must be in the index. Hunks
+// that aren't where the patch says are looked for elsewhere in the file, ignoring up to fuzz
+// lines of context at each end if needed; see diff.FilePatch.Apply.
+//
+// Every file is patched in memory before anything is written, so if a hunk doesn't apply, or
+// a file is missing or already exists, the working tree is left as it was. Patches for paths
+// outside the working tree or inside .git are refused, like git does.
+func (m *MGIService) Apply(patch []byte, index bool, fuzz int) error {
+	if index {
+		if err := m.index.Lock(); err != nil {
+			return err
+		}
+		defer m.index.Unlock()
+	}
+
+	patches, err := diff.ParsePatch(patch)
+	if err != nil {
+		return fmt.Errorf("corrupt patch: %v", err)
+	}
+	if len(patches) == 0 {
+		return fmt.Errorf("no valid patches in input")
+	}
+
+	var tracked map[string]*IndexEntry
+	if index {
+		tracked, err = m.indexFiles()
+		if err != nil {
+			return err
+		}
+	}
+
+	type result struct {
+		patch *diff.FilePatch
+		data  []byte
+		perm  os.FileMode
+	}
+	var results []*result
+	var errs []string
+	for _, p := range patches {
+		if p.OldMode == modeGitlink || p.NewMode == modeGitlink || p.OldMode == 0120000 || p.NewMode == 0120000 {
+			errs = append(errs, fmt.Sprintf("%s: cannot apply changes to symlinks or submodules", p.Path()))
+			continue
+		}
+		if err := checkPatchPaths(p); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		var old []byte
+		perm := os.FileMode(0644)
+		if p.OldPath != "" {
+			if _, ok := tracked[p.OldPath]; index && !ok {
+				errs = append(errs, fmt.Sprintf("%s: does not exist in index", p.OldPath))
+				continue
+			}
+			fi, err := os.Stat(p.OldPath)
+			if err == nil {
+				old, err = ioutil.ReadFile(p.OldPath)
+			}
+			if errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Sprintf("%s: does not exist in working directory", p.OldPath))
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			perm = fi.Mode().Perm()
+		}
+		if p.NewPath != "" && p.NewPath != p.OldPath {
+			if _, err := os.Lstat(p.NewPath); err == nil {
+				errs = append(errs, fmt.Sprintf("%s: already exists in working directory", p.NewPath))
+				continue
+			}
+		}
+		switch p.NewMode {
+		case 0100755:
+			perm = 0755
+		case 0100644:
+			perm = 0644
+		}
+
+		data, err := p.Apply(old, fuzz)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if p.NewPath == "" && len(data) > 0 {
+			errs = append(errs, fmt.Sprintf("%s: removal patch leaves file contents", p.OldPath))
+			continue
+		}
+		results = append(results, &result{patch: p, data: data, perm: perm})
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+
+	var added, removed []string
+	for _, r := range results {
+		p := r.patch
+		if p.OldPath != "" && p.OldPath != p.NewPath {
+			m.logger.Debugf("apply: removing %s", p.OldPath)
+			err := removeFile(p.OldPath)
+			if err != nil {
+				return err
+			}
+			removed = append(removed, p.OldPath)
+		}
+		if p.NewPath == "" {
+			continue
+		}
+
+		m.logger.Debugf("apply: writing %s", p.NewPath)
+		err := os.MkdirAll(filepath.Dir(p.NewPath), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(p.NewPath, r.data, r.perm)
+		if err != nil {
+			return err
+		}
+		// WriteFile keeps the permissions of existing files
+		err = os.Chmod(p.NewPath, r.perm)
+		if err != nil {
+			return err
+		}
+		added = append(added, p.NewPath)
+	}
+	if !index {
+		return nil
+	}
+
+	// Patch paths are taken literally, not as pathspecs
+	return m.stageFiles(added, nil, removed)
+}
+
+// checkPatchPaths refuses the paths of a patch that git apply refuses too, since they would be
+// written outside the working tree or into the repository: absolute paths, paths with "." or
+// ".." components or a ".git" one, and paths below a symbolic link.
+func checkPatchPaths(p *diff.FilePatch) error {
+	for _, path := range []string{p.OldPath, p.NewPath} {
+		if path == "" {
+			continue
+		}
+		if filepath.IsAbs(path) || strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid path %q", path)
+		}
+		parts := strings.Split(path, "/")
+		for _, part := range parts {
+			if part == "" || part == "." || part == ".." || strings.EqualFold(part, ".git") {
+				return fmt.Errorf("invalid path %q", path)
+			}
+		}
+		for i := 1; i < len(parts); i++ {
+			dir := strings.Join(parts[:i], "/")
+			fi, err := os.Lstat(dir)
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("%s: affected file is beyond a symbolic link", path)
+			}
+		}
+	}
+	return nil
+}
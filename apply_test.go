@@ -0,0 +1,48 @@
+package mgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const newFilePatch = `diff --git a/%[1]s b/%[1]s
+new file mode 100644
+--- /dev/null
++++ b/%[1]s
+@@ -0,0 +1 @@
++hello
+`
+
+func TestApplyNewFile(t *testing.T) {
+	repo := newTestRepo(t)
+	err := repo.Apply([]byte(fmt.Sprintf(newFilePatch, "dir/hello.txt")), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("dir/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\n" {
+		t.Errorf("dir/hello.txt = %q, want %q", data, "hello\n")
+	}
+}
+
+func TestApplyRefusesPathsOutsideWorkTree(t *testing.T) {
+	repo := newTestRepo(t)
+	err := os.Symlink(t.TempDir(), "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"../escaped.txt", "a/../../escaped.txt", ".git/hooks/pre-commit", "sub/.GIT/config", "link/escaped.txt"} {
+		err := repo.Apply([]byte(fmt.Sprintf(newFilePatch, path)), false, 0)
+		if err == nil {
+			t.Errorf("a patch for %s was applied", path)
+		}
+		if _, err := os.Lstat(path); err == nil {
+			t.Errorf("%s was written", path)
+		}
+	}
+}
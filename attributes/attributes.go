@@ -0,0 +1,234 @@
+// Package attributes reads .gitattributes files and tells the attributes of the paths of a
+// working tree, following git's rules.
+//
+// Each line of an attributes file is a pattern followed by attributes: "attr" sets it, "-attr"
+// unsets it, "attr=value" gives it a value and "!attr" makes it unspecified again. Patterns
+// follow the .gitignore rules: a pattern without a slash matches a file name at any depth below
+// the directory of the attributes file, and any other pattern is relative to that directory, with
+// wildcards not matching slashes except in "**". The "binary" macro stands for "-diff -merge -text".
+//
+// The attributes files of the directories closer to a path take precedence over the ones of
+// their parents, and later lines over earlier ones in the same file. The info/attributes file of
+// the repository directory takes precedence over all of them.
+package attributes
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bertinatto/mgi/wildmatch"
+)
+
+// States of attributes that have no value, as returned by Attributes. Unspecified attributes are
+// not in the map at all.
+const (
+	Set   = "set"
+	Unset = "unset"
+)
+
+// macros are the built-in macro attributes, which stand for other attributes.
+var macros = map[string][]string{
+	"binary": {"-diff", "-merge", "-text"},
+}
+
+// Attributes maps the name of each specified attribute of a path to Set, Unset or its value.
+type Attributes map[string]string
+
+// IsSet returns whether the attribute is set, or has a value.
+func (a Attributes) IsSet(name string) bool {
+	v, ok := a[name]
+	return ok && v != Unset
+}
+
+// IsUnset returns whether the attribute is explicitly unset.
+func (a Attributes) IsUnset(name string) bool {
+	return a[name] == Unset
+}
+
+// assignment is a single attribute set by a line, with its state, or "" to make it unspecified.
+type assignment struct {
+	name  string
+	value string
+}
+
+// rule is a line of an attributes file.
+type rule struct {
+	pattern *regexp.Regexp
+	base    bool // match the name of the file instead of its path
+	attrs   []assignment
+}
+
+// file is a parsed attributes file, with the directory its patterns are relative to.
+type file struct {
+	dir   string // slash-separated, relative to the root of the working tree, "" for the root
+	rules []*rule
+}
+
+// Matcher looks up the attributes of paths in a working tree. Attributes files are read the
+// first time a path under their directory is looked up.
+type Matcher struct {
+	root  string
+	info  *file
+	files map[string]*file // by directory, nil if it has no attributes file
+}
+
+// NewMatcher creates a Matcher for the working tree at root. The info file, usually
+// "info/attributes" in the repository directory, may be empty or not exist.
+func NewMatcher(root, info string) (*Matcher, error) {
+	m := &Matcher{root: root, files: make(map[string]*file)}
+	if info != "" {
+		f, err := readFile(info, "")
+		if err != nil {
+			return nil, err
+		}
+		m.info = f
+	}
+	return m, nil
+}
+
+// Attributes returns the attributes of a path, given relative to the root of the working tree.
+func (m *Matcher) Attributes(name string) (Attributes, error) {
+	name = path.Clean(filepath.ToSlash(name))
+
+	// From the root down to the directory of the path, so that closer files win
+	dirs := []string{""}
+	for i, c := range name {
+		if c == '/' {
+			dirs = append(dirs, name[:i])
+		}
+	}
+	files := make([]*file, 0, len(dirs)+1)
+	for _, dir := range dirs {
+		f, err := m.dirFile(dir)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	if m.info != nil {
+		files = append(files, m.info)
+	}
+
+	attrs := make(Attributes)
+	for _, f := range files {
+		f.apply(name, attrs)
+	}
+	return attrs, nil
+}
+
+// dirFile returns the attributes file of a directory, reading it if needed.
+func (m *Matcher) dirFile(dir string) (*file, error) {
+	if f, ok := m.files[dir]; ok {
+		return f, nil
+	}
+	f, err := readFile(filepath.Join(m.root, filepath.FromSlash(dir), ".gitattributes"), dir)
+	if err != nil {
+		return nil, err
+	}
+	m.files[dir] = f
+	return f, nil
+}
+
+// apply sets the attributes of the lines that match the path, in order.
+func (f *file) apply(name string, attrs Attributes) {
+	rel := name
+	if f.dir != "" {
+		if !strings.HasPrefix(name, f.dir+"/") {
+			return
+		}
+		rel = name[len(f.dir)+1:]
+	}
+	for _, r := range f.rules {
+		subject := rel
+		if r.base {
+			subject = path.Base(rel)
+		}
+		if !r.pattern.MatchString(subject) {
+			continue
+		}
+		for _, a := range r.attrs {
+			if a.value == "" {
+				delete(attrs, a.name)
+			} else {
+				attrs[a.name] = a.value
+			}
+		}
+	}
+}
+
+// readFile parses the attributes file, whose patterns are relative to dir. It returns nil if the
+// file doesn't exist.
+func readFile(filename, dir string) (*file, error) {
+	data, err := ioutil.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	f.dir = dir
+	return f, nil
+}
+
+// parse parses the contents of an attributes file whose patterns are relative to the root.
+func parse(data []byte) (*file, error) {
+	f := new(file)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		pattern := fields[0]
+		if strings.HasPrefix(pattern, "!") {
+			return nil, fmt.Errorf("line %d: negative patterns are not allowed", n)
+		}
+
+		r := &rule{base: !strings.Contains(strings.TrimSuffix(pattern, "/"), "/")}
+		re, err := wildmatch.Compile(strings.TrimPrefix(pattern, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q: %v", n, pattern, err)
+		}
+		r.pattern = re
+		for _, field := range fields[1:] {
+			r.attrs = append(r.attrs, parseAssignment(field)...)
+		}
+		f.rules = append(f.rules, r)
+	}
+	return f, scanner.Err()
+}
+
+// parseAssignment parses a single attribute of a line, expanding macros.
+func parseAssignment(field string) []assignment {
+	switch {
+	case strings.HasPrefix(field, "-"):
+		return []assignment{{name: field[1:], value: Unset}}
+	case strings.HasPrefix(field, "!"):
+		return []assignment{{name: field[1:]}}
+	case strings.Contains(field, "="):
+		i := strings.IndexByte(field, '=')
+		return []assignment{{name: field[:i], value: field[i+1:]}}
+	}
+	if expansion, ok := macros[field]; ok {
+		list := []assignment{{name: field, value: Set}}
+		for _, f := range expansion {
+			list = append(list, parseAssignment(f)...)
+		}
+		return list
+	}
+	return []assignment{{name: field, value: Set}}
+}
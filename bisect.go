@@ -0,0 +1,345 @@
+package mgi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// bisectStart holds what HEAD pointed to when bisecting started, to go back to it on reset.
+	bisectStart = "BISECT_START"
+	// bisectLog records the commits marked while bisecting.
+	bisectLog = "BISECT_LOG"
+	// bisectRefs holds the commits marked while bisecting: "bad", and "good-<hash>" and
+	// "skip-<hash>" for each good and skipped commit.
+	bisectRefs = "refs/bisect/"
+)
+
+// BisectStep is the state of a bisection after a commit is marked.
+type BisectStep struct {
+	// Entry is the first bad commit if Found is set, or else the commit checked out to be tested.
+	Entry *LogEntry
+	Found bool
+	// Remaining is how many commits may still have to be tested after Commit, and Steps roughly
+	// how many more times a commit has to be marked.
+	Remaining int
+	Steps     int
+}
+
+// BisectStart starts a binary search for the commit that introduced a bug, like git bisect start.
+// The bad commit, which may be empty, and the good ones are marked as with BisectMark. Once there
+// is a bad commit and at least a good one, the commit halfway between them is checked out.
+// Otherwise, the returned step is nil.
+func (m *MGIService) BisectStart(bad string, good []string) (*BisectStep, error) {
+	if m.bisecting() {
+		return nil, fmt.Errorf("already bisecting, reset first")
+	}
+	head, err := m.headRef()
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		head, err = m.currentHead()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if head == "" {
+		return nil, fmt.Errorf("the current branch does not have any commits yet")
+	}
+	err = ioutil.WriteFile(m.gitPath(bisectStart), []byte(head+"\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if bad != "" {
+		err = m.markBisect(bad, "bad")
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, rev := range good {
+		err = m.markBisect(rev, "good")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m.bisectNext()
+}
+
+// BisectMark marks the commit named by rev, or HEAD if it is empty, as "good", "bad" or "skip",
+// and checks out the next commit to test, like git bisect good, bad and skip. The returned step
+// is nil if there isn't both a bad and a good commit yet.
+func (m *MGIService) BisectMark(rev, term string) (*BisectStep, error) {
+	if !m.bisecting() {
+		return nil, fmt.Errorf("not bisecting")
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	err := m.markBisect(rev, term)
+	if err != nil {
+		return nil, err
+	}
+	return m.bisectNext()
+}
+
+// BisectReset ends the bisection, going back to what HEAD pointed to when it started.
+func (m *MGIService) BisectReset() error {
+	data, err := ioutil.ReadFile(m.gitPath(bisectStart))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("not bisecting")
+	}
+	if err != nil {
+		return err
+	}
+	start := strings.TrimSpace(string(data))
+	commit, err := m.readRef(start)
+	if errors.Is(err, os.ErrNotExist) {
+		commit = start
+	} else if err != nil {
+		return err
+	}
+	err = m.switchHead(commit, start, "bisect reset")
+	if err != nil {
+		return err
+	}
+
+	refs, err := m.listRefs(bisectRefs)
+	if err != nil {
+		return err
+	}
+	for _, r := range refs {
+		err := os.Remove(m.gitPath(r.Name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	os.Remove(m.gitPath("refs/bisect"))
+	for _, name := range []string{bisectStart, bisectLog} {
+		err := os.Remove(m.gitPath(name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MGIService) bisecting() bool {
+	_, err := os.Stat(m.gitPath(bisectStart))
+	return err == nil
+}
+
+// markBisect records the commit named by rev with the given term, in its ref and in the log.
+func (m *MGIService) markBisect(rev, term string) error {
+	hash, err := m.resolveCommit(rev)
+	if err != nil {
+		return err
+	}
+	ref := bisectRefs + term
+	switch term {
+	case "bad":
+	case "good", "skip":
+		ref += "-" + hash
+	default:
+		return fmt.Errorf("unknown bisect term %q", term)
+	}
+	old, err := m.readRef(ref)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = m.updateRef(ref, old, hash)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(m.gitPath(bisectLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "git bisect %s %s\n", term, hash)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// bisectNext picks the commit that splits the commits that may have introduced the bug, those
+// reachable from the bad commit but not from the good ones, in two halves as even as possible,
+// and checks it out. If the bad commit is the only one left, it is the first bad commit.
+func (m *MGIService) bisectNext() (*BisectStep, error) {
+	refs, err := m.listRefs(bisectRefs)
+	if err != nil {
+		return nil, err
+	}
+	var bad string
+	var good []string
+	skipped := make(map[string]bool)
+	for _, r := range refs {
+		name := strings.TrimPrefix(r.Name, bisectRefs)
+		switch {
+		case name == "bad":
+			bad = r.Hash
+		case strings.HasPrefix(name, "good-"):
+			good = append(good, r.Hash)
+		case strings.HasPrefix(name, "skip-"):
+			skipped[r.Hash] = true
+		}
+	}
+	if bad == "" || len(good) == 0 {
+		return nil, nil
+	}
+
+	excluded, err := m.reachable(good...)
+	if err != nil {
+		return nil, err
+	}
+	if excluded[bad] {
+		return nil, fmt.Errorf("the bad commit %s is an ancestor of a good commit", bad)
+	}
+	entries, err := m.walkLog([]string{bad}, excluded, false)
+	if err != nil {
+		return nil, err
+	}
+	all := len(entries)
+	if all == 1 {
+		return &BisectStep{Entry: entries[0], Found: true}, nil
+	}
+
+	// Each commit is weighed by how many of the candidates it reaches, itself included
+	var best *LogEntry
+	bestReaches, bestScore := 0, -1
+	for _, e := range entries {
+		if e.Hash == bad || skipped[e.Hash] {
+			continue
+		}
+		reaches := 0
+		err := m.walkNodes([]string{e.Hash}, func(n *commitNode) error {
+			if excluded[n.Hash] {
+				return errSkipParents
+			}
+			reaches++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		score := reaches
+		if all-reaches < score {
+			score = all - reaches
+		}
+		// Like in git, the oldest of the commits that split them as well wins
+		if score >= bestScore {
+			best, bestReaches, bestScore = e, reaches, score
+		}
+	}
+	if best == nil {
+		var left []string
+		for _, e := range entries {
+			left = append(left, e.Hash)
+		}
+		return nil, fmt.Errorf("only skipped commits are left to test, the first bad commit could be any of %s", strings.Join(left, ", "))
+	}
+
+	err = m.switchHead(best.Hash, best.Hash, "checkout: moving to "+best.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &BisectStep{Entry: best, Remaining: all - bestReaches - 1, Steps: bisectSteps(all)}, nil
+}
+
+// bisectSteps estimates how many more commits have to be tested to find the first bad one
+// among all, like git does: about log2(all) - 1, rounded to the closest.
+func bisectSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+	n, e := 0, 1
+	for e*2 <= all {
+		n++
+		e *= 2
+	}
+	if e < 3*(all-e) {
+		return n
+	}
+	return n - 1
+}
+
+// switchHead updates the index and the working tree to the files of the commit, and then points
+// HEAD to target, either a branch (e.g. "refs/heads/master") or the commit itself, detaching
+// HEAD. It refuses to run if there are local changes, since they would be overwritten.
+func (m *MGIService) switchHead(commit, target, reflogMsg string) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	old, err := m.currentHead()
+	if err != nil {
+		return err
+	}
+	headFiles, err := m.headFiles()
+	if err != nil {
+		return err
+	}
+	indexFiles, err := m.indexFiles()
+	if err != nil {
+		return err
+	}
+	workFiles, err := m.workingFiles(indexFiles)
+	if err != nil {
+		return err
+	}
+	if !sameFiles(headFiles, indexFiles) || !sameFiles(indexFiles, workFiles) {
+		return fmt.Errorf("local changes would be overwritten, commit or stash them first")
+	}
+	files, err := m.commitFiles(commit)
+	if err != nil {
+		return err
+	}
+
+	for path := range indexFiles {
+		if _, ok := files[path]; !ok {
+			err := removeFile(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for path, e := range files {
+		if w, ok := workFiles[path]; ok && w.Hash.String() == e.Hash.String() {
+			continue
+		}
+		err := m.checkoutFile(path, e.Hash, e.Mode)
+		if err != nil {
+			return err
+		}
+	}
+	err = m.resetIndex(files)
+	if err != nil {
+		return err
+	}
+
+	head := target
+	if strings.HasPrefix(target, "refs/") {
+		head = "ref: " + target
+	}
+	lock, err := acquireLock(m.gitPath("HEAD"))
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	lock.fsync = m.fsync
+	_, err = lock.Write([]byte(head + "\n"))
+	if err != nil {
+		return err
+	}
+	err = lock.commit()
+	if err != nil {
+		return err
+	}
+	return m.appendReflog("HEAD", old, commit, reflogMsg)
+}
@@ -0,0 +1,200 @@
+package mgi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bertinatto/mgi/diff"
+)
+
+// BlameLine is a line of a file along with the commit that last changed it.
+type BlameLine struct {
+	Hash   string
+	Commit *Commit
+	// Boundary is set if the commit is the first of the history, so the line may be older.
+	Boundary bool
+	// Line is the number of the line in the blamed version of the file, and OrigLine in the
+	// version of the commit, both starting at 1.
+	Line     int
+	OrigLine int
+	Text     string
+}
+
+// blameLine is a line of the blamed version of a file whose commit isn't known yet, along with
+// its index in the version of the file being looked at.
+type blameLine struct {
+	index int // in the version of the commit being looked at, starting at 0
+	final int // in the blamed version, starting at 0
+}
+
+// Blame returns the commit that last changed each line of the file at path as of rev (HEAD if
+// empty). Only the lines from start to end, starting at 1, are blamed if start is not 0; an end
+// of 0, or past the end of the file, means up to the last line.
+//
+// The history is walked back from rev with each commit listed before its parents, keeping the
+// set of lines not blamed yet. The lines a commit has in common with one of its parents are
+// passed to it, the first parent first, and the others are blamed on the commit. Renames are
+// not followed.
+func (m *MGIService) Blame(rev, path string, start, end int) ([]*BlameLine, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+	head, err := m.resolveCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := m.blameContents(head, path)
+	if err != nil {
+		return nil, err
+	}
+	if contents == nil {
+		return nil, fmt.Errorf("no such path %s in %s", path, rev)
+	}
+	lines := diff.SplitLines(contents)
+
+	if start == 0 {
+		start, end = 1, len(lines)
+	}
+	switch {
+	case start < 1 || end < 0:
+		return nil, fmt.Errorf("invalid line range %d,%d", start, end)
+	case end != 0 && end < start:
+		return nil, fmt.Errorf("invalid line range %d,%d: the end comes before the start", start, end)
+	case start > len(lines):
+		return nil, fmt.Errorf("file %s has only %d lines", path, len(lines))
+	}
+	if end == 0 || end > len(lines) {
+		end = len(lines)
+	}
+
+	pending := make(map[string][]blameLine)
+	for i := start - 1; i < end; i++ {
+		pending[head] = append(pending[head], blameLine{index: i, final: i})
+	}
+	blamed := make([]*BlameLine, end-start+1)
+
+	graph := m.CommitGraph()
+	order, err := graph.TopoOrder(head)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range order {
+		todo := pending[hash]
+		if len(todo) == 0 {
+			continue
+		}
+		delete(pending, hash)
+
+		c, err := graph.Commit(hash)
+		if err != nil {
+			return nil, err
+		}
+		data, err := m.blameContents(hash, path)
+		if err != nil {
+			return nil, err
+		}
+		current := diff.SplitLines(data)
+
+		boundary := true
+		for _, p := range c.Parents {
+			if len(todo) == 0 {
+				break
+			}
+			parentData, err := m.blameContents(p, path)
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			boundary = false
+			if parentData == nil {
+				continue
+			}
+
+			// Lines that are in the parent too come from it
+			same := make(map[int]int)
+			oldIndex, newIndex := 0, 0
+			for _, e := range diff.Lines(diff.SplitLines(parentData), current) {
+				switch e.Op {
+				case diff.Equal:
+					same[newIndex] = oldIndex
+					oldIndex++
+					newIndex++
+				case diff.Delete:
+					oldIndex++
+				case diff.Insert:
+					newIndex++
+				}
+			}
+			var kept []blameLine
+			for _, l := range todo {
+				if i, ok := same[l.index]; ok {
+					pending[p] = append(pending[p], blameLine{index: i, final: l.final})
+				} else {
+					kept = append(kept, l)
+				}
+			}
+			todo = kept
+		}
+
+		for _, l := range todo {
+			blamed[l.final-start+1] = &BlameLine{
+				Hash:     hash,
+				Commit:   c,
+				Boundary: boundary,
+				Line:     l.final + 1,
+				OrigLine: l.index + 1,
+				Text:     strings.TrimSuffix(lines[l.final], "\n"),
+			}
+		}
+	}
+	return blamed, nil
+}
+
+// blameContents returns the contents of the file at path in a commit, or nil if it has no such
+// file.
+func (m *MGIService) blameContents(commit, path string) ([]byte, error) {
+	c, err := m.readCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+	e, err := m.lookupPath(c.Tree, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.mode == modeDir || e.mode == modeGitlink {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+	return m.obj.ReadObject(e.hash)
+}
+
+// FormatBlame renders the blamed lines like git blame does: the abbreviated commit (marked with
+// "^" at the boundary), the author, the date and the line number, followed by the line.
+func (m *MGIService) FormatBlame(lines []*BlameLine) []string {
+	authorWidth, numberWidth := 0, 0
+	for _, l := range lines {
+		if len(l.Commit.Author) > authorWidth {
+			authorWidth = len(l.Commit.Author)
+		}
+		if n := len(fmt.Sprint(l.Line)); n > numberWidth {
+			numberWidth = n
+		}
+	}
+
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		hash := l.Hash[:8]
+		if l.Boundary {
+			hash = "^" + l.Hash[:7]
+		}
+		out = append(out, fmt.Sprintf("%s (%-*s %s %*d) %s", hash, authorWidth, l.Commit.Author,
+			l.Commit.AuthorTime.Format("2006-01-02 15:04:05 -0700"), numberWidth, l.Line, l.Text))
+	}
+	return out
+}
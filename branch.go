@@ -0,0 +1,122 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Branch is a local branch, as listed by ListBranches.
+type Branch struct {
+	Name    string
+	Hash    string
+	Current bool
+}
+
+// ListBranches returns the local branches that pass the filter, sorted by name.
+func (m *MGIService) ListBranches(filter *RefFilter) ([]*Branch, error) {
+	refs, err := m.listRefs("refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+	refs, err = m.filterRefs(refs, filter)
+	if err != nil {
+		return nil, err
+	}
+	current, err := m.headRef()
+	if err != nil {
+		return nil, err
+	}
+
+	branches := make([]*Branch, 0, len(refs))
+	for _, r := range refs {
+		branches = append(branches, &Branch{
+			Name:    strings.TrimPrefix(r.Name, "refs/heads/"),
+			Hash:    r.Hash,
+			Current: r.Name == current,
+		})
+	}
+	return branches, nil
+}
+
+// CreateBranch creates a branch pointing to the start commit (HEAD if empty). Existing branches
+// are only moved if force is set, and never if they are checked out.
+func (m *MGIService) CreateBranch(name, start string, force bool) error {
+	ref := "refs/heads/" + name
+	if !validRefName(ref) {
+		return fmt.Errorf("%q is not a valid branch name", name)
+	}
+	if _, err := m.readRef(ref); err == nil {
+		current, err := m.headRef()
+		if err != nil {
+			return err
+		}
+		if !force {
+			return fmt.Errorf("a branch named %q already exists", name)
+		}
+		if ref == current {
+			return fmt.Errorf("cannot force update the current branch")
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if start == "" {
+		start = "HEAD"
+	}
+	commit, err := m.resolveCommit(start)
+	if err != nil {
+		return err
+	}
+	old, err := m.readRef(ref)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = m.updateRef(ref, old, commit)
+	if err != nil {
+		return err
+	}
+	return m.appendReflog(ref, old, commit, "branch: Created from "+start)
+}
+
+// DeleteBranch removes a branch. Unless force is set, it must be merged into HEAD.
+func (m *MGIService) DeleteBranch(name string, force bool) error {
+	ref := "refs/heads/" + name
+	hash, err := m.readRef(ref)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("branch %q not found", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := m.headRef()
+	if err != nil {
+		return err
+	}
+	if ref == current {
+		return fmt.Errorf("cannot delete branch %q checked out", name)
+	}
+	if !force {
+		head, err := m.currentHead()
+		if err != nil {
+			return err
+		}
+		merged := false
+		if head != "" {
+			merged, err = m.isAncestor(hash, head)
+			if err != nil {
+				return err
+			}
+		}
+		if !merged {
+			return fmt.Errorf("the branch %q is not fully merged", name)
+		}
+	}
+
+	err = os.Remove(m.gitPath(ref))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("branch %q is in packed-refs, which can't be edited yet", name)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package mgi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func branchNames(t *testing.T, repo *Repo, filter *RefFilter) []string {
+	t.Helper()
+	branches, err := repo.ListBranches(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range branches {
+		names = append(names, b.Name)
+	}
+	return names
+}
+
+func TestListBranchesFilters(t *testing.T) {
+	repo := newTestRepo(t)
+	first := commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	second := commitTestFiles(t, repo, "second", map[string]string{"a": "2\n"})
+	c, err := repo.readCommit(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	side, err := repo.storeCommit(c.Tree, []string{first}, "side", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, hash := range map[string]string{"old": first, "side": side} {
+		err := repo.CreateBranch(name, hash, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		filter *RefFilter
+		want   []string
+	}{
+		{nil, []string{"master", "old", "side"}},
+		{&RefFilter{Merged: "master"}, []string{"master", "old"}},
+		{&RefFilter{NoMerged: "master"}, []string{"side"}},
+		{&RefFilter{Contains: first}, []string{"master", "old", "side"}},
+		{&RefFilter{Contains: second}, []string{"master"}},
+	}
+	for _, tt := range tests {
+		if got := branchNames(t, repo, tt.filter); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("branches with %+v = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		ancestor, descendant string
+		want                 bool
+	}{
+		{"old", "master", true},
+		{"master", "master", true},
+		{"master", "old", false},
+		{"side", "master", false},
+	} {
+		got, err := repo.IsAncestor(tt.ancestor, tt.descendant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("IsAncestor(%s, %s) = %v, want %v", tt.ancestor, tt.descendant, got, tt.want)
+		}
+	}
+}
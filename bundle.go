@@ -0,0 +1,315 @@
+package mgi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// bundleSignature is the first line of a version 2 bundle.
+const bundleSignature = "# v2 git bundle"
+
+// BundleCreate writes a bundle with the given refs (e.g. "master", "v1.0" or "HEAD") and every
+// object reachable from them to w. Bundles have no prerequisites, so they contain the whole
+// history of the refs.
+func (m *MGIService) BundleCreate(w io.Writer, refs []string) error {
+	if len(refs) == 0 {
+		return fmt.Errorf("refusing to create an empty bundle")
+	}
+
+	var tips []*Ref
+	for _, name := range refs {
+		ref, err := m.expandRef(name)
+		if err != nil {
+			return err
+		}
+		tips = append(tips, ref)
+	}
+
+	starts := make([]string, 0, len(tips))
+	for _, t := range tips {
+		starts = append(starts, t.Hash)
+	}
+	objects, err := m.reachableObjects(starts)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s\n", bundleSignature)
+	for _, t := range tips {
+		fmt.Fprintf(bw, "%s %s\n", t.Hash, t.Name)
+	}
+	fmt.Fprintf(bw, "\n")
+	err = writePack(m.obj, bw, objects)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// BundleUnbundle stores the objects of the bundle read from r and points the refs it lists to
+// them. HEAD and the branch that is checked out are left untouched, unless it has no commits
+// yet, since that would get the working tree out of sync. Existing branches are only updated if
+// it's a fast-forward, and existing tags only if they're unchanged; if a ref can't be updated,
+// none is. It returns the refs listed in the bundle.
+func (m *MGIService) BundleUnbundle(r io.Reader) ([]*Ref, error) {
+	br := bufio.NewReader(r)
+	signature, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle header: %v", err)
+	}
+	if strings.TrimSuffix(signature, "\n") != bundleSignature {
+		return nil, fmt.Errorf("not a v2 bundle")
+	}
+
+	var refs []*Ref
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading bundle header: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, "-") {
+			// Prerequisite commits must already be in the repository
+			fields := strings.Fields(line[1:])
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("malformed bundle prerequisite %q", line)
+			}
+			hash, err := new(Hash).FromString(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("malformed bundle prerequisite %q", line)
+			}
+			exists, err := m.obj.Exists(hash)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				return nil, fmt.Errorf("repository lacks the prerequisite commit %s", hash)
+			}
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed bundle ref %q", line)
+		}
+		if _, err := new(Hash).FromString(fields[0]); err != nil {
+			return nil, fmt.Errorf("malformed bundle ref %q", line)
+		}
+		// The names are used as paths in the repository, so they must be checked like git does
+		if fields[1] != "HEAD" && (!strings.HasPrefix(fields[1], "refs/") || !validRefName(fields[1])) {
+			return nil, fmt.Errorf("bundle ref %q is not a valid ref name", fields[1])
+		}
+		refs = append(refs, &Ref{Name: fields[1], Hash: fields[0]})
+	}
+
+	// Bundles with prerequisites may have deltas against objects of the repository
+	_, err = readPackStream(br, m.obj.ReadTypedObject, func(e *packEntry) error {
+		_, err := m.obj.StoreObject(&rawObject{objType: e.objType, data: e.data})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The current branch can be set if it has no commits yet
+	current, err := m.headRef()
+	if err != nil {
+		return nil, err
+	}
+	head, err := m.currentHead()
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		current = ""
+	}
+	// Every update is checked before any ref is written
+	var updates []*Ref
+	var olds []string
+	for _, ref := range refs {
+		if ref.Name == "HEAD" || ref.Name == current {
+			continue
+		}
+		old, err := m.readRef(ref.Name)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if old == ref.Hash {
+			continue
+		}
+		if old != "" && strings.HasPrefix(ref.Name, "refs/tags/") {
+			return nil, fmt.Errorf("refusing to update %s from %s to %s: it would clobber an existing tag", ref.Name, old, ref.Hash)
+		}
+		if old != "" {
+			ok, err := m.fastForward(old, ref.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("refusing to update %s from %s to %s: not a fast-forward", ref.Name, old, ref.Hash)
+			}
+		}
+		updates = append(updates, ref)
+		olds = append(olds, old)
+	}
+	for i, ref := range updates {
+		err := m.updateRef(ref.Name, olds[i], ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// fastForward returns whether moving a ref from one object to another only adds commits, that
+// is, whether both are commits and the first is an ancestor of the second.
+func (m *MGIService) fastForward(from, to string) (bool, error) {
+	for _, hash := range []string{from, to} {
+		h, err := new(Hash).FromString(hash)
+		if err != nil {
+			return false, err
+		}
+		objType, _, err := m.obj.ReadTypedObject(h)
+		if err != nil {
+			return false, err
+		}
+		if objType != "commit" {
+			return false, nil
+		}
+	}
+	return m.isAncestor(from, to)
+}
+
+// expandRef finds the ref a short name refers to, e.g. "refs/heads/master" for "master",
+// trying the same places git does.
+func (m *MGIService) expandRef(name string) (*Ref, error) {
+	for _, full := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name} {
+		hash, err := m.readRef(full)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Ref{Name: full, Hash: hash}, nil
+	}
+	return nil, fmt.Errorf("unknown ref %q", name)
+}
+
+// reachableObjects returns every object reachable from the given objects: the annotated tags
+// among them, the commits with their trees and blobs, and any tree or blob given directly or
+// through a tag. Submodule commits are not included.
+func (m *MGIService) reachableObjects(starts []string) ([]*Hash, error) {
+	return m.reachableObjectsUntil(starts, nil)
+}
+
+// reachableObjectsUntil is like reachableObjects, but the parents of the commits in shallow are
+// not walked, as for a client that has a shallow history.
+func (m *MGIService) reachableObjectsUntil(starts []string, shallow map[string]bool) ([]*Hash, error) {
+	seen := make(map[string]bool)
+	var objects []*Hash
+	add := func(hash string) (bool, error) {
+		if seen[hash] {
+			return false, nil
+		}
+		h, err := new(Hash).FromString(hash)
+		if err != nil {
+			return false, err
+		}
+		seen[hash] = true
+		objects = append(objects, h)
+		return true, nil
+	}
+
+	var addTree func(hash string) error
+	addTree = func(hash string) error {
+		isNew, err := add(hash)
+		if err != nil || !isNew {
+			return err
+		}
+		tree, err := m.readTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range tree.Entries {
+			switch e.mode {
+			case modeDir:
+				err = addTree(e.hash.String())
+			case modeGitlink:
+				// Gitlinks point to commits of another repository
+			default:
+				_, err = add(e.hash.String())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Annotated tags are included along with what they point to, which is usually a commit
+	commits := make([]string, 0, len(starts))
+	for _, s := range starts {
+		hash := s
+		for {
+			h, err := new(Hash).FromString(hash)
+			if err != nil {
+				return nil, err
+			}
+			objType, data, err := m.obj.ReadTypedObject(h)
+			if err != nil {
+				return nil, err
+			}
+			switch objType {
+			case "commit":
+				commits = append(commits, hash)
+			case "tree":
+				err = addTree(hash)
+			case "blob":
+				_, err = add(hash)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if objType != "tag" {
+				break
+			}
+			_, err = add(hash)
+			if err != nil {
+				return nil, err
+			}
+			tag, err := ParseTag(data)
+			if err != nil {
+				return nil, err
+			}
+			hash = tag.Object
+		}
+	}
+
+	err := m.walkCommits(commits, func(hash string, c *Commit) error {
+		_, err := add(hash)
+		if err != nil {
+			return err
+		}
+		err = addTree(c.Tree)
+		if err != nil {
+			return err
+		}
+		if shallow[hash] {
+			return errSkipParents
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
@@ -0,0 +1,90 @@
+package mgi
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBundleUnbundleFastForwardOnly(t *testing.T) {
+	repo := newTestRepo(t)
+	first := commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	second := commitTestFiles(t, repo, "second", map[string]string{"a": "2\n"})
+
+	bundle := func(hash string) []byte {
+		t.Helper()
+		old, err := repo.readRef("refs/heads/topic")
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		err = repo.updateRef("refs/heads/topic", old, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = repo.BundleCreate(&buf, []string{"topic"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	behind := bundle(first)
+	ahead := bundle(second)
+
+	err := repo.updateRef("refs/heads/topic", second, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.BundleUnbundle(bytes.NewReader(ahead))
+	if err != nil {
+		t.Fatalf("fast-forward: %v", err)
+	}
+	if got, _ := repo.readRef("refs/heads/topic"); got != second {
+		t.Errorf("refs/heads/topic = %s after a fast-forward, want %s", got, second)
+	}
+
+	_, err = repo.BundleUnbundle(bytes.NewReader(behind))
+	if err == nil {
+		t.Errorf("a bundle rewound refs/heads/topic")
+	}
+	if got, _ := repo.readRef("refs/heads/topic"); got != second {
+		t.Errorf("refs/heads/topic = %s after a refused update, want %s", got, second)
+	}
+}
+
+func TestBundleUnbundleInvalidRefName(t *testing.T) {
+	repo := newTestRepo(t)
+	commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	var buf bytes.Buffer
+	err := repo.BundleCreate(&buf, []string{"master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	evil := bytes.Replace(buf.Bytes(), []byte("refs/heads/master"), []byte("refs/../../victim"), 1)
+
+	_, err = repo.BundleUnbundle(bytes.NewReader(evil))
+	if err == nil {
+		t.Errorf("a bundle with the ref refs/../../victim was accepted")
+	}
+	for _, path := range []string{"victim", "../victim"} {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("%s was written outside the refs", path)
+		}
+	}
+}
+
+func TestBundleUnbundleMalformedHeader(t *testing.T) {
+	repo := newTestRepo(t)
+	for _, header := range []string{
+		"# v2 git bundle\n-\n\n",
+		"# v2 git bundle\n- \n\n",
+		"# v2 git bundle\n-nothex\n\n",
+		"# v2 git bundle\nrefs/heads/master\n\n",
+	} {
+		_, err := repo.BundleUnbundle(strings.NewReader(header))
+		if err == nil || !strings.Contains(err.Error(), "malformed") {
+			t.Errorf("unbundling a bundle with the header %q: got error %v, want a malformed header error", header, err)
+		}
+	}
+}
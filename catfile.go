@@ -0,0 +1,97 @@
+package mgi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CatFile returns an object given by a revision (see resolveRevision) along with its type and
+// contents. Objects of unknown types are an error unless allowUnknownType is set.
+func (m *MGIService) CatFile(name string, allowUnknownType bool) (*Hash, string, []byte, error) {
+	rev, err := m.resolveRevision(name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	hash, err := new(Hash).FromString(rev)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	objType, data, err := m.obj.ReadTypedObject(hash)
+	if allowUnknownType && errors.Is(err, ErrUnknownObjectType) {
+		err = nil
+	}
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return hash, objType, data, nil
+}
+
+// CatFileFiltered returns the contents of a blob as they would be checked out to path, with
+// the content filters applied, unlike CatFile which returns them as stored.
+func (m *MGIService) CatFileFiltered(name, path string) ([]byte, error) {
+	hash, _, _, err := m.CatFile(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return m.smudgeBlob(path, hash)
+}
+
+// CatFileBatch reads object names from r, one per line, and writes each object to w in the
+// format "<hash> <type> <size>\n<contents>\n". Names that can't be resolved are reported with a
+// "<name> missing" line instead of stopping the batch.
+func (m *MGIService) CatFileBatch(r io.Reader, w io.Writer) error {
+	out := bufio.NewWriter(w)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		hash, objType, data, err := m.CatFile(name, false)
+		if errors.Is(err, ErrObjectNotFound) || (err != nil && hash == nil) {
+			fmt.Fprintf(out, "%s missing\n", name)
+		} else if err != nil {
+			return err
+		} else {
+			fmt.Fprintf(out, "%s %s %d\n", hash, objType, len(data))
+			out.Write(data)
+			out.WriteString("\n")
+		}
+
+		// Flush every object, since callers usually wait for the answer before asking for more
+		err = out.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// PrettyPrint formats the contents of an object for humans. Trees are listed one entry per
+// line, and the other types are returned as they are.
+func (m *MGIService) PrettyPrint(objType string, data []byte) ([]byte, error) {
+	if objType != "tree" {
+		return data, nil
+	}
+	tree, err := ParseTree(data)
+	if err != nil {
+		return nil, err
+	}
+	b := new(bytes.Buffer)
+	for _, e := range tree.Entries {
+		fmt.Fprintf(b, "%06o %s %s\t%s\n", e.mode, entryType(e.mode), e.hash, e.path)
+	}
+	return b.Bytes(), nil
+}
+
+// entryType returns the type of the object a tree entry with the given mode points to.
+func entryType(mode uint32) string {
+	switch mode {
+	case modeDir:
+		return "tree"
+	case modeGitlink:
+		return "commit"
+	}
+	return "blob"
+}
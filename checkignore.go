@@ -0,0 +1,74 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bertinatto/mgi/ignore"
+)
+
+// CheckIgnore returns, for each path, the pattern that decides whether it is ignored, or nil if
+// there is none, like git check-ignore. A path is ignored if its pattern isn't negated. Paths are
+// relative to the current directory, and may be inside ignored directories or not exist. Tracked
+// files are never ignored, so they have no pattern. The sources of the patterns are given
+// relative to the root of the working tree, or as absolute paths if they are outside of it.
+func (m *MGIService) CheckIgnore(paths []string) ([]*ignore.Match, error) {
+	repoRoot, err := findRoot(m.root)
+	if err != nil {
+		return nil, err
+	}
+	repoRoot, err = filepath.Abs(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := m.ignoreMatcher(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	tracked, err := m.indexFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]*ignore.Match, len(paths))
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(repoRoot, abs)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("%s is outside the repository", path)
+		}
+		if _, ok := tracked[rel]; ok {
+			continue
+		}
+
+		isDir := strings.HasSuffix(path, "/")
+		if fi, err := os.Stat(abs); err == nil && fi.IsDir() {
+			isDir = true
+		}
+		match, err := matcher.Match(rel, isDir)
+		if err != nil {
+			return nil, err
+		}
+		if match != nil {
+			source, err := filepath.Abs(match.Source)
+			if err != nil {
+				return nil, err
+			}
+			match.Source = source
+			if rel, err := filepath.Rel(repoRoot, source); err == nil && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				match.Source = filepath.ToSlash(rel)
+			}
+		}
+		matches[i] = match
+	}
+	return matches, nil
+}
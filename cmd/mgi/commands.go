@@ -0,0 +1,1861 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bertinatto/mgi"
+	"github.com/bertinatto/mgi/diff"
+)
+
+func init() {
+	register(newCommand("init", initCommand))
+	register(newCommand("add", addCommand))
+	register(newCommand("commit", commitCommand))
+	register(newCommand("status", statusCommand))
+	register(newCommand("diff", diffCommand))
+	register(newCommand("config", configCommand))
+	register(newCommand("describe", describeCommand))
+	register(newCommand("prune-packed", prunePackedCommand))
+	register(newCommand("prune", pruneCommand))
+	register(newCommand("gc", gcCommand))
+	register(newCommand("stash", stashCommand))
+	register(newCommand("worktree", worktreeCommand))
+	register(newCommand("restore", restoreCommand))
+	register(newCommand("checkout", checkoutCommand))
+	register(newCommand("reset", resetCommand))
+	register(newCommand("update-index", updateIndexCommand))
+	register(newCommand("cat-file", catFileCommand))
+	register(newCommand("rm", rmCommand))
+	register(newCommand("mv", mvCommand))
+	register(newCommand("bundle", bundleCommand))
+	register(newCommand("tag", tagCommand))
+	register(newCommand("branch", branchCommand))
+	register(newCommand("merge-base", mergeBaseCommand))
+	register(newCommand("reflog", reflogCommand))
+	register(newCommand("rev-parse", revParseCommand))
+	register(newCommand("verify-index", verifyIndexCommand))
+	register(newCommand("fsck", fsckCommand))
+	register(newCommand("log", logCommand))
+	register(newCommand("blame", blameCommand))
+	register(newCommand("serve", serveCommand))
+	register(newCommand("clone", cloneCommand))
+	register(newCommand("fetch", fetchCommand))
+	register(newCommand("notes", notesCommand))
+	register(newCommand("ls-tree", lsTreeCommand))
+	register(newCommand("show", showCommand))
+	register(newCommand("diff-tree", diffTreeCommand))
+	register(newCommand("apply", applyCommand))
+	register(newCommand("format-patch", formatPatchCommand))
+	register(newCommand("am", amCommand))
+	register(newCommand("rev-list", revListCommand))
+	register(newCommand("verify-commit", verifyCommitCommand))
+	register(newCommand("index-pack", indexPackCommand))
+	register(newCommand("unpack-objects", unpackObjectsCommand))
+	register(newCommand("repack", repackCommand))
+	register(newCommand("bisect", bisectCommand))
+	register(newCommand("shortlog", shortlogCommand))
+	register(newCommand("for-each-ref", forEachRefCommand))
+	register(newCommand("commit-graph", commitGraphCommand))
+	register(newCommand("check-ignore", checkIgnoreCommand))
+	register(newCommand("ls-files", lsFilesCommand))
+}
+
+func initCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		err := doInit(svc.root)
+		if err != nil {
+			return failf("Failed to initialize directories: %v\n", err)
+		}
+		return nil
+	}
+}
+
+func addCommand(flags *flag.FlagSet) runFunc {
+	intentToAdd := flags.Bool("N", false, "record only that the files will be added later")
+	quiet := flags.Bool("quiet", false, "do not warn about large files")
+	flags.BoolVar(quiet, "q", false, "shorthand for --quiet")
+	return func(args []string, svc *services) error {
+		svc.mgi.SetQuiet(*quiet)
+		add := svc.mgi.Add
+		if *intentToAdd {
+			add = svc.mgi.AddIntentToAdd
+		}
+		err := add(args)
+		if err != nil {
+			return failf("Error adding files: %v", err)
+		}
+		return nil
+	}
+}
+
+func commitCommand(flags *flag.FlagSet) runFunc {
+	message := flags.String("m", "", "use the given message instead of launching an editor")
+	var signoff bool
+	flags.BoolVar(&signoff, "signoff", false, "add a Signed-off-by trailer with your identity")
+	flags.BoolVar(&signoff, "s", false, "shorthand for --signoff")
+	sign := flags.Bool("S", false, "sign the commit with the key in user.signingkey")
+	fixup := flags.String("fixup", "", "make a commit that fixes up `commit` when rebasing with autosquash")
+	squash := flags.String("squash", "", "make a commit that is squashed into `commit` when rebasing with autosquash")
+	return func(args []string, svc *services) error {
+		if *message == "" && len(args) > 0 {
+			*message = args[0]
+		}
+		if *fixup != "" && *squash != "" {
+			return failf("--fixup and --squash are mutually exclusive")
+		}
+
+		var err error
+		var initial string
+		if *fixup != "" || *squash != "" {
+			rev := *fixup
+			if rev == "" {
+				rev = *squash
+			}
+			initial, err = svc.mgi.FixupMessage(rev, *squash != "")
+			if err != nil {
+				return failf("Error committing files: %v", err)
+			}
+			switch {
+			case *message != "":
+				*message = initial + "\n\n" + *message
+			case *fixup != "":
+				// Fixups keep the message of the commit they fix, so there's nothing to edit
+				*message = initial
+			}
+		}
+		if *message == "" {
+			*message, err = svc.mgi.EditCommitMessage(initial)
+			if err != nil {
+				return failf("Error committing files: %v", err)
+			}
+		}
+		if signoff {
+			*message = mgi.AppendTrailer(*message, mgi.SignOff())
+		}
+		err = svc.mgi.Commit(*message, *sign)
+		if err != nil {
+			return failf("Error committing files: %v", err)
+		}
+		return nil
+	}
+}
+
+// statusCommand prints the untracked and modified files. By convention, it exits with status 0
+// regardless of what it finds unless --exit-code is set, in which case it exits with status 1
+// when tracked files have changes that aren't staged. Untracked files don't count.
+func statusCommand(flags *flag.FlagSet) runFunc {
+	exitCode := flags.Bool("exit-code", false, "exit with status 1 if tracked files have unstaged changes")
+	return func(args []string, svc *services) error {
+		untracked, modified, intentToAdd, err := svc.mgi.Status(args...)
+		if err != nil {
+			return failf("Error checking status: %v", err)
+		}
+
+		tracking, err := svc.mgi.TrackingStatus()
+		if err != nil {
+			return failf("Error comparing with the upstream branch: %v", err)
+		}
+		if tracking != nil {
+			fmt.Printf("%s\n", tracking.Message())
+		}
+
+		if len(untracked) > 0 {
+			fmt.Printf("Untracked files:\n")
+			for i := range untracked {
+				fmt.Printf("\t%s\n", untracked[i])
+			}
+		}
+
+		if len(modified) > 0 {
+			fmt.Printf("Modified files:\n")
+			for i := range modified {
+				fmt.Printf("\t%s\n", modified[i])
+			}
+		}
+
+		if len(intentToAdd) > 0 {
+			fmt.Printf("New files (not staged):\n")
+			for i := range intentToAdd {
+				fmt.Printf("\t%s\n", intentToAdd[i])
+			}
+		}
+
+		if *exitCode && len(modified)+len(intentToAdd) > 0 {
+			return exit(1)
+		}
+		return nil
+	}
+}
+
+// diffCommand prints the unstaged changes, or all the changes since a commit if one is given.
+// Like git, with --exit-code (or --quiet) it exits with status 1 if there are differences and 0
+// otherwise. With --no-index it compares two paths instead, which implies --exit-code.
+func diffCommand(flags *flag.FlagSet) runFunc {
+	exitCode := flags.Bool("exit-code", false, "exit with status 1 if there are differences")
+	quiet := flags.Bool("quiet", false, "print nothing, implies --exit-code")
+	noIndex := flags.Bool("no-index", false, "compare two paths outside the repository (\"-\" is stdin)")
+	renames := new(renameFlag)
+	flags.Var(renames, "M", "detect renames, optionally with the minimum similarity (e.g. -M=60%)")
+	wordDiff := flags.Bool("word-diff", false, "show the changed words instead of the changed lines")
+	wordRegex := flags.String("word-diff-regex", "", "what a word is for --word-diff, which it implies")
+	ignoreAllSpace := flags.Bool("w", false, "ignore whitespace when comparing lines")
+	ignoreSpaceChange := flags.Bool("ignore-space-change", false, "ignore changes in the amount of whitespace")
+	cached := flags.Bool("cached", false, "show the changes staged in the index")
+	nameOnly := flags.Bool("name-only", false, "show only the names of changed files")
+	nameStatus := flags.Bool("name-status", false, "show only the names and status of changed files")
+	binary := flags.Bool("binary", false, "show binary changes as patches that apply can apply")
+	return func(args []string, svc *services) error {
+		opts := mgi.DiffOptions{RenameThreshold: int(*renames)}
+		switch {
+		case *nameOnly && *nameStatus:
+			return failf("--name-only and --name-status are mutually exclusive")
+		case *nameOnly:
+			opts.Format = mgi.DiffNameOnly
+		case *nameStatus:
+			opts.Format = mgi.DiffNameStatus
+		}
+		opts.Render.IgnoreAllSpace = *ignoreAllSpace
+		opts.Render.IgnoreSpaceChange = *ignoreSpaceChange
+		opts.Render.Binary = *binary
+		if *wordRegex != "" {
+			re, err := regexp.Compile(*wordRegex)
+			if err != nil {
+				return failf("invalid --word-diff-regex: %v", err)
+			}
+			opts.Render.WordRegexp = re
+		} else if *wordDiff {
+			opts.Render.WordRegexp = diff.DefaultWordRegexp
+		}
+
+		var diffs []string
+		var err error
+		if *noIndex {
+			if len(args) != 2 {
+				return failf("usage: diff --no-index <path> <path>")
+			}
+			if opts.Format != mgi.DiffPatch {
+				return failf("--name-only and --name-status cannot be used with --no-index")
+			}
+			*exitCode = true
+			diffs, err = mgi.DiffNoIndex(args[0], args[1], os.Stdin, opts.Render)
+		} else {
+			switch {
+			case len(args) > 1:
+				return failf("usage: diff [--cached] [<commit>]")
+			case *cached:
+				var rev string
+				if len(args) == 1 {
+					rev = args[0]
+				}
+				diffs, err = svc.mgi.DiffCached(rev, opts)
+			case len(args) == 1:
+				diffs, err = svc.mgi.DiffCommit(args[0], opts)
+			default:
+				diffs, err = svc.mgi.Diff(opts)
+			}
+		}
+		if err != nil {
+			return failf("Error checking diff: %v", err)
+		}
+
+		if !*quiet {
+			for i := range diffs {
+				fmt.Printf("%s\n", diffs[i])
+			}
+		}
+
+		if (*exitCode || *quiet) && len(diffs) > 0 {
+			return exit(1)
+		}
+		return nil
+	}
+}
+
+// renameFlag is the similarity threshold of -M, or 0 if renames aren't detected. Like git's
+// -M[<n>], the value is optional.
+type renameFlag int
+
+func (f *renameFlag) String() string {
+	return strconv.Itoa(int(*f))
+}
+
+func (f *renameFlag) Set(value string) error {
+	if value == "true" {
+		*f = mgi.DefaultRenameThreshold
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil || n < 0 || n > 100 {
+		return fmt.Errorf("invalid similarity %q", value)
+	}
+	*f = renameFlag(n)
+	return nil
+}
+
+func (f *renameFlag) IsBoolFlag() bool {
+	return true
+}
+
+func configCommand(flags *flag.FlagSet) runFunc {
+	unset := flags.Bool("unset", false, "remove the given key")
+	list := flags.Bool("list", false, "list all variables")
+	return func(args []string, svc *services) error {
+		configService := mgi.NewConfigService(svc.root)
+		config, err := configService.Read()
+		if err != nil {
+			return failf("Error reading config: %v", err)
+		}
+
+		switch {
+		case *list:
+			for _, e := range config.List() {
+				fmt.Printf("%s=%s\n", e.Key, e.Value)
+			}
+			return nil
+		case *unset:
+			if len(args) != 1 {
+				return failf("config --unset needs a key")
+			}
+			err := config.Unset(args[0])
+			if errors.Is(err, os.ErrNotExist) {
+				return exit(5)
+			}
+			if err != nil {
+				return failf("Error unsetting key: %v", err)
+			}
+		case len(args) == 1:
+			value, ok := config.Get(args[0])
+			if !ok {
+				return exit(1)
+			}
+			fmt.Printf("%s\n", value)
+			return nil
+		case len(args) == 2:
+			err := config.Set(args[0], args[1])
+			if err != nil {
+				return failf("Error setting key: %v", err)
+			}
+		default:
+			return failf("config command needs a key and optionally a value")
+		}
+
+		err = configService.Store()
+		if err != nil {
+			return failf("Error writing config: %v", err)
+		}
+		return nil
+	}
+}
+
+func describeCommand(flags *flag.FlagSet) runFunc {
+	always := flags.Bool("always", false, "show the abbreviated commit hash as a fallback")
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("describe command does not have arguments")
+		}
+
+		name, err := svc.mgi.Describe(*always)
+		if err != nil {
+			return failf("Error describing HEAD: %v", err)
+		}
+		fmt.Printf("%s\n", name)
+		return nil
+	}
+}
+
+func prunePackedCommand(flags *flag.FlagSet) runFunc {
+	dryRun := flags.Bool("n", false, "only list the objects that would be removed")
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("prune-packed command does not have arguments")
+		}
+
+		pruned, err := svc.obj.PrunePacked(*dryRun)
+		if err != nil {
+			return failf("Error pruning packed objects: %v", err)
+		}
+		if *dryRun {
+			for _, hash := range pruned {
+				fmt.Printf("%s\n", hash)
+			}
+		}
+		return nil
+	}
+}
+
+func indexPackCommand(flags *flag.FlagSet) runFunc {
+	loose := flags.Bool("loose", false, "also store every object of the pack as a loose object")
+	return func(args []string, svc *services) error {
+		if len(args) != 1 {
+			return failf("usage: index-pack [--loose] <pack>")
+		}
+
+		sum, err := svc.obj.IndexPack(args[0], *loose)
+		if err != nil {
+			return failf("Error indexing pack: %v", err)
+		}
+		fmt.Printf("%s\n", sum)
+		return nil
+	}
+}
+
+func unpackObjectsCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("usage: unpack-objects < <pack>")
+		}
+
+		unpacked, skipped, err := svc.obj.UnpackObjects(os.Stdin)
+		if err != nil {
+			return failf("Error unpacking objects: %v", err)
+		}
+		fmt.Printf("Unpacked %d objects, %d already present\n", unpacked, skipped)
+		return nil
+	}
+}
+
+func repackCommand(flags *flag.FlagSet) runFunc {
+	deleteLoose := flags.Bool("d", false, "remove the loose objects once they are in the new pack")
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("usage: repack [-d]")
+		}
+
+		sum, removed, err := svc.obj.Repack(*deleteLoose)
+		if err != nil {
+			return failf("Error repacking objects: %v", err)
+		}
+		if sum == "" {
+			fmt.Printf("Nothing new to pack.\n")
+			return nil
+		}
+		svc.logger.Debugf("wrote pack-%s, removed %d loose objects", sum, removed)
+		return nil
+	}
+}
+
+func pruneCommand(flags *flag.FlagSet) runFunc {
+	expire := flags.String("expire", mgi.DefaultPruneExpire, "only remove unreachable objects older than this date (\"now\" for all)")
+	dryRun := flags.Bool("dry-run", false, "only list the objects that would be removed")
+	flags.BoolVar(dryRun, "n", false, "shorthand for --dry-run")
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("prune command does not have arguments")
+		}
+
+		pruned, err := svc.mgi.Prune(*expire, *dryRun)
+		if err != nil {
+			return failf("Error pruning objects: %v", err)
+		}
+		if *dryRun {
+			for _, hash := range pruned {
+				fmt.Printf("%s\n", hash)
+			}
+		}
+		return nil
+	}
+}
+
+// gcCommand expires old reflog entries and prunes the unreachable objects. The expiry dates come
+// from the gc.reflogExpire and gc.pruneExpire settings unless given.
+func gcCommand(flags *flag.FlagSet) runFunc {
+	prune := flags.String("prune", "", "prune unreachable objects older than this date (default gc.pruneExpire, or "+mgi.DefaultPruneExpire+")")
+	auto := flags.Bool("auto", false, "only collect garbage if there are more loose objects than gc.auto")
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("gc command does not have arguments")
+		}
+
+		config, err := mgi.NewConfigService(svc.root).Read()
+		if err != nil {
+			return failf("Error reading config: %v", err)
+		}
+		reflogExpire, ok := config.Get("gc.reflogExpire")
+		if !ok {
+			reflogExpire = mgi.DefaultReflogExpire
+		}
+		if *prune == "" {
+			value, ok := config.Get("gc.pruneExpire")
+			if !ok {
+				value = mgi.DefaultPruneExpire
+			}
+			*prune = value
+		}
+
+		if *auto {
+			limit := int64(mgi.DefaultGCAuto)
+			if _, ok := config.Get("gc.auto"); ok {
+				limit, err = config.GetSize("gc.auto")
+				if err != nil {
+					return failf("Error reading config: %v", err)
+				}
+			}
+			_, err = svc.mgi.GCAuto(limit, reflogExpire, *prune)
+			if err != nil {
+				return failf("Error collecting garbage: %v", err)
+			}
+			return nil
+		}
+
+		pruned, err := svc.mgi.GC(reflogExpire, *prune)
+		if err != nil {
+			return failf("Error collecting garbage: %v", err)
+		}
+		svc.logger.Debugf("pruned %d objects", len(pruned))
+		return nil
+	}
+}
+
+func stashCommand(flags *flag.FlagSet) runFunc {
+	message := flags.String("m", "", "description of the stash entry")
+	return func(args []string, svc *services) error {
+		action := "push"
+		if len(args) > 0 {
+			action, args = args[0], args[1:]
+		}
+
+		var err error
+		switch action {
+		case "push", "save":
+			if action == "save" && len(args) > 0 {
+				*message = strings.Join(args, " ")
+			}
+			err = svc.mgi.Stash(*message)
+		case "pop":
+			err = svc.mgi.StashPop()
+		case "list":
+			var list []string
+			list, err = svc.mgi.StashList()
+			for i := range list {
+				fmt.Printf("%s\n", list[i])
+			}
+		case "show", "drop":
+			n := 0
+			if len(args) > 0 {
+				n, err = parseStashIndex(args[0])
+				if err != nil {
+					break
+				}
+			}
+			if action == "drop" {
+				err = svc.mgi.StashDrop(n)
+				break
+			}
+			var diffs []string
+			diffs, err = svc.mgi.StashShow(n)
+			for i := range diffs {
+				fmt.Printf("%s", diffs[i])
+			}
+		default:
+			return failf("Unknown stash subcommand %q", action)
+		}
+		if err != nil {
+			return failf("Error running stash %s: %v", action, err)
+		}
+		return nil
+	}
+}
+
+// parseStashIndex accepts both "stash@{n}" and "n".
+func parseStashIndex(s string) (int, error) {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "stash@{"), "}")
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid stash reference %q", s)
+	}
+	return n, nil
+}
+
+func worktreeCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		if len(args) != 3 || args[0] != "add" {
+			return failf("usage: worktree add <path> <branch>")
+		}
+
+		err := svc.mgi.WorktreeAdd(args[1], args[2])
+		if err != nil {
+			return failf("Error adding worktree: %v", err)
+		}
+		return nil
+	}
+}
+
+func restoreCommand(flags *flag.FlagSet) runFunc {
+	source := flags.String("source", "", "restore from the given commit or tree instead of the index")
+	staged := flags.Bool("staged", false, "restore the index instead of the working tree")
+	return func(args []string, svc *services) error {
+		if len(args) < 1 {
+			return failf("restore command needs at least one path")
+		}
+
+		err := svc.mgi.Restore(args, *source, *staged)
+		if err != nil {
+			return failf("Error restoring files: %v", err)
+		}
+		return nil
+	}
+}
+
+// checkoutCommand restores paths from a commit, which must be separated from them by "--". Without
+// a commit, the paths are restored from the index. Switching branches is not supported.
+// checkoutCommand checks out the given paths from a commit or the index. With --ours or
+// --theirs, files with conflicts are resolved by taking that side of the merge.
+func checkoutCommand(flags *flag.FlagSet) runFunc {
+	ours := flags.Bool("ours", false, "resolve conflicts by checking out our version of the files")
+	theirs := flags.Bool("theirs", false, "resolve conflicts by checking out their version of the files")
+	return func(args []string, svc *services) error {
+		if *ours || *theirs {
+			if *ours && *theirs {
+				return failf("--ours and --theirs are mutually exclusive")
+			}
+			if len(args) > 0 && args[0] == "--" {
+				args = args[1:]
+			}
+			if len(args) == 0 {
+				return failf("usage: checkout --ours|--theirs [--] <path>...")
+			}
+			stage := 2
+			if *theirs {
+				stage = 3
+			}
+			err := svc.mgi.CheckoutStage(args, stage)
+			if err != nil {
+				return failf("Error checking out files: %v", err)
+			}
+			return nil
+		}
+
+		var source string
+		paths := args
+		for i, arg := range args {
+			if arg == "--" {
+				if i > 1 {
+					return failf("usage: checkout [<commit>] -- <path>...")
+				}
+				if i == 1 {
+					source = args[0]
+				}
+				paths = args[i+1:]
+				break
+			}
+		}
+		if len(paths) == 0 {
+			return failf("usage: checkout [<commit>] -- <path>...")
+		}
+
+		err := svc.mgi.CheckoutPaths(paths, source)
+		if err != nil {
+			return failf("Error checking out files: %v", err)
+		}
+		return nil
+	}
+}
+
+// resetCommand unstages the given paths or, with a mode or a single commit, moves the current
+// branch to that commit like git reset.
+func resetCommand(flags *flag.FlagSet) runFunc {
+	modes := []struct {
+		mode mgi.ResetMode
+		set  *bool
+	}{
+		{mgi.ResetSoft, flags.Bool("soft", false, "only move HEAD")},
+		{mgi.ResetMixed, flags.Bool("mixed", false, "reset the index but not the working tree")},
+		{mgi.ResetHard, flags.Bool("hard", false, "reset the index and the working tree, discarding local changes")},
+		{mgi.ResetMerge, flags.Bool("merge", false, "reset the index and the files that differ from the commit, keeping unstaged changes")},
+		{mgi.ResetKeep, flags.Bool("keep", false, "reset the index and the files that differ from the commit, keeping local changes")},
+	}
+	return func(args []string, svc *services) error {
+		mode, modeSet := mgi.ResetMixed, false
+		for _, m := range modes {
+			if !*m.set {
+				continue
+			}
+			if modeSet {
+				return failf("--soft, --mixed, --hard, --merge and --keep are mutually exclusive")
+			}
+			mode, modeSet = m.mode, true
+		}
+		if !modeSet && len(args) == 1 {
+			if _, err := svc.mgi.RevParse(args[0]); err == nil {
+				modeSet = true
+			}
+		}
+		if modeSet {
+			if len(args) > 1 {
+				return failf("usage: reset [--soft | --mixed | --hard | --merge | --keep] [<commit>]")
+			}
+			rev := "HEAD"
+			if len(args) == 1 {
+				rev = args[0]
+			}
+			err := svc.mgi.ResetTo(rev, mode)
+			if err != nil {
+				return failf("Error resetting to %s: %v", rev, err)
+			}
+			return nil
+		}
+
+		err := svc.mgi.Reset(args)
+		if err != nil {
+			return failf("Error unstaging files: %v", err)
+		}
+		return nil
+	}
+}
+
+func updateIndexCommand(flags *flag.FlagSet) runFunc {
+	add := flags.Bool("add", false, "add files that are not in the index yet")
+	remove := flags.Bool("remove", false, "remove files that are missing from the working tree")
+	cacheInfo := flags.Bool("cacheinfo", false, "add entries given as <mode> <sha> <path> (or <mode>,<sha>,<path>)")
+	assumeUnchanged := flags.Bool("assume-unchanged", false, "mark the files as assume-unchanged")
+	noAssumeUnchanged := flags.Bool("no-assume-unchanged", false, "clear the assume-unchanged mark")
+	return func(args []string, svc *services) error {
+		if *assumeUnchanged && *noAssumeUnchanged {
+			return failf("--assume-unchanged and --no-assume-unchanged are mutually exclusive")
+		}
+
+		var err error
+		switch {
+		case *cacheInfo:
+			var entries [][]string
+			for len(args) > 0 {
+				if fields := strings.Split(args[0], ","); len(fields) == 3 {
+					entries, args = append(entries, fields), args[1:]
+				} else if len(args) >= 3 {
+					entries, args = append(entries, args[:3]), args[3:]
+				} else {
+					return failf("--cacheinfo needs <mode> <sha> <path>")
+				}
+			}
+			for _, e := range entries {
+				mode, perr := strconv.ParseUint(e[0], 8, 32)
+				if perr != nil {
+					return failf("Invalid mode %q", e[0])
+				}
+				err = svc.mgi.AddCacheInfo(uint32(mode), e[1], e[2])
+				if err != nil {
+					break
+				}
+			}
+		case *assumeUnchanged || *noAssumeUnchanged:
+			err = svc.mgi.SetAssumeUnchanged(args, *assumeUnchanged)
+		default:
+			err = svc.mgi.UpdateIndex(args, *add, *remove)
+		}
+		if err != nil {
+			return failf("Error updating index: %v", err)
+		}
+		return nil
+	}
+}
+
+func catFileCommand(flags *flag.FlagSet) runFunc {
+	showType := flags.Bool("t", false, "show the object type")
+	showSize := flags.Bool("s", false, "show the object size")
+	pretty := flags.Bool("p", false, "pretty-print the object contents")
+	batch := flags.Bool("batch", false, "read object names from stdin and print each object")
+	filters := flags.Bool("filters", false, "show the blob as it would be checked out, with the content filters applied")
+	path := flags.String("path", "", "the path the blob is filtered for with --filters")
+	allowUnknownType := flags.Bool("allow-unknown-type", false, "allow -t and -s on objects of unknown types")
+	return func(args []string, svc *services) error {
+		if *batch {
+			err := svc.mgi.CatFileBatch(os.Stdin, os.Stdout)
+			if err != nil {
+				return failf("Error reading objects: %v", err)
+			}
+			return nil
+		}
+
+		if *filters {
+			if len(args) != 1 {
+				return failf("usage: cat-file --filters [--path=<path>] <object>")
+			}
+			data, err := svc.mgi.CatFileFiltered(args[0], *path)
+			if err != nil {
+				return failf("Error reading object: %v", err)
+			}
+			os.Stdout.Write(data)
+			return nil
+		}
+
+		// Either a flag and an object, or the expected type and an object
+		var expectedType string
+		if len(args) == 2 {
+			expectedType, args = args[0], args[1:]
+		}
+		if len(args) != 1 {
+			return failf("usage: cat-file (-t | -s | -p | <type> | --filters) <object>")
+		}
+
+		if *allowUnknownType && !*showType && !*showSize {
+			return failf("--allow-unknown-type requires -t or -s")
+		}
+		_, objType, data, err := svc.mgi.CatFile(args[0], *allowUnknownType)
+		if err != nil {
+			return failf("Error reading object: %v", err)
+		}
+		switch {
+		case *showType:
+			fmt.Printf("%s\n", objType)
+		case *showSize:
+			fmt.Printf("%d\n", len(data))
+		case *pretty:
+			out, err := svc.mgi.PrettyPrint(objType, data)
+			if err != nil {
+				return failf("Error reading object: %v", err)
+			}
+			os.Stdout.Write(out)
+		case expectedType != "":
+			if expectedType != objType {
+				return failf("Object %s is a %s, not a %s", args[0], objType, expectedType)
+			}
+			os.Stdout.Write(data)
+		default:
+			return failf("usage: cat-file (-t | -s | -p | <type>) <object>")
+		}
+		return nil
+	}
+}
+
+func rmCommand(flags *flag.FlagSet) runFunc {
+	cached := flags.Bool("cached", false, "only remove the files from the index")
+	recursive := flags.Bool("r", false, "allow removing directories recursively")
+	dryRun := flags.Bool("n", false, "only show the files that would be removed")
+	return func(args []string, svc *services) error {
+		if len(args) < 1 {
+			return failf("rm command needs at least one path")
+		}
+
+		removed, err := svc.mgi.Rm(args, *cached, *recursive, *dryRun)
+		if err != nil {
+			return failf("Error removing files: %v", err)
+		}
+		for _, path := range removed {
+			fmt.Printf("rm '%s'\n", path)
+		}
+		return nil
+	}
+}
+
+func mvCommand(flags *flag.FlagSet) runFunc {
+	dryRun := flags.Bool("n", false, "only show the files that would be moved")
+	return func(args []string, svc *services) error {
+		if len(args) < 2 {
+			return failf("usage: mv [-n] <source>... <destination>")
+		}
+
+		moved, err := svc.mgi.Mv(args[:len(args)-1], args[len(args)-1], *dryRun)
+		if err != nil {
+			return failf("Error moving files: %v", err)
+		}
+		if *dryRun {
+			for _, mv := range moved {
+				fmt.Printf("Renaming %s to %s\n", mv.From, mv.To)
+			}
+		}
+		return nil
+	}
+}
+
+func bundleCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		if len(args) < 2 {
+			return failf("usage: bundle create <file> <ref>... | bundle unbundle <file>")
+		}
+
+		switch args[0] {
+		case "create":
+			if len(args) < 3 {
+				return failf("bundle create needs at least one ref")
+			}
+			f, err := os.Create(args[1])
+			if err != nil {
+				return failf("Error creating bundle: %v", err)
+			}
+			defer f.Close()
+			err = svc.mgi.BundleCreate(f, args[2:])
+			if err == nil {
+				err = f.Close()
+			}
+			if err != nil {
+				os.Remove(args[1])
+				return failf("Error creating bundle: %v", err)
+			}
+		case "unbundle":
+			f, err := os.Open(args[1])
+			if err != nil {
+				return failf("Error reading bundle: %v", err)
+			}
+			defer f.Close()
+			refs, err := svc.mgi.BundleUnbundle(f)
+			if err != nil {
+				return failf("Error reading bundle: %v", err)
+			}
+			for _, ref := range refs {
+				fmt.Printf("%s %s\n", ref.Hash, ref.Name)
+			}
+		default:
+			return failf("Unknown bundle subcommand %q", args[0])
+		}
+		return nil
+	}
+}
+
+func tagCommand(flags *flag.FlagSet) runFunc {
+	list := flags.Bool("l", false, "list tags, optionally only the ones matching a pattern")
+	annotate := flags.Bool("a", false, "create an annotated tag")
+	message := flags.String("m", "", "message of the annotated tag")
+	force := flags.Bool("f", false, "replace the tag if it exists")
+	del := flags.Bool("d", false, "delete the given tags")
+	var verify bool
+	flags.BoolVar(&verify, "verify", false, "verify the signatures of the given tags")
+	flags.BoolVar(&verify, "v", false, "shorthand for --verify")
+	filter := new(mgi.RefFilter)
+	flags.StringVar(&filter.Contains, "contains", "", "only list tags that contain the commit")
+	flags.StringVar(&filter.Merged, "merged", "", "only list tags reachable from the commit")
+	flags.StringVar(&filter.NoMerged, "no-merged", "", "only list tags not reachable from the commit")
+	return func(args []string, svc *services) error {
+		filtering := filter.Contains != "" || filter.Merged != "" || filter.NoMerged != ""
+
+		switch {
+		case *del:
+			for _, name := range args {
+				err := svc.mgi.DeleteTag(name)
+				if err != nil {
+					return failf("Error deleting tag: %v", err)
+				}
+			}
+		case verify:
+			if len(args) == 0 {
+				return failf("usage: tag --verify <name>...")
+			}
+			for _, name := range args {
+				report, err := svc.mgi.VerifyTag(name)
+				fmt.Fprint(os.Stderr, report)
+				if err != nil {
+					return failf("Error verifying tag %s: %v", name, err)
+				}
+			}
+		case *list || filtering || len(args) == 0:
+			if len(args) > 1 {
+				return failf("tag -l takes at most one pattern")
+			}
+			var pattern string
+			if len(args) == 1 {
+				pattern = args[0]
+			}
+			tags, err := svc.mgi.ListTags(pattern, filter)
+			if err != nil {
+				return failf("Error listing tags: %v", err)
+			}
+			for _, name := range tags {
+				fmt.Printf("%s\n", name)
+			}
+		default:
+			if len(args) > 2 {
+				return failf("usage: tag [-a] [-m <message>] [-f] <name> [<commit>]")
+			}
+			var target string
+			if len(args) == 2 {
+				target = args[1]
+			}
+			if *annotate && *message == "" {
+				return failf("annotated tags need a message")
+			}
+			err := svc.mgi.CreateTag(args[0], target, *message, *force)
+			if err != nil {
+				return failf("Error creating tag: %v", err)
+			}
+		}
+		return nil
+	}
+}
+
+func branchCommand(flags *flag.FlagSet) runFunc {
+	del := flags.Bool("d", false, "delete the given branches, which must be merged into HEAD")
+	forceDel := flags.Bool("D", false, "delete the given branches, even if they aren't merged")
+	force := flags.Bool("f", false, "move the branch if it exists")
+	filter := new(mgi.RefFilter)
+	flags.StringVar(&filter.Contains, "contains", "", "only list branches that contain the commit")
+	flags.StringVar(&filter.Merged, "merged", "", "only list branches reachable from the commit")
+	flags.StringVar(&filter.NoMerged, "no-merged", "", "only list branches not reachable from the commit")
+	return func(args []string, svc *services) error {
+		switch {
+		case *del || *forceDel:
+			for _, name := range args {
+				err := svc.mgi.DeleteBranch(name, *forceDel)
+				if err != nil {
+					return failf("Error deleting branch: %v", err)
+				}
+			}
+		case len(args) == 0:
+			branches, err := svc.mgi.ListBranches(filter)
+			if err != nil {
+				return failf("Error listing branches: %v", err)
+			}
+			for _, b := range branches {
+				marker := " "
+				if b.Current {
+					marker = "*"
+				}
+				fmt.Printf("%s %s\n", marker, b.Name)
+			}
+		case len(args) <= 2:
+			var start string
+			if len(args) == 2 {
+				start = args[1]
+			}
+			err := svc.mgi.CreateBranch(args[0], start, *force)
+			if err != nil {
+				return failf("Error creating branch: %v", err)
+			}
+		default:
+			return failf("usage: branch [-f] <name> [<start>]")
+		}
+		return nil
+	}
+}
+
+func mergeBaseCommand(flags *flag.FlagSet) runFunc {
+	isAncestor := flags.Bool("is-ancestor", false, "exit with status 0 if the first commit is an ancestor of the second, 1 otherwise")
+	return func(args []string, svc *services) error {
+		if len(args) != 2 {
+			return failf("usage: merge-base [--is-ancestor] <commit> <commit>")
+		}
+
+		if *isAncestor {
+			ok, err := svc.mgi.IsAncestor(args[0], args[1])
+			if err != nil {
+				return failf("Error checking ancestry: %v", err)
+			}
+			if !ok {
+				return exit(1)
+			}
+			return nil
+		}
+
+		bases, err := svc.mgi.MergeBase(args[0], args[1])
+		if err != nil {
+			return failf("Error finding merge base: %v", err)
+		}
+		if len(bases) == 0 {
+			return exit(1)
+		}
+		fmt.Printf("%s\n", bases[0])
+		return nil
+	}
+}
+
+func reflogCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		action := "show"
+		if len(args) > 0 && (args[0] == "show" || args[0] == "expire") {
+			action, args = args[0], args[1:]
+		}
+
+		switch action {
+		case "show":
+			if len(args) > 1 {
+				return failf("usage: reflog [show] [<ref>]")
+			}
+			ref := ""
+			if len(args) == 1 {
+				ref = args[0]
+			}
+			list, err := svc.mgi.Reflog(ref)
+			if err != nil {
+				return failf("Error reading reflog: %v", err)
+			}
+			for i := range list {
+				fmt.Printf("%s\n", list[i])
+			}
+		case "expire":
+			expireFlags := flag.NewFlagSet("reflog expire", flag.ExitOnError)
+			expire := expireFlags.String("expire", "", "prune entries older than this date (default gc.reflogExpire, or "+mgi.DefaultReflogExpire+")")
+			all := expireFlags.Bool("all", false, "prune the reflogs of all refs")
+			expireFlags.Parse(args)
+			if !*all && expireFlags.NArg() == 0 {
+				return failf("usage: reflog expire [--expire=<date>] (--all | <ref>...)")
+			}
+
+			if *expire == "" {
+				config, err := mgi.NewConfigService(svc.root).Read()
+				if err != nil {
+					return failf("Error reading config: %v", err)
+				}
+				value, ok := config.Get("gc.reflogExpire")
+				if !ok {
+					value = mgi.DefaultReflogExpire
+				}
+				*expire = value
+			}
+			removed, err := svc.mgi.ReflogExpire(expireFlags.Args(), *all, *expire)
+			if err != nil {
+				return failf("Error expiring reflog: %v", err)
+			}
+			svc.logger.Debugf("removed %d reflog entries", removed)
+		}
+		return nil
+	}
+}
+
+func revParseCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		if len(args) == 0 {
+			return failf("usage: rev-parse <revision>...")
+		}
+		for _, rev := range args {
+			hash, err := svc.mgi.RevParse(rev)
+			if err != nil {
+				return failf("Error parsing revision: %v", err)
+			}
+			fmt.Printf("%s\n", hash)
+		}
+		return nil
+	}
+}
+
+func verifyIndexCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		problems, err := svc.mgi.VerifyIndex()
+		if err != nil {
+			return failf("Error reading index: %v", err)
+		}
+		for _, p := range problems {
+			fmt.Printf("%s\n", p)
+		}
+		if len(problems) > 0 {
+			return exit(1)
+		}
+		return nil
+	}
+}
+
+func fsckCommand(flags *flag.FlagSet) runFunc {
+	connectivityOnly := flags.Bool("connectivity-only", false, "only check that referenced objects exist, without rehashing them")
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("fsck command does not have arguments")
+		}
+
+		report, err := svc.mgi.Fsck(*connectivityOnly)
+		if err != nil {
+			return failf("Error checking objects: %v", err)
+		}
+		for _, list := range [][]string{report.Corrupt, report.Missing, report.Dangling} {
+			for _, line := range list {
+				fmt.Printf("%s\n", line)
+			}
+		}
+		if !report.OK() {
+			return exit(1)
+		}
+		return nil
+	}
+}
+
+// logCommand lists the history of a revision. Paths limiting the history may follow the revision,
+// separated from it by "--" unless none of them is a revision.
+func logCommand(flags *flag.FlagSet) runFunc {
+	oneline := flags.Bool("oneline", false, "show each commit as its abbreviated hash and subject")
+	graph := flags.Bool("graph", false, "draw the history next to the commits")
+	patch := flags.Bool("p", false, "show the changes of each commit")
+	pretty := flags.String("pretty", "medium", "describe commits in `format`: oneline, short, medium, full or format:<format>")
+	date := flags.String("date", "default", "write dates in `format`: default, iso, relative, unix or short")
+	return func(args []string, svc *services) error {
+		var rev string
+		paths := args
+		for i, arg := range args {
+			if arg == "--" {
+				if i > 1 {
+					return failf("usage: log [--oneline] [--pretty=<format>] [--date=<format>] [--graph] [-p] [<revision>] [[--] <path>...]")
+				}
+				if i == 1 {
+					rev = args[0]
+				}
+				paths = args[i+1:]
+				break
+			}
+		}
+		if rev == "" && len(paths) == len(args) && len(args) > 0 {
+			if _, err := svc.mgi.RevParse(args[0]); err == nil {
+				rev, paths = args[0], args[1:]
+			}
+		}
+		if *graph && len(paths) > 0 {
+			return failf("--graph cannot be used with paths")
+		}
+		if *oneline {
+			*pretty = "oneline"
+		}
+		prettyFormat, err := mgi.ParsePrettyFormat(*pretty)
+		if err != nil {
+			return failf("%v", err)
+		}
+		prettyFormat.AbbrevCommit = *oneline
+		prettyFormat.Date, err = mgi.ParseDateFormat(*date)
+		if err != nil {
+			return failf("%v", err)
+		}
+
+		entries, err := svc.mgi.Log(rev, *graph, paths...)
+		if err != nil {
+			return failf("Error reading history: %v", err)
+		}
+		format := func(e *mgi.LogEntry) []string {
+			return svc.mgi.FormatLogEntry(e, prettyFormat)
+		}
+		if *graph {
+			for _, line := range mgi.LogGraph(entries, format) {
+				fmt.Printf("%s\n", line)
+			}
+			return nil
+		}
+		for i, e := range entries {
+			if i > 0 && prettyFormat.Separated() {
+				fmt.Printf("\n")
+			}
+			for _, line := range format(e) {
+				fmt.Printf("%s\n", line)
+			}
+			if !*patch {
+				continue
+			}
+			diffs, err := svc.mgi.CommitDiff(e.Hash, paths)
+			if err != nil {
+				return failf("Error comparing %s: %v", e.Hash, err)
+			}
+			if len(diffs) == 0 {
+				continue
+			}
+			if prettyFormat.SeparatesDiff() {
+				fmt.Printf("\n")
+			}
+			for _, d := range diffs {
+				fmt.Print(d)
+			}
+		}
+		return nil
+	}
+}
+
+func blameCommand(flags *flag.FlagSet) runFunc {
+	var lines lineRangeFlag
+	flags.Var(&lines, "L", "only blame the lines from `start,end`, where end may be +count")
+	return func(args []string, svc *services) error {
+		if len(args) < 1 || len(args) > 2 {
+			return failf("usage: blame [-L <start>,<end>] [<revision>] <file>")
+		}
+		var rev string
+		if len(args) == 2 {
+			rev = args[0]
+		}
+		path := args[len(args)-1]
+
+		blamed, err := svc.mgi.Blame(rev, path, lines.start, lines.end)
+		if err != nil {
+			return failf("Error blaming %s: %v", path, err)
+		}
+		for _, line := range svc.mgi.FormatBlame(blamed) {
+			fmt.Printf("%s\n", line)
+		}
+		return nil
+	}
+}
+
+// lineRangeFlag is the range of lines of -L, given like git's -L <start>,<end>. The end can be
+// omitted to mean the end of the file, or given as +<count>.
+type lineRangeFlag struct {
+	start, end int
+}
+
+func (f *lineRangeFlag) String() string {
+	if f.start == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d,%d", f.start, f.end)
+}
+
+func (f *lineRangeFlag) Set(value string) error {
+	startValue, endValue, hasEnd := value, "", false
+	if i := strings.Index(value, ","); i >= 0 {
+		startValue, endValue, hasEnd = value[:i], value[i+1:], true
+	}
+	start, err := strconv.Atoi(startValue)
+	if err != nil || start < 1 {
+		return fmt.Errorf("invalid start of range %q", value)
+	}
+	end := 0
+	switch {
+	case !hasEnd || endValue == "":
+	case strings.HasPrefix(endValue, "+"):
+		count, err := strconv.Atoi(endValue[1:])
+		if err != nil || count < 1 {
+			return fmt.Errorf("invalid line count in range %q", value)
+		}
+		end = start + count - 1
+	default:
+		end, err = strconv.Atoi(endValue)
+		if err != nil || end < 1 {
+			return fmt.Errorf("invalid end of range %q", value)
+		}
+		if end < start {
+			return fmt.Errorf("invalid range %q: the end comes before the start", value)
+		}
+	}
+	f.start, f.end = start, end
+	return nil
+}
+
+func serveCommand(flags *flag.FlagSet) runFunc {
+	port := flags.Int("port", 8080, "port to listen on, on the loopback interface")
+	listen := flags.String("listen", "", "address to listen on, e.g. 0.0.0.0:8080 to serve other hosts (default 127.0.0.1:<port>)")
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("usage: serve [--port <port> | --listen <address>]")
+		}
+		addr := *listen
+		if addr == "" {
+			addr = fmt.Sprintf("127.0.0.1:%d", *port)
+		}
+		fmt.Fprintf(os.Stderr, "Serving the repository on http://%s\n", addr)
+		err := http.ListenAndServe(addr, svc.mgi.HTTPHandler())
+		if err != nil {
+			return failf("Error serving the repository: %v", err)
+		}
+		return nil
+	}
+}
+
+// cloneCommand clones the repository served over HTTP at the given URL into a new directory,
+// named after the repository unless one is given.
+func cloneCommand(flags *flag.FlagSet) runFunc {
+	depth := flags.Int("depth", 0, "only fetch this many commits of history")
+	return func(args []string, svc *services) error {
+		if len(args) < 1 || len(args) > 2 || *depth < 0 {
+			return failf("usage: clone [--depth <depth>] <url> [<directory>]")
+		}
+		url := args[0]
+		dir := strings.TrimSuffix(path.Base(strings.TrimSuffix(url, "/")), ".git")
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+			return failf("destination path '%s' already exists and is not an empty directory", dir)
+		}
+		fmt.Fprintf(os.Stderr, "Cloning into '%s'...\n", dir)
+
+		err := doInit(filepath.Join(dir, rootLocation))
+		if err == nil {
+			err = os.Chdir(dir)
+		}
+		if err != nil {
+			return failf("Error cloning: %v", err)
+		}
+		repo, err := mgi.NewRepo(rootLocation, svc.logger)
+		if err == nil {
+			err = repo.Clone(url, *depth)
+		}
+		if err != nil {
+			return failf("Error cloning: %v", err)
+		}
+		return nil
+	}
+}
+
+// fetchCommand fetches the branches and tags of a remote, "origin" unless another is given.
+func fetchCommand(flags *flag.FlagSet) runFunc {
+	depth := flags.Int("depth", 0, "limit the history to this many commits from the tip of each branch")
+	return func(args []string, svc *services) error {
+		if len(args) > 1 || *depth < 0 {
+			return failf("usage: fetch [--depth <depth>] [<remote>]")
+		}
+		remote := "origin"
+		if len(args) == 1 {
+			remote = args[0]
+		}
+		_, err := svc.mgi.Fetch(remote, *depth)
+		if err != nil {
+			return failf("Error fetching: %v", err)
+		}
+		return nil
+	}
+}
+
+// notesCommand runs "notes add" or "notes show", on HEAD unless a commit is given.
+func notesCommand(flags *flag.FlagSet) runFunc {
+	message := flags.String("m", "", "the note to add")
+	force := flags.Bool("f", false, "replace the existing note of the commit")
+	appendNote := flags.Bool("append", false, "add to the end of the existing note of the commit")
+	return func(args []string, svc *services) error {
+		if len(args) == 0 {
+			return failf("usage: notes add -m <message> [-f | --append] [<commit>] | notes show [<commit>]")
+		}
+		// Flags may come after the subcommand, e.g. "notes add -m msg"
+		subcommand := args[0]
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		args = flags.Args()
+		if len(args) > 1 {
+			return failf("usage: notes %s [<commit>]", subcommand)
+		}
+		rev := "HEAD"
+		if len(args) == 1 {
+			rev = args[0]
+		}
+
+		switch subcommand {
+		case "add":
+			if *message == "" {
+				return failf("notes add needs a message (-m)")
+			}
+			err := svc.mgi.AddNote(rev, *message, *force, *appendNote)
+			if err != nil {
+				return failf("Error adding note: %v", err)
+			}
+		case "show":
+			note, err := svc.mgi.ShowNote(rev)
+			if err != nil {
+				return failf("Error showing note: %v", err)
+			}
+			fmt.Print(note)
+		default:
+			return failf("unknown notes subcommand %q", subcommand)
+		}
+		return nil
+	}
+}
+
+func lsTreeCommand(flags *flag.FlagSet) runFunc {
+	recursive := flags.Bool("r", false, "list the files in subdirectories")
+	return func(args []string, svc *services) error {
+		if len(args) != 1 {
+			return failf("usage: ls-tree [-r] <tree-ish>")
+		}
+		lines, err := svc.mgi.LsTree(args[0], *recursive)
+		if err != nil {
+			return failf("Error listing tree: %v", err)
+		}
+		for _, line := range lines {
+			fmt.Printf("%s\n", line)
+		}
+		return nil
+	}
+}
+
+func showCommand(flags *flag.FlagSet) runFunc {
+	pretty := flags.String("pretty", "medium", "describe commits in `format`: oneline, short, medium, full or format:<format>")
+	date := flags.String("date", "default", "write dates in `format`: default, iso, relative, unix or short")
+	return func(args []string, svc *services) error {
+		if len(args) > 1 {
+			return failf("usage: show [--pretty=<format>] [--date=<format>] [<object>]")
+		}
+		prettyFormat, err := mgi.ParsePrettyFormat(*pretty)
+		if err != nil {
+			return failf("%v", err)
+		}
+		prettyFormat.Date, err = mgi.ParseDateFormat(*date)
+		if err != nil {
+			return failf("%v", err)
+		}
+		rev := "HEAD"
+		if len(args) == 1 {
+			rev = args[0]
+		}
+		err = svc.mgi.Show(rev, prettyFormat, os.Stdout)
+		if err != nil {
+			return failf("Error showing %s: %v", rev, err)
+		}
+		return nil
+	}
+}
+
+func diffTreeCommand(flags *flag.FlagSet) runFunc {
+	recursive := flags.Bool("r", false, "compare the files in subdirectories")
+	nameOnly := flags.Bool("name-only", false, "show only the names of changed files")
+	nameStatus := flags.Bool("name-status", false, "show only the names and status of changed files")
+	return func(args []string, svc *services) error {
+		if len(args) < 1 || len(args) > 2 {
+			return failf("usage: diff-tree [-r] [--name-only | --name-status] <tree-ish> [<tree-ish>]")
+		}
+		format := mgi.DiffRaw
+		switch {
+		case *nameOnly && *nameStatus:
+			return failf("--name-only and --name-status are mutually exclusive")
+		case *nameOnly:
+			format = mgi.DiffNameOnly
+		case *nameStatus:
+			format = mgi.DiffNameStatus
+		}
+		lines, err := svc.mgi.DiffTree(args, *recursive, format)
+		if err != nil {
+			return failf("Error comparing trees: %v", err)
+		}
+		for _, line := range lines {
+			fmt.Printf("%s\n", line)
+		}
+		return nil
+	}
+}
+
+func applyCommand(flags *flag.FlagSet) runFunc {
+	index := flags.Bool("index", false, "apply the patch to the index too")
+	fuzz := flags.Int("fuzz", 0, "number of context lines that may be ignored at each end of a hunk")
+	return func(args []string, svc *services) error {
+		if len(args) > 1 || *fuzz < 0 {
+			return failf("usage: apply [--index] [--fuzz=<n>] [<patch>]")
+		}
+		var patch []byte
+		var err error
+		if len(args) == 0 || args[0] == "-" {
+			patch, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			patch, err = ioutil.ReadFile(args[0])
+		}
+		if err != nil {
+			return failf("Error reading patch: %v", err)
+		}
+		err = svc.mgi.Apply(patch, *index, *fuzz)
+		if err != nil {
+			return failf("Error applying patch: %v", err)
+		}
+		return nil
+	}
+}
+
+func formatPatchCommand(flags *flag.FlagSet) runFunc {
+	outputDir := flags.String("o", ".", "write the patches to the given directory")
+	stdout := flags.Bool("stdout", false, "print the patches as an mbox instead of writing files")
+	return func(args []string, svc *services) error {
+		if len(args) != 1 {
+			return failf("usage: format-patch [-o <dir>] [--stdout] <since> | <from>..<to>")
+		}
+		patches, err := svc.mgi.FormatPatch(args[0])
+		if err != nil {
+			return failf("Error formatting patches: %v", err)
+		}
+		if *stdout {
+			for _, p := range patches {
+				os.Stdout.Write(p.Contents)
+			}
+			return nil
+		}
+
+		err = os.MkdirAll(*outputDir, 0755)
+		if err != nil {
+			return failf("Error creating %s: %v", *outputDir, err)
+		}
+		for _, p := range patches {
+			path := filepath.Join(*outputDir, p.Name)
+			err := ioutil.WriteFile(path, p.Contents, 0644)
+			if err != nil {
+				return failf("Error writing %s: %v", path, err)
+			}
+			fmt.Printf("%s\n", path)
+		}
+		return nil
+	}
+}
+
+func amCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		var mbox []byte
+		if len(args) == 0 {
+			data, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return failf("Error reading patches: %v", err)
+			}
+			mbox = data
+		}
+		for _, arg := range args {
+			data, err := ioutil.ReadFile(arg)
+			if err != nil {
+				return failf("Error reading patches: %v", err)
+			}
+			mbox = append(mbox, data...)
+		}
+		err := svc.mgi.Am(mbox)
+		if err != nil {
+			return failf("Error applying patches: %v", err)
+		}
+		return nil
+	}
+}
+
+func revListCommand(flags *flag.FlagSet) runFunc {
+	count := flags.Bool("count", false, "print the number of commits instead of listing them")
+	var maxCount int
+	flags.IntVar(&maxCount, "max-count", -1, "list at most the given number of commits")
+	flags.IntVar(&maxCount, "n", -1, "shorthand for --max-count")
+	return func(args []string, svc *services) error {
+		if len(args) == 0 {
+			return failf("usage: rev-list [--count] [--max-count=<n>] <commit>... | <from>..<to> | <a>...<b>")
+		}
+		hashes, err := svc.mgi.RevList(args, maxCount)
+		if err != nil {
+			return failf("Error listing commits: %v", err)
+		}
+		if *count {
+			fmt.Printf("%d\n", len(hashes))
+			return nil
+		}
+		for _, hash := range hashes {
+			fmt.Printf("%s\n", hash)
+		}
+		return nil
+	}
+}
+
+func verifyCommitCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		if len(args) == 0 {
+			return failf("usage: verify-commit <commit>...")
+		}
+		for _, rev := range args {
+			report, err := svc.mgi.VerifyCommit(rev)
+			fmt.Fprint(os.Stderr, report)
+			if err != nil {
+				return failf("Error verifying commit %s: %v", rev, err)
+			}
+		}
+		return nil
+	}
+}
+
+func bisectCommand(flags *flag.FlagSet) runFunc {
+	usage := "usage: bisect (start [<bad> [<good>...]] | good [<rev>] | bad [<rev>] | skip [<rev>] | reset | run <cmd> [<arg>...])"
+	return func(args []string, svc *services) error {
+		if len(args) == 0 {
+			return failf(usage)
+		}
+		action, args := args[0], args[1:]
+
+		var step *mgi.BisectStep
+		var err error
+		switch action {
+		case "start":
+			var bad string
+			var good []string
+			if len(args) > 0 {
+				bad, good = args[0], args[1:]
+			}
+			step, err = svc.mgi.BisectStart(bad, good)
+		case "good", "bad", "skip":
+			if len(args) > 1 {
+				return failf(usage)
+			}
+			var rev string
+			if len(args) == 1 {
+				rev = args[0]
+			}
+			step, err = svc.mgi.BisectMark(rev, action)
+		case "reset":
+			err = svc.mgi.BisectReset()
+		case "run":
+			if len(args) == 0 {
+				return failf(usage)
+			}
+			return bisectRun(args, svc)
+		default:
+			return failf("Unknown bisect subcommand %q", action)
+		}
+		if err != nil {
+			return failf("Error running bisect %s: %v", action, err)
+		}
+		return printBisectStep(step, svc)
+	}
+}
+
+// bisectRun marks the commits checked out by bisect as good or bad according to the exit status
+// of the command, like git bisect run: 0 is good, 125 skips the commit, any other status below
+// 128 is bad, and others abort the bisection.
+func bisectRun(args []string, svc *services) error {
+	for {
+		fmt.Printf("running %s\n", strings.Join(args, " "))
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err := cmd.Run()
+		term := "good"
+		if err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return failf("Error running %s: %v", args[0], err)
+			}
+			switch code := exitErr.ExitCode(); {
+			case code == 125:
+				term = "skip"
+			case code > 0 && code < 128:
+				term = "bad"
+			default:
+				return failf("bisect run failed: %s exited with status %d", args[0], code)
+			}
+		}
+
+		step, err := svc.mgi.BisectMark("", term)
+		if err != nil {
+			return failf("Error running bisect %s: %v", term, err)
+		}
+		if step == nil {
+			return failf("bisect run needs a good and a bad commit")
+		}
+		err = printBisectStep(step, svc)
+		if err != nil || step.Found {
+			return err
+		}
+	}
+}
+
+// printBisectStep reports the commit checked out next and how many are left to test, or the
+// first bad commit once it is found.
+func printBisectStep(step *mgi.BisectStep, svc *services) error {
+	if step == nil {
+		return nil
+	}
+	if step.Found {
+		fmt.Printf("%s is the first bad commit\n", step.Entry.Hash)
+		pretty, err := mgi.ParsePrettyFormat("medium")
+		if err != nil {
+			return failf("%v", err)
+		}
+		for _, line := range svc.mgi.FormatLogEntry(step.Entry, pretty) {
+			fmt.Printf("%s\n", line)
+		}
+		return nil
+	}
+
+	revisions, steps := "revisions", "steps"
+	if step.Remaining == 1 {
+		revisions = "revision"
+	}
+	if step.Steps == 1 {
+		steps = "step"
+	}
+	fmt.Printf("Bisecting: %d %s left to test after this (roughly %d %s)\n", step.Remaining, revisions, step.Steps, steps)
+	fmt.Printf("[%s] %s\n", step.Entry.Hash, strings.SplitN(step.Entry.Commit.Message, "\n", 2)[0])
+	return nil
+}
+
+func shortlogCommand(flags *flag.FlagSet) runFunc {
+	summary := flags.Bool("s", false, "show only the number of commits of each author")
+	numbered := flags.Bool("n", false, "sort authors by number of commits")
+	email := flags.Bool("e", false, "show the email of each author")
+	return func(args []string, svc *services) error {
+		authors, err := svc.mgi.Shortlog(args, *numbered)
+		if err != nil {
+			return failf("Error reading history: %v", err)
+		}
+		for _, a := range authors {
+			name := a.Name
+			if *email {
+				name += " <" + a.Email + ">"
+			}
+			if *summary {
+				fmt.Printf("%6d\t%s\n", len(a.Subjects), name)
+				continue
+			}
+			fmt.Printf("%s (%d):\n", name, len(a.Subjects))
+			for _, s := range a.Subjects {
+				fmt.Printf("      %s\n", s)
+			}
+			fmt.Printf("\n")
+		}
+		return nil
+	}
+}
+
+func forEachRefCommand(flags *flag.FlagSet) runFunc {
+	format := flags.String("format", mgi.DefaultRefFormat, "describe each ref with `format`, e.g. %(refname) %(objectname)")
+	sortKey := flags.String("sort", "refname", "sort refs by `key`: refname or committerdate, reversed with a leading -")
+	return func(args []string, svc *services) error {
+		lines, err := svc.mgi.ForEachRef(args, *format, *sortKey)
+		if err != nil {
+			return failf("Error listing refs: %v", err)
+		}
+		for _, line := range lines {
+			fmt.Printf("%s\n", line)
+		}
+		return nil
+	}
+}
+
+func commitGraphCommand(flags *flag.FlagSet) runFunc {
+	return func(args []string, svc *services) error {
+		if len(args) != 1 || args[0] != "write" {
+			return failf("usage: commit-graph write")
+		}
+		n, err := svc.mgi.WriteCommitGraph()
+		if err != nil {
+			return failf("Error writing commit-graph: %v", err)
+		}
+		svc.logger.Debugf("wrote %d commits to the commit-graph", n)
+		return nil
+	}
+}
+
+// checkIgnoreCommand prints the paths that are ignored and exits with 1 if none is, like git
+// check-ignore. With -v, the pattern that decides is printed too, and paths matched by negated
+// patterns are printed and count as matches as well, as in git.
+func checkIgnoreCommand(flags *flag.FlagSet) runFunc {
+	verbose := flags.Bool("v", false, "show the pattern that matches each path and where it comes from")
+	return func(args []string, svc *services) error {
+		if len(args) == 0 {
+			return failf("usage: check-ignore [-v] <path>...")
+		}
+		matches, err := svc.mgi.CheckIgnore(args)
+		if err != nil {
+			return failf("Error checking ignored paths: %v", err)
+		}
+		matched := false
+		for i, match := range matches {
+			if match == nil || (match.Negate && !*verbose) {
+				continue
+			}
+			matched = true
+			if *verbose {
+				fmt.Printf("%s:%d:%s\t%s\n", match.Source, match.Line, match.Pattern, args[i])
+				continue
+			}
+			fmt.Printf("%s\n", args[i])
+		}
+		if !matched {
+			return exit(1)
+		}
+		return nil
+	}
+}
+
+// lsFilesCommand lists the files in the index, with their mode, hash and stage if --stage is set,
+// or only the ones with conflicts, described the same way, if --unmerged is.
+func lsFilesCommand(flags *flag.FlagSet) runFunc {
+	var stage, unmerged bool
+	flags.BoolVar(&stage, "stage", false, "show the mode, hash and merge stage of each file")
+	flags.BoolVar(&stage, "s", false, "shorthand for --stage")
+	flags.BoolVar(&unmerged, "unmerged", false, "only show files with conflicts, like --stage")
+	flags.BoolVar(&unmerged, "u", false, "shorthand for --unmerged")
+	return func(args []string, svc *services) error {
+		if len(args) > 0 {
+			return failf("usage: ls-files [--stage] [--unmerged]")
+		}
+		lines, err := svc.mgi.LsFiles(stage, unmerged)
+		if err != nil {
+			return failf("Error listing files: %v", err)
+		}
+		for _, line := range lines {
+			fmt.Printf("%s\n", line)
+		}
+		return nil
+	}
+}
@@ -5,116 +5,156 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/bertinatto/mgi"
 )
 
-func main() {
-	const rootLocation = ".git"
+const rootLocation = ".git"
 
-	// Commands
-	initCmd := flag.NewFlagSet("init", flag.ExitOnError)
-	addCmd := flag.NewFlagSet("add", flag.ExitOnError)
-	commitCmd := flag.NewFlagSet("commit", flag.ExitOnError)
-	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
-	diffCmd := flag.NewFlagSet("diff", flag.ExitOnError)
+// Command is a mgi subcommand, e.g. "mgi add".
+type Command interface {
+	Name() string
+	FlagSet() *flag.FlagSet
+	// Run runs the command with the arguments left after parsing the flags.
+	Run(args []string, svc *services) error
+}
 
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Available subcommands: init, add, commit, status, diff")
-		os.Exit(1)
+// services are the repository services commands operate on.
+type services struct {
+	root   string
+	logger mgi.Logger
+	obj    *mgi.ObjectService
+	index  *mgi.IndexService
+	mgi    *mgi.MGIService
+}
+
+func newServices(root string, logger mgi.Logger) (*services, error) {
+	repo, err := mgi.NewRepo(root, logger)
+	if err != nil {
+		return nil, err
 	}
+	return &services{
+		root:   repo.CommonDir,
+		logger: logger,
+		obj:    repo.Objects,
+		index:  repo.Index,
+		mgi:    repo.MGIService,
+	}, nil
+}
 
-	switch os.Args[1] {
-	case "init":
-		initCmd.Parse(nil)
-		err := doInit(rootLocation)
-		if err != nil {
-			log.Fatalf("Failed to initialize directories: %v", err)
-		}
-	case "add":
-		addCmd.Parse(os.Args[2:])
-		indexService := mgi.NewIndexService(rootLocation)
-		obj := mgi.NewObjectService(rootLocation)
-		mgi := mgi.NewMGIService(rootLocation, obj, indexService)
-		err := mgi.Add(addCmd.Args())
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error adding files: %v", err)
-			os.Exit(1)
-		}
-	case "commit":
-		commitCmd.Parse(os.Args[2:])
-		opts := commitCmd.Args()
-		if len(opts) < 1 {
-			fmt.Fprintf(os.Stderr, "commit command needs a message")
-			os.Exit(1)
-		}
-		indexService := mgi.NewIndexService(rootLocation)
-		obj := mgi.NewObjectService(rootLocation)
-		mgi := mgi.NewMGIService(rootLocation, obj, indexService)
-		err := mgi.Commit(opts[0])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error committing files: %v", err)
-			os.Exit(1)
-		}
-	case "status":
-		statusCmd.Parse(os.Args[2:])
-		opts := statusCmd.Args()
-		if len(opts) > 0 {
-			fmt.Fprintf(os.Stderr, "status command does not have arguments")
-			os.Exit(1)
-		}
+// commands is the registry of all subcommands, in the order they are listed in the usage.
+var commands []Command
 
-		indexService := mgi.NewIndexService(rootLocation)
-		obj := mgi.NewObjectService(rootLocation)
-		mgi := mgi.NewMGIService(rootLocation, obj, indexService)
+// register adds a command to the registry.
+func register(cmd Command) {
+	commands = append(commands, cmd)
+}
 
-		untracked, modified, err := mgi.Status()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error checking status: %v", err)
-			os.Exit(1)
+// findCommand returns the registered command with the given name.
+func findCommand(name string) (Command, bool) {
+	for _, cmd := range commands {
+		if cmd.Name() == name {
+			return cmd, true
 		}
+	}
+	return nil, false
+}
 
-		if len(untracked) > 0 {
-			fmt.Printf("Untracked files:\n")
-			for i := range untracked {
-				fmt.Printf("\t%s\n", untracked[i])
-			}
-		}
+// runFunc runs a command created with newCommand.
+type runFunc func(args []string, svc *services) error
 
-		if len(modified) > 0 {
-			fmt.Printf("Modified files:\n")
-			for i := range modified {
-				fmt.Printf("\t%s\n", modified[i])
-			}
-		}
-	case "diff":
-		diffCmd.Parse(os.Args[2:])
-		opts := diffCmd.Args()
-		if len(opts) > 0 {
-			fmt.Fprintf(os.Stderr, "diff command does not have arguments")
-			os.Exit(1)
-		}
+// funcCommand is a Command whose flags are defined by a setup function, which returns the
+// function that runs the command with those flags.
+type funcCommand struct {
+	flags *flag.FlagSet
+	run   runFunc
+}
 
-		indexService := mgi.NewIndexService(rootLocation)
-		obj := mgi.NewObjectService(rootLocation)
-		mgi := mgi.NewMGIService(rootLocation, obj, indexService)
+func newCommand(name string, setup func(flags *flag.FlagSet) runFunc) Command {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	return &funcCommand{flags: flags, run: setup(flags)}
+}
 
-		diffs, err := mgi.Diff()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error checking diff: %v", err)
-			os.Exit(1)
-		}
+func (c *funcCommand) Name() string {
+	return c.flags.Name()
+}
+
+func (c *funcCommand) FlagSet() *flag.FlagSet {
+	return c.flags
+}
+
+func (c *funcCommand) Run(args []string, svc *services) error {
+	return c.run(args, svc)
+}
+
+// exitError stops mgi with the given exit code, printing the message if there is one.
+type exitError struct {
+	code int
+	msg  string
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
+// failf returns an error that makes mgi print the message and exit with status 1.
+func failf(format string, args ...interface{}) error {
+	return &exitError{code: 1, msg: fmt.Sprintf(format, args...)}
+}
+
+// exit returns an error that makes mgi exit with the given status without printing anything.
+func exit(code int) error {
+	return &exitError{code: code}
+}
 
-		for i := range diffs {
-			fmt.Printf("%s\n", diffs[i])
+func main() {
+	verbose := flag.Bool("verbose", false, "log what mgi does to stderr")
+	noPager := flag.Bool("no-pager", false, "do not pipe the output into a pager")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 1 {
+		names := make([]string, 0, len(commands))
+		for _, cmd := range commands {
+			names = append(names, cmd.Name())
 		}
-	default:
+		fmt.Fprintf(os.Stderr, "Available subcommands: %s", strings.Join(names, ", "))
+		os.Exit(1)
+	}
+
+	cmd, ok := findCommand(args[0])
+	if !ok {
 		fmt.Fprintf(os.Stderr, "Unknown command")
 		os.Exit(1)
 	}
+	flags := cmd.FlagSet()
+	flags.Parse(args[1:])
+
+	stopPager := func() {}
+	if pagedCommands[cmd.Name()] && !*noPager {
+		stopPager = startPager()
+	}
+
+	logger := mgi.NewLogger(os.Stderr, *verbose)
+	svc, err := newServices(rootLocation, logger)
+	if err == nil {
+		err = cmd.Run(flags.Args(), svc)
+	}
+	stopPager()
+	if err != nil {
+		code := 1
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.code
+		}
+		if msg := err.Error(); msg != "" {
+			fmt.Fprintf(os.Stderr, "%s", msg)
+		}
+		os.Exit(code)
+	}
 }
 
 func doInit(root string) error {
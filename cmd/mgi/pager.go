@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// pagedCommands are the commands whose output goes through the pager.
+var pagedCommands = map[string]bool{
+	"diff": true,
+	"log":  true,
+	"show": true,
+}
+
+// startPager sends everything written to os.Stdout through $GIT_PAGER, $PAGER or "less" when
+// stdout is a terminal, like git does. It returns a function that must be called once all the
+// output is written, which waits for the user to quit the pager. If stdout isn't a terminal, or
+// the pager can't be started, output goes directly to stdout.
+func startPager() func() {
+	nop := func() {}
+
+	fi, err := os.Stdout.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nop
+	}
+
+	pager, ok := os.LookupEnv("GIT_PAGER")
+	if !ok {
+		pager, ok = os.LookupEnv("PAGER")
+	}
+	if !ok {
+		pager = "less"
+	}
+	if pager == "" || pager == "cat" {
+		return nop
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nop
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		// Quit if the output fits in one screen, keep colors and don't clear the screen
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		w.Close()
+		return nop
+	}
+
+	// If the pager exits early, writes to the pipe fail with EPIPE instead of killing mgi,
+	// since it is not file descriptor 1
+	stdout := os.Stdout
+	os.Stdout = w
+	return func() {
+		w.Close()
+		cmd.Wait()
+		os.Stdout = stdout
+	}
+}
@@ -0,0 +1,213 @@
+package mgi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultCommitGraphSize is how many parsed commits a CommitGraph keeps unless told otherwise.
+const DefaultCommitGraphSize = 100000
+
+// CommitGraph loads the commits of the repository as they are needed and keeps them parsed, so
+// that walking the history more than once, as describe and merge-base do, doesn't read and parse
+// the same objects again. Commits are immutable, so they never go stale. At most size commits are
+// kept: the ones loaded first are dropped to make room for new ones.
+//
+// If the repository has a commit-graph file, the walks that only need the parents and dates of
+// commits read them from it instead of parsing the commits.
+//
+// The commits it returns are shared and must not be modified.
+type CommitGraph struct {
+	obj     ObjectStore
+	size    int
+	commits map[string]*Commit
+	order   []string // hashes of the cached commits, in the order they were loaded
+	file    *commitGraphFile
+}
+
+// commitNode is what walking the history needs to know about a commit.
+type commitNode struct {
+	Hash       string
+	Parents    []string
+	CommitTime time.Time
+	// Generation is 1 for root commits and one more than the largest of the parents otherwise,
+	// or 0 if it is unknown because the commit isn't in the commit-graph file.
+	Generation uint32
+}
+
+// NewCommitGraph creates an empty CommitGraph that keeps at most size commits.
+func NewCommitGraph(obj ObjectStore, size int) *CommitGraph {
+	return &CommitGraph{
+		obj:     obj,
+		size:    size,
+		commits: make(map[string]*Commit),
+	}
+}
+
+// CommitGraph returns the commit graph of the repository, which is shared by all the operations
+// of the service.
+func (m *MGIService) CommitGraph() *CommitGraph {
+	if m.graph == nil {
+		m.graph = NewCommitGraph(m.obj, DefaultCommitGraphSize)
+		if o, ok := m.obj.(*ObjectService); ok {
+			f, err := loadCommitGraphFile(commitGraphPath(o.path))
+			switch {
+			case err == nil:
+				m.graph.file = f
+			case !errors.Is(err, os.ErrNotExist):
+				// The commits can still be read from the objects
+				m.logger.Debugf("ignoring the commit-graph file: %v", err)
+			}
+		}
+	}
+	return m.graph
+}
+
+// Commit returns the parsed commit with the given hash.
+func (g *CommitGraph) Commit(hash string) (*Commit, error) {
+	if c, ok := g.commits[hash]; ok {
+		return c, nil
+	}
+
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return nil, err
+	}
+	objType, data, err := g.obj.ReadTypedObject(h)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "commit" {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", hash, objType)
+	}
+	c, err := ParseCommit(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.size > 0 && len(g.order) >= g.size {
+		delete(g.commits, g.order[0])
+		g.order = g.order[1:]
+	}
+	g.commits[hash] = c
+	g.order = append(g.order, hash)
+	return c, nil
+}
+
+// node returns the parents and date of a commit, from the commit-graph file if it's there.
+func (g *CommitGraph) node(hash string) (*commitNode, error) {
+	if g.file != nil {
+		n, ok, err := g.file.node(hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return n, nil
+		}
+	}
+	c, err := g.Commit(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &commitNode{Hash: hash, Parents: c.Parents, CommitTime: c.CommitTime}, nil
+}
+
+// Parents returns the parents of a commit.
+func (g *CommitGraph) Parents(hash string) ([]string, error) {
+	n, err := g.node(hash)
+	if err != nil {
+		return nil, err
+	}
+	return n.Parents, nil
+}
+
+// Ancestors returns the commits reachable from the start commits, including themselves. Like
+// walkCommits, parents missing from the object store end the history.
+func (g *CommitGraph) Ancestors(start ...string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	queue := append([]string(nil), start...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if set[hash] {
+			continue
+		}
+		parents, err := g.Parents(hash)
+		if err != nil {
+			if len(set) > 0 && errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		set[hash] = true
+		queue = append(queue, parents...)
+	}
+	return set, nil
+}
+
+// TopoOrder returns the commits reachable from the start commits in topological order: every
+// commit comes before its parents, and the commits of each line of history are kept together,
+// with the last parent of a merge followed first, like git log --topo-order.
+func (g *CommitGraph) TopoOrder(start ...string) ([]string, error) {
+	ancestors, err := g.Ancestors(start...)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string]int, len(ancestors))
+	for hash := range ancestors {
+		parents, err := g.Parents(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			if ancestors[p] {
+				children[p]++
+			}
+		}
+	}
+
+	// The start commits are listed in the order given, the first one first
+	var stack []string
+	for i := len(start) - 1; i >= 0; i-- {
+		if children[start[i]] == 0 && !containsString(stack, start[i]) {
+			stack = append(stack, start[i])
+		}
+	}
+	order := make([]string, 0, len(ancestors))
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		order = append(order, hash)
+		parents, err := g.Parents(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			if !ancestors[p] {
+				continue
+			}
+			children[p]--
+			if children[p] == 0 {
+				stack = append(stack, p)
+			}
+		}
+	}
+	return order, nil
+}
+
+// LoadCommitGraph reads the commits reachable from the given revisions into the commit graph
+// ahead of time, e.g. to load only the branches an operation cares about.
+func (m *MGIService) LoadCommitGraph(revs ...string) error {
+	start := make([]string, 0, len(revs))
+	for _, rev := range revs {
+		hash, err := m.resolveCommit(rev)
+		if err != nil {
+			return err
+		}
+		start = append(start, hash)
+	}
+	_, err := m.CommitGraph().Ancestors(start...)
+	return err
+}
@@ -0,0 +1,176 @@
+package mgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteCommitGraph(t *testing.T) {
+	repo := newTestRepo(t)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := commitAt(t, repo, "root", now)
+	a := commitAt(t, repo, "a", now.Add(time.Hour), root)
+	b := commitAt(t, repo, "b", now.Add(2*time.Hour), root)
+	c := commitAt(t, repo, "c", now.Add(3*time.Hour), a)
+	// An octopus merge, whose parents after the first go in the edges chunk
+	octopus := commitAt(t, repo, "octopus", now.Add(4*time.Hour), c, a, b)
+	unreachable := commitAt(t, repo, "unreachable", now.Add(5*time.Hour), root)
+	err := repo.updateRef("refs/heads/master", "", octopus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := repo.WriteCommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("wrote %d commits, want 5", n)
+	}
+	f, err := loadCommitGraphFile(commitGraphPath(repo.Objects.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		hash       string
+		parents    []string
+		generation uint32
+		time       time.Time
+	}{
+		{root, nil, 1, now},
+		{a, []string{root}, 2, now.Add(time.Hour)},
+		{b, []string{root}, 2, now.Add(2 * time.Hour)},
+		{c, []string{a}, 3, now.Add(3 * time.Hour)},
+		{octopus, []string{c, a, b}, 4, now.Add(4 * time.Hour)},
+	} {
+		node, ok, err := f.node(tt.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("%s is not in the commit-graph", tt.hash)
+			continue
+		}
+		if !reflect.DeepEqual(node.Parents, tt.parents) || node.Generation != tt.generation || !node.CommitTime.Equal(tt.time) {
+			t.Errorf("%s: parents %v, generation %d and time %v, want %v, %d and %v",
+				tt.hash, node.Parents, node.Generation, node.CommitTime, tt.parents, tt.generation, tt.time)
+		}
+	}
+	if _, ok, _ := f.node(unreachable); ok {
+		t.Errorf("the unreachable commit %s is in the commit-graph", unreachable)
+	}
+}
+
+func TestCommitGraphWalks(t *testing.T) {
+	repo := newTestRepo(t)
+	commits := mergeHistory(t, repo)
+	err := repo.updateRef("refs/heads/side", "", commits["C"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Commits missing from the commit-graph are read from their objects
+	withCommitGraph(t, repo, func(repo *Repo, graph bool) {
+		tip := commits["M"]
+		if graph {
+			tip = commitAt(t, repo, "N", time.Unix(1000000500, 0).UTC(), commits["M"])
+		}
+		entries, err := repo.Log(tip, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"M", "C", "D", "B", "A"}
+		if graph {
+			want = append([]string{"N"}, want...)
+		}
+		if got := subjects(entries); !reflect.DeepEqual(got, want) {
+			t.Errorf("commit-graph %v: log listed %q, want %q", graph, got, want)
+		}
+		hashes, err := repo.RevList([]string{"side.." + tip}, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hashes) != len(want)-2 {
+			t.Errorf("commit-graph %v: rev-list listed %d commits, want %d", graph, len(hashes), len(want)-2)
+		}
+	})
+}
+
+func TestCorruptCommitGraphIsIgnored(t *testing.T) {
+	repo := newTestRepo(t)
+	mergeHistory(t, repo)
+	writeTestFile(t, commitGraphPath(repo.Objects.path), "CGPH garbage")
+
+	repo, err := NewRepo(repo.GitDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := repo.Log("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Errorf("log listed %d commits, want 5", len(entries))
+	}
+}
+
+func BenchmarkRevListCommitGraph(b *testing.B) {
+	dir := b.TempDir()
+	repo, err := NewRepo(dir, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	tree, err := repo.Objects.StoreObject(&Tree{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	var head string
+	for i := 0; i < 2000; i++ {
+		c := &Commit{
+			Tree:        tree.String(),
+			Author:      "A U Thor",
+			AuthorEmail: "author@example.com",
+			AuthorTime:  time.Unix(int64(1000000000+i), 0).UTC(),
+			Message:     fmt.Sprintf("commit %d\n", i),
+		}
+		if head != "" {
+			c.Parents = []string{head}
+		}
+		head, err = repo.writeCommit(c, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(repo.gitPath("HEAD"), []byte(head+"\n"), 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, graph := range []bool{false, true} {
+		if graph {
+			_, err := repo.WriteCommitGraph()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.Run(fmt.Sprintf("graph=%v", graph), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// A new instance, so nothing is kept from the previous walk
+				r, err := NewRepo(dir, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				hashes, err := r.RevList([]string{head}, -1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(hashes) != 2000 {
+					b.Fatalf("listed %d commits, want 2000", len(hashes))
+				}
+			}
+		})
+	}
+}
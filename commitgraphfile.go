@@ -0,0 +1,403 @@
+package mgi
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The commit-graph file stores the parents, tree, commit time and generation number of commits,
+// in the format git uses for objects/info/commit-graph (version 1), so that walking the history
+// doesn't need to read and parse commit objects.
+const (
+	commitGraphSignature = "CGPH"
+	commitGraphVersion   = 1
+	commitGraphHashSHA1  = 1
+
+	commitGraphChunkFanout = "OIDF" // 256 cumulative counts of commits by first hash byte
+	commitGraphChunkLookup = "OIDL" // the hashes of the commits, sorted
+	commitGraphChunkData   = "CDAT" // tree, first two parents, generation and time of each
+	commitGraphChunkEdges  = "EDGE" // the other parents of octopus merges
+
+	commitGraphDataSize = sha1.Size + 16
+
+	// commitGraphNoParent marks a missing parent, and commitGraphEdge a second parent field
+	// that is the index of the rest of the parents in the edges chunk.
+	commitGraphNoParent = 0x70000000
+	commitGraphEdge     = 0x80000000
+
+	// commitGraphMaxGeneration is the largest generation number that fits in a commit-graph.
+	commitGraphMaxGeneration = 0x3fffffff
+)
+
+// commitGraphFile is a parsed commit-graph file.
+type commitGraphFile struct {
+	fanout []byte
+	lookup []byte
+	data   []byte
+	edges  []byte
+	count  int
+}
+
+// commitGraphPath returns the path of the commit-graph file of an object directory.
+func commitGraphPath(objects string) string {
+	return filepath.Join(objects, "info", "commit-graph")
+}
+
+// loadCommitGraphFile reads the commit-graph file at path. It returns an error that satisfies
+// errors.Is(err, os.ErrNotExist) if there is none.
+func loadCommitGraphFile(path string) (*commitGraphFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+12+sha1.Size || string(data[:4]) != commitGraphSignature {
+		return nil, fmt.Errorf("%s: not a commit-graph file", path)
+	}
+	if data[4] != commitGraphVersion || data[5] != commitGraphHashSHA1 {
+		return nil, fmt.Errorf("%s: unsupported commit-graph version %d with hash version %d", path, data[4], data[5])
+	}
+	if data[7] != 0 {
+		return nil, fmt.Errorf("%s: split commit-graphs are not supported", path)
+	}
+
+	f := new(commitGraphFile)
+	chunks := int(data[6])
+	if len(data) < 8+12*(chunks+1)+sha1.Size {
+		return nil, fmt.Errorf("%s: truncated commit-graph file", path)
+	}
+	end := uint64(len(data) - sha1.Size)
+	for i := 0; i < chunks; i++ {
+		entry := data[8+12*i:]
+		id := string(entry[:4])
+		start := binary.BigEndian.Uint64(entry[4:12])
+		next := binary.BigEndian.Uint64(entry[16:24])
+		if start > next || next > end {
+			return nil, fmt.Errorf("%s: invalid offsets of chunk %s", path, id)
+		}
+		chunk := data[start:next]
+		switch id {
+		case commitGraphChunkFanout:
+			f.fanout = chunk
+		case commitGraphChunkLookup:
+			f.lookup = chunk
+		case commitGraphChunkData:
+			f.data = chunk
+		case commitGraphChunkEdges:
+			f.edges = chunk
+		}
+	}
+	if len(f.fanout) != 256*4 {
+		return nil, fmt.Errorf("%s: missing or invalid OID fanout chunk", path)
+	}
+	f.count = int(binary.BigEndian.Uint32(f.fanout[255*4:]))
+	if len(f.lookup) != f.count*sha1.Size || len(f.data) != f.count*commitGraphDataSize {
+		return nil, fmt.Errorf("%s: OID lookup or commit data chunk does not match the fanout", path)
+	}
+	return f, nil
+}
+
+// position returns the position of the commit in the file, if it is there.
+func (f *commitGraphFile) position(hash *Hash) (int, bool) {
+	first := hash.Bytes()[0]
+	lo := 0
+	if first > 0 {
+		lo = int(binary.BigEndian.Uint32(f.fanout[4*(int(first)-1):]))
+	}
+	hi := int(binary.BigEndian.Uint32(f.fanout[4*int(first):]))
+	if hi > f.count || lo > hi {
+		return 0, false
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(f.lookup[(lo+i)*sha1.Size:(lo+i+1)*sha1.Size], hash.Bytes()) >= 0
+	})
+	if i < hi && bytes.Equal(f.lookup[i*sha1.Size:(i+1)*sha1.Size], hash.Bytes()) {
+		return i, true
+	}
+	return 0, false
+}
+
+// hashAt returns the hash of the commit at the given position.
+func (f *commitGraphFile) hashAt(pos int) string {
+	return new(Hash).FromSHA1Bytes(f.lookup[pos*sha1.Size : (pos+1)*sha1.Size]).String()
+}
+
+// node returns what the file knows about the commit, or false if it isn't in the file.
+func (f *commitGraphFile) node(hash string) (*commitNode, bool, error) {
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return nil, false, err
+	}
+	pos, ok := f.position(h)
+	if !ok {
+		return nil, false, nil
+	}
+
+	d := f.data[pos*commitGraphDataSize : (pos+1)*commitGraphDataSize]
+	n := &commitNode{Hash: hash}
+	parentAt := func(p uint32) (string, error) {
+		if int(p) >= f.count {
+			return "", fmt.Errorf("commit-graph: invalid parent position %d of %s", p, hash)
+		}
+		return f.hashAt(int(p)), nil
+	}
+	first := binary.BigEndian.Uint32(d[sha1.Size:])
+	if first != commitGraphNoParent {
+		p, err := parentAt(first)
+		if err != nil {
+			return nil, false, err
+		}
+		n.Parents = append(n.Parents, p)
+	}
+	second := binary.BigEndian.Uint32(d[sha1.Size+4:])
+	switch {
+	case second == commitGraphNoParent:
+	case second&commitGraphEdge != 0:
+		for i := int(second &^ commitGraphEdge); ; i++ {
+			if 4*(i+1) > len(f.edges) {
+				return nil, false, fmt.Errorf("commit-graph: invalid edge list of %s", hash)
+			}
+			e := binary.BigEndian.Uint32(f.edges[4*i:])
+			p, err := parentAt(e &^ commitGraphEdge)
+			if err != nil {
+				return nil, false, err
+			}
+			n.Parents = append(n.Parents, p)
+			if e&commitGraphEdge != 0 {
+				break
+			}
+		}
+	default:
+		p, err := parentAt(second)
+		if err != nil {
+			return nil, false, err
+		}
+		n.Parents = append(n.Parents, p)
+	}
+
+	high := binary.BigEndian.Uint32(d[sha1.Size+8:])
+	low := binary.BigEndian.Uint32(d[sha1.Size+12:])
+	n.Generation = high >> 2
+	n.CommitTime = time.Unix(int64(high&3)<<32|int64(low), 0)
+	return n, true, nil
+}
+
+// WriteCommitGraph writes the commit-graph file of the repository, objects/info/commit-graph,
+// with every commit reachable from the refs and HEAD, like git commit-graph write --reachable.
+// The history walks of later operations read the parents of these commits from it instead of
+// parsing the commit objects. It returns the number of commits written.
+func (m *MGIService) WriteCommitGraph() (int, error) {
+	objects, err := m.diskObjects()
+	if err != nil {
+		return 0, err
+	}
+	shallow, err := m.readShallow()
+	if err != nil {
+		return 0, err
+	}
+	if len(shallow) > 0 {
+		return 0, fmt.Errorf("commit-graphs of shallow repositories are not supported")
+	}
+
+	refs, err := m.listRefs("refs/")
+	if err != nil {
+		return 0, err
+	}
+	var start []string
+	for _, r := range refs {
+		commit, err := m.peelCommit(r.Hash)
+		if err != nil {
+			// Refs may point to other objects, e.g. tags of trees
+			continue
+		}
+		start = append(start, commit)
+	}
+	head, err := m.currentHead()
+	if err != nil {
+		return 0, err
+	}
+	if head != "" {
+		start = append(start, head)
+	}
+
+	commits := make(map[string]*Commit)
+	err = m.walkCommits(start, func(hash string, c *Commit) error {
+		commits[hash] = c
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	hashes := make([]*Hash, 0, len(commits))
+	for hash, c := range commits {
+		for _, p := range c.Parents {
+			if _, ok := commits[p]; !ok {
+				return 0, fmt.Errorf("parent %s of commit %s is missing", p, hash)
+			}
+		}
+		h, err := new(Hash).FromString(hash)
+		if err != nil {
+			return 0, err
+		}
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i].Bytes(), hashes[j].Bytes()) < 0
+	})
+	positions := make(map[string]uint32, len(hashes))
+	for i, h := range hashes {
+		positions[h.String()] = uint32(i)
+	}
+
+	generations := commitGenerations(commits)
+
+	var fanout, lookup, data, edges bytes.Buffer
+	var counts [256]uint32
+	for _, h := range hashes {
+		counts[h.Bytes()[0]]++
+	}
+	var total uint32
+	for _, n := range counts {
+		total += n
+		binary.Write(&fanout, binary.BigEndian, total)
+	}
+	for _, h := range hashes {
+		lookup.Write(h.Bytes())
+
+		c := commits[h.String()]
+		tree, err := new(Hash).FromString(c.Tree)
+		if err != nil {
+			return 0, err
+		}
+		data.Write(tree.Bytes())
+		parents := [2]uint32{commitGraphNoParent, commitGraphNoParent}
+		for i, p := range c.Parents {
+			if i < 2 {
+				parents[i] = positions[p]
+			}
+		}
+		if len(c.Parents) > 2 {
+			parents[1] = commitGraphEdge | uint32(edges.Len()/4)
+			for i, p := range c.Parents[1:] {
+				e := positions[p]
+				if i == len(c.Parents)-2 {
+					e |= commitGraphEdge
+				}
+				binary.Write(&edges, binary.BigEndian, e)
+			}
+		}
+		binary.Write(&data, binary.BigEndian, parents)
+		seconds := c.CommitTime.Unix()
+		if seconds < 0 || seconds >= 1<<34 {
+			seconds = 0
+		}
+		gen := generations[h.String()]
+		binary.Write(&data, binary.BigEndian, gen<<2|uint32(seconds>>32))
+		binary.Write(&data, binary.BigEndian, uint32(seconds))
+	}
+
+	chunks := []struct {
+		id   string
+		data []byte
+	}{
+		{commitGraphChunkFanout, fanout.Bytes()},
+		{commitGraphChunkLookup, lookup.Bytes()},
+		{commitGraphChunkData, data.Bytes()},
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, struct {
+			id   string
+			data []byte
+		}{commitGraphChunkEdges, edges.Bytes()})
+	}
+
+	var out bytes.Buffer
+	out.WriteString(commitGraphSignature)
+	out.Write([]byte{commitGraphVersion, commitGraphHashSHA1, byte(len(chunks)), 0})
+	offset := uint64(8 + 12*(len(chunks)+1))
+	for _, c := range chunks {
+		out.WriteString(c.id)
+		binary.Write(&out, binary.BigEndian, offset)
+		offset += uint64(len(c.data))
+	}
+	out.Write([]byte{0, 0, 0, 0})
+	binary.Write(&out, binary.BigEndian, offset)
+	for _, c := range chunks {
+		out.Write(c.data)
+	}
+	sum := sha1.Sum(out.Bytes())
+	out.Write(sum[:])
+
+	path := commitGraphPath(objects.path)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return 0, err
+	}
+	lock, err := acquireLock(path)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.rollback()
+	lock.fsync = m.fsync
+	_, err = lock.Write(out.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	err = lock.commit()
+	if err != nil {
+		return 0, err
+	}
+	m.logger.Debugf("wrote %d commits to %s", len(hashes), path)
+
+	// Walks pick up the new file
+	m.graph = nil
+	return len(hashes), nil
+}
+
+// commitGenerations returns the generation number of each commit: 1 for root commits, and one
+// more than the largest of its parents otherwise. The parents of every commit must be given.
+func commitGenerations(commits map[string]*Commit) map[string]uint32 {
+	generations := make(map[string]uint32, len(commits))
+	for hash := range commits {
+		if generations[hash] != 0 {
+			continue
+		}
+		// Depth-first, so that deep histories don't overflow the stack
+		stack := []string{hash}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if generations[top] != 0 {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			gen := uint32(1)
+			pending := false
+			for _, p := range commits[top].Parents {
+				g := generations[p]
+				if g == 0 {
+					stack = append(stack, p)
+					pending = true
+					continue
+				}
+				if g+1 > gen {
+					gen = g + 1
+				}
+			}
+			if pending {
+				continue
+			}
+			if gen > commitGraphMaxGeneration {
+				gen = commitGraphMaxGeneration
+			}
+			generations[top] = gen
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return generations
+}
@@ -0,0 +1,255 @@
+package mgi
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// EditCommitMessage opens the user's editor on COMMIT_EDITMSG, pre-filled with the initial
+// message, if any, and a summary of the changes in comment lines, and returns the message without
+// the comments. It fails if the message is empty, which aborts the commit.
+func (m *MGIService) EditCommitMessage(initial string) (string, error) {
+	template, err := m.commitTemplate()
+	if err != nil {
+		return "", err
+	}
+	if initial != "" {
+		template = strings.TrimRight(initial, "\n") + "\n" + template
+	}
+	path := m.gitPath("COMMIT_EDITMSG")
+	err = ioutil.WriteFile(path, []byte(template), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	err = runEditor(path)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	message := cleanupMessage(string(data))
+	if message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	return message, nil
+}
+
+// FixupMessage returns the message of a commit that fixes up the commit named by rev, or that
+// squashes into it if squash is set: the subject of that commit with a "fixup! " or "squash! "
+// prefix, which tells a rebase with autosquash where the new commit goes (see RebaseTodo).
+func (m *MGIService) FixupMessage(rev string, squash bool) (string, error) {
+	hash, err := m.resolveCommit(rev)
+	if err != nil {
+		return "", err
+	}
+	c, err := m.readCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	prefix := "fixup! "
+	if squash {
+		prefix = "squash! "
+	}
+	return prefix + subject(c.Message), nil
+}
+
+// commitTemplate returns the initial contents of COMMIT_EDITMSG.
+func (m *MGIService) commitTemplate() (string, error) {
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString("# Please enter the commit message for your changes. Lines starting\n")
+	b.WriteString("# with '#' will be ignored, and an empty message aborts the commit.\n")
+	b.WriteString("#\n")
+
+	branch, err := m.headRef()
+	if err != nil {
+		return "", err
+	}
+	if branch == "" {
+		b.WriteString("# HEAD detached\n")
+	} else {
+		fmt.Fprintf(&b, "# On branch %s\n", strings.TrimPrefix(branch, "refs/heads/"))
+	}
+
+	staged, err := m.stagedChanges()
+	if err != nil {
+		return "", err
+	}
+	untracked, modified, intentToAdd, err := m.Status()
+	if err != nil {
+		return "", err
+	}
+
+	writeSection := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "#\n# %s:\n", title)
+		for _, l := range lines {
+			fmt.Fprintf(&b, "#\t%s\n", l)
+		}
+	}
+	writeSection("Changes to be committed", staged)
+	var unstaged []string
+	for _, path := range modified {
+		unstaged = append(unstaged, "modified:   "+path)
+	}
+	for _, path := range intentToAdd {
+		unstaged = append(unstaged, "new file:   "+path)
+	}
+	writeSection("Changes not staged for commit", unstaged)
+	writeSection("Untracked files", untracked)
+	return b.String(), nil
+}
+
+// stagedChanges describes the differences between HEAD and the index, e.g. "new file:   a.txt".
+func (m *MGIService) stagedChanges() ([]string, error) {
+	headFiles, err := m.headFiles()
+	if err != nil {
+		return nil, err
+	}
+	indexFiles, err := m.indexFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	for path, e := range indexFiles {
+		old, ok := headFiles[path]
+		if !ok {
+			changes = append(changes, "new file:   "+path)
+		} else if old.Hash.String() != e.Hash.String() || old.Mode != e.Mode {
+			changes = append(changes, "modified:   "+path)
+		}
+	}
+	for path := range headFiles {
+		if _, ok := indexFiles[path]; !ok {
+			changes = append(changes, "deleted:    "+path)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i][12:] < changes[j][12:]
+	})
+	return changes, nil
+}
+
+// runEditor opens the file in $GIT_EDITOR, $EDITOR or vi, and waits for it to exit.
+func runEditor(path string) error {
+	editor := os.Getenv("GIT_EDITOR")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// Go through the shell, since the editor may come with arguments (e.g. "code --wait")
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("there was a problem with the editor %q: %v", editor, err)
+	}
+	return nil
+}
+
+// cleanupMessage removes comment lines, trailing whitespace and surrounding blank lines from a
+// message written in the editor.
+func cleanupMessage(text string) string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// Trailer is a "Key: value" line in the last paragraph of a commit message, such as
+// "Signed-off-by: Name <email>".
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+func (t Trailer) String() string {
+	return t.Key + ": " + t.Value
+}
+
+// ParseTrailers returns the trailers of a message. The last paragraph of the message is its
+// trailer block if every line in it is a trailer, or the continuation of one (a line starting
+// with whitespace). The first paragraph is the subject, so it is never a trailer block.
+func ParseTrailers(message string) []Trailer {
+	trailers, _ := trailerBlock(message)
+	return trailers
+}
+
+// AppendTrailer adds the trailer at the end of the trailer block of the message, which is
+// started, separated from the rest by a blank line, if the message doesn't have one. The
+// message is returned unchanged if the block already has the same trailer.
+func AppendTrailer(message string, t Trailer) string {
+	trailers, ok := trailerBlock(message)
+	for _, existing := range trailers {
+		if existing == t {
+			return message
+		}
+	}
+
+	body := strings.TrimRight(message, "\n")
+	separator := "\n\n"
+	switch {
+	case body == "":
+		separator = ""
+	case ok:
+		separator = "\n"
+	}
+	appended := body + separator + t.String()
+	if strings.HasSuffix(message, "\n") {
+		appended += "\n"
+	}
+	return appended
+}
+
+// SignOff returns the Signed-off-by trailer of the current user, the same identity commits are
+// authored with.
+func SignOff() Trailer {
+	name, email := identity()
+	return Trailer{Key: "Signed-off-by", Value: fmt.Sprintf("%s <%s>", name, email)}
+}
+
+// trailerBlock parses the trailers in the last paragraph of the message, and returns whether
+// the paragraph is a trailer block.
+func trailerBlock(message string) ([]Trailer, bool) {
+	paragraphs := strings.Split(strings.Trim(message, "\n"), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil, false
+	}
+
+	var trailers []Trailer
+	for _, line := range strings.Split(strings.Trim(paragraphs[len(paragraphs)-1], "\n"), "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(trailers) > 0 {
+			trailers[len(trailers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 || strings.TrimLeft(line[:i], "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			return nil, false
+		}
+		trailers = append(trailers, Trailer{Key: line[:i], Value: strings.TrimSpace(line[i+1:])})
+	}
+	return trailers, true
+}
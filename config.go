@@ -0,0 +1,354 @@
+package mgi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config represents a git configuration file, typically ".git/config".
+// Lines that are not touched by Set or Unset, including comments, are kept verbatim.
+type Config struct {
+	lines []*configLine
+}
+
+// configLine is a single line of a configuration file.
+type configLine struct {
+	raw     string
+	section string // section name, with the subsection if any (e.g. "remote.origin")
+	key     string // empty for section headers, comments and blank lines
+	value   string
+}
+
+// ConfigEntry is a key-value pair, where the key is in its dotted form (e.g. "user.name").
+type ConfigEntry struct {
+	Key   string
+	Value string
+}
+
+// Get returns the last value set for the given key.
+func (c *Config) Get(key string) (string, bool) {
+	section, name, err := splitConfigKey(key)
+	if err != nil {
+		return "", false
+	}
+
+	var value string
+	var found bool
+	for _, l := range c.lines {
+		if l.key == name && l.section == section {
+			value = l.value
+			found = true
+		}
+	}
+	return value, found
+}
+
+// GetBool returns the value of a boolean key, which is false if the key is not set. Like in git,
+// "true", "yes", "on" and "1" are true, and "false", "no", "off", "0" and "" are false.
+func (c *Config) GetBool(key string) (bool, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return false, nil
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean value %q for %s", value, key)
+}
+
+// GetSize returns the value of a size key, which is 0 if the key is not set. Like in git, the
+// value may end with "k", "m" or "g" for kibibytes, mebibytes and gibibytes.
+func (c *Config) GetSize(key string) (int64, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return 0, nil
+	}
+	number, unit := value, int64(1)
+	if n := len(value); n > 0 {
+		switch strings.ToLower(value[n-1:]) {
+		case "k":
+			number, unit = value[:n-1], 1<<10
+		case "m":
+			number, unit = value[:n-1], 1<<20
+		case "g":
+			number, unit = value[:n-1], 1<<30
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 || size > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid size %q for %s", value, key)
+	}
+	return size * unit, nil
+}
+
+// Set updates the value of the given key, creating its section if needed.
+func (c *Config) Set(key, value string) error {
+	section, name, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Replace the last occurrence of the key, if any
+	for i := len(c.lines) - 1; i >= 0; i-- {
+		l := c.lines[i]
+		if l.key == name && l.section == section {
+			c.lines[i] = newConfigValueLine(section, name, value)
+			return nil
+		}
+	}
+
+	// Otherwise append it to the last line of its section
+	last := -1
+	for i, l := range c.lines {
+		if l.section == section {
+			last = i
+		}
+	}
+	if last < 0 {
+		c.lines = append(c.lines, newConfigSectionLine(section), newConfigValueLine(section, name, value))
+		return nil
+	}
+
+	lines := make([]*configLine, 0, len(c.lines)+1)
+	lines = append(lines, c.lines[:last+1]...)
+	lines = append(lines, newConfigValueLine(section, name, value))
+	c.lines = append(lines, c.lines[last+1:]...)
+	return nil
+}
+
+// Unset removes all values of the given key. It returns os.ErrNotExist if the key is not set.
+func (c *Config) Unset(key string) error {
+	section, name, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	lines := c.lines[:0]
+	var removed bool
+	for _, l := range c.lines {
+		if l.key == name && l.section == section {
+			removed = true
+			continue
+		}
+		lines = append(lines, l)
+	}
+	c.lines = lines
+
+	if !removed {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+// List returns all entries in the order they appear in the file.
+func (c *Config) List() []*ConfigEntry {
+	var entries []*ConfigEntry
+	for _, l := range c.lines {
+		if l.key == "" {
+			continue
+		}
+		entries = append(entries, &ConfigEntry{Key: l.section + "." + l.key, Value: l.value})
+	}
+	return entries
+}
+
+// Marshal serializes the configuration into the format used on disk.
+func (c *Config) Marshal() ([]byte, error) {
+	b := new(bytes.Buffer)
+	for _, l := range c.lines {
+		b.WriteString(l.raw)
+		b.WriteString("\n")
+	}
+	return b.Bytes(), nil
+}
+
+// ConfigService reads and writes the repository configuration file.
+type ConfigService struct {
+	path   string
+	config *Config
+}
+
+// NewConfigService creates a new ConfigService.
+func NewConfigService(root string) *ConfigService {
+	return &ConfigService{
+		path:   filepath.Join(root, "config"),
+		config: &Config{},
+	}
+}
+
+// Read parses the configuration file. A missing file results in an empty configuration.
+func (s *ConfigService) Read() (*Config, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.config = &Config{}
+		return s.config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", s.path, err)
+	}
+	s.config = config
+	return s.config, nil
+}
+
+// Store writes the configuration file to disk, creating it if needed.
+func (s *ConfigService) Store() error {
+	data, err := s.config.Marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+func parseConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	var section string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		l := &configLine{raw: raw, section: section}
+
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			// Blank lines and comments are kept as they are
+		case line[0] == '[':
+			s, err := parseConfigSection(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			section = s
+			l.section = s
+		default:
+			if section == "" {
+				return nil, fmt.Errorf("line %d: key outside of a section", n)
+			}
+			key, value, err := parseConfigValue(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			l.key = key
+			l.value = value
+		}
+		c.lines = append(c.lines, l)
+	}
+
+	return c, scanner.Err()
+}
+
+// parseConfigSection parses headers like `[core]` and `[remote "origin"]`.
+func parseConfigSection(line string) (string, error) {
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return "", fmt.Errorf("unterminated section header %q", line)
+	}
+	header := strings.TrimSpace(line[1:end])
+
+	i := strings.IndexByte(header, '"')
+	if i < 0 {
+		// The deprecated [section.subsection] syntax only lowercases the section
+		name, sub := header, ""
+		if dot := strings.IndexByte(header, '.'); dot >= 0 {
+			name, sub = header[:dot], header[dot:]
+		}
+		return strings.ToLower(name) + sub, nil
+	}
+
+	name := strings.ToLower(strings.TrimSpace(header[:i]))
+	sub := header[i+1:]
+	if !strings.HasSuffix(sub, `"`) {
+		return "", fmt.Errorf("unterminated subsection in %q", line)
+	}
+	sub = strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(sub[:len(sub)-1])
+	return name + "." + sub, nil
+}
+
+// parseConfigValue parses lines like `name = value`. A key without a value means true.
+func parseConfigValue(line string) (string, string, error) {
+	eq := strings.IndexByte(line, '=')
+	if eq < 0 {
+		return strings.ToLower(line), "true", nil
+	}
+	key := strings.ToLower(strings.TrimSpace(line[:eq]))
+	if key == "" {
+		return "", "", fmt.Errorf("missing key in %q", line)
+	}
+
+	var value strings.Builder
+	var quoted bool
+	raw := strings.TrimSpace(line[eq+1:])
+	for i := 0; i < len(raw); i++ {
+		ch := raw[i]
+		switch {
+		case ch == '"':
+			quoted = !quoted
+		case ch == '\\' && i+1 < len(raw):
+			i++
+			switch raw[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(raw[i])
+			}
+		case (ch == '#' || ch == ';') && !quoted:
+			return key, strings.TrimSpace(value.String()), nil
+		default:
+			value.WriteByte(ch)
+		}
+	}
+	if quoted {
+		return "", "", fmt.Errorf("unterminated quote in %q", line)
+	}
+	return key, strings.TrimSpace(value.String()), nil
+}
+
+// splitConfigKey splits a dotted key into its section (with subsection) and name.
+func splitConfigKey(key string) (string, string, error) {
+	first := strings.IndexByte(key, '.')
+	last := strings.LastIndexByte(key, '.')
+	if first <= 0 || last == len(key)-1 {
+		return "", "", fmt.Errorf("key %q does not contain a section and a name", key)
+	}
+	section := strings.ToLower(key[:first]) + key[first:last]
+	return section, strings.ToLower(key[last+1:]), nil
+}
+
+func newConfigSectionLine(section string) *configLine {
+	raw := "[" + section + "]"
+	if i := strings.IndexByte(section, '.'); i >= 0 {
+		sub := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(section[i+1:])
+		raw = fmt.Sprintf("[%s \"%s\"]", section[:i], sub)
+	}
+	return &configLine{raw: raw, section: section}
+}
+
+func newConfigValueLine(section, key, value string) *configLine {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if escaped != strings.TrimSpace(escaped) || strings.ContainsAny(escaped, "#;") {
+		escaped = `"` + escaped + `"`
+	}
+	return &configLine{
+		raw:     fmt.Sprintf("\t%s = %s", key, escaped),
+		section: section,
+		key:     key,
+		value:   value,
+	}
+}
@@ -0,0 +1,159 @@
+package mgi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateUnits are the units accepted in relative dates such as "2.weeks.ago". Months and years are
+// approximated as 30 and 365 days.
+var dateUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  30 * 24 * time.Hour,
+	"year":   365 * 24 * time.Hour,
+}
+
+// dateLayouts are the absolute date formats parseDate accepts, in local time unless a zone is given.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseDate parses a date the way git's expiry options do: "now" (or "all"), "never" (or
+// "false"), a relative date like "90.days.ago" or "3 hours ago", an absolute date like
+// "2021-05-01 12:00:00", or a Unix timestamp like "@1620000000". "never" is the zero time.
+func parseDate(spec string, now time.Time) (time.Time, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "now", "all":
+		return now, nil
+	case "never", "false":
+		return time.Time{}, nil
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		sec, err := strconv.ParseInt(spec[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp")
+		}
+		return time.Unix(sec, 0), nil
+	}
+
+	fields := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == '.' || r == ' '
+	})
+	if len(fields) == 3 && fields[2] == "ago" {
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid number %q", fields[0])
+		}
+		unit, ok := dateUnits[strings.TrimSuffix(fields[1], "s")]
+		if !ok {
+			return time.Time{}, fmt.Errorf("unknown unit %q", fields[1])
+		}
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+
+	for _, layout := range dateLayouts {
+		t, err := time.ParseInLocation(layout, spec, now.Location())
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date")
+}
+
+// DateFormat is how dates are written in the log, like git's --date option.
+type DateFormat string
+
+const (
+	// DateDefault is like "Mon Jan 2 15:04:05 2006 -0700".
+	DateDefault DateFormat = "default"
+	// DateISO is like "2006-01-02 15:04:05 -0700".
+	DateISO DateFormat = "iso"
+	// DateRelative is like "3 days ago".
+	DateRelative DateFormat = "relative"
+	// DateUnix is the number of seconds since the epoch.
+	DateUnix DateFormat = "unix"
+	// DateShort is like "2006-01-02".
+	DateShort DateFormat = "short"
+)
+
+// ParseDateFormat parses the value of --date.
+func ParseDateFormat(s string) (DateFormat, error) {
+	switch f := DateFormat(s); f {
+	case DateDefault, DateISO, DateRelative, DateUnix, DateShort:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown date format %s", s)
+}
+
+// formatDate writes t in the given format, in the time zone it was recorded in. Relative dates
+// are relative to now.
+func formatDate(t time.Time, f DateFormat, now time.Time) string {
+	switch f {
+	case DateISO:
+		return t.Format("2006-01-02 15:04:05 -0700")
+	case DateRelative:
+		return relativeDate(t, now)
+	case DateUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case DateShort:
+		return t.Format("2006-01-02")
+	}
+	return t.Format("Mon Jan 2 15:04:05 2006 -0700")
+}
+
+// relativeDate describes how long before now t is, rounding like git does: seconds up to 90
+// seconds, then minutes up to 90 minutes, hours up to 36 hours, days up to two weeks, weeks up
+// to 10 weeks, months up to a year, years and months up to 5 years and years after that.
+func relativeDate(t, now time.Time) string {
+	if t.After(now) {
+		return "in the future"
+	}
+	diff := int64(now.Sub(t) / time.Second)
+	if diff < 90 {
+		return plural(diff, "second") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return plural(diff, "minute") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return plural(diff, "hour") + " ago"
+	}
+	diff = (diff + 12) / 24
+	switch {
+	case diff < 14:
+		return plural(diff, "day") + " ago"
+	case diff < 70:
+		return plural((diff+3)/7, "week") + " ago"
+	case diff < 365:
+		return plural((diff+15)/30, "month") + " ago"
+	case diff < 1825:
+		months := (diff*12*2 + 365) / (365 * 2)
+		if months%12 == 0 {
+			return plural(months/12, "year") + " ago"
+		}
+		return plural(months/12, "year") + ", " + plural(months%12, "month") + " ago"
+	}
+	return plural((diff+183)/365, "year") + " ago"
+}
+
+// plural returns "1 <unit>" or "<n> <unit>s".
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
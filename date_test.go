@@ -0,0 +1,60 @@
+package mgi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDate(t *testing.T) {
+	when := time.Date(2005, 4, 7, 15, 13, 13, 0, time.FixedZone("", -(90*60)))
+	for _, tt := range []struct {
+		format DateFormat
+		want   string
+	}{
+		{DateDefault, "Thu Apr 7 15:13:13 2005 -0130"},
+		{DateISO, "2005-04-07 15:13:13 -0130"},
+		{DateShort, "2005-04-07"},
+		{DateUnix, "1112892193"},
+		{DateRelative, "2 hours ago"},
+	} {
+		f, err := ParseDateFormat(string(tt.format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatDate(when, f, when.Add(2*time.Hour)); got != tt.want {
+			t.Errorf("formatDate in %s format = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+	if _, err := ParseDateFormat("rfc"); err == nil {
+		t.Error("ParseDateFormat accepted an unknown format")
+	}
+}
+
+func TestRelativeDate(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	day := 24 * time.Hour
+	for _, tt := range []struct {
+		ago  time.Duration
+		want string
+	}{
+		{0, "0 seconds ago"},
+		{time.Second, "1 second ago"},
+		{89 * time.Second, "89 seconds ago"},
+		{90 * time.Second, "2 minutes ago"},
+		{5369 * time.Second, "89 minutes ago"},
+		{5370 * time.Second, "2 hours ago"},
+		{35 * time.Hour, "35 hours ago"},
+		{36 * time.Hour, "2 days ago"},
+		{13 * day, "13 days ago"},
+		{14 * day, "2 weeks ago"},
+		{100 * day, "3 months ago"},
+		{400 * day, "1 year, 1 month ago"},
+		{730 * day, "2 years ago"},
+		{2000 * day, "5 years ago"},
+		{-time.Second, "in the future"},
+	} {
+		if got := relativeDate(now.Add(-tt.ago), now); got != tt.want {
+			t.Errorf("relativeDate %v ago = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package mgi
+
+import (
+	"errors"
+	"io"
+)
+
+// maxDeltaDepth bounds the chains of deltas that are resolved, each delta having another delta
+// as its base, so that a corrupt pack can't make readers recurse forever. git writes chains of
+// at most 50 deltas by default, and never more than 4095.
+const maxDeltaDepth = 4095
+
+// readOfsDeltaOffset reads the distance from an OFS_DELTA object back to its base, which is
+// encoded in a variant of the usual variable-length integers where each continuation adds one.
+func readOfsDeltaOffset(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	offset := uint64(b & 0x7f)
+	for b&0x80 != 0 {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if offset >= 1<<56 {
+			return 0, errors.New("delta base offset overflows")
+		}
+		offset = ((offset + 1) << 7) | uint64(b&0x7f)
+	}
+	return offset, nil
+}
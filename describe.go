@@ -0,0 +1,93 @@
+package mgi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDescribeCandidates is the number of tags considered before picking the closest one.
+const maxDescribeCandidates = 10
+
+// Describe names the HEAD commit after the nearest tag it can reach, in the form
+// "<tag>-<n>-g<short hash>", where n is the number of commits since the tag.
+// When no tag is reachable, the short hash is returned if always is set.
+func (m *MGIService) Describe(always bool) (string, error) {
+	head, err := m.currentHead()
+	if err != nil {
+		return "", err
+	}
+	if head == "" {
+		return "", fmt.Errorf("no commits yet")
+	}
+	headHash, err := new(Hash).FromString(head)
+	if err != nil {
+		return "", err
+	}
+
+	// Index the tags by the commit they point to
+	refs, err := m.listRefs("refs/tags/")
+	if err != nil {
+		return "", err
+	}
+	tags := make(map[string]string)
+	for _, r := range refs {
+		commit, err := m.peelCommit(r.Hash)
+		if err != nil {
+			// Tags pointing to trees or blobs can't describe a commit
+			continue
+		}
+		if _, ok := tags[commit]; !ok {
+			tags[commit] = strings.TrimPrefix(r.Name, "refs/tags/")
+		}
+	}
+
+	if name, ok := tags[head]; ok {
+		return name, nil
+	}
+
+	// Collect the tags closest to HEAD
+	var candidates []string
+	err = m.walkNodes([]string{head}, func(n *commitNode) error {
+		if _, ok := tags[n.Hash]; ok {
+			candidates = append(candidates, n.Hash)
+		}
+		if len(candidates) == maxDescribeCandidates {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(candidates) == 0 {
+		if always {
+			return m.obj.Abbrev(headHash), nil
+		}
+		return "", fmt.Errorf("no tags can describe %q", head)
+	}
+
+	// The distance is the number of commits reachable from HEAD but not from the tag
+	best, bestDepth := "", -1
+	for _, candidate := range candidates {
+		fromTag, err := m.reachable(candidate)
+		if err != nil {
+			return "", err
+		}
+		depth := 0
+		err = m.walkNodes([]string{head}, func(n *commitNode) error {
+			if !fromTag[n.Hash] {
+				depth++
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		if bestDepth < 0 || depth < bestDepth {
+			best, bestDepth = candidate, depth
+		}
+	}
+
+	return fmt.Sprintf("%s-%d-g%s", tags[best], bestDepth, m.obj.Abbrev(headHash)), nil
+}
@@ -0,0 +1,168 @@
+package diff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// base85Alphabet is the alphabet of the base85 encoding of git binary patches.
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// binaryLineLen is how many bytes each line of a binary patch encodes at most.
+const binaryLineLen = 52
+
+// BinaryPatch renders the differences between two binary contents as a git binary patch, which
+// apply can apply, rather than only reporting that they differ. It has a hunk turning a into
+// b, followed by one turning b back into a, each being either the whole contents ("literal") or
+// a delta against the other side ("delta"), whichever is smaller.
+func BinaryPatch(a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	return "GIT binary patch\n" + formatBinaryHunk(a, b) + formatBinaryHunk(b, a)
+}
+
+// formatBinaryHunk encodes b, as a delta against a if that is smaller, followed by a blank line.
+func formatBinaryHunk(a, b []byte) string {
+	kind, data := "literal", b
+	compressed := compress(b)
+	if len(a) > 0 && len(b) > 0 {
+		delta := MakeDelta(a, b)
+		if c := compress(delta); len(c) < len(compressed) {
+			kind, data, compressed = "delta", delta, c
+		}
+	}
+
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "%s %d\n", kind, len(data))
+	for len(compressed) > 0 {
+		n := len(compressed)
+		if n > binaryLineLen {
+			n = binaryLineLen
+		}
+		// The length of the line is a letter: A-Z for 1 to 26 bytes, a-z for 27 to 52
+		if n <= 26 {
+			out.WriteByte(byte('A' + n - 1))
+		} else {
+			out.WriteByte(byte('a' + n - 27))
+		}
+		out.WriteString(encode85(compressed[:n]))
+		out.WriteByte('\n')
+		compressed = compressed[n:]
+	}
+	out.WriteByte('\n')
+	return out.String()
+}
+
+func compress(data []byte) []byte {
+	b := new(bytes.Buffer)
+	w, _ := zlib.NewWriterLevel(b, zlib.BestCompression)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+// encode85 encodes data in base85, 4 bytes (padded with zeros) to 5 characters.
+func encode85(data []byte) string {
+	out := make([]byte, 0, (len(data)+3)/4*5)
+	for i := 0; i < len(data); i += 4 {
+		var v uint32
+		for j := 0; j < 4; j++ {
+			v <<= 8
+			if i+j < len(data) {
+				v |= uint32(data[i+j])
+			}
+		}
+		var group [5]byte
+		for j := 4; j >= 0; j-- {
+			group[j] = base85Alphabet[v%85]
+			v /= 85
+		}
+		out = append(out, group[:]...)
+	}
+	return string(out)
+}
+
+// decode85 decodes n bytes from their base85 encoding.
+func decode85(s string, n int) ([]byte, error) {
+	if len(s) != (n+3)/4*5 {
+		return nil, fmt.Errorf("base85 line of %d characters for %d bytes", len(s), n)
+	}
+	out := make([]byte, 0, len(s)/5*4)
+	for i := 0; i < len(s); i += 5 {
+		var v uint64
+		for j := 0; j < 5; j++ {
+			d := strings.IndexByte(base85Alphabet, s[i+j])
+			if d < 0 {
+				return nil, fmt.Errorf("invalid base85 character %q", s[i+j])
+			}
+			v = v*85 + uint64(d)
+		}
+		if v > 0xffffffff {
+			return nil, errors.New("invalid base85 group")
+		}
+		out = append(out, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return out[:n], nil
+}
+
+// binaryPatchHunk is one hunk of a git binary patch: the new contents, whole or as a delta.
+type binaryPatchHunk struct {
+	delta bool
+	data  []byte // uncompressed
+}
+
+// parseBinaryHunk parses the hunk at the start of lines, after the "GIT binary patch" line or a
+// previous hunk, and returns it along with the number of lines it takes, blank line included.
+func parseBinaryHunk(lines []string) (*binaryPatchHunk, int, error) {
+	header := strings.Fields(strings.TrimSuffix(lines[0], "\n"))
+	if len(header) != 2 || (header[0] != "literal" && header[0] != "delta") {
+		return nil, 0, fmt.Errorf("malformed binary hunk header %q", strings.TrimSuffix(lines[0], "\n"))
+	}
+	size, err := strconv.Atoi(header[1])
+	if err != nil || size < 0 {
+		return nil, 0, fmt.Errorf("malformed binary hunk header %q", strings.TrimSuffix(lines[0], "\n"))
+	}
+
+	var compressed []byte
+	n := 1
+	for ; n < len(lines); n++ {
+		line := strings.TrimSuffix(lines[n], "\n")
+		if line == "" {
+			n++
+			break
+		}
+		var length int
+		switch c := line[0]; {
+		case c >= 'A' && c <= 'Z':
+			length = int(c-'A') + 1
+		case c >= 'a' && c <= 'z':
+			length = int(c-'a') + 27
+		default:
+			return nil, 0, fmt.Errorf("invalid binary patch line %q", line)
+		}
+		data, err := decode85(line[1:], length)
+		if err != nil {
+			return nil, 0, err
+		}
+		compressed = append(compressed, data...)
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, 0, fmt.Errorf("corrupt binary patch: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("corrupt binary patch: %v", err)
+	}
+	if len(data) != size {
+		return nil, 0, fmt.Errorf("binary hunk has %d bytes instead of %d", len(data), size)
+	}
+	return &binaryPatchHunk{delta: header[0] == "delta", data: data}, n, nil
+}
@@ -0,0 +1,182 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ApplyDelta reconstructs contents from their base and a delta, in the format git uses in the
+// OFS_DELTA and REF_DELTA objects of packfiles and in binary patches. A delta starts with the sizes of the base and of the result,
+// followed by instructions that either copy a range of the base or insert the bytes that follow
+// them.
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("delta expects a base of %d bytes, not %d", baseSize, len(base))
+	}
+	size, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// Copy: the bits of op say which bytes of the offset and size follow
+			var offset, n uint64
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errors.New("truncated copy instruction in delta")
+				}
+				if i < 4 {
+					offset |= uint64(delta[0]) << (8 * i)
+				} else {
+					n |= uint64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > uint64(len(base)) {
+				return nil, fmt.Errorf("delta copies %d bytes at offset %d of a base of %d bytes", n, offset, len(base))
+			}
+			out = append(out, base[offset:offset+n]...)
+		case op != 0:
+			// Insert: op is the number of bytes that follow
+			if int(op) > len(delta) {
+				return nil, errors.New("truncated insert instruction in delta")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("reserved instruction 0 in delta")
+		}
+		if uint64(len(out)) > size {
+			return nil, fmt.Errorf("delta produces more than the %d bytes it declares", size)
+		}
+	}
+	if uint64(len(out)) != size {
+		return nil, fmt.Errorf("delta produces %d bytes instead of %d", len(out), size)
+	}
+	return out, nil
+}
+
+// deltaSize reads one of the variable-length sizes at the start of a delta, and returns the rest
+// of the delta.
+func deltaSize(delta []byte) (uint64, []byte, error) {
+	var size uint64
+	for shift := uint(0); ; shift += 7 {
+		if len(delta) == 0 || shift > 63 {
+			return 0, nil, errors.New("truncated delta header")
+		}
+		b := delta[0]
+		delta = delta[1:]
+		size |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return size, delta, nil
+		}
+	}
+}
+
+// deltaBlock is the size of the chunks of the base MakeDelta looks for in the target.
+const deltaBlock = 16
+
+// maxDeltaCopy is the most bytes MakeDelta copies with a single instruction, which is what git
+// writes for compatibility with old readers.
+const maxDeltaCopy = 0x10000
+
+// MakeDelta returns a delta that turns base into target, as read by ApplyDelta. It finds the
+// chunks of deltaBlock bytes of the base in the target and extends each match as far as it
+// goes, inserting the bytes that are not found.
+func MakeDelta(base, target []byte) []byte {
+	out := appendDeltaSize(nil, uint64(len(base)))
+	out = appendDeltaSize(out, uint64(len(target)))
+
+	blocks := map[string]int{}
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		key := string(base[i : i+deltaBlock])
+		if _, ok := blocks[key]; !ok {
+			blocks[key] = i
+		}
+	}
+
+	var insert []byte
+	flush := func() {
+		for len(insert) > 0 {
+			n := len(insert)
+			if n > 127 {
+				n = 127
+			}
+			out = append(out, byte(n))
+			out = append(out, insert[:n]...)
+			insert = insert[n:]
+		}
+	}
+	for i := 0; i < len(target); {
+		offset, ok := -1, false
+		if i+deltaBlock <= len(target) {
+			offset, ok = blocks[string(target[i:i+deltaBlock])]
+		}
+		if !ok {
+			insert = append(insert, target[i])
+			i++
+			continue
+		}
+		n := deltaBlock
+		for offset+n < len(base) && i+n < len(target) && base[offset+n] == target[i+n] {
+			n++
+		}
+		flush()
+		for n > 0 {
+			size := n
+			if size > maxDeltaCopy {
+				size = maxDeltaCopy
+			}
+			out = appendDeltaCopy(out, offset, size)
+			offset, i, n = offset+size, i+size, n-size
+		}
+	}
+	flush()
+	return out
+}
+
+// appendDeltaSize appends one of the sizes at the start of a delta, as read by deltaSize.
+func appendDeltaSize(out []byte, size uint64) []byte {
+	for size >= 0x80 {
+		out = append(out, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(out, byte(size))
+}
+
+// appendDeltaCopy appends an instruction copying size bytes of the base at offset, leaving out
+// the bytes of either that are zero. A size of 0x10000 is written as 0.
+func appendDeltaCopy(out []byte, offset, size int) []byte {
+	op := byte(0x80)
+	var args []byte
+	for i := uint(0); i < 4; i++ {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			op |= 1 << i
+			args = append(args, b)
+		}
+	}
+	if size != 0x10000 {
+		for i := uint(0); i < 3; i++ {
+			if b := byte(size >> (8 * i)); b != 0 {
+				op |= 1 << (4 + i)
+				args = append(args, b)
+			}
+		}
+	}
+	return append(append(out, op), args...)
+}
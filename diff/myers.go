@@ -0,0 +1,186 @@
+package diff
+
+import "bytes"
+
+// Op is the kind of an edit.
+type Op int
+
+const (
+	// Equal keeps a line that is in both versions.
+	Equal Op = iota
+	// Delete removes a line of the old version.
+	Delete
+	// Insert adds a line of the new version.
+	Insert
+)
+
+// Edit is a single step of the script that turns one version into the other.
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// SplitLines splits the contents into lines, keeping their "\n". The last line has no "\n" if
+// the contents don't end with one.
+func SplitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		lines = append(lines, string(data[:end]))
+		data = data[end:]
+	}
+	return lines
+}
+
+// Lines returns a shortest edit script that turns the lines of a into the lines of b, computed
+// with Myers' algorithm in linear space. Within each change, deletions come before insertions.
+func Lines(a, b []string) []Edit {
+	return LinesFunc(a, b, nil)
+}
+
+// LinesFunc is like Lines, but lines are equal if they have the same key, e.g. once their
+// whitespace is normalized. The edits keep the original lines, with unchanged lines taken from
+// b. A nil key compares the lines as they are.
+func LinesFunc(a, b []string, key func(line string) string) []Edit {
+	// Compare small integers instead of strings
+	ids := make(map[string]int)
+	toIDs := func(lines []string) []int {
+		s := make([]int, len(lines))
+		for i, l := range lines {
+			if key != nil {
+				l = key(l)
+			}
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			s[i] = id
+		}
+		return s
+	}
+
+	d := &differ{
+		a:       toIDs(a),
+		b:       toIDs(b),
+		deleted: make([]bool, len(a)),
+		added:   make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	edits := make([]Edit, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && d.deleted[i]:
+			edits = append(edits, Edit{Op: Delete, Line: a[i]})
+			i++
+		case j < len(b) && d.added[j]:
+			edits = append(edits, Edit{Op: Insert, Line: b[j]})
+			j++
+		default:
+			edits = append(edits, Edit{Op: Equal, Line: b[j]})
+			i++
+			j++
+		}
+	}
+	return edits
+}
+
+// differ marks the lines deleted from a and added to b.
+type differ struct {
+	a, b    []int
+	deleted []bool
+	added   []bool
+}
+
+// compare marks the differences between a[aLo:aHi] and b[bLo:bHi], splitting the problem at the
+// middle snake of an optimal path until one of the sides is empty.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.added[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.deleted[i] = true
+		}
+	default:
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		d.compare(u, aHi, v, bHi)
+	}
+}
+
+// middleSnake finds the snake in the middle of an optimal path from (aLo, bLo) to (aHi, bHi) by
+// searching from both ends at once. It returns the start (x, y) and end (u, v) of the snake.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+
+	// forward[k] is the furthest x reached on diagonal k (x - y = k) from the start, and
+	// backward[k] the same from the end, with both coordinates counted backwards
+	off := max + 1
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+
+	for D := 0; D <= max; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && forward[off+k-1] < forward[off+k+1]) {
+				x = forward[off+k+1]
+			} else {
+				x = forward[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[off+k] = x
+
+			// The backward search has gone D-1 steps, reaching diagonals -(D-1) to D-1
+			if kb := delta - k; odd && kb >= -(D-1) && kb <= D-1 && x+backward[off+kb] >= n {
+				return aLo + sx, bLo + sy, aLo + x, bLo + y
+			}
+		}
+
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && backward[off+k-1] < backward[off+k+1]) {
+				x = backward[off+k+1]
+			} else {
+				x = backward[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[off+k] = x
+
+			if kf := delta - k; !odd && kf >= -D && kf <= D && x+forward[off+kf] >= n {
+				return aHi - x, bHi - y, aHi - sx, bHi - sy
+			}
+		}
+	}
+	// Unreachable: the searches always meet after at most max steps
+	return aLo, bLo, aHi, bHi
+}
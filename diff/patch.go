@@ -0,0 +1,406 @@
+package diff
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FilePatch is the part of a unified diff that changes one file.
+type FilePatch struct {
+	// OldPath and NewPath are the paths of the file before and after the change, without the
+	// "a/" and "b/" prefixes. OldPath is empty for new files, and NewPath for deleted ones.
+	OldPath, NewPath string
+	// OldMode and NewMode are the modes given by git's extended headers, or 0 if there are none.
+	OldMode, NewMode uint32
+	// Binary is set if the diff only reports that binary contents differ.
+	Binary bool
+	hunks  []*hunk
+	// binary is the hunk of a git binary patch that gives the new contents, if it is one
+	binary *binaryPatchHunk
+	// oldHash is the hash of the old contents given by the "index" line, which may be abbreviated
+	oldHash string
+}
+
+// ParsePatch parses a unified diff, as written by diff -u or git diff, possibly changing several
+// files. Lines that are not part of a file's diff, such as a commit message, are skipped.
+func ParsePatch(data []byte) ([]*FilePatch, error) {
+	lines := SplitLines(data)
+	var patches []*FilePatch
+	var cur *FilePatch
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\n")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			cur = &FilePatch{}
+			patches = append(patches, cur)
+			if a, b, ok := gitDiffPaths(line); ok {
+				cur.OldPath, cur.NewPath = a, b
+			}
+		case cur != nil && strings.HasPrefix(line, "new file mode "):
+			cur.OldPath = ""
+			cur.NewMode = parseMode(line)
+		case cur != nil && strings.HasPrefix(line, "deleted file mode "):
+			cur.NewPath = ""
+			cur.OldMode = parseMode(line)
+		case cur != nil && strings.HasPrefix(line, "old mode "):
+			cur.OldMode = parseMode(line)
+		case cur != nil && strings.HasPrefix(line, "new mode "):
+			cur.NewMode = parseMode(line)
+		case cur != nil && strings.HasPrefix(line, "rename from "):
+			cur.OldPath = strings.TrimPrefix(line, "rename from ")
+		case cur != nil && strings.HasPrefix(line, "rename to "):
+			cur.NewPath = strings.TrimPrefix(line, "rename to ")
+		case cur != nil && strings.HasPrefix(line, "Binary files "):
+			cur.Binary = true
+		case cur != nil && strings.HasPrefix(line, "index "):
+			if i := strings.Index(line, ".."); i >= 0 {
+				cur.oldHash = line[len("index "):i]
+			}
+		case cur != nil && line == "GIT binary patch":
+			h, n, err := parseBinaryHunk(lines[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+2, err)
+			}
+			cur.binary = h
+			i += n
+			// The hunk that reverts the patch is only checked
+			if i+1 < len(lines) && (strings.HasPrefix(lines[i+1], "literal ") || strings.HasPrefix(lines[i+1], "delta ")) {
+				_, n, err := parseBinaryHunk(lines[i+1:])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", i+2, err)
+				}
+				i += n
+			}
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			// Plain unified diffs have no "diff --git" line, so the file headers start the patch
+			if cur == nil || len(cur.hunks) > 0 {
+				cur = &FilePatch{}
+				patches = append(patches, cur)
+			}
+			cur.OldPath = patchPath(line[4:])
+			cur.NewPath = patchPath(strings.TrimSuffix(lines[i+1], "\n")[4:])
+			i++
+		case strings.HasPrefix(line, "@@ "):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk without file headers", i+1)
+			}
+			h, n, err := parseHunk(lines[i:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			cur.hunks = append(cur.hunks, h)
+			i += n - 1
+		}
+	}
+	for _, p := range patches {
+		if p.OldPath == "" && p.NewPath == "" {
+			return nil, fmt.Errorf("patch with no file name")
+		}
+	}
+	return patches, nil
+}
+
+// gitDiffPaths returns the paths of a "diff --git a/<old> b/<new>" line, which is ambiguous if
+// the paths have spaces, unless they are the same.
+func gitDiffPaths(line string) (string, string, bool) {
+	rest := strings.TrimPrefix(line, "diff --git ")
+	if !strings.HasPrefix(rest, "a/") {
+		return "", "", false
+	}
+	if n := len(rest); n%2 == 1 {
+		a, b := rest[:n/2], rest[n/2+1:]
+		if strings.HasPrefix(b, "b/") && a[2:] == b[2:] {
+			return a[2:], b[2:], true
+		}
+	}
+	i := strings.Index(rest, " b/")
+	if i < 0 {
+		return "", "", false
+	}
+	return rest[2:i], rest[i+3:], true
+}
+
+// patchPath returns the path of a "---" or "+++" header without the leading directory (usually
+// "a/" or "b/"), or an empty string for /dev/null. A timestamp after a tab is ignored.
+func patchPath(name string) string {
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+	if name == "/dev/null" {
+		return ""
+	}
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// parseMode returns the octal mode at the end of a header line such as "new file mode 100644".
+func parseMode(line string) uint32 {
+	mode, _ := strconv.ParseUint(line[strings.LastIndexByte(line, ' ')+1:], 8, 32)
+	return uint32(mode)
+}
+
+// parseHunk parses the hunk at the start of lines and returns it along with the number of lines
+// it takes.
+func parseHunk(lines []string) (*hunk, int, error) {
+	var oldStart, oldCount, newStart, newCount int
+	header := strings.TrimSuffix(lines[0], "\n")
+	fields := strings.Fields(header)
+	if len(fields) < 4 || fields[0] != "@@" || fields[3] != "@@" {
+		return nil, 0, fmt.Errorf("malformed hunk header %q", header)
+	}
+	oldStart, oldCount, err := parseRange(fields[1], "-")
+	if err == nil {
+		newStart, newCount, err = parseRange(fields[2], "+")
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed hunk header %q", header)
+	}
+
+	h := &hunk{oldStart: oldStart, newStart: newStart}
+	n := 1
+	for oldCount > 0 || newCount > 0 {
+		if n >= len(lines) {
+			return nil, 0, fmt.Errorf("hunk ends early")
+		}
+		line := lines[n]
+		n++
+		var op Op
+		switch line[0] {
+		case ' ':
+			op = Equal
+			oldCount--
+			newCount--
+		case '\n':
+			// Some editors strip the space of empty context lines
+			op, line = Equal, " \n"
+			oldCount--
+			newCount--
+		case '-':
+			op = Delete
+			oldCount--
+		case '+':
+			op = Insert
+			newCount--
+		case '\\':
+			noNewline(h, lines[n-2][0])
+			continue
+		default:
+			return nil, 0, fmt.Errorf("unexpected line %q in hunk", strings.TrimSuffix(line, "\n"))
+		}
+		if oldCount < 0 || newCount < 0 {
+			return nil, 0, fmt.Errorf("hunk has more lines than its header says")
+		}
+		h.edits = append(h.edits, Edit{Op: op, Line: line[1:]})
+	}
+	// The last line of a side may have no newline at the end of the file
+	for n < len(lines) && strings.HasPrefix(lines[n], "\\") {
+		noNewline(h, lines[n-1][0])
+		n++
+	}
+	return h, n, nil
+}
+
+// noNewline removes the newline of the last line of the hunk that starts with the given marker.
+func noNewline(h *hunk, marker byte) {
+	op := map[byte]Op{' ': Equal, '-': Delete, '+': Insert}[marker]
+	for i := len(h.edits) - 1; i >= 0; i-- {
+		if h.edits[i].Op == op {
+			h.edits[i].Line = strings.TrimSuffix(h.edits[i].Line, "\n")
+			return
+		}
+	}
+}
+
+// parseRange parses a range of a hunk header, such as "-12,3", into the line it starts at
+// (from 0) and its number of lines. It reverses hunkRange.
+func parseRange(s, sign string) (int, int, error) {
+	if !strings.HasPrefix(s, sign) {
+		return 0, 0, fmt.Errorf("missing %q", sign)
+	}
+	s = s[1:]
+	count := 1
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		var err error
+		count, err = strconv.Atoi(s[i+1:])
+		if err != nil {
+			return 0, 0, err
+		}
+		s = s[:i]
+	}
+	start, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if count > 0 {
+		start--
+	}
+	return start, count, nil
+}
+
+// HunkError reports the hunks of a patch that don't apply, numbered from 1.
+type HunkError struct {
+	Path  string
+	Hunks []int
+}
+
+func (e *HunkError) Error() string {
+	hunks := make([]string, len(e.Hunks))
+	for i, n := range e.Hunks {
+		hunks[i] = "#" + strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%s: hunk %s does not apply", e.Path, strings.Join(hunks, ", "))
+}
+
+// Apply applies the changes to the contents of the file and returns the new contents. Each hunk
+// is looked for where it is expected, adjusted by how far the previous hunk moved, then further
+// and further away, except that a hunk without context at its start or end must be at the start
+// or end of the file. If it isn't found, up to fuzz lines of context are ignored at each end of
+// it. If any hunk doesn't apply, Apply changes nothing and returns a *HunkError listing them.
+func (p *FilePatch) Apply(data []byte, fuzz int) ([]byte, error) {
+	if p.binary != nil {
+		return p.applyBinary(data)
+	}
+	if p.Binary {
+		return nil, fmt.Errorf("%s: cannot apply binary patch without its contents (see --binary)", p.Path())
+	}
+	lines := SplitLines(data)
+	var out []string
+	var failed []int
+	pos, offset := 0, 0
+	for i, h := range p.hunks {
+		// Like git, a hunk without context at its start or end must be at that end of the file
+		atStart := h.oldStart == 0 && len(h.edits) > 0 && h.edits[0].Op != Equal
+		atEnd := len(h.edits) > 0 && h.edits[len(h.edits)-1].Op != Equal
+
+		at, edits, skipped := -1, h.edits, 0
+		for f := 0; f <= fuzz && at < 0; f++ {
+			edits, skipped = trimContext(h.edits, f)
+			want := oldLines(edits)
+			switch last := len(lines) - len(want); {
+			case atEnd:
+				if last >= pos && (!atStart || last == 0) && matchAt(lines, want, last) {
+					at = last
+				}
+			case atStart:
+				if pos == 0 && matchAt(lines, want, 0) {
+					at = 0
+				}
+			default:
+				at = findHunk(lines, want, pos, h.oldStart+skipped+offset)
+			}
+		}
+		if at < 0 {
+			failed = append(failed, i+1)
+			continue
+		}
+
+		out = append(out, lines[pos:at]...)
+		next := at
+		for _, e := range edits {
+			switch e.Op {
+			case Equal:
+				out = append(out, lines[next])
+				next++
+			case Delete:
+				next++
+			case Insert:
+				out = append(out, e.Line)
+			}
+		}
+		offset = at - skipped - h.oldStart
+		pos = next
+	}
+	if len(failed) > 0 {
+		return nil, &HunkError{Path: p.Path(), Hunks: failed}
+	}
+	out = append(out, lines[pos:]...)
+	return []byte(strings.Join(out, "")), nil
+}
+
+// applyBinary applies a git binary patch. Since binary patches have no context, the old contents
+// must be those the patch was made from, which is checked against the hash of the index line
+// when there is one.
+func (p *FilePatch) applyBinary(data []byte) ([]byte, error) {
+	if p.oldHash != "" && strings.Trim(p.oldHash, "0") != "" {
+		header := fmt.Sprintf("blob %d\x00", len(data))
+		sum := sha1.Sum(append([]byte(header), data...))
+		if !strings.HasPrefix(hex.EncodeToString(sum[:]), p.oldHash) {
+			return nil, fmt.Errorf("%s: binary patch does not apply: the contents differ from %s", p.Path(), p.oldHash)
+		}
+	}
+	if !p.binary.delta {
+		return p.binary.data, nil
+	}
+	out, err := ApplyDelta(data, p.binary.data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: binary patch does not apply: %v", p.Path(), err)
+	}
+	return out, nil
+}
+
+// Path returns the path of the file after the change, or before it if it is deleted.
+func (p *FilePatch) Path() string {
+	if p.NewPath != "" {
+		return p.NewPath
+	}
+	return p.OldPath
+}
+
+// trimContext returns the edits without up to n context lines at each end, along with the
+// number of lines removed from the start.
+func trimContext(edits []Edit, n int) ([]Edit, int) {
+	skipped := 0
+	for ; skipped < n && len(edits) > 0 && edits[0].Op == Equal; skipped++ {
+		edits = edits[1:]
+	}
+	for i := 0; i < n && len(edits) > 0 && edits[len(edits)-1].Op == Equal; i++ {
+		edits = edits[:len(edits)-1]
+	}
+	return edits, skipped
+}
+
+// oldLines returns the lines the edits expect to find.
+func oldLines(edits []Edit) []string {
+	var lines []string
+	for _, e := range edits {
+		if e.Op != Insert {
+			lines = append(lines, e.Line)
+		}
+	}
+	return lines
+}
+
+// findHunk returns where the lines of want are in lines, at or after pos, looking first at
+// expected and then further and further away from it, or -1 if they are nowhere.
+func findHunk(lines, want []string, pos, expected int) int {
+	last := len(lines) - len(want)
+	if expected < pos {
+		expected = pos
+	}
+	if expected > last {
+		expected = last
+	}
+	for d := 0; expected-d >= pos || expected+d <= last; d++ {
+		if at := expected - d; at >= pos && at <= last && matchAt(lines, want, at) {
+			return at
+		}
+		if at := expected + d; d > 0 && at >= pos && at <= last && matchAt(lines, want, at) {
+			return at
+		}
+	}
+	return -1
+}
+
+func matchAt(lines, want []string, at int) bool {
+	for i, line := range want {
+		if lines[at+i] != line {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+// Package diff compares file contents.
+package diff
+
+import (
+	"bytes"
+	"hash/fnv"
+)
+
+// binarySniffLen is how much of a file is checked for NUL bytes to tell whether it is binary,
+// the same amount git checks.
+const binarySniffLen = 8000
+
+// IsBinary returns whether the contents look binary, i.e. whether there is a NUL byte at the
+// beginning of them.
+func IsBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// Similarity scores how similar two contents are, from 0 (nothing in common) to 100 (identical).
+// Text is compared line by line: the score is the size of the intersection of the multisets of
+// line hashes over the size of their union. Binary contents are only similar if they are
+// identical. Different contents never score 100, even if they have the same lines.
+func Similarity(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return 100
+	}
+	if IsBinary(a) || IsBinary(b) {
+		return 0
+	}
+
+	linesA, linesB := lineHashes(a), lineHashes(b)
+	var intersection, union int
+	for h, n := range linesA {
+		m := linesB[h]
+		intersection += min(n, m)
+		union += max(n, m)
+	}
+	for h, m := range linesB {
+		if _, ok := linesA[h]; !ok {
+			union += m
+		}
+	}
+	if union == 0 {
+		return 0
+	}
+
+	score := intersection * 100 / union
+	if score == 100 {
+		// Same lines in a different order
+		score = 99
+	}
+	return score
+}
+
+// lineHashes counts the lines of data by their hash. The last line doesn't need a newline.
+func lineHashes(data []byte) map[uint64]int {
+	counts := make(map[uint64]int)
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i+1], data[i+1:]
+		} else {
+			data = nil
+		}
+		h := fnv.New64a()
+		h.Write(bytes.TrimSuffix(line, []byte("\n")))
+		counts[h.Sum64()]++
+	}
+	return counts
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
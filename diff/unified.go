@@ -0,0 +1,224 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// contextLines is how many unchanged lines are shown around each change, like diff -u.
+const contextLines = 3
+
+// Options changes how the differences are rendered. The zero value renders them line by line.
+type Options struct {
+	// WordRegexp, if set, makes the changed lines of each hunk be compared word by word, with
+	// the words matching it, like git diff --word-diff. See DefaultWordRegexp.
+	WordRegexp *regexp.Regexp
+	// IgnoreAllSpace makes lines that only differ in whitespace be treated as unchanged, like
+	// diff -w. Unchanged lines are shown as they are in the new version.
+	IgnoreAllSpace bool
+	// IgnoreSpaceChange makes lines that only differ in the amount of whitespace, or in
+	// whitespace at their end, be treated as unchanged, like diff -b.
+	IgnoreSpaceChange bool
+	// Binary makes binary contents be rendered as git binary patches, like git diff --binary,
+	// rather than only reported as differing.
+	Binary bool
+}
+
+// lineKey returns what lines are compared by, or nil if they are compared as they are.
+func (o Options) lineKey() func(line string) string {
+	switch {
+	case o.IgnoreAllSpace:
+		return func(line string) string {
+			return strings.Join(strings.Fields(line), "")
+		}
+	case o.IgnoreSpaceChange:
+		return collapseSpace
+	}
+	return nil
+}
+
+// collapseSpace replaces each run of whitespace in the line with a single space, and removes
+// the whitespace at its end.
+func collapseSpace(line string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range line {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Unified renders the differences between two contents as a unified diff, in the same format as
+// diff -u, using the given labels in the file headers. It returns an empty string if they are
+// the same. Binary contents are only reported as differing, as by Binary.
+func Unified(oldLabel, newLabel string, a, b []byte) string {
+	return Options{}.Unified(oldLabel, newLabel, a, b)
+}
+
+// Text is like Unified, but it renders the contents as text even if they look binary.
+func Text(oldLabel, newLabel string, a, b []byte) string {
+	return Options{}.Text(oldLabel, newLabel, a, b)
+}
+
+// Unified is like the Unified function, with the given options.
+func (o Options) Unified(oldLabel, newLabel string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	if IsBinary(a) || IsBinary(b) {
+		return o.binary(oldLabel, newLabel, a, b)
+	}
+	return o.Text(oldLabel, newLabel, a, b)
+}
+
+// Text is like the Text function, with the given options.
+func (o Options) Text(oldLabel, newLabel string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	edits := LinesFunc(SplitLines(a), SplitLines(b), o.lineKey())
+	list := hunks(edits)
+	if len(list) == 0 {
+		return ""
+	}
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", oldLabel, newLabel)
+	for _, h := range list {
+		if o.WordRegexp != nil {
+			h.writeWords(out, o.WordRegexp)
+		} else {
+			h.write(out)
+		}
+	}
+	return out.String()
+}
+
+// Binary reports that two binary contents differ, without showing the differences.
+func Binary(oldLabel, newLabel string) string {
+	return fmt.Sprintf("Binary files %s and %s differ\n", oldLabel, newLabel)
+}
+
+// RenderBinary renders the differences between two contents as binary, either as a patch or
+// only reporting that they differ, depending on o.Binary. It returns an empty string if they
+// are the same.
+func (o Options) RenderBinary(oldLabel, newLabel string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	return o.binary(oldLabel, newLabel, a, b)
+}
+
+func (o Options) binary(oldLabel, newLabel string, a, b []byte) string {
+	if o.Binary {
+		return BinaryPatch(a, b)
+	}
+	return Binary(oldLabel, newLabel)
+}
+
+// hunk is a run of edits with the line numbers (starting at 0) they start at.
+type hunk struct {
+	oldStart, newStart int
+	edits              []Edit
+}
+
+// hunks groups the changes with their surrounding context. Changes separated by no more than
+// twice the context share a hunk.
+func hunks(edits []Edit) []*hunk {
+	var list []*hunk
+	var cur *hunk
+	added := 0 // index of the first edit not added to the current hunk yet
+	end := 0   // index of the first edit after the context of the last change
+	oldLine, newLine := 0, 0
+	for i, e := range edits {
+		if e.Op != Equal {
+			start := i - contextLines
+			if start < 0 {
+				start = 0
+			}
+			if cur == nil || start > end {
+				if cur != nil {
+					cur.edits = append(cur.edits, edits[added:end]...)
+				}
+				// The context lines before the change are all equal lines
+				cur = &hunk{oldStart: oldLine - (i - start), newStart: newLine - (i - start)}
+				list = append(list, cur)
+				added = start
+			}
+			cur.edits = append(cur.edits, edits[added:i+1]...)
+			added = i + 1
+			end = i + 1 + contextLines
+			if end > len(edits) {
+				end = len(edits)
+			}
+		}
+
+		switch e.Op {
+		case Equal:
+			oldLine++
+			newLine++
+		case Delete:
+			oldLine++
+		case Insert:
+			newLine++
+		}
+	}
+	if cur != nil {
+		cur.edits = append(cur.edits, edits[added:end]...)
+	}
+	return list
+}
+
+// writeHeader renders the line ranges of the hunk.
+func (h *hunk) writeHeader(b *strings.Builder) {
+	oldCount, newCount := 0, 0
+	for _, e := range h.edits {
+		if e.Op != Insert {
+			oldCount++
+		}
+		if e.Op != Delete {
+			newCount++
+		}
+	}
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(h.oldStart, oldCount), hunkRange(h.newStart, newCount))
+}
+
+// write renders the hunk header and its lines.
+func (h *hunk) write(b *strings.Builder) {
+	h.writeHeader(b)
+	for _, e := range h.edits {
+		switch e.Op {
+		case Equal:
+			b.WriteByte(' ')
+		case Delete:
+			b.WriteByte('-')
+		case Insert:
+			b.WriteByte('+')
+		}
+		b.WriteString(e.Line)
+		if !strings.HasSuffix(e.Line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the range of lines of one side of a hunk the way diff -u does: the count is
+// left out if it is 1, and an empty range starts at the line before it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,36 @@
+package diff
+
+import "testing"
+
+func TestIgnoreSpace(t *testing.T) {
+	a := "func f() {\n\treturn a+b\n}\n"
+	for _, tt := range []struct {
+		name string
+		b    string
+		opts Options
+		want string
+	}{
+		{"indentation, -w", "func f() {\n    return a+b\n}\n", Options{IgnoreAllSpace: true}, ""},
+		{"indentation, -b", "func f() {\n    return a+b\n}\n", Options{IgnoreSpaceChange: true}, ""},
+		{"trailing space, -b", "func f() { \n\treturn a+b\t\n}\n", Options{IgnoreSpaceChange: true}, ""},
+		{"added space, -w", "func f() {\n\treturn a + b\n}\n", Options{IgnoreAllSpace: true}, ""},
+		{
+			"added space, -b", "func f() {\n\treturn a + b\n}\n", Options{IgnoreSpaceChange: true},
+			"--- a\n+++ b\n@@ -1,3 +1,3 @@\n func f() {\n-\treturn a+b\n+\treturn a + b\n }\n",
+		},
+		{
+			// Unchanged lines are shown as they are in the new version
+			"other changes, -w", "func f()  {\n\treturn a-b\n}\n", Options{IgnoreAllSpace: true},
+			"--- a\n+++ b\n@@ -1,3 +1,3 @@\n func f()  {\n-\treturn a+b\n+\treturn a-b\n }\n",
+		},
+		{
+			"indentation", "func f() {\n    return a+b\n}\n", Options{},
+			"--- a\n+++ b\n@@ -1,3 +1,3 @@\n func f() {\n-\treturn a+b\n+    return a+b\n }\n",
+		},
+	} {
+		got := tt.opts.Unified("a", "b", []byte(a), []byte(tt.b))
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
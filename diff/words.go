@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultWordRegexp splits lines into words for word diffs: runs of letters, digits and
+// underscores, and each other character that isn't a space on its own.
+var DefaultWordRegexp = regexp.MustCompile(`[\pL\pN_]+|[^\pL\pN_\s]`)
+
+// writeWords renders the hunk header and its lines, with each run of deleted and inserted lines
+// compared word by word. Unchanged words are written as they are and the others marked as
+// deleted ("[-...-]") or inserted ("{+...+}"), like git diff --word-diff. Unchanged lines have
+// no prefix.
+func (h *hunk) writeWords(b *strings.Builder, words *regexp.Regexp) {
+	h.writeHeader(b)
+	var deleted, inserted strings.Builder
+	flush := func() {
+		if deleted.Len() > 0 || inserted.Len() > 0 {
+			writeWordChanges(b, deleted.String(), inserted.String(), words)
+			deleted.Reset()
+			inserted.Reset()
+		}
+	}
+	for _, e := range h.edits {
+		switch e.Op {
+		case Equal:
+			flush()
+			b.WriteString(e.Line)
+			if !strings.HasSuffix(e.Line, "\n") {
+				b.WriteByte('\n')
+			}
+		case Delete:
+			deleted.WriteString(e.Line)
+		case Insert:
+			inserted.WriteString(e.Line)
+		}
+	}
+	flush()
+}
+
+// writeWordChanges renders the changes from the old text to the new one word by word.
+func writeWordChanges(b *strings.Builder, old, new string, words *regexp.Regexp) {
+	edits := Lines(splitWords(old, words), splitWords(new, words))
+	var deleted, inserted strings.Builder
+	flush := func() {
+		// Changes that are only in the spaces between words are not shown
+		if strings.TrimSpace(deleted.String()) == "" && strings.TrimSpace(inserted.String()) == "" {
+			b.WriteString(inserted.String())
+		} else {
+			writeMarked(b, "[-", deleted.String(), "-]")
+			writeMarked(b, "{+", inserted.String(), "+}")
+		}
+		deleted.Reset()
+		inserted.Reset()
+	}
+	for _, e := range edits {
+		switch e.Op {
+		case Equal:
+			flush()
+			b.WriteString(e.Line)
+		case Delete:
+			deleted.WriteString(e.Line)
+		case Insert:
+			inserted.WriteString(e.Line)
+		}
+	}
+	flush()
+	if !strings.HasSuffix(new, "\n") && (new != "" || !strings.HasSuffix(old, "\n")) {
+		b.WriteByte('\n')
+	}
+}
+
+// writeMarked writes the text between the markers, line by line so that markers never span
+// lines. The spaces around the text are left outside of the markers.
+func writeMarked(b *strings.Builder, open, text, close string) {
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		word := strings.TrimSpace(line)
+		if word == "" {
+			continue
+		}
+		start := strings.Index(line, word)
+		b.WriteString(line[:start])
+		b.WriteString(open + word + close)
+		b.WriteString(line[start+len(word):])
+	}
+}
+
+// splitWords splits the text into the words matching the regular expression and the text
+// between them, so that joining them gives the text back. Words never span lines.
+func splitWords(text string, words *regexp.Regexp) []string {
+	var tokens []string
+	for _, line := range SplitLines([]byte(text)) {
+		content := strings.TrimSuffix(line, "\n")
+		last := 0
+		for _, loc := range words.FindAllStringIndex(content, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			if loc[0] > last {
+				tokens = append(tokens, content[last:loc[0]])
+			}
+			tokens = append(tokens, content[loc[0]:loc[1]])
+			last = loc[1]
+		}
+		if last < len(content) {
+			tokens = append(tokens, content[last:])
+		}
+		if len(content) < len(line) {
+			tokens = append(tokens, "\n")
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,188 @@
+package mgi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DiffFormat is how the changes between two sets of files are shown.
+type DiffFormat int
+
+const (
+	// DiffPatch shows the changes as unified diffs.
+	DiffPatch DiffFormat = iota
+	// DiffRaw shows the modes and hashes of both versions of each changed file, followed by the
+	// status and the path, like git's raw diff format.
+	DiffRaw
+	// DiffNameOnly shows only the path of each changed file.
+	DiffNameOnly
+	// DiffNameStatus shows the status of each changed file (e.g. "M") followed by its path.
+	DiffNameStatus
+)
+
+// fileChange is a file that differs between two sets of files.
+type fileChange struct {
+	// status is 'A' for added files, 'D' for deleted ones, 'M' for modified ones, 'T' for those
+	// whose type changed (e.g. from a file to a symlink) and 'R' for renamed ones.
+	status   byte
+	path     string
+	old, new *IndexEntry
+	rename   *rename // for renamed files only
+}
+
+// changedFiles returns the files that differ between old and new, sorted by path. Renames are
+// detected if renameThreshold is positive, reading the contents of the files with readBlob.
+func changedFiles(old, new map[string]*IndexEntry, renameThreshold int, readBlob func(*IndexEntry) ([]byte, error)) ([]*fileChange, error) {
+	var renames map[string]*rename
+	if renameThreshold > 0 {
+		var err error
+		renames, err = detectRenames(old, new, renameThreshold, readBlob)
+		if err != nil {
+			return nil, err
+		}
+	}
+	renamedFrom := make(map[string]bool, len(renames))
+	for _, r := range renames {
+		renamedFrom[r.from] = true
+	}
+
+	paths := make(map[string]bool)
+	for path := range old {
+		if !renamedFrom[path] {
+			paths[path] = true
+		}
+	}
+	for path := range new {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var changes []*fileChange
+	for _, path := range sorted {
+		c := &fileChange{path: path, old: old[path], new: new[path]}
+		switch r, ok := renames[path]; {
+		case ok:
+			c.status, c.old, c.rename = 'R', old[r.from], r
+		case c.old == nil:
+			c.status = 'A'
+		case c.new == nil:
+			c.status = 'D'
+		case c.old.Hash.String() == c.new.Hash.String() && c.old.Mode == c.new.Mode:
+			continue
+		case c.old.Mode&0170000 != c.new.Mode&0170000:
+			c.status = 'T'
+		default:
+			c.status = 'M'
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// formatChange renders a change in one of the formats that don't show the contents.
+func formatChange(c *fileChange, format DiffFormat) string {
+	status, paths := string(c.status), c.path
+	if c.rename != nil {
+		status = fmt.Sprintf("R%03d", c.rename.similarity)
+		paths = c.rename.from + "\t" + c.path
+	}
+
+	switch format {
+	case DiffNameOnly:
+		return c.path
+	case DiffNameStatus:
+		return status + "\t" + paths
+	}
+	var oldMode, newMode uint32
+	oldHash, newHash := strings.Repeat("0", 40), strings.Repeat("0", 40)
+	if c.old != nil {
+		oldMode, oldHash = c.old.Mode, c.old.Hash.String()
+	}
+	if c.new != nil {
+		newMode, newHash = c.new.Mode, c.new.Hash.String()
+	}
+	return fmt.Sprintf(":%06o %06o %s %s %s\t%s", oldMode, newMode, oldHash, newHash, status, paths)
+}
+
+// DiffTree compares two trees, like git diff-tree, and returns a line for each changed entry in
+// the given format, which must not be DiffPatch. Commits and tags are peeled to their trees.
+// Given a single commit, it is compared to its parent, and the commit is listed first; root and
+// merge commits have nothing to compare to. Unless recursive is set, only the top-level entries
+// are compared, so a changed subdirectory is shown as a whole.
+func (m *MGIService) DiffTree(revs []string, recursive bool, format DiffFormat) ([]string, error) {
+	if format == DiffPatch {
+		return nil, fmt.Errorf("diff-tree cannot show patches")
+	}
+
+	var header []string
+	var oldRev, newRev string
+	switch len(revs) {
+	case 1:
+		commit, err := m.resolveCommit(revs[0])
+		if err != nil {
+			return nil, err
+		}
+		c, err := m.readCommit(commit)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Parents) != 1 {
+			return nil, nil
+		}
+		header = []string{commit}
+		oldRev, newRev = c.Parents[0], commit
+	case 2:
+		oldRev, newRev = revs[0], revs[1]
+	default:
+		return nil, fmt.Errorf("diff-tree needs one commit or two trees")
+	}
+
+	trees := make([]map[string]*IndexEntry, 2)
+	for i, rev := range []string{oldRev, newRev} {
+		hash, err := m.resolveRevision(rev)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := m.peelRevision(hash, "tree")
+		if err != nil {
+			return nil, fmt.Errorf("revision %q: %v", rev, err)
+		}
+		if recursive {
+			trees[i], err = m.flattenTree(tree)
+		} else {
+			trees[i], err = m.treeEntries(tree)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := changedFiles(trees[0], trees[1], 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	lines := header
+	for _, c := range changes {
+		lines = append(lines, formatChange(c, format))
+	}
+	return lines, nil
+}
+
+// treeEntries returns the entries of a tree, without looking into its subdirectories, keyed by
+// their name.
+func (m *MGIService) treeEntries(hash string) (map[string]*IndexEntry, error) {
+	tree, err := m.readTree(hash)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*IndexEntry, len(tree.Entries))
+	for _, e := range tree.Entries {
+		entries[e.path] = &IndexEntry{Mode: e.mode, Hash: e.hash, Path: e.path}
+	}
+	return entries, nil
+}
@@ -0,0 +1,86 @@
+package mgi_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bertinatto/mgi"
+)
+
+// This example opens the repository containing a subdirectory and lists its history, most recent
+// commit first.
+func ExampleOpenRepository() {
+	dir, err := ioutil.TempDir("", "mgi-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	err = setupExample(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	repo, err := mgi.OpenRepository(filepath.Join(dir, "docs"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries, err := repo.Log("", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, e := range entries {
+		fmt.Println(strings.TrimSpace(e.Commit.Message))
+	}
+	// Output:
+	// Add the docs
+	// Add the readme
+}
+
+// setupExample creates a repository in dir with two commits, and changes to it.
+func setupExample(dir string) error {
+	err := os.Chdir(dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range []string{".git/objects", ".git/refs/heads", "docs"} {
+		err := os.MkdirAll(d, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	err = ioutil.WriteFile(".git/HEAD", []byte("ref: refs/heads/master\n"), 0644)
+	if err != nil {
+		return err
+	}
+	repo, err := mgi.NewRepo(".git", nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range []struct{ path, msg string }{
+		{"README", "Add the readme"},
+		{"docs/index.md", "Add the docs"},
+	} {
+		err := ioutil.WriteFile(c.path, []byte(c.msg+"\n"), 0644)
+		if err != nil {
+			return err
+		}
+		err = repo.Add([]string{c.path})
+		if err != nil {
+			return err
+		}
+		err = repo.Commit(c.msg, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
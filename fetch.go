@@ -0,0 +1,483 @@
+package mgi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The client side of git's smart HTTP protocol (version 0), the counterpart of HTTPHandler:
+// the refs of the remote repository are listed with GET /info/refs?service=git-upload-pack,
+// and the objects the repository lacks are asked for in a single POST to /git-upload-pack,
+// along with the tips of the local refs, so that only the missing ones are sent.
+
+// FetchResult is what a fetch got from the remote repository.
+type FetchResult struct {
+	// Refs are the refs of the remote repository, without HEAD.
+	Refs []*Ref
+	// Head is the branch HEAD points to in the remote repository, e.g. "refs/heads/master",
+	// or empty if it's detached or unknown.
+	Head string
+	// HeadHash is the commit HEAD points to, or empty if the repository has no commits.
+	HeadHash string
+}
+
+// Fetch gets the objects of the remote repository that are missing from this one, and points
+// the remote-tracking branches, "refs/remotes/<remote>/<branch>", to its branches. New tags are
+// created too. The remote is either the name of a remote configured in remote.<name>.url or a
+// URL, in which case only the tags are updated.
+//
+// If depth is positive, only the depth most recent commits of each branch are fetched, and the
+// commits whose parents are missing are listed in the shallow file. A repository that is already
+// shallow is deepened or shortened to the new depth.
+func (m *MGIService) Fetch(remote string, depth int) (*FetchResult, error) {
+	url, name, err := m.remoteURL(remote)
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.fetch(url, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range result.Refs {
+		var local string
+		switch {
+		case strings.HasPrefix(ref.Name, "refs/heads/") && name != "":
+			local = "refs/remotes/" + name + "/" + strings.TrimPrefix(ref.Name, "refs/heads/")
+		case strings.HasPrefix(ref.Name, "refs/tags/") && !strings.HasSuffix(ref.Name, "^{}"):
+			local = ref.Name
+		default:
+			continue
+		}
+		old, err := m.readRef(local)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		// Existing tags are left as they are, like git does
+		if old == ref.Hash || (old != "" && strings.HasPrefix(local, "refs/tags/")) {
+			continue
+		}
+		exists, err := m.hasObject(ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			// A tag pointing outside of the history that was fetched
+			continue
+		}
+		err = m.updateRef(local, old, ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+		err = m.appendReflog(local, old, ref.Hash, "fetch: "+url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Clone sets up the repository, which must be empty, as a clone of the one at url: the remote
+// is configured as "origin", its refs are fetched as with Fetch, and the branch its HEAD points to
+// is created, set to track the remote one, and checked out. If depth is positive, the history is
+// shallow, as with Fetch.
+func (m *MGIService) Clone(url string, depth int) error {
+	configService := NewConfigService(m.common)
+	config, err := configService.Read()
+	if err != nil {
+		return err
+	}
+	err = config.Set("remote.origin.url", url)
+	if err == nil {
+		err = config.Set("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	}
+	if err != nil {
+		return err
+	}
+	err = configService.Store()
+	if err != nil {
+		return err
+	}
+
+	result, err := m.Fetch("origin", depth)
+	if err != nil {
+		return err
+	}
+	if result.HeadHash == "" {
+		m.logger.Infof("warning: You appear to have cloned an empty repository.")
+		return nil
+	}
+
+	// Servers that don't say where HEAD points to leave it to be guessed from the branches
+	branch := result.Head
+	if branch == "" {
+		for _, ref := range result.Refs {
+			if strings.HasPrefix(ref.Name, "refs/heads/") && ref.Hash == result.HeadHash {
+				branch = ref.Name
+				break
+			}
+		}
+	}
+	reflogMsg := "clone: from " + url
+	if branch == "" {
+		return m.switchHead(result.HeadHash, result.HeadHash, reflogMsg)
+	}
+
+	// The files are checked out while the branch doesn't exist yet, so that there's nothing to
+	// compare them against
+	err = m.switchHead(result.HeadHash, branch, reflogMsg)
+	if err != nil {
+		return err
+	}
+	err = m.updateRef(branch, "", result.HeadHash)
+	if err != nil {
+		return err
+	}
+	err = m.appendReflog(branch, "", result.HeadHash, reflogMsg)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(branch, "refs/heads/")
+	err = config.Set("branch."+name+".remote", "origin")
+	if err == nil {
+		err = config.Set("branch."+name+".merge", branch)
+	}
+	if err != nil {
+		return err
+	}
+	return configService.Store()
+}
+
+// remoteURL returns the URL of the remote, and its name if it's a configured one rather than a
+// URL.
+func (m *MGIService) remoteURL(remote string) (url, name string, err error) {
+	if strings.Contains(remote, "://") {
+		return remote, "", nil
+	}
+	config, err := NewConfigService(m.common).Read()
+	if err != nil {
+		return "", "", err
+	}
+	url, ok := config.Get("remote." + remote + ".url")
+	if !ok {
+		return "", "", fmt.Errorf("%q does not appear to be a git repository", remote)
+	}
+	return url, remote, nil
+}
+
+// fetch lists the refs of the repository at url and gets the objects reachable from its branches
+// that are missing, and the tags pointing to them. The shallow file is updated if depth is
+// positive.
+func (m *MGIService) fetch(url string, depth int) (*FetchResult, error) {
+	url = strings.TrimSuffix(url, "/")
+	refs, capabilities, err := m.listRemoteRefs(url)
+	if err != nil {
+		return nil, err
+	}
+	result := &FetchResult{}
+	for _, ref := range refs {
+		if ref.Name == "HEAD" {
+			result.HeadHash = ref.Hash
+			continue
+		}
+		result.Refs = append(result.Refs, ref)
+	}
+	for _, c := range capabilities {
+		if strings.HasPrefix(c, "symref=HEAD:") {
+			result.Head = strings.TrimPrefix(c, "symref=HEAD:")
+		}
+	}
+
+	shallow, err := m.readShallow()
+	if err != nil {
+		return nil, err
+	}
+	if (depth > 0 || len(shallow) > 0) && !hasCapability(capabilities, "shallow") {
+		return nil, fmt.Errorf("the remote repository does not support shallow histories")
+	}
+	haves, err := m.localTips()
+	if err != nil {
+		return nil, err
+	}
+
+	// Commits that are already here aren't wanted, unless the history is being deepened
+	var wants []string
+	seen := make(map[string]bool)
+	for _, ref := range result.Refs {
+		if !strings.HasPrefix(ref.Name, "refs/heads/") || seen[ref.Hash] {
+			continue
+		}
+		seen[ref.Hash] = true
+		exists, err := m.hasObject(ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if !exists || depth > 0 {
+			wants = append(wants, ref.Hash)
+		}
+	}
+	if len(wants) > 0 {
+		shallow, err = m.fetchPack(url, wants, haves, shallow, depth)
+		if err != nil {
+			return nil, err
+		}
+		if depth > 0 {
+			err = m.writeShallow(shallow)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Like git, annotated tags are only fetched if what they point to is here by now
+	peeled := make(map[string]string)
+	for _, ref := range result.Refs {
+		if strings.HasSuffix(ref.Name, "^{}") {
+			peeled[strings.TrimSuffix(ref.Name, "^{}")] = ref.Hash
+		}
+	}
+	var tags []string
+	for _, ref := range result.Refs {
+		target, ok := peeled[ref.Name]
+		if !ok || seen[ref.Hash] {
+			continue
+		}
+		seen[ref.Hash] = true
+		have, err := m.hasObject(ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+		pointsHere, err := m.hasObject(target)
+		if err != nil {
+			return nil, err
+		}
+		if !have && pointsHere {
+			tags = append(tags, ref.Hash)
+		}
+	}
+	if len(tags) > 0 {
+		_, err = m.fetchPack(url, tags, append(haves, wants...), shallow, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// fetchPack asks the repository at url for the wanted objects, telling it about the ones that
+// are here and the boundary of the shallow history, and stores the objects it sends. If depth is
+// positive, the history is limited to depth commits, and the new shallow boundary is returned.
+func (m *MGIService) fetchPack(url string, wants, haves []string, shallow map[string]bool, depth int) (map[string]bool, error) {
+	var req bytes.Buffer
+	for i, want := range wants {
+		if i == 0 {
+			writePktLine(&req, "want %s agent=mgi\n", want)
+		} else {
+			writePktLine(&req, "want %s\n", want)
+		}
+	}
+	boundary := make([]string, 0, len(shallow))
+	for hash := range shallow {
+		boundary = append(boundary, hash)
+	}
+	sort.Strings(boundary)
+	for _, hash := range boundary {
+		writePktLine(&req, "shallow %s\n", hash)
+	}
+	if depth > 0 {
+		writePktLine(&req, "deepen %d\n", depth)
+	}
+	writeFlushPkt(&req)
+	for _, have := range haves {
+		writePktLine(&req, "have %s\n", have)
+	}
+	writePktLine(&req, "done\n")
+
+	m.logger.Debugf("fetch: asking %s for %d objects", url, len(wants))
+	resp, err := http.Post(url+"/git-upload-pack", "application/x-git-upload-pack-request", &req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	br := bufio.NewReader(resp.Body)
+	if depth > 0 {
+		updated := make(map[string]bool, len(shallow))
+		for hash := range shallow {
+			updated[hash] = true
+		}
+		for {
+			line, err := readPktLine(br)
+			if err != nil {
+				return nil, fmt.Errorf("reading the shallow list: %v", err)
+			}
+			if line == "" {
+				break
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 2 && fields[0] == "shallow":
+				updated[fields[1]] = true
+			case len(fields) == 2 && fields[0] == "unshallow":
+				delete(updated, fields[1])
+			default:
+				return nil, fmt.Errorf("malformed shallow line %q", line)
+			}
+		}
+		shallow = updated
+	}
+	line, err := readPktLine(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading the acknowledgments: %v", err)
+	}
+	if line != "NAK" && !strings.HasPrefix(line, "ACK ") {
+		return nil, fmt.Errorf("unexpected line %q instead of an acknowledgment", line)
+	}
+
+	// Thin packs may have deltas against objects of the repository
+	n := 0
+	_, err = readPackStream(br, m.obj.ReadTypedObject, func(e *packEntry) error {
+		n++
+		_, err := m.obj.StoreObject(&rawObject{objType: e.objType, data: e.data})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.logger.Debugf("fetch: received %d objects", n)
+	return shallow, nil
+}
+
+// listRemoteRefs returns the refs advertised by the repository at url, and its capabilities.
+func (m *MGIService) listRemoteRefs(url string) ([]*Ref, []string, error) {
+	resp, err := http.Get(url + "/info/refs?service=git-upload-pack")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-advertisement" {
+		return nil, nil, fmt.Errorf("%s does not speak git's smart HTTP protocol", url)
+	}
+
+	br := bufio.NewReader(resp.Body)
+	line, err := readPktLine(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	if line != "# service=git-upload-pack" {
+		return nil, nil, fmt.Errorf("unexpected line %q in the ref advertisement", line)
+	}
+	if line, err = readPktLine(br); err != nil || line != "" {
+		return nil, nil, fmt.Errorf("malformed ref advertisement")
+	}
+
+	var refs []*Ref
+	var capabilities []string
+	for {
+		line, err := readPktLine(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		if line == "" {
+			break
+		}
+		if i := strings.IndexByte(line, 0); i >= 0 {
+			capabilities = strings.Fields(line[i+1:])
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("malformed ref %q", line)
+		}
+		if fields[1] == "capabilities^{}" {
+			continue
+		}
+		if _, err := new(Hash).FromString(fields[0]); err != nil {
+			return nil, nil, fmt.Errorf("malformed ref %q", line)
+		}
+		refs = append(refs, &Ref{Name: fields[1], Hash: fields[0]})
+	}
+	return refs, capabilities, nil
+}
+
+// localTips returns the commits the refs of the repository point to, which are sent to the
+// remote repository as the objects that don't have to be sent back.
+func (m *MGIService) localTips() ([]string, error) {
+	refs, err := m.listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var tips []string
+	for _, ref := range refs {
+		if seen[ref.Hash] {
+			continue
+		}
+		seen[ref.Hash] = true
+		tips = append(tips, ref.Hash)
+	}
+	return tips, nil
+}
+
+// hasObject returns whether the object is in the repository.
+func (m *MGIService) hasObject(hash string) (bool, error) {
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return false, err
+	}
+	return m.obj.Exists(h)
+}
+
+// writeShallow replaces the shallow file with the given commits, removing it if there are none.
+func (m *MGIService) writeShallow(shallow map[string]bool) error {
+	path := m.gitPath("shallow")
+	lock, err := acquireLock(path)
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	if len(shallow) == 0 {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	lines := make([]string, 0, len(shallow))
+	for hash := range shallow {
+		lines = append(lines, hash+"\n")
+	}
+	sort.Strings(lines)
+	lock.fsync = m.fsync
+	_, err = lock.Write([]byte(strings.Join(lines, "")))
+	if err != nil {
+		return err
+	}
+	return lock.commit()
+}
+
+// hasCapability returns whether the capability is in the list.
+func hasCapability(capabilities []string, name string) bool {
+	for _, c := range capabilities {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
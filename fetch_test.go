@@ -0,0 +1,80 @@
+package mgi
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCloneAndFetchWithDepth(t *testing.T) {
+	origin := newTestRepo(t)
+	first := commitTestFiles(t, origin, "first", map[string]string{"a": "1\n"})
+	second := commitTestFiles(t, origin, "second", map[string]string{"a": "2\n"})
+	third := commitTestFiles(t, origin, "third", map[string]string{"a": "3\n"})
+	// The clone changes to another directory, so the origin has to be found without it
+	gitDir, err := filepath.Abs(".git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin, err = NewRepo(gitDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(origin.HTTPHandler())
+	defer server.Close()
+
+	clone := newTestRepo(t)
+	err = clone.Clone(server.URL+"/origin.git", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkShallow := func(want ...string) {
+		t.Helper()
+		shallow, err := clone.readShallow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shallow) != len(want) {
+			t.Errorf("shallow = %v, want %v", shallow, want)
+		}
+		for _, hash := range want {
+			if !shallow[hash] {
+				t.Errorf("shallow = %v, want %v", shallow, want)
+			}
+		}
+	}
+	checkShallow(third)
+	data, err := ioutil.ReadFile("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "3\n" {
+		t.Errorf("a = %q after cloning, want %q", data, "3\n")
+	}
+	if exists, _ := clone.hasObject(second); exists {
+		t.Errorf("the parent of the shallow commit was fetched")
+	}
+	if tracking, _ := clone.readRef("refs/remotes/origin/master"); tracking != third {
+		t.Errorf("refs/remotes/origin/master = %s, want %s", tracking, third)
+	}
+
+	_, err = clone.Fetch("origin", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkShallow(second)
+
+	_, err = clone.Fetch("origin", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkShallow()
+	if _, err := os.Stat(".git/shallow"); !os.IsNotExist(err) {
+		t.Errorf("the shallow file was kept once the history is complete")
+	}
+	if exists, _ := clone.hasObject(first); !exists {
+		t.Errorf("the root commit wasn't fetched when deepening")
+	}
+}
@@ -0,0 +1,184 @@
+package mgi
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/bertinatto/mgi/attributes"
+	"github.com/bertinatto/mgi/diff"
+)
+
+// Content filters convert files between the form they have in the working tree and the form
+// their blobs are stored in, e.g. turning CRLF line endings into LF with core.autocrlf or the
+// text and eol attributes.
+//
+// Blobs are always stored clean. There are two ways of reading them:
+//   - raw, with ObjectService.ReadObject, which is what cat-file, diff and every command that
+//     shows or compares objects use;
+//   - filtered, with smudgeBlob, which is what checkoutFile uses to write the working tree for
+//     restore, stash and worktree add, and what cat-file --filters shows.
+//
+// In the other direction, add, update-index, status, diff and stash read working tree files
+// with readWorkingFile, which cleans them, so a file that was just checked out is not modified.
+
+// contentFilter holds the filters configured for the repository: core.autocrlf and the text,
+// eol and binary attributes of .gitattributes files, which take precedence over it.
+type contentFilter struct {
+	autocrlf string // "true", "input" or "false"
+	root     string // root of the working tree
+	attrs    *attributes.Matcher
+}
+
+// contentFilter returns the filters configured for the repository, reading the configuration
+// the first time.
+func (m *MGIService) contentFilter() (*contentFilter, error) {
+	if m.filter != nil {
+		return m.filter, nil
+	}
+	config, err := NewConfigService(m.common).Read()
+	if err != nil {
+		return nil, err
+	}
+	f := &contentFilter{autocrlf: "false"}
+	if value, ok := config.Get("core.autocrlf"); ok && value == "input" {
+		f.autocrlf = value
+	} else {
+		on, err := config.GetBool("core.autocrlf")
+		if err != nil {
+			return nil, err
+		}
+		if on {
+			f.autocrlf = "true"
+		}
+	}
+
+	f.root, err = findRoot(m.root)
+	if err != nil {
+		return nil, err
+	}
+	f.attrs, err = attributes.NewMatcher(f.root, filepath.Join(m.common, "info", "attributes"))
+	if err != nil {
+		return nil, err
+	}
+	m.filter = f
+	return f, nil
+}
+
+// attributes returns the attributes of the file at path. Files outside of the working tree have
+// none.
+func (f *contentFilter) attributes(path string) (attributes.Attributes, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(f.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return attributes.Attributes{}, nil
+	}
+	return f.attrs.Attributes(rel)
+}
+
+// lineEndings returns whether the line endings of the file at path are converted, and whether
+// they are CRLF in the working tree. Files are converted if their text attribute is set, or if
+// it is "auto" or unspecified and they aren't binary, in which case core.autocrlf must be set.
+func (f *contentFilter) lineEndings(path string, data []byte) (convert, crlf bool, err error) {
+	attrs, err := f.attributes(path)
+	if err != nil {
+		return false, false, err
+	}
+	eol := attrs["eol"]
+	switch {
+	case attrs.IsUnset("text"):
+		return false, false, nil
+	case attrs["text"] == attributes.Set || eol == "lf" || eol == "crlf":
+		convert = true
+	case attrs["text"] == "auto":
+		convert = !diff.IsBinary(data)
+	default:
+		convert = f.autocrlf != "false" && !diff.IsBinary(data)
+	}
+
+	switch eol {
+	case "crlf":
+		crlf = true
+	case "lf":
+		crlf = false
+	default:
+		crlf = f.autocrlf == "true"
+	}
+	return convert, crlf, nil
+}
+
+// clean converts the contents of the working tree file at path into the form they are stored in.
+func (f *contentFilter) clean(path string, data []byte) ([]byte, error) {
+	convert, _, err := f.lineEndings(path, data)
+	if err != nil || !convert {
+		return data, err
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), nil
+}
+
+// smudge converts the stored contents of the file at path into the form they have in the
+// working tree.
+func (f *contentFilter) smudge(path string, data []byte) ([]byte, error) {
+	convert, crlf, err := f.lineEndings(path, data)
+	if err != nil || !convert || !crlf {
+		return data, err
+	}
+	// Lines already ending in CRLF are kept as they are
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n")), nil
+}
+
+// diffRenderer returns how the diff of the file at path is rendered with the given options: as
+// text if its diff attribute is set, as binary if it is unset (e.g. by the binary attribute),
+// and depending on its contents otherwise.
+func (m *MGIService) diffRenderer(path string, opts diff.Options) (func(oldLabel, newLabel string, a, b []byte) string, error) {
+	f, err := m.contentFilter()
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := f.attributes(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case attrs.IsUnset("diff"):
+		return opts.RenderBinary, nil
+	case attrs.IsSet("diff"):
+		return opts.Text, nil
+	}
+	return opts.Unified, nil
+}
+
+// readWorkingFile reads a file of the working tree in the form it would be stored in.
+func (m *MGIService) readWorkingFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := m.contentFilter()
+	if err != nil {
+		return nil, err
+	}
+	return f.clean(path, data)
+}
+
+// smudgeBlob reads a blob in the form it should have in the working tree at path.
+func (m *MGIService) smudgeBlob(path string, hash *Hash) ([]byte, error) {
+	objType, data, err := m.obj.ReadTypedObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "blob" {
+		return nil, fmt.Errorf("object %s is a %s, not a blob", hash, objType)
+	}
+	f, err := m.contentFilter()
+	if err != nil {
+		return nil, err
+	}
+	return f.smudge(path, data)
+}
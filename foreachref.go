@@ -0,0 +1,184 @@
+package mgi
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultRefFormat is the format of ForEachRef if none is given.
+const DefaultRefFormat = "%(objectname) %(objecttype)\t%(refname)"
+
+// ForEachRef describes the refs matching any of the patterns, or all refs if there are none,
+// like git for-each-ref. A pattern matches the refs under it, a whole path component at a time
+// (e.g. "refs/heads" but not "refs/hea"), or the refs it matches as a glob.
+//
+// Each ref is described by the format, where these fields are replaced:
+//   - %(refname): the full name of the ref, or its short name with %(refname:short);
+//   - %(objectname): the hash of the object it points to, abbreviated with %(objectname:short);
+//   - %(objecttype): the type of that object;
+//   - %(subject): the first line of its message, if it's a commit or an annotated tag.
+//
+// and "%%" is a literal "%". Refs are sorted by sortKey, "refname" or "committerdate", in
+// reverse if it starts with "-". Refs that don't point to commits are the oldest by date.
+func (m *MGIService) ForEachRef(patterns []string, format, sortKey string) ([]string, error) {
+	refs, err := m.listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) > 0 {
+		var matched []*Ref
+		for _, r := range refs {
+			if matchRefPattern(r.Name, patterns) {
+				matched = append(matched, r)
+			}
+		}
+		refs = matched
+	}
+
+	type refObject struct {
+		objType string
+		data    []byte
+	}
+	objects := make(map[string]*refObject)
+	read := func(r *Ref) (*refObject, error) {
+		if o, ok := objects[r.Name]; ok {
+			return o, nil
+		}
+		h, err := new(Hash).FromString(r.Hash)
+		if err != nil {
+			return nil, err
+		}
+		objType, data, err := m.obj.ReadTypedObject(h)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", r.Name, err)
+		}
+		objects[r.Name] = &refObject{objType: objType, data: data}
+		return objects[r.Name], nil
+	}
+
+	reverse := strings.HasPrefix(sortKey, "-")
+	switch strings.TrimPrefix(sortKey, "-") {
+	case "", "refname":
+		// listRefs sorts by name already
+	case "committerdate":
+		dates := make(map[string]time.Time)
+		for _, r := range refs {
+			o, err := read(r)
+			if err != nil {
+				return nil, err
+			}
+			if o.objType != "commit" {
+				continue
+			}
+			c, err := ParseCommit(o.data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", r.Name, err)
+			}
+			dates[r.Name] = c.CommitTime
+		}
+		sort.SliceStable(refs, func(i, j int) bool {
+			return dates[refs[i].Name].Before(dates[refs[j].Name])
+		})
+	default:
+		return nil, fmt.Errorf("unknown sort key %q", sortKey)
+	}
+	if reverse {
+		for i, j := 0, len(refs)-1; i < j; i, j = i+1, j-1 {
+			refs[i], refs[j] = refs[j], refs[i]
+		}
+	}
+
+	if format == "" {
+		format = DefaultRefFormat
+	}
+	lines := make([]string, 0, len(refs))
+	for _, r := range refs {
+		var out strings.Builder
+		for rest := format; rest != ""; {
+			i := strings.IndexByte(rest, '%')
+			if i < 0 {
+				out.WriteString(rest)
+				break
+			}
+			out.WriteString(rest[:i])
+			rest = rest[i:]
+			if strings.HasPrefix(rest, "%%") {
+				out.WriteByte('%')
+				rest = rest[2:]
+				continue
+			}
+			end := strings.IndexByte(rest, ')')
+			if !strings.HasPrefix(rest, "%(") || end < 0 {
+				return nil, fmt.Errorf("malformed format string %q", format)
+			}
+			field := rest[2:end]
+			rest = rest[end+1:]
+
+			switch field {
+			case "refname":
+				out.WriteString(r.Name)
+			case "refname:short":
+				out.WriteString(shortRefName(r.Name))
+			case "objectname":
+				out.WriteString(r.Hash)
+			case "objectname:short":
+				out.WriteString(m.abbrev(r.Hash))
+			case "objecttype", "subject":
+				o, err := read(r)
+				if err != nil {
+					return nil, err
+				}
+				if field == "objecttype" {
+					out.WriteString(o.objType)
+					break
+				}
+				switch o.objType {
+				case "commit":
+					c, err := ParseCommit(o.data)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %v", r.Name, err)
+					}
+					out.WriteString(subject(c.Message))
+				case "tag":
+					t, err := ParseTag(o.data)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %v", r.Name, err)
+					}
+					out.WriteString(subject(t.Message))
+				}
+			default:
+				return nil, fmt.Errorf("unknown field name: %s", field)
+			}
+		}
+		lines = append(lines, out.String())
+	}
+	return lines, nil
+}
+
+// matchRefPattern returns whether a ref is matched by any of the patterns of ForEachRef.
+func matchRefPattern(name string, patterns []string) bool {
+	for _, p := range patterns {
+		p = strings.TrimSuffix(p, "/")
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// shortRefName returns the name of a ref without the prefix of its kind, e.g. "master" for
+// "refs/heads/master".
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
@@ -0,0 +1,297 @@
+package mgi
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bertinatto/mgi/diff"
+)
+
+// mailDateFormat is how dates are written in the Date header of patch emails.
+const mailDateFormat = "Mon, 2 Jan 2006 15:04:05 -0700"
+
+// MailPatch is a commit formatted as an email by FormatPatch.
+type MailPatch struct {
+	// Name is the file name git format-patch would give it, e.g. "0001-Fix-the-parser.patch".
+	Name     string
+	Contents []byte
+}
+
+// FormatPatch formats each commit of a range as an email with the changes it made, like git
+// format-patch, oldest first. The range is either "<since>", for the commits from since to
+// HEAD, or "<from>..<to>", where either end defaults to HEAD. Merge commits are not supported.
+//
+// Each email is in mbox format: a "From <commit>" line, then the author, the date and the
+// subject, prefixed with "[PATCH n/m]", as headers, and the rest of the message followed by
+// "---" and the diff as the body.
+func (m *MGIService) FormatPatch(rev string) ([]*MailPatch, error) {
+	from, to := rev, "HEAD"
+	if i := strings.Index(rev, ".."); i >= 0 {
+		from, to = orHead(rev[:i]), orHead(rev[i+2:])
+	}
+	since, err := m.resolveCommit(from)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := m.reachable(since)
+	if err != nil {
+		return nil, err
+	}
+	log, err := m.Log(to, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*LogEntry
+	for i := len(log) - 1; i >= 0; i-- {
+		e := log[i]
+		if excluded[e.Hash] {
+			continue
+		}
+		if len(e.Commit.Parents) > 1 {
+			return nil, fmt.Errorf("commit %s is a merge, which cannot be formatted as a patch", e.Hash)
+		}
+		entries = append(entries, e)
+	}
+
+	patches := make([]*MailPatch, 0, len(entries))
+	for i, e := range entries {
+		prefix := "[PATCH]"
+		if len(entries) > 1 {
+			prefix = fmt.Sprintf("[PATCH %d/%d]", i+1, len(entries))
+		}
+		data, err := m.formatMail(e, prefix)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%04d-%s.patch", i+1, patchFileName(mailSubject(e.Commit.Message)))
+		patches = append(patches, &MailPatch{Name: name, Contents: data})
+	}
+	return patches, nil
+}
+
+// formatMail formats a commit that has at most one parent as a patch email.
+func (m *MGIService) formatMail(e *LogEntry, prefix string) ([]byte, error) {
+	before := map[string]*IndexEntry{}
+	if len(e.Commit.Parents) == 1 {
+		var err error
+		before, err = m.commitFiles(e.Commit.Parents[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	after, err := m.commitFiles(e.Hash)
+	if err != nil {
+		return nil, err
+	}
+	// Binary changes are included, so that the patches can be applied
+	diffs, err := m.diffFiles(before, after, DiffOptions{Render: diff.Options{Binary: true}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := e.Commit
+	subject, body := mailSubject(c.Message), mailBody(c.Message)
+	b := new(bytes.Buffer)
+	// The date of the "From" line is fixed, which tells it apart from a real mbox separator
+	fmt.Fprintf(b, "From %s Mon Sep 17 00:00:00 2001\n", e.Hash)
+	fmt.Fprintf(b, "From: %s <%s>\n", mailName(c.Author), c.AuthorEmail)
+	fmt.Fprintf(b, "Date: %s\n", c.AuthorTime.Format(mailDateFormat))
+	fmt.Fprintf(b, "Subject: %s\n\n", mime.QEncoding.Encode("utf-8", prefix+" "+subject))
+	if body != "" {
+		fmt.Fprintf(b, "%s\n\n", body)
+	}
+	fmt.Fprintf(b, "---\n")
+	for _, d := range diffs {
+		fmt.Fprintf(b, "%s", d)
+	}
+	fmt.Fprintf(b, "-- \nmgi\n\n")
+	return b.Bytes(), nil
+}
+
+// mailName returns the name of an author as written in the From header: encoded if it isn't
+// ASCII, and quoted if it has characters with a special meaning in addresses.
+func mailName(name string) string {
+	for _, r := range name {
+		if r >= 0x80 {
+			return mime.QEncoding.Encode("utf-8", name)
+		}
+	}
+	if strings.ContainsAny(name, `()<>[]:;@\,."`) {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name
+}
+
+// mailSubject returns the subject of a commit message for an email: its first paragraph,
+// joined into a single line.
+func mailSubject(message string) string {
+	paragraph := strings.SplitN(strings.TrimLeft(message, "\n"), "\n\n", 2)[0]
+	return strings.Join(strings.Fields(paragraph), " ")
+}
+
+// mailBody returns the rest of a commit message after the subject.
+func mailBody(message string) string {
+	parts := strings.SplitN(strings.TrimLeft(message, "\n"), "\n\n", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.Trim(parts[1], "\n")
+}
+
+// patchFileNameRe matches the runs of characters that are replaced with "-" in patch file names.
+var patchFileNameRe = regexp.MustCompile(`[^A-Za-z0-9._]+`)
+
+// patchFileName turns a subject into the part of a patch file name after the number, like git.
+func patchFileName(subject string) string {
+	name := patchFileNameRe.ReplaceAllString(subject, "-")
+	if len(name) > 52 {
+		name = name[:52]
+	}
+	return strings.TrimRight(strings.TrimLeft(name, "-."), "-.")
+}
+
+// mboxFromRe matches the lines that start each email of the patches written by FormatPatch.
+var mboxFromRe = regexp.MustCompile(`^From [0-9a-f]{40} `)
+
+// Am applies the patch emails in the mbox, such as those written by FormatPatch, and commits
+// each of them on the current branch with the author, date and message of the email, like git
+// am. The index must have no staged changes. If a patch doesn't apply, Am stops, keeping the
+// commits made so far, and the working tree and index are left as they were before the patch.
+func (m *MGIService) Am(mbox []byte) error {
+	staged, err := m.DiffCached("", DiffOptions{Format: DiffNameOnly})
+	if err != nil {
+		return err
+	}
+	if len(staged) > 0 {
+		return fmt.Errorf("the index has staged changes: %s", strings.Join(staged, ", "))
+	}
+
+	mails := splitMbox(mbox)
+	if len(mails) == 0 {
+		return fmt.Errorf("no patches found")
+	}
+	for i, data := range mails {
+		c, patch, err := parseMail(data)
+		if err != nil {
+			return fmt.Errorf("patch %d: %v", i+1, err)
+		}
+		err = m.Apply(patch, true, 0)
+		if err != nil {
+			return fmt.Errorf("patch failed at %04d %s: %v", i+1, subject(c.Message), err)
+		}
+
+		tree, err := m.writeTree()
+		if err != nil {
+			return err
+		}
+		parent, err := m.currentHead()
+		if err != nil {
+			return err
+		}
+		if parent != "" {
+			c.Parents = []string{parent}
+		}
+		c.Tree = tree
+		c.Committer, c.CommitterEmail = identity()
+		c.CommitTime = time.Now()
+		hash, err := m.writeCommit(c, false)
+		if err != nil {
+			return err
+		}
+		err = m.advanceHead(parent, hash, "am: "+subject(c.Message))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitMbox splits an mbox into its emails. Without a "From" line, the whole input is taken as
+// a single email.
+func splitMbox(mbox []byte) [][]byte {
+	var mails [][]byte
+	var cur []byte
+	for _, line := range bytes.SplitAfter(mbox, []byte("\n")) {
+		if mboxFromRe.Match(line) {
+			if len(bytes.TrimSpace(cur)) > 0 {
+				mails = append(mails, cur)
+			}
+			cur = nil
+			continue
+		}
+		cur = append(cur, line...)
+	}
+	if len(bytes.TrimSpace(cur)) > 0 {
+		mails = append(mails, cur)
+	}
+	return mails
+}
+
+// patchSubjectPrefixRe matches the "[PATCH ...]" prefix of patch email subjects.
+var patchSubjectPrefixRe = regexp.MustCompile(`^\s*(\[[^]]*\]\s*)+`)
+
+// parseMail returns the author, date and message of a patch email as a commit, along with
+// the patch it carries.
+func parseMail(data []byte) (*Commit, []byte, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	dec := new(mime.WordDecoder)
+
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid From header: %v", err)
+	}
+	date, err := time.Parse(mailDateFormat, msg.Header.Get("Date"))
+	if err != nil {
+		date, err = msg.Header.Date()
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Date header: %v", err)
+	}
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Subject header: %v", err)
+	}
+	subject = patchSubjectPrefixRe.ReplaceAllString(subject, "")
+
+	body := new(bytes.Buffer)
+	_, err = body.ReadFrom(msg.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The message ends at the "---" line, or at the diff if there is none
+	var message []string
+	lines := strings.SplitAfter(body.String(), "\n")
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\n")
+		if line == "---" {
+			i++
+			break
+		}
+		if strings.HasPrefix(line, "diff --git ") {
+			break
+		}
+		message = append(message, line)
+	}
+	text := subject
+	if rest := strings.Trim(strings.Join(message, "\n"), "\n"); rest != "" {
+		text += "\n\n" + rest
+	}
+
+	return &Commit{
+		Author:      from.Name,
+		AuthorEmail: from.Address,
+		AuthorTime:  date,
+		Message:     text,
+	}, []byte(strings.Join(lines[i:], "")), nil
+}
@@ -0,0 +1,276 @@
+package mgi
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FsckReport lists the problems found by Fsck, one line per object, e.g. "missing blob <hash>".
+type FsckReport struct {
+	// Missing lists the objects that are referenced but not in the object store.
+	Missing []string
+	// Corrupt lists the objects that can't be read or parsed, or whose contents don't match
+	// their hash.
+	Corrupt []string
+	// Dangling lists the unreachable objects that no other unreachable object points to. They
+	// are not errors, but what prune would remove.
+	Dangling []string
+}
+
+// OK returns whether the repository has no missing or corrupt objects.
+func (r *FsckReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0
+}
+
+// fsckLink is a reference from one object to another, along with the type it should have.
+type fsckLink struct {
+	hash    string
+	objType string
+}
+
+// fsckChecker holds the state of a single Fsck run.
+type fsckChecker struct {
+	m       *MGIService
+	objects map[string]bool       // every object in the store
+	types   map[string]string     // type of the objects read so far
+	links   map[string][]fsckLink // objects pointed to by the objects read so far
+	corrupt map[string]bool
+	report  *FsckReport
+}
+
+// Fsck verifies that every object reachable from the refs, their reflogs, the HEAD and index of
+// each worktree is present, and reports the dangling objects. Unless connectivityOnly is set,
+// every object in the store is also decompressed and rehashed to check that it is intact. With
+// connectivityOnly, only the objects needed to follow the links are read, and blobs are merely
+// checked for existence.
+func (m *MGIService) Fsck(connectivityOnly bool) (*FsckReport, error) {
+	c := &fsckChecker{
+		m:       m,
+		objects: make(map[string]bool),
+		types:   make(map[string]string),
+		links:   make(map[string][]fsckLink),
+		corrupt: make(map[string]bool),
+		report:  new(FsckReport),
+	}
+	add := func(hash *Hash) error {
+		c.objects[hash.String()] = true
+		return nil
+	}
+	err := m.allObjects(add)
+	if err != nil {
+		return nil, err
+	}
+
+	if !connectivityOnly {
+		hashes := make([]string, 0, len(c.objects))
+		for hash := range c.objects {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+		for _, hash := range hashes {
+			c.read(hash, true)
+		}
+	}
+
+	roots, err := m.fsckRoots()
+	if err != nil {
+		return nil, err
+	}
+	reachable, err := c.walk(roots)
+	if err != nil {
+		return nil, err
+	}
+	c.findDangling(reachable)
+
+	sort.Strings(c.report.Missing)
+	sort.Strings(c.report.Corrupt)
+	sort.Strings(c.report.Dangling)
+	return c.report, nil
+}
+
+// fsckRoots returns the objects everything else must be reachable from: the refs and the
+// values in their reflogs, and the HEAD and the staged blobs of each worktree.
+func (m *MGIService) fsckRoots() ([]fsckLink, error) {
+	var roots []fsckLink
+	refs, err := m.listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range refs {
+		roots = append(roots, fsckLink{hash: r.Hash})
+	}
+
+	values, err := m.reflogValues()
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range values {
+		roots = append(roots, fsckLink{hash: hash})
+	}
+
+	worktrees, err := m.worktreeServices()
+	if err != nil {
+		return nil, err
+	}
+	for _, wt := range worktrees {
+		head, err := wt.currentHead()
+		if err != nil {
+			return nil, err
+		}
+		if head != "" {
+			roots = append(roots, fsckLink{hash: head, objType: "commit"})
+		}
+
+		index, err := wt.index.Read()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range index.Entries {
+			if e.Mode == modeGitlink || e.IntentToAdd() {
+				continue
+			}
+			roots = append(roots, fsckLink{hash: e.Hash.String(), objType: "blob"})
+		}
+	}
+	return roots, nil
+}
+
+// read reads and parses an object, recording its type and links, and reports it if it is
+// corrupt. If verify is set, its contents are also rehashed. It returns false if the object
+// couldn't be read.
+func (c *fsckChecker) read(hash string, verify bool) bool {
+	if _, ok := c.types[hash]; ok {
+		return true
+	}
+	if c.corrupt[hash] {
+		return false
+	}
+	fail := func(err error) bool {
+		c.corrupt[hash] = true
+		c.report.Corrupt = append(c.report.Corrupt, fmt.Sprintf("error in object %s: %v", hash, err))
+		return false
+	}
+
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return fail(err)
+	}
+	objType, data, err := c.m.obj.ReadTypedObject(h)
+	if err != nil {
+		return fail(err)
+	}
+	if verify {
+		actual, err := c.m.obj.HashObject(&rawObject{objType: objType, data: data})
+		if err != nil {
+			return fail(err)
+		}
+		if actual.String() != hash {
+			return fail(fmt.Errorf("hash mismatch, contents hash to %s", actual))
+		}
+	}
+
+	var links []fsckLink
+	switch objType {
+	case "commit":
+		commit, err := ParseCommit(data)
+		if err != nil {
+			return fail(err)
+		}
+		links = append(links, fsckLink{hash: commit.Tree, objType: "tree"})
+		for _, p := range commit.Parents {
+			links = append(links, fsckLink{hash: p, objType: "commit"})
+		}
+	case "tree":
+		tree, err := ParseTree(data)
+		if err != nil {
+			return fail(err)
+		}
+		for _, e := range tree.Entries {
+			switch e.mode {
+			case modeDir:
+				links = append(links, fsckLink{hash: e.hash.String(), objType: "tree"})
+			case modeGitlink:
+				// Gitlinks point to commits of another repository
+			default:
+				links = append(links, fsckLink{hash: e.hash.String(), objType: "blob"})
+			}
+		}
+	case "tag":
+		tag, err := ParseTag(data)
+		if err != nil {
+			return fail(err)
+		}
+		links = append(links, fsckLink{hash: tag.Object, objType: tag.Type})
+	case "blob":
+	default:
+		return fail(fmt.Errorf("unknown object type %q", objType))
+	}
+	c.types[hash] = objType
+	c.links[hash] = links
+	return true
+}
+
+// walk follows the links from the roots and returns the objects reached, reporting the missing
+// ones. Blobs are not read, since they don't link to anything. The parents of shallow commits
+// are not expected to be present.
+func (c *fsckChecker) walk(roots []fsckLink) (map[string]bool, error) {
+	shallow, err := c.m.readShallow()
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[string]bool)
+	queue := roots
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		if reachable[l.hash] {
+			continue
+		}
+		reachable[l.hash] = true
+
+		if !c.objects[l.hash] {
+			objType := l.objType
+			if objType == "" {
+				objType = "object"
+			}
+			c.report.Missing = append(c.report.Missing, fmt.Sprintf("missing %s %s", objType, l.hash))
+			continue
+		}
+		if l.objType == "blob" || !c.read(l.hash, false) {
+			continue
+		}
+		if l.objType != "" && c.types[l.hash] != l.objType {
+			c.report.Corrupt = append(c.report.Corrupt,
+				fmt.Sprintf("object %s is a %s, not a %s", l.hash, c.types[l.hash], l.objType))
+		}
+		for _, next := range c.links[l.hash] {
+			if next.objType == "commit" && c.types[l.hash] == "commit" && shallow[l.hash] {
+				continue
+			}
+			queue = append(queue, next)
+		}
+	}
+	return reachable, nil
+}
+
+// findDangling reports the unreachable objects that no other unreachable object points to.
+func (c *fsckChecker) findDangling(reachable map[string]bool) {
+	var unreachable []string
+	for hash := range c.objects {
+		if !reachable[hash] && c.read(hash, false) {
+			unreachable = append(unreachable, hash)
+		}
+	}
+	referenced := make(map[string]bool)
+	for _, hash := range unreachable {
+		for _, l := range c.links[hash] {
+			referenced[l.hash] = true
+		}
+	}
+	for _, hash := range unreachable {
+		if !referenced[hash] {
+			c.report.Dangling = append(c.report.Dangling, fmt.Sprintf("dangling %s %s", c.types[hash], hash))
+		}
+	}
+}
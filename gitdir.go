@@ -0,0 +1,81 @@
+package mgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ResolveGitDir returns the repository directory a ".git" path stands for. Submodules and linked
+// worktrees have a ".git" file containing "gitdir: <path>" instead of a directory, in which case
+// the absolute path it names is returned (relative paths are relative to the file). Any other
+// path, including one that doesn't exist yet, is returned as is.
+func ResolveGitDir(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return path, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(contents))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("%s is not a gitdir file", path)
+	}
+	dir := strings.TrimPrefix(line, "gitdir: ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("%s points to %s: %v", path, dir, err)
+	}
+	return dir, nil
+}
+
+// commonDir returns the directory holding what all the worktrees of a repository share (objects,
+// refs and the configuration). It is named by the "commondir" file of a linked worktree's
+// repository directory, and is the repository directory itself otherwise.
+func commonDir(gitDir string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	dir := strings.TrimSpace(string(contents))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitDir, dir)
+	}
+	return filepath.Clean(dir)
+}
+
+// isCommonPath returns whether a file of the repository directory (e.g. "refs/heads/master" or
+// "logs/HEAD") is shared by all worktrees. HEAD, the index and their logs belong to each worktree.
+func isCommonPath(name string) bool {
+	name = filepath.ToSlash(name)
+	for _, prefix := range []string{"refs/", "logs/refs/", "worktrees/"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	switch name {
+	case "refs", "logs/refs", "worktrees", "packed-refs", "shallow", "config":
+		return true
+	}
+	return false
+}
+
+// gitPath returns the path of a file of the repository directory, in the common directory if all
+// worktrees share it.
+func (m *MGIService) gitPath(name string) string {
+	if isCommonPath(name) {
+		return filepath.Join(m.common, name)
+	}
+	return filepath.Join(m.root, name)
+}
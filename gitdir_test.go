@@ -0,0 +1,67 @@
+package mgi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitDirFile(t *testing.T) {
+	repo := newTestRepo(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Move the repository directory elsewhere, as git submodule absorbgitdirs does
+	err = os.Rename(repo.GitDir, "modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir := filepath.Join(wd, "modules")
+
+	for _, link := range []string{"gitdir: modules\n", "gitdir: " + gitDir + "\n"} {
+		writeTestFile(t, ".git", link)
+		got, err := ResolveGitDir(".git")
+		if err != nil {
+			t.Fatalf("ResolveGitDir with %q: %v", link, err)
+		}
+		if got != gitDir {
+			t.Errorf("ResolveGitDir with %q = %s, want %s", link, got, gitDir)
+		}
+	}
+
+	repo, err = NewRepo(".git", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.GitDir != gitDir {
+		t.Errorf("NewRepo opened %s, want %s", repo.GitDir, gitDir)
+	}
+	head := commitTestFiles(t, repo, "Initial commit", map[string]string{"file": "contents\n"})
+	if _, err := os.Stat(filepath.Join(gitDir, "refs/heads/master")); err != nil {
+		t.Errorf("the branch was not written to the repository directory: %v", err)
+	}
+	opened, err := OpenRepository(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := opened.currentHead(); err != nil || got != head {
+		t.Errorf("OpenRepository has HEAD at %s (%v), want %s", got, err, head)
+	}
+	untracked, modified, _, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(untracked) != 0 || len(modified) != 0 {
+		t.Errorf("Status() = %q, %q, want a clean working tree", untracked, modified)
+	}
+
+	writeTestFile(t, ".git", "modules\n")
+	if _, err := ResolveGitDir(".git"); err == nil {
+		t.Error("ResolveGitDir accepted a .git file without a gitdir line")
+	}
+	writeTestFile(t, ".git", "gitdir: missing\n")
+	if _, err := ResolveGitDir(".git"); err == nil {
+		t.Error("ResolveGitDir accepted a .git file pointing to a missing directory")
+	}
+}
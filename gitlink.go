@@ -0,0 +1,36 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// isNestedRepo returns whether the directory is the working tree of another repository, whose
+// ".git" may be a directory or a file.
+func isNestedRepo(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// gitlinkHead returns the commit checked out in the nested repository at dir, which is what a
+// gitlink entry for it records.
+func (m *MGIService) gitlinkHead(dir string) (*Hash, error) {
+	repo, err := NewRepo(filepath.Join(dir, ".git"), m.logger)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.currentHead()
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		return nil, fmt.Errorf("submodule %s has no commits yet", dir)
+	}
+	return new(Hash).FromString(head)
+}
+
+// gitlinkContents is what git shows as the contents of a gitlink in diffs.
+func gitlinkContents(hash *Hash) []byte {
+	return []byte(fmt.Sprintf("Subproject commit %s\n", hash))
+}
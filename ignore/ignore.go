@@ -0,0 +1,265 @@
+// Package ignore reads .gitignore files and tells which paths of a working tree are ignored,
+// following git's rules.
+//
+// Each line of an ignore file is a pattern. A pattern without a slash, other than a trailing
+// one, matches a file name at any depth below the directory of the ignore file, and any other
+// pattern is relative to that directory, with wildcards not matching slashes except in "**".
+// A trailing slash only matches directories, and a leading "!" re-includes the paths a previous
+// pattern ignored. Blank lines and lines starting with "#" are skipped.
+//
+// The last pattern that matches a path decides whether it is ignored, so a "!" pattern can
+// re-include a path ignored by any source with lower precedence. From the highest precedence to
+// the lowest, patterns come from the command line, the .gitignore files, with the directories
+// closer to a path taking precedence over their parents, and the exclude files (e.g. info/exclude
+// and then the global core.excludesFile). Paths inside an ignored directory are ignored too,
+// since git never looks inside it, and no pattern can re-include them.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bertinatto/mgi/wildmatch"
+)
+
+// rule is a line of an ignore file.
+type rule struct {
+	pattern *regexp.Regexp
+	base    bool // match the name of the file instead of its path
+	dirOnly bool
+	negate  bool
+	line    int
+	text    string // the pattern as written
+}
+
+// file is a parsed ignore file, with the directory its patterns are relative to.
+type file struct {
+	name  string
+	dir   string // slash-separated, relative to the root of the working tree, "" for the root
+	rules []*rule
+}
+
+// Match is the pattern that decides whether a path is ignored.
+type Match struct {
+	// Source is the ignore file of the pattern, and Line its line number in it.
+	Source string
+	Line   int
+	// Pattern is the pattern as written in the file, with its "!" if it's negated, in which
+	// case the path is not ignored.
+	Pattern string
+	Negate  bool
+}
+
+// Matcher tells whether the paths of a working tree are ignored. The .gitignore files are read
+// the first time a path under their directory is looked up.
+type Matcher struct {
+	root        string
+	excludes    []*file
+	files       map[string]*file // by directory, nil if it has no .gitignore file
+	commandLine []string
+	patterns    *file // the parsed commandLine patterns
+}
+
+// NewMatcher creates a Matcher for the working tree at root. The exclude files, such as
+// "info/exclude" in the repository directory, apply to the whole working tree, with the later
+// ones taking precedence. They don't need to exist.
+func NewMatcher(root string, excludeFiles ...string) (*Matcher, error) {
+	m := &Matcher{root: root, files: make(map[string]*file)}
+	for _, name := range excludeFiles {
+		f, err := readFile(name, "")
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			m.excludes = append(m.excludes, f)
+		}
+	}
+	return m, nil
+}
+
+// AddPatterns adds patterns given on the command line, which are relative to the root of the
+// working tree and take precedence over the ignore files. Later patterns take precedence over
+// earlier ones, including the ones of previous calls.
+func (m *Matcher) AddPatterns(patterns ...string) error {
+	commandLine := append(append([]string(nil), m.commandLine...), patterns...)
+	f, err := parse([]byte(strings.Join(commandLine, "\n")))
+	if err != nil {
+		return err
+	}
+	m.commandLine = commandLine
+	m.patterns = f
+	return nil
+}
+
+// Ignored returns whether the path, given relative to the root of the working tree, is ignored.
+// The parent directories of the path are not checked: callers walking the working tree are
+// expected to skip the directories that are ignored.
+func (m *Matcher) Ignored(name string, isDir bool) (bool, error) {
+	match, err := m.lastMatch(path.Clean(filepath.ToSlash(name)), isDir)
+	if err != nil || match == nil {
+		return false, err
+	}
+	return !match.Negate, nil
+}
+
+// Match returns the pattern that decides whether the path, given relative to the root of the
+// working tree, is ignored, or nil if no pattern matches it. Unlike with Ignored, the parent
+// directories of the path are checked too, so that the paths inside an ignored directory are
+// matched by the pattern that ignores it.
+func (m *Matcher) Match(name string, isDir bool) (*Match, error) {
+	name = path.Clean(filepath.ToSlash(name))
+	for i, c := range name {
+		if c != '/' {
+			continue
+		}
+		match, err := m.lastMatch(name[:i], true)
+		if err != nil {
+			return nil, err
+		}
+		if match != nil && !match.Negate {
+			return match, nil
+		}
+	}
+	return m.lastMatch(name, isDir)
+}
+
+// lastMatch returns the pattern that matches the clean path with the highest precedence, if any.
+func (m *Matcher) lastMatch(name string, isDir bool) (*Match, error) {
+	// From the lowest precedence to the highest, so that the last match wins
+	files := append([]*file(nil), m.excludes...)
+	dirs := []string{""}
+	for i, c := range name {
+		if c == '/' {
+			dirs = append(dirs, name[:i])
+		}
+	}
+	for _, dir := range dirs {
+		f, err := m.dirFile(dir)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	if m.patterns != nil {
+		files = append(files, m.patterns)
+	}
+
+	var match *Match
+	for _, f := range files {
+		if r := f.match(name, isDir); r != nil {
+			match = &Match{Source: f.name, Line: r.line, Pattern: r.text, Negate: r.negate}
+		}
+	}
+	return match, nil
+}
+
+// dirFile returns the .gitignore file of a directory, reading it if needed.
+func (m *Matcher) dirFile(dir string) (*file, error) {
+	if f, ok := m.files[dir]; ok {
+		return f, nil
+	}
+	f, err := readFile(filepath.Join(m.root, filepath.FromSlash(dir), ".gitignore"), dir)
+	if err != nil {
+		return nil, err
+	}
+	m.files[dir] = f
+	return f, nil
+}
+
+// match returns the last line of the file that matches the path, if any.
+func (f *file) match(name string, isDir bool) *rule {
+	rel := name
+	if f.dir != "" {
+		if !strings.HasPrefix(name, f.dir+"/") {
+			return nil
+		}
+		rel = name[len(f.dir)+1:]
+	}
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		r := f.rules[i]
+		if r.dirOnly && !isDir {
+			continue
+		}
+		subject := rel
+		if r.base {
+			subject = path.Base(rel)
+		}
+		if r.pattern.MatchString(subject) {
+			return r
+		}
+	}
+	return nil
+}
+
+// readFile parses the ignore file, whose patterns are relative to dir. It returns nil if the
+// file doesn't exist.
+func readFile(filename, dir string) (*file, error) {
+	data, err := ioutil.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	f.name = filename
+	f.dir = dir
+	return f, nil
+}
+
+// parse parses the contents of an ignore file whose patterns are relative to the root.
+func parse(data []byte) (*file, error) {
+	f := new(file)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := trimTrailingSpaces(strings.TrimSuffix(scanner.Text(), "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r := &rule{line: n, text: line}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.base = !strings.Contains(line, "/")
+
+		re, err := wildmatch.Compile(strings.TrimPrefix(line, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q: %v", n, line, err)
+		}
+		r.pattern = re
+		f.rules = append(f.rules, r)
+	}
+	return f, scanner.Err()
+}
+
+// trimTrailingSpaces removes the spaces at the end of a line, unless they are escaped.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -21,9 +22,17 @@ type Index struct {
 	Version    string
 	EntryCount int
 	Entries    []*IndexEntry
+	Extensions []*IndexExtension
 	Hash       *Hash
 }
 
+// IndexExtension is an extension section stored after the index entries, such as the cached
+// trees ("TREE") or the resolve-undo data ("REUC"). Its data is kept as is.
+type IndexExtension struct {
+	Signature string
+	Data      []byte
+}
+
 // IndexEntry stores
 type IndexEntry struct {
 	CTimeSecs     uint32
@@ -38,26 +47,86 @@ type IndexEntry struct {
 	FileSize      uint32
 	Hash          *Hash
 	Flags         uint16
+	ExtendedFlags uint16 // Only stored if Flags has indexFlagExtended set (version 3 and up)
 	Path          string
 }
 
+const (
+	// indexFlagAssumeValid is set in IndexEntry.Flags for entries marked as assume-unchanged.
+	indexFlagAssumeValid = 0x8000
+
+	// indexFlagExtended is set in IndexEntry.Flags when the entry has extended flags.
+	indexFlagExtended = 0x4000
+
+	// indexFlagStageMask covers the bits of IndexEntry.Flags that store the merge stage.
+	indexFlagStageMask  = 0x3000
+	indexFlagStageShift = 12
+
+	// indexFlagNameMask covers the bits of IndexEntry.Flags that store the length of the path.
+	indexFlagNameMask = 0x0fff
+
+	// indexExtFlagIntentToAdd is set in IndexEntry.ExtendedFlags for entries added with "add -N".
+	indexExtFlagIntentToAdd = 0x2000
+)
+
+// AssumeUnchanged returns whether the working tree file should be assumed to match the entry.
+func (e *IndexEntry) AssumeUnchanged() bool {
+	return e.Flags&indexFlagAssumeValid != 0
+}
+
+// Stage returns the merge stage of the entry: 0 for a merged file, or 1, 2 and 3 for the common
+// ancestor, our and their versions of a file with conflicts.
+func (e *IndexEntry) Stage() int {
+	return int(e.Flags&indexFlagStageMask) >> indexFlagStageShift
+}
+
+// nameFlags returns the flags encoding the path length, which saturates if it doesn't fit.
+func nameFlags(path string) uint16 {
+	if len(path) >= indexFlagNameMask {
+		return indexFlagNameMask
+	}
+	return uint16(len(path))
+}
+
+// IntentToAdd returns whether the entry only records that the file will be added later.
+func (e *IndexEntry) IntentToAdd() bool {
+	return e.ExtendedFlags&indexExtFlagIntentToAdd != 0
+}
+
 type IndexService struct {
-	path  string
-	index *Index
+	path   string
+	index  *Index
+	fsync  bool
+	logger Logger
+	lock   *lockFile // held from Lock to Unlock
+	locks  int       // nested calls to Lock
 }
 
-func NewIndexService(root string) *IndexService {
+// NewIndexService creates a new IndexService. The logger may be nil.
+func NewIndexService(root string, logger Logger) *IndexService {
 	return &IndexService{
 		path: filepath.Join(root, "index"),
 		index: &Index{
 			Signature: "DIRC",
 			Version:   "2",
 		},
+		logger: orNop(logger),
 	}
 }
 
+// SetFsync sets whether the index is flushed to disk before Store returns.
+func (i *IndexService) SetFsync(on bool) {
+	i.fsync = on
+}
+
 func (i *IndexService) Add(path string, hash *Hash) error {
-	fi, err := os.Stat(path)
+	return i.AddFile(path, path, hash)
+}
+
+// AddFile is like Add, but the file metadata is taken from file rather than from path.
+// This allows adding files of a working tree other than the current directory.
+func (i *IndexService) AddFile(file, path string, hash *Hash) error {
+	fi, err := os.Stat(file)
 	if err != nil {
 		return err
 	}
@@ -82,26 +151,139 @@ func (i *IndexService) Add(path string, hash *Hash) error {
 		Gid:           stat.Gid,
 		FileSize:      uint32(stat.Size),
 		Hash:          hash,
-		Flags:         uint16(len(path)),
+		Flags:         nameFlags(path),
 		Path:          path,
 	}
 
-	var replaced bool
-	for ei, v := range i.index.Entries {
-		if v.Path == entry.Path {
-			i.index.Entries[ei] = entry
-			replaced = true
+	i.AddEntry(entry)
+	return nil
+}
+
+// AddGitlink adds an entry recording that the nested repository at path has the commit checked out.
+func (i *IndexService) AddGitlink(path string, commit *Hash) {
+	i.AddEntry(&IndexEntry{
+		Mode:  modeGitlink,
+		Hash:  commit,
+		Flags: nameFlags(path),
+		Path:  path,
+	})
+}
+
+// AddEntry adds the entry to the index, replacing the existing entry for the same path and stage,
+// if any. Adding a merged entry (stage 0) replaces the entries of all stages, which resolves the
+// conflicts of the file.
+func (i *IndexService) AddEntry(entry *IndexEntry) {
+	i.logger.Debugf("index: adding %s (%s)", entry.Path, entry.Hash)
+	i.invalidateCacheTree()
+
+	entries := i.index.Entries[:0]
+	for _, v := range i.index.Entries {
+		if v.Path != entry.Path || (v.Stage() != entry.Stage() && entry.Stage() != 0) {
+			entries = append(entries, v)
 		}
 	}
+	i.index.Entries = append(entries, entry)
+	i.index.EntryCount = len(i.index.Entries)
+}
+
+// AddIntentToAdd records that the file will be added later, without staging its contents.
+// The entry points to the empty blob until the file is added for real.
+func (i *IndexService) AddIntentToAdd(path string) error {
+	for _, v := range i.index.Entries {
+		if v.Path == path {
+			// Already tracked, nothing to do
+			return nil
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	mode := uint32(0100644)
+	if fi.Mode()&0100 != 0 {
+		mode = 0100755
+	}
 
-	if !replaced {
-		i.index.Entries = append(i.index.Entries, entry)
-		i.index.EntryCount = len(i.index.Entries)
+	i.logger.Debugf("index: adding %s as intent-to-add", path)
+	i.invalidateCacheTree()
+	i.index.Entries = append(i.index.Entries, &IndexEntry{
+		Mode:          mode,
+		Hash:          new(Hash).From([]byte("blob 0\x00")),
+		Flags:         nameFlags(path) | indexFlagExtended,
+		ExtendedFlags: indexExtFlagIntentToAdd,
+		Path:          path,
+	})
+	i.index.EntryCount = len(i.index.Entries)
+	return nil
+}
+
+// SetAssumeUnchanged sets or clears the assume-unchanged bit of the entry for the given path.
+// It returns os.ErrNotExist if there is no such entry.
+func (i *IndexService) SetAssumeUnchanged(path string, value bool) error {
+	for _, v := range i.index.Entries {
+		if v.Path == path {
+			if value {
+				v.Flags |= indexFlagAssumeValid
+			} else {
+				v.Flags &^= indexFlagAssumeValid
+			}
+			return nil
+		}
 	}
+	return os.ErrNotExist
+}
 
+// Remove removes the entries for the given path, of all stages. It returns os.ErrNotExist if
+// there is no such entry.
+func (i *IndexService) Remove(path string) error {
+	entries := i.index.Entries[:0]
+	for _, v := range i.index.Entries {
+		if v.Path != path {
+			entries = append(entries, v)
+		}
+	}
+	if len(entries) == len(i.index.Entries) {
+		return os.ErrNotExist
+	}
+	i.logger.Debugf("index: removing %s", path)
+	i.invalidateCacheTree()
+	i.index.Entries = entries
+	i.index.EntryCount = len(i.index.Entries)
 	return nil
 }
 
+// Rename moves the entry for oldPath to newPath, keeping its contents and metadata.
+// It returns os.ErrNotExist if there is no entry for oldPath.
+func (i *IndexService) Rename(oldPath, newPath string) error {
+	for _, v := range i.index.Entries {
+		if v.Path == oldPath {
+			entry := *v
+			entry.Path = newPath
+			entry.Flags = entry.Flags&^indexFlagNameMask | nameFlags(newPath)
+			err := i.Remove(oldPath)
+			if err != nil {
+				return err
+			}
+			i.AddEntry(&entry)
+			return nil
+		}
+	}
+	return os.ErrNotExist
+}
+
+// invalidateCacheTree drops the cached trees extension, which no longer matches the entries
+// once they change. Other extensions are kept.
+func (i *IndexService) invalidateCacheTree() {
+	extensions := i.index.Extensions[:0]
+	for _, ext := range i.index.Extensions {
+		if ext.Signature != "TREE" {
+			extensions = append(extensions, ext)
+		}
+	}
+	i.index.Extensions = extensions
+}
+
 func (i *IndexService) Marshal() ([]byte, error) {
 	// Build the index signature.
 	var signature [4]byte
@@ -117,12 +299,22 @@ func (i *IndexService) Marshal() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, v := range i.index.Entries {
+		// Extended flags are only supported from version 3 on
+		if v.Flags&indexFlagExtended != 0 && version < 3 {
+			version = 3
+		}
+	}
 	binary.Write(mb, binary.BigEndian, uint32(version))
 	binary.Write(mb, binary.BigEndian, uint32(i.index.EntryCount))
 
-	// Index entries should be sorted
+	// Index entries should be sorted by path, and then by stage
 	sort.Slice(i.index.Entries, func(x, y int) bool {
-		return i.index.Entries[x].Path < i.index.Entries[y].Path
+		a, b := i.index.Entries[x], i.index.Entries[y]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Stage() < b.Stage()
 	})
 
 	// Serialize each index entry to a format that can be stored on disk.
@@ -140,33 +332,93 @@ func (i *IndexService) Marshal() ([]byte, error) {
 		binary.Write(b, binary.BigEndian, v.FileSize)
 		binary.Write(b, binary.BigEndian, v.Hash)
 		binary.Write(b, binary.BigEndian, v.Flags)
+		if v.Flags&indexFlagExtended != 0 {
+			binary.Write(b, binary.BigEndian, v.ExtendedFlags)
+		}
+		headerLen := b.Len()
 		b.WriteString(v.Path)
 		b.WriteString("\x00")
 
 		// Some entries require some padding.
-		length := ((62 + len(v.Path) + 8) / 8) * 8
+		length := ((headerLen + len(v.Path) + 8) / 8) * 8
 		for b.Len() < length {
 			b.WriteString("\x00")
 		}
 		mb.Write(b.Bytes())
 	}
 
+	// Extensions are written back after the entries.
+	for _, ext := range i.index.Extensions {
+		if len(ext.Signature) != 4 {
+			return nil, fmt.Errorf("extension signature %q must be 4 bytes long", ext.Signature)
+		}
+		mb.WriteString(ext.Signature)
+		binary.Write(mb, binary.BigEndian, uint32(len(ext.Data)))
+		mb.Write(ext.Data)
+	}
+
 	binary.Write(mb, binary.BigEndian, sha1.Sum(mb.Bytes()))
 	return mb.Bytes(), nil
 }
 
+// Lock creates index.lock, so that no other process can change the index until Unlock is called.
+// Operations that read the index, change it and store it take the lock before reading it, so
+// that they don't overwrite the changes made by others in between. Calls may be nested: the lock
+// is released by the last Unlock. It fails right away if another process holds the lock.
+func (i *IndexService) Lock() error {
+	if i.locks == 0 {
+		lock, err := acquireLock(i.path)
+		if err != nil {
+			return err
+		}
+		i.lock = lock
+	}
+	i.locks++
+	return nil
+}
+
+// Unlock releases the lock taken by Lock, leaving the index as it was last stored.
+func (i *IndexService) Unlock() {
+	if i.locks == 0 {
+		return
+	}
+	i.locks--
+	if i.locks == 0 && i.lock != nil {
+		i.lock.rollback()
+		i.lock = nil
+	}
+}
+
+// Store writes the index to disk. If the index is locked, it is written while keeping the lock,
+// so it can be stored again before Unlock. Otherwise, the lock is only taken while writing, and
+// Store fails if another process holds it.
 func (i *IndexService) Store() error {
 	data, err := i.Marshal()
 	if err != nil {
 		return err
 	}
-	fd, err := os.OpenFile(i.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	i.logger.Debugf("writing index with %d entries to %s", len(i.index.Entries), i.path)
+
+	if i.lock != nil {
+		i.lock.fsync = i.fsync
+		return i.lock.replace(data)
+	}
+
+	// The new index is written to index.lock, which is then renamed over the index. This keeps
+	// concurrent mgi processes from writing it at the same time, and readers never see a
+	// partially written index.
+	lock, err := acquireLock(i.path)
 	if err != nil {
 		return err
 	}
-	defer fd.Close()
-	_, err = fd.Write(data)
-	return err
+	defer lock.rollback()
+	lock.fsync = i.fsync
+
+	_, err = lock.Write(data)
+	if err != nil {
+		return err
+	}
+	return lock.commit()
 }
 
 func (i *IndexService) Read() (*Index, error) {
@@ -185,16 +437,26 @@ func (i *IndexService) Read() (*Index, error) {
 		return nil, err
 	}
 
+	// The smallest valid index has a 12 bytes header and a 20 bytes digest.
+	if len(data) < 12+20 {
+		return nil, fmt.Errorf("index file is too short")
+	}
+
 	// Pre-populate header.
 	i.index.Signature = string(data[:4])
 	i.index.Version = fmt.Sprintf("%d", binary.BigEndian.Uint32(data[4:8]))
 	i.index.EntryCount = int(binary.BigEndian.Uint32(data[8:12]))
 	i.index.Hash = new(Hash).FromSHA1Bytes(data[len(data)-20:])
 
-	if i.index.Version != "2" {
+	if i.index.Version != "2" && i.index.Version != "3" {
 		return nil, fmt.Errorf("unsupported version %q", i.index.Version)
 	}
 
+	// Every entry takes at least 64 bytes, so a larger count can't be right.
+	if i.index.EntryCount > (len(data)-12-20)/64 {
+		return nil, fmt.Errorf("index claims %d entries, more than it can hold", i.index.EntryCount)
+	}
+
 	payloadHash := new(Hash).From(data[:len(data)-20])
 	if i.index.Hash.String() != payloadHash.String() {
 		return nil, fmt.Errorf("digests don't match")
@@ -282,6 +544,19 @@ func (i *IndexService) Read() (*Index, error) {
 		}
 		e.Flags = binary.BigEndian.Uint16(v)
 
+		headerLen := 62
+		if e.Flags&indexFlagExtended != 0 {
+			if i.index.Version == "2" {
+				return nil, fmt.Errorf("extended flags are not allowed in version 2")
+			}
+			v, err = readNBytes(reader, 2)
+			if err != nil {
+				return nil, err
+			}
+			e.ExtendedFlags = binary.BigEndian.Uint16(v)
+			headerLen += 2
+		}
+
 		path, err := reader.ReadBytes('\x00')
 		if err != nil {
 			return nil, err
@@ -290,13 +565,46 @@ func (i *IndexService) Read() (*Index, error) {
 		e.Path = string(path[:len(path)-1])
 
 		// We need to take into account the padding bytes to point the index variable to the right location.
-		totalEntryLen := ((62 + len(e.Path) + 1 /* this is the \x00 byte */ + 8) / 8) * 8
-		padding := totalEntryLen - (62 + len(e.Path) + 1 /* this is the \x00 byte */)
+		// Entries are padded with 1 to 8 NUL bytes, one of which we have already read.
+		totalEntryLen := ((headerLen + len(e.Path) + 8) / 8) * 8
+		padding := totalEntryLen - (headerLen + len(e.Path) + 1 /* this is the \x00 byte */)
 		reader.Discard(padding)
 		entries = append(entries, e)
 	}
 
+	// Whatever is left before the digest are extensions, each with a 4-byte signature and a 4-byte length.
+	// Extensions whose signature starts with an uppercase letter are optional and can be kept without
+	// being understood; the others change how the index must be read, so we can't go on without them.
+	var extensions []*IndexExtension
+	for {
+		header := make([]byte, 8)
+		_, err := io.ReadFull(reader, header)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed extension header: %v", err)
+		}
+		if header[0] < 'A' || header[0] > 'Z' {
+			return nil, fmt.Errorf("unsupported mandatory extension %q", header[:4])
+		}
+		length := binary.BigEndian.Uint32(header[4:])
+		if int(length) > len(data) {
+			return nil, fmt.Errorf("extension %q is longer than the index", header[:4])
+		}
+		ext := &IndexExtension{
+			Signature: string(header[:4]),
+			Data:      make([]byte, length),
+		}
+		_, err = io.ReadFull(reader, ext.Data)
+		if err != nil {
+			return nil, fmt.Errorf("malformed extension %q: %v", ext.Signature, err)
+		}
+		extensions = append(extensions, ext)
+	}
+
 	i.index.Entries = entries
+	i.index.Extensions = extensions
 	return i.index, nil
 }
 
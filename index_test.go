@@ -0,0 +1,198 @@
+package mgi
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndexLockHeldUntilUnlock(t *testing.T) {
+	repo := newTestRepo(t)
+	commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+
+	err := repo.Index.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another process reading and storing the index has to wait for the lock
+	other := NewIndexService(repo.GitDir, nil)
+	if err := other.Lock(); err == nil {
+		other.Unlock()
+		t.Fatalf("the index was locked twice")
+	}
+
+	// Storing in between keeps the lock
+	_, err = repo.Index.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Index.Store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Lock(); err == nil {
+		other.Unlock()
+		t.Fatalf("the index lock was released when the index was stored")
+	}
+
+	repo.Index.Unlock()
+	err = other.Lock()
+	if err != nil {
+		t.Fatalf("the index lock wasn't released: %v", err)
+	}
+	other.Unlock()
+}
+
+// sealIndex replaces the digest at the end of the index data with the one of its contents.
+func sealIndex(data []byte) []byte {
+	sum := sha1.Sum(data[:len(data)-20])
+	return append(data[:len(data)-20:len(data)-20], sum[:]...)
+}
+
+// withExtension returns the index data with an extension added after the entries.
+func withExtension(data []byte, signature string, ext []byte) []byte {
+	var out []byte
+	out = append(out, data[:len(data)-20]...)
+	out = append(out, signature...)
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(ext)))
+	out = append(out, length...)
+	out = append(out, ext...)
+	return sealIndex(append(out, make([]byte, 20)...))
+}
+
+func TestReadIndexChecksBoundsAndExtensions(t *testing.T) {
+	repo := newTestRepo(t)
+	commitTestFiles(t, repo, "first", map[string]string{"a": "1\n", "b": "2\n"})
+	data, err := ioutil.ReadFile(".git/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tooMany := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(tooMany[8:12], 1000)
+	longExt := withExtension(data, "ABCD", nil)
+	binary.BigEndian.PutUint32(longExt[len(longExt)-24:], 1<<30)
+	tests := []struct {
+		name  string
+		data  []byte
+		error string // empty if the index is valid
+	}{
+		{"optional extension", withExtension(data, "ABCD", []byte("kept")), ""},
+		{"too short", data[:20], "too short"},
+		{"too many entries", sealIndex(tooMany), "entries"},
+		{"mandatory extension", withExtension(data, "link", make([]byte, 20)), "mandatory extension"},
+		{"extension past the end", sealIndex(longExt), "longer than the index"},
+	}
+	for _, tt := range tests {
+		err := ioutil.WriteFile(".git/index", tt.data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index, err := NewIndexService(".git", nil).Read()
+		if tt.error == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if len(index.Entries) != 2 || len(index.Extensions) != 1 || string(index.Extensions[0].Data) != "kept" {
+				t.Errorf("%s: read %d entries and extensions %v", tt.name, len(index.Entries), index.Extensions)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("%s: got error %v, want one about %q", tt.name, err, tt.error)
+		}
+	}
+}
+
+func TestReadersNeverSeePartialIndex(t *testing.T) {
+	repo := newTestRepo(t)
+	files := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("file%03d", i)] = fmt.Sprintf("%d\n", i)
+	}
+	commitTestFiles(t, repo, "first", files)
+
+	done := make(chan error)
+	go func() {
+		for n := 0; n < 50; n++ {
+			_, err := repo.Index.Read()
+			if err == nil {
+				err = repo.Index.Store()
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		default:
+		}
+		index, err := NewIndexService(".git", nil).Read()
+		if err != nil {
+			t.Fatalf("reading the index while it's written: %v", err)
+		}
+		if len(index.Entries) != len(files) {
+			t.Fatalf("read %d entries while the index was written, want %d", len(index.Entries), len(files))
+		}
+	}
+}
+
+func TestIndexStoreTwiceWhileLocked(t *testing.T) {
+	repo := newTestRepo(t)
+	commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	writeTestFile(t, "b", "2\n")
+	writeTestFile(t, "c", "3\n")
+
+	err := repo.Index.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Index.Unlock()
+	lockPath := filepath.Join(repo.GitDir, "index.lock")
+	before, err := os.Stat(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"b", "c"} {
+		err := repo.Add([]string{path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The lock file is never given up, so no other process can take it in between
+		after, err := os.Stat(lockPath)
+		if err != nil || !os.SameFile(before, after) {
+			t.Fatalf("the index lock was released or replaced when storing %s: %v", path, err)
+		}
+	}
+
+	stored, err := NewIndexService(repo.GitDir, nil).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range stored.Entries {
+		paths = append(paths, e.Path)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("the stored index has %q, want %q", paths, want)
+	}
+	leftovers, err := filepath.Glob(filepath.Join(repo.GitDir, "index.tmp*"))
+	if err != nil || len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %q", leftovers)
+	}
+}
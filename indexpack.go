@@ -0,0 +1,99 @@
+package mgi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IndexPack reads the packfile at path, which doesn't need to be in the object store, verifies
+// its checksum and writes its index next to it, replacing the ".pack" extension with ".idx",
+// like git index-pack. If loose is set, every object of the pack is also stored as a loose
+// object. It returns the checksum of the pack, which git uses to name packs.
+//
+// The index is only written once the whole pack has been read, so a corrupt pack leaves no
+// index behind.
+func (o *ObjectService) IndexPack(path string, loose bool) (string, error) {
+	if !strings.HasSuffix(path, ".pack") {
+		return "", fmt.Errorf("packfile name %q does not end with .pack", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var entries []*packEntry
+	sum, err := readPackStream(f, nil, func(e *packEntry) error {
+		if loose {
+			_, err := o.StoreObject(&rawObject{objType: e.objType, data: e.data})
+			if err != nil {
+				return err
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	idxPath := strings.TrimSuffix(path, ".pack") + ".idx"
+	o.logger.Debugf("writing index of %d objects to %s", len(entries), idxPath)
+	lock, err := acquireLock(idxPath)
+	if err != nil {
+		return "", err
+	}
+	lock.fsync = o.fsync
+	err = writePackIndex(lock, entries, sum)
+	if err != nil {
+		lock.rollback()
+		return "", err
+	}
+	err = lock.commit()
+	if err != nil {
+		return "", err
+	}
+
+	// Pick up the new pack if it is one of ours
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	packDir, err := filepath.Abs(filepath.Join(o.path, "pack"))
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(abs) == packDir {
+		o.packs = nil
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// UnpackObjects reads a packfile from r and stores each of its objects as a loose object, like
+// git unpack-objects. Objects that are already in the store, loose or packed, are skipped. The
+// bases of deltas may be in the store rather than in the pack. It returns the number of objects
+// stored and skipped.
+func (o *ObjectService) UnpackObjects(r io.Reader) (int, int, error) {
+	unpacked, skipped := 0, 0
+	_, err := readPackStream(r, o.ReadTypedObject, func(e *packEntry) error {
+		exists, err := o.Exists(e.hash)
+		if err != nil {
+			return err
+		}
+		if exists {
+			skipped++
+			return nil
+		}
+		_, err = o.StoreObject(&rawObject{objType: e.objType, data: e.data})
+		if err != nil {
+			return err
+		}
+		unpacked++
+		return nil
+	})
+	return unpacked, skipped, err
+}
@@ -0,0 +1,124 @@
+package mgi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockFile guards a file against concurrent writers, like git does: the new contents are written
+// to "<path>.lock", created exclusively, which is then renamed over the file. While the lock
+// file exists, nobody else can update the file.
+type lockFile struct {
+	path  string
+	f     *os.File
+	done  bool
+	fsync bool // flush the file and its directory to disk on commit
+}
+
+// acquireLock creates the lock file for path. It fails right away if the lock is already held.
+func acquireLock(path string) (*lockFile, error) {
+	lockPath := path + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		age := ""
+		if fi, err := os.Stat(lockPath); err == nil {
+			age = fmt.Sprintf(" (created %s ago)", time.Since(fi.ModTime()).Round(time.Second))
+		}
+		return nil, fmt.Errorf("unable to create %q: file exists%s. Another mgi process seems to be "+
+			"running in this repository. If that's not the case, a previous process may have crashed: "+
+			"remove the file manually to continue", lockPath, age)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lockFile{path: path, f: f}, nil
+}
+
+func (l *lockFile) Write(p []byte) (int, error) {
+	return l.f.Write(p)
+}
+
+// commit replaces the file with the contents written to the lock, releasing it.
+func (l *lockFile) commit() error {
+	if l.fsync {
+		err := l.f.Sync()
+		if err != nil {
+			l.rollback()
+			return err
+		}
+	}
+	err := l.f.Close()
+	if err != nil {
+		l.rollback()
+		return err
+	}
+	err = os.Rename(l.f.Name(), l.path)
+	if err != nil {
+		l.rollback()
+		return err
+	}
+	l.done = true
+	if l.fsync {
+		return syncDir(filepath.Dir(l.path))
+	}
+	return nil
+}
+
+// replace writes the file with data while keeping the lock, for writers that update the file
+// several times before releasing it. The data are written to a temporary file next to it, which
+// is renamed over the file, so readers never see it partially written.
+func (l *lockFile) replace(data []byte) error {
+	if l.done {
+		return fmt.Errorf("lock on %s is already released", l.path)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(l.path), filepath.Base(l.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil && l.fsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), l.path)
+	if err != nil {
+		return err
+	}
+	if l.fsync {
+		return syncDir(filepath.Dir(l.path))
+	}
+	return nil
+}
+
+// rollback releases the lock, leaving the file untouched. It is safe to call after commit.
+func (l *lockFile) rollback() {
+	if l.done {
+		return
+	}
+	l.done = true
+	l.f.Close()
+	os.Remove(l.f.Name())
+}
+
+// syncDir flushes a directory to disk, so that the files just renamed into it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
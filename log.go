@@ -0,0 +1,440 @@
+package mgi
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bertinatto/mgi/pathspec"
+)
+
+// LogEntry is a commit listed by Log, with the note attached to it, if any.
+type LogEntry struct {
+	Hash   string
+	Commit *Commit
+	Note   string
+}
+
+// Log returns the commits reachable from rev (HEAD if empty). Commits are always listed before
+// their parents. Among the commits whose children have all been listed, the most recent comes
+// first, unless topoOrder is set, in which case the parents of the last commit listed come first
+// so that the commits of each line of history are kept together, like git log --topo-order.
+//
+// If paths are given, only the commits that changed what is at any of them are listed, including
+// those that created or deleted it. A merge is only listed if it differs from each of its
+// parents.
+func (m *MGIService) Log(rev string, topoOrder bool, paths ...string) ([]*LogEntry, error) {
+	if rev == "" {
+		head, err := m.currentHead()
+		if err != nil {
+			return nil, err
+		}
+		if head == "" {
+			return nil, fmt.Errorf("the current branch does not have any commits yet")
+		}
+		rev = head
+	}
+	start, err := m.resolveCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.walkLog([]string{start}, nil, topoOrder)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) > 0 {
+		entries, err = m.limitLog(entries, paths)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, m.readLogNotes(entries)
+}
+
+// limitLog returns the entries whose commits changed any of the paths. What is at the paths is
+// compared by the hashes of their blobs or trees, so that directories can be given too.
+func (m *MGIService) limitLog(entries []*LogEntry, paths []string) ([]*LogEntry, error) {
+	cleaned := make([]string, len(paths))
+	for i, path := range paths {
+		cleaned[i] = strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/")
+	}
+	// The hashes at the paths in each commit, with empty strings for the missing ones
+	states := make(map[string]string)
+	state := func(commit string) (string, error) {
+		if s, ok := states[commit]; ok {
+			return s, nil
+		}
+		c, err := m.readCommit(commit)
+		if err != nil {
+			return "", err
+		}
+		var hashes []string
+		for _, path := range cleaned {
+			if path == "." || path == "" {
+				hashes = append(hashes, c.Tree)
+				continue
+			}
+			e, err := m.lookupPath(c.Tree, path)
+			if errors.Is(err, os.ErrNotExist) {
+				hashes = append(hashes, "")
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			hashes = append(hashes, e.hash.String())
+		}
+		states[commit] = strings.Join(hashes, " ")
+		return states[commit], nil
+	}
+
+	var limited []*LogEntry
+	for _, e := range entries {
+		s, err := state(e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		// A root commit changed the paths if it has any of them
+		changed := strings.TrimSpace(s) != ""
+		for _, p := range e.Commit.Parents {
+			ps, err := state(p)
+			if err != nil {
+				return nil, err
+			}
+			changed = ps != s
+			if !changed {
+				break
+			}
+		}
+		if changed {
+			limited = append(limited, e)
+		}
+	}
+	return limited, nil
+}
+
+// walkLog returns the commits reachable from the start commits, leaving out those in exclude,
+// in the order Log lists them. The excluded commits must include their own ancestors, as
+// returned by reachable.
+func (m *MGIService) walkLog(start []string, exclude map[string]bool, topoOrder bool) ([]*LogEntry, error) {
+	order, err := m.logOrder(start, exclude, topoOrder)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*LogEntry, 0, len(order))
+	for _, hash := range order {
+		c, err := m.readCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &LogEntry{Hash: hash, Commit: c})
+	}
+	return entries, nil
+}
+
+// logOrder returns the hashes of the commits listed by walkLog, in the same order, using only
+// their parents and dates.
+func (m *MGIService) logOrder(start []string, exclude map[string]bool, topoOrder bool) ([]string, error) {
+	nodes := make(map[string]*commitNode)
+	children := make(map[string]int)
+	err := m.walkNodes(start, func(n *commitNode) error {
+		if exclude[n.Hash] {
+			return errSkipParents
+		}
+		nodes[n.Hash] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		for _, p := range n.Parents {
+			if _, ok := nodes[p]; ok {
+				children[p]++
+			}
+		}
+	}
+
+	order := make([]string, 0, len(nodes))
+	if topoOrder {
+		topo, err := m.CommitGraph().TopoOrder(start...)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range topo {
+			if _, ok := nodes[hash]; ok {
+				order = append(order, hash)
+			}
+		}
+		return order, nil
+	}
+
+	ready := new(logQueue)
+	for _, hash := range start {
+		if n, ok := nodes[hash]; ok && children[hash] == 0 {
+			heap.Push(ready, n)
+			// Listed once, even if given more than once
+			children[hash] = -1
+		}
+	}
+	for ready.Len() > 0 {
+		n := heap.Pop(ready).(*commitNode)
+		order = append(order, n.Hash)
+		for _, p := range n.Parents {
+			parent, ok := nodes[p]
+			if !ok {
+				continue
+			}
+			children[p]--
+			if children[p] == 0 {
+				heap.Push(ready, parent)
+			}
+		}
+	}
+	return order, nil
+}
+
+// readLogNotes sets the notes attached to the commits.
+func (m *MGIService) readLogNotes(entries []*LogEntry) error {
+	notes, _, err := m.readNotes()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		n, ok := notes[e.Hash]
+		if !ok {
+			continue
+		}
+		data, err := m.obj.ReadObject(n.Hash)
+		if err != nil {
+			return err
+		}
+		e.Note = string(data)
+	}
+	return nil
+}
+
+// CommitDiff returns the changes the commit made to its first parent, or to an empty tree if it
+// is a root commit, like git log -p. Merges have no changes. If paths are given, only the
+// changes of the files they match are returned.
+func (m *MGIService) CommitDiff(hash string, paths []string) ([]string, error) {
+	c, err := m.readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Parents) > 1 {
+		return nil, nil
+	}
+	ps, err := pathspec.Compile(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	old := map[string]*IndexEntry{}
+	if len(c.Parents) == 1 {
+		old, err = m.commitFiles(c.Parents[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	new, err := m.commitFiles(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) > 0 {
+		for _, files := range []map[string]*IndexEntry{old, new} {
+			for path := range files {
+				if !ps.Match(path) {
+					delete(files, path)
+				}
+			}
+		}
+	}
+	return m.diffFiles(old, new, DiffOptions{}, nil)
+}
+
+// logQueue orders the commits ready to be listed by Log, most recent first.
+type logQueue []*commitNode
+
+func (q logQueue) Len() int { return len(q) }
+func (q logQueue) Less(i, j int) bool {
+	ti, tj := q[i].CommitTime, q[j].CommitTime
+	if ti.Equal(tj) {
+		return q[i].Hash < q[j].Hash
+	}
+	return ti.After(tj)
+}
+func (q logQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *logQueue) Push(x interface{}) { *q = append(*q, x.(*commitNode)) }
+func (q *logQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// abbrev returns the abbreviated form of a hash, or the hash itself if it isn't valid.
+func (m *MGIService) abbrev(hash string) string {
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return hash
+	}
+	return m.obj.Abbrev(h)
+}
+
+// LogGraph draws the history of the commits, given in the order of Log with topoOrder, next to
+// the lines that describe each of them, like git log --graph. Descriptions of more than one
+// line are separated by a blank line. The commit is marked with "*" in its lane, and
+// lanes are opened for the other parents of merges and closed when they reach a commit that is
+// already in another lane.
+func LogGraph(entries []*LogEntry, lines func(e *LogEntry) []string) []string {
+	var out []string
+	var lanes []string // the commit expected next in each lane
+	separate := false
+	for _, e := range entries {
+		waiting := len(lanes)
+		col := -1
+		for i, hash := range lanes {
+			if hash == e.Hash {
+				col = i
+				break
+			}
+		}
+		if col < 0 {
+			col = len(lanes)
+			lanes = append(lanes, e.Hash)
+		}
+		before := lanes
+
+		// The first parent takes the lane of the commit, and other parents open new lanes to
+		// its right unless they are already in one
+		after := append([]string(nil), lanes[:col]...)
+		opened := 0
+		joinsRight, joinsLeft := false, false
+		if len(e.Commit.Parents) > 0 {
+			after = append(after, e.Commit.Parents[0])
+			for _, p := range e.Commit.Parents[1:] {
+				switch {
+				case containsString(lanes[col+1:], p):
+					joinsRight = true
+				case containsString(after, p):
+					joinsLeft = true
+				default:
+					after = append(after, p)
+					opened++
+				}
+			}
+		}
+		after = append(after, lanes[col+1:]...)
+
+		var rows []string
+		commitRow := make([]string, len(before))
+		for i := range before {
+			commitRow[i] = "|"
+		}
+		commitRow[col] = "*"
+		rows = append(rows, strings.Join(commitRow, " "))
+		switch {
+		case opened > 0:
+			rows = append(rows, expandRow(col, opened, len(before)))
+		case joinsRight || joinsLeft:
+			rows = append(rows, joinRow(col, len(before), joinsRight, joinsLeft))
+		case len(e.Commit.Parents) == 0 && col < len(before)-1:
+			rows = append(rows, shiftRow(col, col+1, len(before)))
+		}
+
+		// Lanes waiting for the same commit join the leftmost one
+		for j := 1; j < len(after); j++ {
+			if containsString(after[:j], after[j]) {
+				rows = append(rows, shiftRow(j, j, len(after)))
+				after = append(after[:j:j], after[j+1:]...)
+				j--
+			}
+		}
+		lanes = after
+
+		width := len(before)
+		if len(lanes) > width {
+			width = len(lanes)
+		}
+		// Rows are padded to the same width, so that the descriptions line up
+		if separate {
+			out = append(out, fmt.Sprintf("%-*s", 2*width, laneRow(waiting)))
+		}
+		text := lines(e)
+		separate = len(text) > 1
+		for i := 0; i < len(rows) || i < len(text); i++ {
+			row := laneRow(len(lanes))
+			if i < len(rows) {
+				row = rows[i]
+			}
+			line := ""
+			if i < len(text) {
+				line = text[i]
+			}
+			out = append(out, fmt.Sprintf("%-*s%s", 2*width, row, line))
+		}
+	}
+	return out
+}
+
+// laneRow draws n lanes going straight down.
+func laneRow(n int) string {
+	return strings.TrimRight(strings.Repeat("| ", n), " ")
+}
+
+// expandRow draws the lanes opened by a merge in the lane col, out of n lanes, moving the lanes
+// to its right.
+func expandRow(col, opened, n int) string {
+	row := []byte(strings.Repeat(" ", 2*(n+opened)))
+	for i := 0; i <= col; i++ {
+		row[2*i] = '|'
+	}
+	for i := 0; i < opened; i++ {
+		row[2*col+1+i] = '\\'
+	}
+	for j := col + 1; j < n; j++ {
+		row[2*j+opened] = '\\'
+	}
+	return strings.TrimRight(string(row), " ")
+}
+
+// joinRow draws n lanes where the merge in the lane col has parents that are already in the
+// lanes to its right or left.
+func joinRow(col, n int, right, left bool) string {
+	row := []byte(laneRow(n))
+	if right {
+		row[2*col+1] = '\\'
+	}
+	if left && col > 0 {
+		row[2*col-1] = '/'
+	}
+	return string(row)
+}
+
+// shiftRow draws n lanes where the lanes from the one at from on move one lane to the left.
+// The lanes before keep go straight down, and the others end.
+func shiftRow(keep, from, n int) string {
+	row := []byte(strings.Repeat(" ", 2*n))
+	for i := 0; i < keep; i++ {
+		row[2*i] = '|'
+	}
+	for j := from; j < n; j++ {
+		if j > 0 {
+			row[2*j-1] = '/'
+		}
+	}
+	return strings.TrimRight(string(row), " ")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package mgi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mergeHistory commits A, then B and D on master and C on a side branch started at A, and
+// merges the side branch into master with M. HEAD points to M. It returns the commits by subject.
+func mergeHistory(t *testing.T, repo *Repo) map[string]string {
+	t.Helper()
+	at := func(s int64) time.Time { return time.Unix(1000000000+s, 0).UTC() }
+	a := commitAt(t, repo, "A", at(0))
+	b := commitAt(t, repo, "B", at(200), a)
+	c := commitAt(t, repo, "C", at(100), a)
+	d := commitAt(t, repo, "D", at(300), b)
+	m := commitAt(t, repo, "M", at(400), d, c)
+	err := repo.updateRef("refs/heads/master", "", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"A": a, "B": b, "C": c, "D": d, "M": m}
+}
+
+func subjects(entries []*LogEntry) []string {
+	var list []string
+	for _, e := range entries {
+		list = append(list, strings.TrimSpace(e.Commit.Message))
+	}
+	return list
+}
+
+func TestLogOrder(t *testing.T) {
+	repo := newTestRepo(t)
+	mergeHistory(t, repo)
+
+	for _, tt := range []struct {
+		topoOrder bool
+		want      []string
+	}{
+		{false, []string{"M", "D", "B", "C", "A"}},
+		{true, []string{"M", "C", "D", "B", "A"}},
+	} {
+		entries, err := repo.Log("", tt.topoOrder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := subjects(entries); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Log with topoOrder %v listed %q, want %q", tt.topoOrder, got, tt.want)
+		}
+	}
+}
+
+func TestLogGraph(t *testing.T) {
+	repo := newTestRepo(t)
+	mergeHistory(t, repo)
+	entries, err := repo.Log("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The output of git log --graph --format=%s for the same history
+	want := []string{
+		"*   M",
+		"|\\  ",
+		"| * C",
+		"* | D",
+		"* | B",
+		"|/  ",
+		"* A",
+	}
+	got := LogGraph(entries, func(e *LogEntry) []string {
+		return []string{strings.TrimSpace(e.Commit.Message)}
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LogGraph drew\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
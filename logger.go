@@ -0,0 +1,48 @@
+package mgi
+
+import (
+	"fmt"
+	"io"
+)
+
+// Logger receives the messages the services log about what they do. Debug messages are meant
+// for troubleshooting, e.g. every object and ref written.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+}
+
+// nopLogger discards all messages. It is used when services are created without a logger.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+
+// orNop returns the logger, or a logger that discards everything if it is nil.
+func orNop(logger Logger) Logger {
+	if logger == nil {
+		return nopLogger{}
+	}
+	return logger
+}
+
+// writerLogger writes one line per message to w.
+type writerLogger struct {
+	w     io.Writer
+	debug bool
+}
+
+// NewLogger returns a Logger that writes to w. Debug messages are only written if debug is set.
+func NewLogger(w io.Writer, debug bool) Logger {
+	return &writerLogger{w: w, debug: debug}
+}
+
+func (l *writerLogger) Debugf(format string, args ...interface{}) {
+	if l.debug {
+		fmt.Fprintf(l.w, "debug: "+format+"\n", args...)
+	}
+}
+
+func (l *writerLogger) Infof(format string, args ...interface{}) {
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
@@ -0,0 +1,37 @@
+package mgi
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LsFiles lists the files in the index, like git ls-files. With stage, each entry is described
+// by its mode, hash and merge stage, followed by a tab and its path, or else only by its path.
+// Like in git, a file with conflicts is listed once for each of its stages. With unmerged, only
+// the files with conflicts are listed, described like that.
+func (m *MGIService) LsFiles(stage, unmerged bool) ([]string, error) {
+	index, err := m.index.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file: %v", err)
+	}
+	entries := append([]*IndexEntry(nil), index.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Path != entries[j].Path {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Stage() < entries[j].Stage()
+	})
+
+	var lines []string
+	for _, e := range entries {
+		switch {
+		case unmerged && e.Stage() == 0:
+			continue
+		case stage || unmerged:
+			lines = append(lines, fmt.Sprintf("%06o %s %d\t%s", e.Mode, e.Hash, e.Stage(), e.Path))
+		default:
+			lines = append(lines, e.Path)
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,152 @@
+package mgi
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MemoryObjectStore is an ObjectStore that keeps the objects in memory, e.g. to work on objects
+// that don't need to be saved, or to exercise MGIService without touching the disk.
+type MemoryObjectStore struct {
+	objects map[string]*rawObject
+}
+
+// NewMemoryObjectStore creates an empty MemoryObjectStore.
+func NewMemoryObjectStore() *MemoryObjectStore {
+	return &MemoryObjectStore{objects: make(map[string]*rawObject)}
+}
+
+func (o *MemoryObjectStore) HashObject(m Marshaller) (*Hash, error) {
+	data, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return new(Hash).From(data), nil
+}
+
+// StoreObject keeps a copy of the serialized object.
+func (o *MemoryObjectStore) StoreObject(m Marshaller) (*Hash, error) {
+	data, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.IndexByte(data, '\x00')
+	sp := bytes.IndexByte(data, ' ')
+	if i < 0 || sp < 0 || sp > i {
+		return nil, fmt.Errorf("%w: no header", ErrCorruptObject)
+	}
+
+	hash := new(Hash).From(data)
+	o.objects[hash.String()] = &rawObject{objType: string(data[:sp]), data: data[i+1:]}
+	return hash, nil
+}
+
+func (o *MemoryObjectStore) ReadObject(hash *Hash) ([]byte, error) {
+	_, contents, err := o.ReadTypedObject(hash)
+	return contents, err
+}
+
+func (o *MemoryObjectStore) ReadTypedObject(hash *Hash) (string, []byte, error) {
+	obj, ok := o.objects[hash.String()]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrObjectNotFound, hash)
+	}
+	return obj.objType, obj.data, nil
+}
+
+func (o *MemoryObjectStore) Exists(hash *Hash) (bool, error) {
+	_, ok := o.objects[hash.String()]
+	return ok, nil
+}
+
+// Iterate calls fn with the hash of every object, in order.
+func (o *MemoryObjectStore) Iterate(fn func(hash string) error) error {
+	hashes := make([]string, 0, len(o.objects))
+	for hash := range o.objects {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		err := fn(hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *MemoryObjectStore) ResolvePrefix(prefix string) (*Hash, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 || len(prefix) > 40 {
+		return nil, fmt.Errorf("invalid object name %q", prefix)
+	}
+
+	var matches []string
+	for hash := range o.objects {
+		if strings.HasPrefix(hash, prefix) {
+			matches = append(matches, hash)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, prefix)
+	case 1:
+		return new(Hash).FromString(matches[0])
+	}
+	return nil, fmt.Errorf("short object name %q is ambiguous", prefix)
+}
+
+func (o *MemoryObjectStore) Abbrev(hash *Hash) string {
+	hashStr := hash.String()
+	n := 7
+	for other := range o.objects {
+		if other == hashStr {
+			continue
+		}
+		for n < len(hashStr) && strings.HasPrefix(other, hashStr[:n]) {
+			n++
+		}
+	}
+	return hashStr[:n]
+}
+
+func (o *MemoryObjectStore) PeelToCommit(hash *Hash) (*Hash, error) {
+	return peel(o, hash, "commit")
+}
+
+func (o *MemoryObjectStore) PeelToTree(hash *Hash) (*Hash, error) {
+	return peel(o, hash, "tree")
+}
+
+// MemoryIndexStore is an IndexStore that is never saved: Read returns the entries as they were
+// left, and Store does nothing. Files are still added from the working tree.
+type MemoryIndexStore struct {
+	*IndexService
+}
+
+// NewMemoryIndexStore creates an empty MemoryIndexStore. The logger may be nil.
+func NewMemoryIndexStore(logger Logger) *MemoryIndexStore {
+	return &MemoryIndexStore{IndexService: NewIndexService("", logger)}
+}
+
+func (i *MemoryIndexStore) Read() (*Index, error) {
+	// Like the index file, entries are sorted by path
+	sort.Slice(i.index.Entries, func(x, y int) bool {
+		return i.index.Entries[x].Path < i.index.Entries[y].Path
+	})
+	return i.index, nil
+}
+
+func (i *MemoryIndexStore) Store() error {
+	return nil
+}
+
+// Lock does nothing, since the index isn't shared with other processes.
+func (i *MemoryIndexStore) Lock() error {
+	return nil
+}
+
+// Unlock does nothing.
+func (i *MemoryIndexStore) Unlock() {}
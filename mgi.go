@@ -1,46 +1,113 @@
 package mgi
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/bertinatto/mgi/diff"
+	"github.com/bertinatto/mgi/ignore"
+	"github.com/bertinatto/mgi/pathspec"
 )
 
 type MGIService struct {
-	root  string
-	obj   *ObjectService
-	index *IndexService
+	root   string
+	common string // Where refs live, which differs from root in linked worktrees
+	obj    ObjectStore
+	index  IndexStore
+	fsync  bool           // flush refs to disk when they are updated
+	quiet  bool           // leave out advisory warnings
+	filter *contentFilter // read from the configuration when first needed
+	graph  *CommitGraph   // created when first needed
+	logger Logger
 }
 
-func NewMGIService(root string, obj *ObjectService, index *IndexService) *MGIService {
+// NewMGIService creates a new MGIService. The logger may be nil.
+func NewMGIService(root string, obj ObjectStore, index IndexStore, logger Logger) *MGIService {
 	return &MGIService{
-		root:  root,
-		obj:   obj,
-		index: index,
+		root:   root,
+		common: commonDir(root),
+		obj:    obj,
+		index:  index,
+		logger: orNop(logger),
+	}
+}
+
+// SetQuiet sets whether advisory warnings, such as the one about adding large files, are left
+// out. They are logged with Infof otherwise.
+func (m *MGIService) SetQuiet(on bool) {
+	m.quiet = on
+}
+
+// Add stages the working tree contents of the files matched by the pathspecs (see the pathspec
+// package), which are relative to the root of the working tree. Directories are added with the
+// files under them, nested repositories are recorded by the commit they have checked out, and
+// tracked files that were deleted are removed from the index. Untracked files that are ignored
+// are only added if they are named by their path. Every pathspec must match a file.
+func (m *MGIService) Add(pathspecs []string) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	files, gitlinks, removed, err := m.addPathspecs(pathspecs)
+	if err != nil {
+		return err
 	}
+	return m.stageFiles(files, gitlinks, removed)
 }
 
-func (m *MGIService) Add(files []string) error {
+// stageFiles stages the working tree contents of the files and the commits the nested
+// repositories have checked out, and removes the other paths from the index. Paths are taken
+// literally. The caller must hold the index lock.
+func (m *MGIService) stageFiles(files, gitlinks, removed []string) error {
 	_, err := m.index.Read()
 	if err != nil {
 		return fmt.Errorf("error reading index file: %v", err)
 	}
+	maxSize, err := m.maxBlobSize()
+	if err != nil {
+		return err
+	}
+	warnSize, err := m.largeFileWarning()
+	if err != nil {
+		return err
+	}
+
+	// Nested repositories are recorded by the commit they have checked out
+	for _, dir := range gitlinks {
+		head, err := m.gitlinkHead(dir)
+		if err != nil {
+			return err
+		}
+		m.index.AddGitlink(dir, head)
+	}
+	for _, f := range removed {
+		err := m.index.Remove(f)
+		if err != nil {
+			return err
+		}
+	}
 
 	for _, f := range files {
-		f := strings.TrimPrefix(f, "./")
-		fileData, err := ioutil.ReadFile(f)
+		err := checkBlobSize(f, maxSize)
+		if err != nil {
+			return err
+		}
+		fileData, err := m.readWorkingFile(f)
 		if err != nil {
 			// TODO: make this atomic instead
 			return err
 		}
+		if warnSize > 0 && int64(len(fileData)) > warnSize && !m.quiet {
+			m.logger.Infof("warning: %s is %d bytes, larger than add.largeFileWarning (%d bytes); "+
+				"large files may not belong in version control", f, len(fileData), warnSize)
+		}
 
 		blob := &Blob{Data: fileData}
 		hash, err := m.obj.StoreObject(blob)
@@ -57,7 +124,180 @@ func (m *MGIService) Add(files []string) error {
 	return m.index.Store()
 }
 
-func (m *MGIService) Commit(msg string) error {
+// addPathspecs returns what Add does for the pathspecs: the files to add, the nested repositories
+// to record as gitlinks, and the tracked files to remove because they were deleted.
+func (m *MGIService) addPathspecs(specs []string) ([]string, []string, []string, error) {
+	if len(specs) == 0 {
+		return nil, nil, nil, nil
+	}
+	repoRoot, err := findRoot(m.root)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ps, err := pathspec.Compile(specs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tracked, err := m.indexFiles()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var files, gitlinks, removed []string
+	seen := make(map[string]bool)
+	matched := make(map[*pathspec.Pattern]bool)
+	record := func(list *[]string, path string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		*list = append(*list, path)
+		for _, p := range ps.Patterns {
+			if !p.IsExclude() && (p.Match(path) || p.Match(path+"/")) {
+				matched[p] = true
+			}
+		}
+	}
+	// A directory is matched by "dir" and "dir/" alike
+	matchDir := func(path string) bool {
+		return ps.Match(path) || ps.Match(path+"/")
+	}
+
+	// Files named by their path are added even if they are ignored
+	for _, p := range ps.Patterns {
+		if p.IsExclude() || p.IsGlob() || p.Path() == "." {
+			continue
+		}
+		fi, err := os.Lstat(filepath.Join(repoRoot, filepath.FromSlash(p.Path())))
+		if err != nil {
+			continue
+		}
+		switch {
+		case !fi.IsDir():
+			if ps.Match(p.Path()) {
+				record(&files, p.Path())
+			}
+		case isNestedRepo(filepath.Join(repoRoot, p.Path())):
+			if matchDir(p.Path()) {
+				record(&gitlinks, p.Path())
+			}
+		}
+	}
+
+	err = m.walkWorkingTree(repoRoot, ps, func(path, relPath string, d fs.DirEntry, ignored bool) error {
+		relPath = filepath.ToSlash(relPath)
+		if d.IsDir() {
+			if isNestedRepo(path) {
+				if matchDir(relPath) {
+					record(&gitlinks, relPath)
+				}
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if ignored && tracked[relPath] == nil {
+			return nil
+		}
+		record(&files, relPath)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for path := range tracked {
+		if seen[path] || !ps.Match(path) {
+			continue
+		}
+		_, err := os.Lstat(filepath.Join(repoRoot, filepath.FromSlash(path)))
+		if os.IsNotExist(err) {
+			record(&removed, path)
+		}
+	}
+	sort.Strings(removed)
+
+	for _, p := range ps.Patterns {
+		if !p.IsExclude() && !matched[p] {
+			return nil, nil, nil, fmt.Errorf("pathspec %q did not match any files", p.Original)
+		}
+	}
+	return files, gitlinks, removed, nil
+}
+
+// maxBlobSize returns the largest file Add accepts, set by core.maxBlobSize, or 0 if there
+// is no limit.
+func (m *MGIService) maxBlobSize() (int64, error) {
+	config, err := NewConfigService(m.common).Read()
+	if err != nil {
+		return 0, err
+	}
+	return config.GetSize("core.maxBlobSize")
+}
+
+// defaultLargeFileWarning is the size above which Add warns about files unless
+// add.largeFileWarning says otherwise.
+const defaultLargeFileWarning = 50 << 20
+
+// largeFileWarning returns the size above which Add warns that a file may not belong in version
+// control, set by add.largeFileWarning, or 0 if it shouldn't warn.
+func (m *MGIService) largeFileWarning() (int64, error) {
+	config, err := NewConfigService(m.common).Read()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := config.Get("add.largeFileWarning"); !ok {
+		return defaultLargeFileWarning, nil
+	}
+	return config.GetSize("add.largeFileWarning")
+}
+
+// checkBlobSize returns an error if the file is larger than maxSize, unless it is 0.
+func checkBlobSize(path string, maxSize int64) error {
+	if maxSize == 0 {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Size() > maxSize {
+		return fmt.Errorf("%s is %d bytes, which exceeds core.maxBlobSize (%d bytes)", path, fi.Size(), maxSize)
+	}
+	return nil
+}
+
+// AddIntentToAdd records that the files will be added later, without staging their contents.
+// Files that are already tracked are left untouched.
+func (m *MGIService) AddIntentToAdd(files []string) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	_, err := m.index.Read()
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+
+	// Make sure the empty blob the entries point to exists
+	_, err = m.obj.StoreObject(&Blob{})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		f := strings.TrimPrefix(f, "./")
+		err := m.index.AddIntentToAdd(f)
+		if err != nil {
+			return err
+		}
+	}
+	return m.index.Store()
+}
+
+// Commit records the index as a new commit on the current branch. If sign is set, the commit is
+// signed with the key in user.signingkey.
+func (m *MGIService) Commit(msg string, sign bool) error {
 	tree, err := m.writeTree()
 	if err != nil {
 		return err
@@ -68,54 +308,102 @@ func (m *MGIService) Commit(msg string) error {
 		return err
 	}
 
-	author := os.Getenv("GIT_AUTHOR")
-	if author == "" {
-		author = os.Getenv("USER")
+	var parents []string
+	if parent != "" {
+		parents = append(parents, parent)
 	}
 
-	authorEmail := os.Getenv("GIT_EMAIL")
-	if authorEmail == "" {
-		authorEmail = os.Getenv("USER") + "@" + os.Getenv("HOSTNAME")
+	hash, err := m.storeCommit(tree, parents, msg, sign)
+	if err != nil {
+		return err
 	}
 
-	c := &Commit{
-		Parent:      parent,
-		Tree:        tree,
-		Author:      author,
-		AuthorEmail: authorEmail,
-		AuthorTime:  time.Now(),
-		Message:     msg,
+	reflogMsg := "commit: " + subject(msg)
+	if parent == "" {
+		reflogMsg = "commit (initial): " + subject(msg)
 	}
+	return m.advanceHead(parent, hash, reflogMsg)
+}
 
-	hash, err := m.obj.StoreObject(c)
+// advanceHead moves the tip of the current branch, or HEAD itself if it is detached, from parent
+// to the new commit, and records the move in the reflogs with the given message. It fails if the
+// branch no longer points to parent.
+func (m *MGIService) advanceHead(parent, hash, reflogMsg string) error {
+	ref, err := m.headRef()
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	err = m.updateRef(ref, parent, hash)
 	if err != nil {
 		return err
 	}
 
-	// Update the tip
-	pathMaster := filepath.Join(m.root, "refs", "heads", "master")
-	fd, err := os.OpenFile(pathMaster, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	err = m.appendReflog(ref, parent, hash, reflogMsg)
 	if err != nil {
 		return err
 	}
-	defer fd.Close()
+	if ref != "HEAD" {
+		return m.appendReflog("HEAD", parent, hash, reflogMsg)
+	}
+	return nil
+}
 
-	fd.WriteString(hash.String())
-	fd.WriteString("\n")
+// storeCommit stores a commit authored by the current user, signed if sign is set, and returns
+// its hash.
+func (m *MGIService) storeCommit(tree string, parents []string, message string, sign bool) (string, error) {
+	name, email := identity()
+	return m.writeCommit(&Commit{
+		Parents:     parents,
+		Tree:        tree,
+		Author:      name,
+		AuthorEmail: email,
+		AuthorTime:  time.Now(),
+		Message:     message,
+	}, sign)
+}
 
-	return nil
+// writeCommit stores the commit, signed if sign is set, and returns its hash.
+func (m *MGIService) writeCommit(c *Commit, sign bool) (string, error) {
+	if sign {
+		err := m.signCommit(c)
+		if err != nil {
+			return "", err
+		}
+	}
+	hash, err := m.obj.StoreObject(c)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// identity returns the name and email used for commits and reflog entries.
+func identity() (string, string) {
+	name := os.Getenv("GIT_AUTHOR")
+	if name == "" {
+		name = os.Getenv("USER")
+	}
+
+	email := os.Getenv("GIT_EMAIL")
+	if email == "" {
+		email = os.Getenv("USER") + "@" + os.Getenv("HOSTNAME")
+	}
+	return name, email
 }
 
+// currentHead returns the commit HEAD points to, or an empty string if there are no commits yet.
 func (m *MGIService) currentHead() (string, error) {
-	pathMaster := filepath.Join(m.root, "refs", "heads", "master")
-	contents, err := ioutil.ReadFile(pathMaster)
+	head, err := m.readRef("HEAD")
 	if os.IsNotExist(err) {
 		return "", nil
 	}
 	if err != nil {
 		return "", err
 	}
-	return string(bytes.TrimSpace(contents)), nil
+	return head, nil
 }
 
 func (m *MGIService) writeTree() (string, error) {
@@ -143,8 +431,15 @@ func (m *MGIService) writeSubTree(subTree string, entries []*IndexEntry) (*Hash,
 	// First of all, we are going to figure out the entries for our tree object
 	children := make(map[string]*TreeEntry)
 	for _, indexEntry := range entries {
+		// Files that are only intended to be added aren't staged yet
+		if indexEntry.IntentToAdd() {
+			continue
+		}
 		entryDir, entryFile := filepath.Split(indexEntry.Path)
 		if entryDir == subTree {
+			if !validFileMode(indexEntry.Mode) {
+				return nil, fmt.Errorf("invalid mode %o for %q in the index", indexEntry.Mode, indexEntry.Path)
+			}
 			// The entry is both a file and a direct child of the subTree, so add it to our tree object listing
 			e := &TreeEntry{
 				mode: indexEntry.Mode,
@@ -171,7 +466,7 @@ func (m *MGIService) writeSubTree(subTree string, entries []*IndexEntry) (*Hash,
 			}
 
 			e := &TreeEntry{
-				mode: 040000,
+				mode: modeDir,
 				path: directChild,
 			}
 			e.hash = hash
@@ -186,9 +481,10 @@ func (m *MGIService) writeSubTree(subTree string, entries []*IndexEntry) (*Hash,
 			lines = append(lines, v)
 		}
 
-		// Entries should be sorted in acending order
+		// Entries should be sorted in acending order, with directories compared as if their
+		// names ended with a slash, like git does
 		sort.Slice(lines, func(i, j int) bool {
-			return lines[i].path < lines[j].path
+			return lines[i].sortName() < lines[j].sortName()
 		})
 
 		t := &Tree{Entries: lines}
@@ -217,26 +513,48 @@ func (m *MGIService) findIndexEntry(path string) (*IndexEntry, error) {
 	return nil, os.ErrNotExist
 }
 
-func (m *MGIService) Status() ([]string, []string, error) {
+// Status returns the untracked files, the tracked files with unstaged changes, and the new files
+// that were added with AddIntentToAdd but whose contents are not staged yet. If pathspecs are
+// given, only the parts of the working tree they cover are walked and only matching files are
+// reported.
+func (m *MGIService) Status(pathspecs ...string) ([]string, []string, []string, error) {
 	repoRoot, err := findRoot(m.root)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	ps, err := pathspec.Compile(pathspecs)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	var untracked []string
 	var modified []string
-	err = filepath.WalkDir(repoRoot, func(path string, d fs.DirEntry, walkErr error) error {
+	var intentToAdd []string
+	err = m.walkWorkingTree(repoRoot, ps, func(path, relPath string, d fs.DirEntry, ignored bool) error {
 		if d.IsDir() {
+			if isNestedRepo(path) {
+				return m.statusGitlink(repoRoot, path, &untracked, &modified)
+			}
 			return nil
 		}
 
-		if strings.Contains(path, ".git/") {
+		indexEntry, err := m.findIndexEntry(relPath)
+		if os.IsNotExist(err) {
+			if !ignored {
+				untracked = append(untracked, relPath)
+			}
 			return nil
 		}
+		if err != nil {
+			return err
+		}
 
-		// TODO: parse .gitignore
+		// Don't even read files the user asked us to assume unchanged
+		if indexEntry.AssumeUnchanged() {
+			return nil
+		}
 
-		fileData, err := ioutil.ReadFile(path)
+		fileData, err := m.readWorkingFile(path)
 		if err != nil {
 			return err
 		}
@@ -246,40 +564,372 @@ func (m *MGIService) Status() ([]string, []string, error) {
 			return err
 		}
 
+		if indexEntry.IntentToAdd() {
+			intentToAdd = append(intentToAdd, relPath)
+		} else if hash.String() != indexEntry.Hash.String() {
+			modified = append(modified, relPath)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sort.Strings(untracked)
+	sort.Strings(modified)
+	sort.Strings(intentToAdd)
+	return untracked, modified, intentToAdd, nil
+}
+
+// walkWorkingTree walks the parts of the working tree at repoRoot that the pathspec covers,
+// leaving out the repository directory. It calls fn with the path of each file that matches the
+// pathspec, along with the path relative to repoRoot and whether the file is ignored, and with
+// each directory that isn't ignored, which fn may skip by returning fs.SkipDir. Ignored
+// directories are not walked, unless they have tracked files.
+func (m *MGIService) walkWorkingTree(repoRoot string, ps *pathspec.Pathspec, fn func(path, relPath string, d fs.DirEntry, ignored bool) error) error {
+	gitDir := m.root
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(repoRoot, gitDir)
+	}
+	// In submodules and linked worktrees, .git is a file pointing to the repository directory
+	gitLink := filepath.Join(repoRoot, ".git")
+
+	ignored, err := m.ignoreMatcher(repoRoot)
+	if err != nil {
+		return err
+	}
+	index, err := m.index.Read()
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+	trackedDirs := make(map[string]bool)
+	for _, e := range index.Entries {
+		for dir := filepath.Dir(e.Path); dir != "." && !trackedDirs[dir]; dir = filepath.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+	ignoredDirs := make(map[string]bool)
+
+	seen := make(map[string]bool)
+	walk := func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			if filepath.Clean(path) == gitDir {
+				return fs.SkipDir
+			}
+			if path == repoRoot {
+				return nil
+			}
+			relPath, err := filepath.Rel(repoRoot, path)
+			if err != nil {
+				return err
+			}
+			isIgnored := ignoredDirs[filepath.Dir(relPath)]
+			if !isIgnored {
+				isIgnored, err = ignored.Ignored(relPath, true)
+				if err != nil {
+					return err
+				}
+			}
+			if isIgnored {
+				if !trackedDirs[relPath] {
+					return fs.SkipDir
+				}
+				ignoredDirs[relPath] = true
+				return nil
+			}
+			return fn(path, relPath, d, false)
+		}
+		if path == gitLink {
+			return nil
+		}
+
 		relPath, err := filepath.Rel(repoRoot, path)
 		if err != nil {
 			return err
 		}
+		// Walks of overlapping pathspecs visit some files twice
+		if seen[relPath] || !ps.Match(filepath.ToSlash(relPath)) {
+			return nil
+		}
+		seen[relPath] = true
 
-		indexEntry, err := m.findIndexEntry(relPath)
+		isIgnored := ignoredDirs[filepath.Dir(relPath)]
+		if !isIgnored {
+			isIgnored, err = ignored.Ignored(relPath, false)
+			if err != nil {
+				return err
+			}
+		}
+		return fn(path, relPath, d, isIgnored)
+	}
+
+	for _, root := range ps.Roots() {
+		// Walks starting inside an ignored directory must know it is
+		dirs := strings.Split(root, "/")
+		for i := 1; i < len(dirs); i++ {
+			dir := filepath.Join(dirs[:i]...)
+			isIgnored := ignoredDirs[filepath.Dir(dir)]
+			if !isIgnored {
+				isIgnored, err = ignored.Ignored(dir, true)
+				if err != nil {
+					return err
+				}
+			}
+			ignoredDirs[dir] = isIgnored
+		}
+
+		root = filepath.Join(repoRoot, filepath.FromSlash(root))
+		if _, err := os.Lstat(root); os.IsNotExist(err) {
+			continue
+		}
+		err = filepath.WalkDir(root, walk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignoreMatcher returns the matcher of the ignored files of the working tree at root, which
+// reads its .gitignore files, the info/exclude file of the repository and the global excludes
+// file.
+func (m *MGIService) ignoreMatcher(root string) (*ignore.Matcher, error) {
+	global, err := m.globalExcludesFile()
+	if err != nil {
+		return nil, err
+	}
+	excludes := []string{filepath.Join(m.common, "info", "exclude")}
+	if global != "" {
+		// info/exclude takes precedence
+		excludes = append([]string{global}, excludes...)
+	}
+	return ignore.NewMatcher(root, excludes...)
+}
+
+// globalExcludesFile returns the file of the ignore patterns of the user, set by
+// core.excludesFile, where a leading "~/" stands for the home directory. Like in git, it
+// defaults to git/ignore in $XDG_CONFIG_HOME or, if that isn't set, in ~/.config. It returns ""
+// if there is no home directory to find it in.
+func (m *MGIService) globalExcludesFile() (string, error) {
+	config, err := NewConfigService(m.common).Read()
+	if err != nil {
+		return "", err
+	}
+	home, _ := os.UserHomeDir()
+	if name, ok := config.Get("core.excludesFile"); ok {
+		if !strings.HasPrefix(name, "~/") {
+			return name, nil
+		}
+		if home == "" {
+			return "", fmt.Errorf("cannot expand %q: no home directory", name)
+		}
+		return filepath.Join(home, name[2:]), nil
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore"), nil
+	}
+	if home == "" {
+		return "", nil
+	}
+	return filepath.Join(home, ".config", "git", "ignore"), nil
+}
+
+// statusGitlink reports the nested repository at path as untracked, or as modified if a
+// different commit is checked out than the one recorded in the index. Its files are skipped.
+func (m *MGIService) statusGitlink(repoRoot, path string, untracked, modified *[]string) error {
+	relPath, err := filepath.Rel(repoRoot, path)
+	if err != nil {
+		return err
+	}
+	indexEntry, err := m.findIndexEntry(relPath)
+	if os.IsNotExist(err) {
+		*untracked = append(*untracked, relPath+"/")
+		return fs.SkipDir
+	}
+	if err != nil {
+		return err
+	}
+	head, err := m.gitlinkHead(path)
+	if err != nil {
+		return err
+	}
+	if head.String() != indexEntry.Hash.String() {
+		*modified = append(*modified, relPath)
+	}
+	return fs.SkipDir
+}
+
+// UpdateIndex updates the index entries of the given files with their working tree contents.
+// Files that are not in the index are only added if add is set, and the entries of files missing
+// from the working tree are only removed if remove is set.
+func (m *MGIService) UpdateIndex(files []string, add, remove bool) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	_, err := m.index.Read()
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+	maxSize, err := m.maxBlobSize()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		f := strings.TrimPrefix(f, "./")
+		_, err := m.findIndexEntry(f)
+		tracked := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		fileData, err := m.readWorkingFile(f)
 		if os.IsNotExist(err) {
-			untracked = append(untracked, relPath)
-			return nil
+			if !tracked || !remove {
+				return fmt.Errorf("%q does not exist and --remove was not given", f)
+			}
+			err := m.index.Remove(f)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !tracked && !add {
+			return fmt.Errorf("%q is not in the index and --add was not given", f)
 		}
+		err = checkBlobSize(f, maxSize)
 		if err != nil {
 			return err
 		}
 
-		if hash.String() != indexEntry.Hash.String() {
-			modified = append(modified, relPath)
+		hash, err := m.obj.StoreObject(&Blob{Data: fileData})
+		if err != nil {
+			return err
+		}
+		err = m.index.Add(f, hash)
+		if err != nil {
+			return err
 		}
+	}
+	return m.index.Store()
+}
 
-		return nil
+// AddCacheInfo adds an index entry for an object already in the store, without a working tree file.
+func (m *MGIService) AddCacheInfo(mode uint32, hash, path string) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	if !validFileMode(mode) {
+		return fmt.Errorf("invalid mode %o for %q", mode, path)
+	}
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return err
+	}
+	// Gitlinks point to commits of another repository, so they can't be checked
+	if mode != modeGitlink {
+		exists, err := m.obj.Exists(h)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("object %s for %q does not exist", hash, path)
+		}
+	}
+
+	_, err = m.index.Read()
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+	path = strings.TrimPrefix(path, "./")
+	m.index.AddEntry(&IndexEntry{
+		Mode:  mode,
+		Hash:  h,
+		Flags: nameFlags(path),
+		Path:  path,
 	})
+	return m.index.Store()
+}
+
+// SetAssumeUnchanged marks the index entries of the given files as assume-unchanged, so Status
+// and Diff skip them, or clears the mark.
+func (m *MGIService) SetAssumeUnchanged(files []string, value bool) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
 
+	_, err := m.index.Read()
 	if err != nil {
-		return nil, nil, err
+		return fmt.Errorf("error reading index file: %v", err)
 	}
 
-	return untracked, modified, nil
+	for _, f := range files {
+		f := strings.TrimPrefix(f, "./")
+		err := m.index.SetAssumeUnchanged(f, value)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%q is not in the index", f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return m.index.Store()
 }
 
-func (m *MGIService) Show() (string, error) {
-	panic("Implement me")
+// DiffOptions changes how changes between files are found and rendered.
+type DiffOptions struct {
+	// RenameThreshold, if positive, makes deleted and new files at least that similar (0-100) be
+	// shown as renames.
+	RenameThreshold int
+	// Render changes how the differences of each file are rendered, e.g. word by word.
+	Render diff.Options
+	// Format is how the changes are shown. Only the paths are shown with DiffNameOnly and
+	// DiffNameStatus, without reading the contents unless renames are detected.
+	Format DiffFormat
 }
 
-func (m *MGIService) Diff() ([]string, error) {
-	repoRoot, err := findRoot(m.root)
+// Diff returns the changes in the working tree that are not staged yet. Files added with
+// intent-to-add are shown as new files.
+func (m *MGIService) Diff(opts DiffOptions) ([]string, error) {
+	index, err := m.index.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file: %v", err)
+	}
+	indexFiles := make(map[string]*IndexEntry)
+	for _, ie := range index.Entries {
+		if !ie.IntentToAdd() {
+			indexFiles[ie.Path] = ie
+		}
+	}
+
+	workFiles, blobs, err := m.readWorkingTree(index)
+	if err != nil {
+		return nil, err
+	}
+	return m.diffFiles(indexFiles, workFiles, opts, blobs)
+}
+
+// DiffCommit returns the changes in the working tree since the given commit, whether they are
+// staged or not. Like Diff, only the files in the index are looked at in the working tree, so
+// files that are in the commit but not in the index are shown as deleted.
+func (m *MGIService) DiffCommit(rev string, opts DiffOptions) ([]string, error) {
+	commit, err := m.resolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+	commitFiles, err := m.commitFiles(commit)
 	if err != nil {
 		return nil, err
 	}
@@ -288,42 +938,91 @@ func (m *MGIService) Diff() ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error reading index file: %v", err)
 	}
+	workFiles, blobs, err := m.readWorkingTree(index)
+	if err != nil {
+		return nil, err
+	}
+	return m.diffFiles(commitFiles, workFiles, opts, blobs)
+}
 
-	var diffs []string
-	for _, ie := range index.Entries {
-		file := filepath.Join(repoRoot, ie.Path)
-		fileData, err := ioutil.ReadFile(file)
-		if err != nil {
-			return nil, err
+// DiffCached returns the changes staged in the index since the given commit, or HEAD if rev is
+// empty. Files added with intent-to-add are left out, since nothing is staged for them yet.
+func (m *MGIService) DiffCached(rev string, opts DiffOptions) ([]string, error) {
+	var commitFiles map[string]*IndexEntry
+	var err error
+	if rev == "" {
+		commitFiles, err = m.headFiles()
+	} else {
+		var commit string
+		commit, err = m.resolveRevision(rev)
+		if err == nil {
+			commitFiles, err = m.commitFiles(commit)
 		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		hash, err := m.obj.HashObject(&Blob{fileData})
-		if err != nil {
-			return nil, err
+	index, err := m.index.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file: %v", err)
+	}
+	indexFiles := make(map[string]*IndexEntry, len(index.Entries))
+	for _, e := range index.Entries {
+		if !e.IntentToAdd() {
+			indexFiles[e.Path] = e
 		}
+	}
+	return m.diffFiles(commitFiles, indexFiles, opts, nil)
+}
 
-		if hash.String() != ie.Hash.String() {
-			indexedData, err := m.obj.ReadObject(ie.Hash)
-			if err != nil {
-				return nil, err
-			}
+// readWorkingTree returns the working tree version of the files in the index, with their
+// contents keyed by hash, without storing them. Files that were removed from the working tree
+// and submodules that aren't checked out are left out. Files marked assume-unchanged keep their
+// index version.
+func (m *MGIService) readWorkingTree(index *Index) (map[string]*IndexEntry, map[string][]byte, error) {
+	repoRoot, err := findRoot(m.root)
+	if err != nil {
+		return nil, nil, err
+	}
 
-			c := exec.Command("diff", "-u", file, "/dev/stdin")
-			buffer := bytes.Buffer{}
-			buffer.Write(indexedData)
-			c.Stdin = &buffer
+	workFiles := make(map[string]*IndexEntry)
+	blobs := make(map[string][]byte)
+	for _, ie := range index.Entries {
+		if ie.AssumeUnchanged() {
+			workFiles[ie.Path] = ie
+			continue
+		}
 
-			var cerr *exec.ExitError
-			out, err := c.CombinedOutput()
-			if !errors.As(err, &cerr) {
-				fmt.Fprintf(os.Stderr, "Failed to run diff: %v", err)
-				os.Exit(1)
+		if ie.Mode == modeGitlink {
+			// Submodules that aren't checked out have no changes
+			dir := filepath.Join(repoRoot, ie.Path)
+			if !isNestedRepo(dir) {
+				continue
+			}
+			head, err := m.gitlinkHead(dir)
+			if err != nil {
+				return nil, nil, err
 			}
-			diffs = append(diffs, string(out))
+			workFiles[ie.Path] = &IndexEntry{Mode: ie.Mode, Hash: head, Path: ie.Path}
+			continue
 		}
-	}
 
-	return diffs, nil
+		fileData, err := m.readWorkingFile(filepath.Join(repoRoot, ie.Path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		hash, err := m.obj.HashObject(&Blob{fileData})
+		if err != nil {
+			return nil, nil, err
+		}
+		blobs[hash.String()] = fileData
+		workFiles[ie.Path] = &IndexEntry{Mode: ie.Mode, Hash: hash, Path: ie.Path}
+	}
+	return workFiles, blobs, nil
 }
 
 func (m *MGIService) Pull(remote string) error {
@@ -334,7 +1033,21 @@ func (m *MGIService) Push(remote string) error {
 	panic("Implement me")
 }
 
+// findRoot returns the root of the working tree: the directory containing gitRoot, which is
+// searched for from the current directory up unless it is an absolute path. The repository
+// directory of a linked worktree records where its working tree is instead, and the one of a
+// submodule is found through the ".git" file pointing to it.
 func findRoot(gitRoot string) (string, error) {
+	if filepath.IsAbs(gitRoot) {
+		if link, err := ioutil.ReadFile(filepath.Join(gitRoot, "gitdir")); err == nil {
+			return filepath.Dir(strings.TrimSpace(string(link))), nil
+		}
+		if filepath.Base(gitRoot) == ".git" {
+			return filepath.Dir(gitRoot), nil
+		}
+		return findLinkingRoot(gitRoot)
+	}
+
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return "", err
@@ -351,3 +1064,22 @@ func findRoot(gitRoot string) (string, error) {
 	}
 	return "", fmt.Errorf("not in a git repository")
 }
+
+// findLinkingRoot searches from the current directory up for a ".git" file pointing to gitDir.
+func findLinkingRoot(gitDir string) (string, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		dir, err := ResolveGitDir(filepath.Join(currentDir, ".git"))
+		if err == nil && dir == gitDir {
+			return currentDir, nil
+		}
+		parent := filepath.Dir(currentDir)
+		if parent == currentDir {
+			return "", fmt.Errorf("cannot find the working tree of %s", gitDir)
+		}
+		currentDir = parent
+	}
+}
@@ -0,0 +1,97 @@
+package mgi
+
+import (
+	"reflect"
+	"testing"
+)
+
+// indexPaths returns the paths in the index, sorted.
+func indexPaths(t *testing.T, repo *Repo) []string {
+	t.Helper()
+	index, err := repo.Index.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range index.Entries {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func TestAddPathspecs(t *testing.T) {
+	for _, tt := range []struct {
+		specs []string
+		want  []string
+	}{
+		{[]string{"top.txt"}, []string{"top.txt"}},
+		{[]string{"d"}, []string{"d/a.txt", "d/b.md", "d/sub/c.txt"}},
+		{[]string{"d/"}, []string{"d/a.txt", "d/b.md", "d/sub/c.txt"}},
+		{[]string{"./d/sub"}, []string{"d/sub/c.txt"}},
+		{[]string{":/d/sub"}, []string{"d/sub/c.txt"}},
+		// Wildcards match across slashes
+		{[]string{"d/*.txt"}, []string{"d/a.txt", "d/sub/c.txt"}},
+		{[]string{"*.md"}, []string{"d/b.md"}},
+		{[]string{"d/?.txt"}, []string{"d/a.txt"}},
+		{[]string{".", ":!d"}, []string{".gitignore", "top.txt"}},
+		// Ignored files are only added if they are named
+		{[]string{"."}, []string{".gitignore", "d/a.txt", "d/b.md", "d/sub/c.txt", "top.txt"}},
+		{[]string{"debug.log"}, []string{"debug.log"}},
+	} {
+		t.Run(tt.specs[0], func(t *testing.T) {
+			repo := newTestRepo(t)
+			for path, contents := range map[string]string{
+				".gitignore":  "*.log\n",
+				"top.txt":     "top\n",
+				"debug.log":   "ignored\n",
+				"d/a.txt":     "a\n",
+				"d/b.md":      "b\n",
+				"d/sub/c.txt": "c\n",
+			} {
+				writeTestFile(t, path, contents)
+			}
+			err := repo.Add(tt.specs)
+			if err != nil {
+				t.Fatalf("Add(%q): %v", tt.specs, err)
+			}
+			if got := indexPaths(t, repo); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Add(%q) staged %q, want %q", tt.specs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPathspecErrorsAndDeletions(t *testing.T) {
+	repo := newTestRepo(t)
+	commitTestFiles(t, repo, "first", map[string]string{"d/a": "a\n", "d/b": "b\n", "c": "c\n"})
+
+	for _, specs := range [][]string{{"missing"}, {"d/*.txt"}, {"c", "nothing*"}} {
+		if err := repo.Add(specs); err == nil {
+			t.Errorf("Add(%q) succeeded without matching files", specs)
+		}
+	}
+	if got, want := indexPaths(t, repo), []string{"c", "d/a", "d/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("a failed Add changed the index to %q", got)
+	}
+
+	// Deleted files under a directory are removed from the index
+	err := removeFile("d/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, "d/b", "changed\n")
+	err = repo.Add([]string{"d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexPaths(t, repo), []string{"c", "d/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the index has %q, want %q", got, want)
+	}
+	_, modified, _, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modified) != 0 {
+		t.Errorf("modified files %q after adding them", modified)
+	}
+}
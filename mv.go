@@ -0,0 +1,107 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bertinatto/mgi/pathspec"
+)
+
+// PathMove is a tracked path renamed by Mv.
+type PathMove struct {
+	From string
+	To   string
+}
+
+// Mv moves the files matched by the sources to dest, both in the working tree and in the index.
+// Sources may be files, directories or glob patterns expanded against the index. When there is
+// more than one source, or dest is an existing directory, they are moved into dest. It returns
+// the renamed paths; with dryRun nothing is actually moved.
+func (m *MGIService) Mv(sources []string, dest string, dryRun bool) ([]*PathMove, error) {
+	if err := m.index.Lock(); err != nil {
+		return nil, err
+	}
+	defer m.index.Unlock()
+
+	index, err := m.indexFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each unit is a file or a directory that is moved as a whole
+	var units []string
+	for _, spec := range sources {
+		p, err := pathspec.CompilePattern(spec)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := index[p.Path()]; ok || !p.IsGlob() {
+			units = append(units, p.Path())
+			continue
+		}
+		paths, err := expandPathspecs([]string{spec}, index, false)
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, paths...)
+	}
+
+	intoDir := len(units) > 1 || strings.HasSuffix(dest, "/")
+	dest = path.Clean(dest)
+	if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
+		intoDir = true
+	}
+
+	var moves []*PathMove
+	for _, unit := range units {
+		target := dest
+		if intoDir {
+			target = path.Join(dest, path.Base(unit))
+		}
+		if target == unit || strings.HasPrefix(target, unit+"/") {
+			return nil, fmt.Errorf("can not move %q to a subdirectory of itself", unit)
+		}
+
+		paths, err := expandPathspecs([]string{unit}, index, true)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not under version control", unit)
+		}
+		for _, p := range paths {
+			to := target + strings.TrimPrefix(p, unit)
+			if _, ok := index[to]; ok {
+				return nil, fmt.Errorf("destination %q already exists", to)
+			}
+			if _, err := os.Lstat(to); err == nil {
+				return nil, fmt.Errorf("destination %q already exists", to)
+			}
+			moves = append(moves, &PathMove{From: p, To: to})
+		}
+	}
+	if dryRun {
+		return moves, nil
+	}
+
+	for _, mv := range moves {
+		err := os.MkdirAll(filepath.Dir(mv.To), 0755)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Rename(mv.From, mv.To)
+		if err != nil {
+			return nil, err
+		}
+		// Clean up the directories left empty by the move
+		err = removeFile(mv.From)
+		if err != nil {
+			return nil, err
+		}
+		err = m.index.Rename(mv.From, mv.To)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return moves, m.index.Store()
+}
@@ -0,0 +1,175 @@
+package mgi
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bertinatto/mgi/diff"
+)
+
+// DiffNoIndex compares two paths outside of any repository and returns a unified diff for each
+// file that differs. Directories are compared recursively, file by file, and a file compared
+// with a directory is compared with the file of the same name in it. Either path may be "-" to
+// read that side from stdin. The differences are rendered with the given options.
+func DiffNoIndex(a, b string, stdin io.Reader, opts diff.Options) ([]string, error) {
+	if a == "-" && b == "-" {
+		return nil, fmt.Errorf("cannot compare stdin to itself")
+	}
+	aDir, err := isDir(a)
+	if err != nil {
+		return nil, err
+	}
+	bDir, err := isDir(b)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case aDir && bDir:
+		return diffDirs(a, b, opts)
+	case aDir:
+		a = filepath.Join(a, filepath.Base(b))
+	case bDir:
+		b = filepath.Join(b, filepath.Base(a))
+	}
+
+	old, err := readNoIndexFile(a, stdin)
+	if err != nil {
+		return nil, err
+	}
+	new, err := readNoIndexFile(b, stdin)
+	if err != nil {
+		return nil, err
+	}
+	d := diffNoIndexFiles(a, b, old, new, opts)
+	if d == "" {
+		return nil, nil
+	}
+	return []string{d}, nil
+}
+
+// diffDirs compares the files under two directories, pairing them by their relative path.
+func diffDirs(a, b string, opts diff.Options) ([]string, error) {
+	aFiles, err := listFiles(a)
+	if err != nil {
+		return nil, err
+	}
+	bFiles, err := listFiles(b)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool, len(aFiles)+len(bFiles))
+	for path := range aFiles {
+		paths[path] = true
+	}
+	for path := range bFiles {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, path := range sorted {
+		var old, new []byte
+		if aFiles[path] {
+			old, err = ioutil.ReadFile(filepath.Join(a, path))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if bFiles[path] {
+			new, err = ioutil.ReadFile(filepath.Join(b, path))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if aFiles[path] && bFiles[path] && string(old) == string(new) {
+			continue
+		}
+
+		oldPath, newPath := filepath.Join(a, path), filepath.Join(b, path)
+		if !aFiles[path] {
+			oldPath = ""
+		}
+		if !bFiles[path] {
+			newPath = ""
+		}
+		if d := diffNoIndexFiles(oldPath, newPath, old, new, opts); d != "" {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+// diffNoIndexFiles renders the diff of two files given by their paths. An empty path means the
+// file doesn't exist on that side. It returns an empty string if both exist and no differences
+// are found.
+func diffNoIndexFiles(oldPath, newPath string, old, new []byte, opts diff.Options) string {
+	oldLabel, newLabel := "a/"+filepath.ToSlash(oldPath), "b/"+filepath.ToSlash(newPath)
+	if oldPath == "" {
+		oldLabel, old = "/dev/null", nil
+	}
+	if newPath == "" {
+		newLabel, new = "/dev/null", nil
+	}
+	// The header names the file that exists when the other side is missing
+	headerOld, headerNew := oldLabel, newLabel
+	if oldPath == "" {
+		headerOld = "a/" + filepath.ToSlash(newPath)
+	}
+	if newPath == "" {
+		headerNew = "b/" + filepath.ToSlash(oldPath)
+	}
+	rendered := opts.Unified(oldLabel, newLabel, old, new)
+	if rendered == "" && oldPath != "" && newPath != "" {
+		return ""
+	}
+	return fmt.Sprintf("diff --git %s %s\n%s", headerOld, headerNew, rendered)
+}
+
+// listFiles returns the paths of the files under a directory, relative to it.
+func listFiles(dir string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = true
+		return nil
+	})
+	return files, err
+}
+
+func isDir(path string) (bool, error) {
+	if path == "-" {
+		return false, nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
+
+func readNoIndexFile(path string, stdin io.Reader) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(stdin)
+	}
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,107 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// notesRef is the ref of the commit whose tree holds the notes attached to commits.
+const notesRef = "refs/notes/commits"
+
+// Notes are stored like git does: refs/notes/commits points to a commit whose tree has a blob
+// for each annotated object, named after its hash. Git splits the names into directories
+// (e.g. "ab/cdef...") when there are many notes, which is understood when reading them, but
+// notes are always written at the top of the tree.
+
+// readNotes returns the notes tree, keyed by the hash of the object each note is attached to,
+// along with the notes commit it comes from, which is empty if there are no notes yet.
+func (m *MGIService) readNotes() (map[string]*IndexEntry, string, error) {
+	commit, err := m.readRef(notesRef)
+	if os.IsNotExist(err) {
+		return map[string]*IndexEntry{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	files, err := m.commitFiles(commit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	notes := make(map[string]*IndexEntry, len(files))
+	for path, e := range files {
+		object := strings.ReplaceAll(path, "/", "")
+		notes[object] = &IndexEntry{Mode: e.Mode, Hash: e.Hash, Path: object}
+	}
+	return notes, commit, nil
+}
+
+// ShowNote returns the note attached to the object rev names.
+func (m *MGIService) ShowNote(rev string) (string, error) {
+	object, err := m.resolveRevision(rev)
+	if err != nil {
+		return "", err
+	}
+	notes, _, err := m.readNotes()
+	if err != nil {
+		return "", err
+	}
+	e, ok := notes[object]
+	if !ok {
+		return "", fmt.Errorf("no note found for object %s", object)
+	}
+	data, err := m.obj.ReadObject(e.Hash)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// AddNote attaches a note with the message to the object rev names. If the object already has a
+// note, the message is added to the end of it, separated by a blank line, if appendNote is set,
+// or replaces it if force is set; otherwise it is an error.
+func (m *MGIService) AddNote(rev, message string, force, appendNote bool) error {
+	object, err := m.resolveRevision(rev)
+	if err != nil {
+		return err
+	}
+	notes, parent, err := m.readNotes()
+	if err != nil {
+		return err
+	}
+
+	note := strings.TrimRight(message, "\n") + "\n"
+	if e, ok := notes[object]; ok {
+		switch {
+		case appendNote:
+			data, err := m.obj.ReadObject(e.Hash)
+			if err != nil {
+				return err
+			}
+			note = strings.TrimRight(string(data), "\n") + "\n\n" + note
+		case !force:
+			return fmt.Errorf("cannot add notes, found existing notes for object %s; use -f to overwrite existing notes", object)
+		}
+	}
+
+	hash, err := m.obj.StoreObject(&Blob{Data: []byte(note)})
+	if err != nil {
+		return err
+	}
+	notes[object] = &IndexEntry{Mode: 0100644, Hash: hash, Path: object}
+	tree, err := m.writeFilesTree(notes)
+	if err != nil {
+		return err
+	}
+
+	var parents []string
+	if parent != "" {
+		parents = append(parents, parent)
+	}
+	commit, err := m.storeCommit(tree, parents, "Notes added by 'mgi notes add'", false)
+	if err != nil {
+		return err
+	}
+	return m.updateRef(notesRef, parent, commit)
+}
@@ -4,14 +4,28 @@ import (
 	"bytes"
 	"compress/zlib"
 	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// ErrObjectNotFound is returned when an object is not present in the object store.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrCorruptObject is returned when an object can't be decompressed or parsed.
+var ErrCorruptObject = errors.New("corrupt object")
+
+// ErrUnknownObjectType is returned when a loose object has a well-formed header with a type
+// other than blob, tree, commit and tag. The type and contents are returned along with it.
+var ErrUnknownObjectType = errors.New("unknown object type")
+
 // Hash represents a SHA-1 signature.
 type Hash struct {
 	sha1 [20]byte
@@ -35,6 +49,16 @@ func (h *Hash) FromSHA1Bytes(sha1 []byte) *Hash {
 	return h
 }
 
+// FromString returns a new *Hash from the hexadecimal representation of a SHA-1.
+func (h *Hash) FromString(s string) (*Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h.sha1) {
+		return nil, fmt.Errorf("invalid object name %q", s)
+	}
+	copy(h.sha1[:], b)
+	return h, nil
+}
+
 // String returns a string representing the SHA-1 sum.
 func (h *Hash) String() string {
 	return fmt.Sprintf("%x", h.sha1)
@@ -66,6 +90,18 @@ func (b *Blob) Marshal() ([]byte, error) {
 	return join(header, b.Data)
 }
 
+// rawObject is an object of any type whose contents are already serialized, e.g. one read
+// from a packfile.
+type rawObject struct {
+	objType string
+	data    []byte
+}
+
+func (r *rawObject) Marshal() ([]byte, error) {
+	header := []byte(fmt.Sprintf("%s %d\x00", r.objType, len(r.data)))
+	return join(header, r.data)
+}
+
 // TreeEntry represents a single entry in the tree
 type TreeEntry struct {
 	mode uint32
@@ -73,6 +109,37 @@ type TreeEntry struct {
 	hash *Hash
 }
 
+// modeDir is the mode of tree entries that are subdirectories. Like git, it is written
+// without a leading zero ("40000") in tree objects.
+const modeDir = 040000
+
+// modeGitlink is the mode of entries that record a commit of another repository, i.e. submodules.
+const modeGitlink = 0160000
+
+// validFileMode returns whether the mode is one git allows for non-directory entries:
+// regular files, executables, symbolic links and gitlinks (submodules).
+func validFileMode(mode uint32) bool {
+	switch mode {
+	case 0100644, 0100755, 0120000, modeGitlink:
+		return true
+	}
+	return false
+}
+
+// sortName returns the name used to order the entry within its tree: directories sort as if
+// they had a trailing slash, so "a.b" comes before the directory "a".
+func (e *TreeEntry) sortName() string {
+	if e.mode == modeDir {
+		return e.path + "/"
+	}
+	return e.path
+}
+
+// validTreeMode returns whether the mode is valid for a tree entry.
+func validTreeMode(mode uint32) bool {
+	return mode == modeDir || validFileMode(mode)
+}
+
 // Tree represents a directory with potentially other directories or files.
 type Tree struct {
 	Entries []*TreeEntry
@@ -81,6 +148,9 @@ type Tree struct {
 func (t *Tree) Marshal() ([]byte, error) {
 	b := new(bytes.Buffer)
 	for _, e := range t.Entries {
+		if !validTreeMode(e.mode) {
+			return nil, fmt.Errorf("invalid mode %o for tree entry %q", e.mode, e.path)
+		}
 		b.WriteString(fmt.Sprintf("%o %s\x00%s", e.mode, e.path, e.hash.Sha1()))
 	}
 	data := b.Bytes()
@@ -88,68 +158,217 @@ func (t *Tree) Marshal() ([]byte, error) {
 	return join(header, data)
 }
 
+// ParseTree creates a Tree out of the contents of a tree object.
+func ParseTree(data []byte) (*Tree, error) {
+	t := new(Tree)
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, '\x00')
+		if sp < 0 || nul < sp || nul+21 > len(data) {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tree entry mode %q", data[:sp])
+		}
+		t.Entries = append(t.Entries, &TreeEntry{
+			mode: uint32(mode),
+			path: string(data[sp+1 : nul]),
+			hash: new(Hash).FromSHA1Bytes(data[nul+1 : nul+21]),
+		})
+		data = data[nul+21:]
+	}
+	return t, nil
+}
+
 // Commit represents a commit object.
 type Commit struct {
-	Parent      string
+	Parents     []string
 	Tree        string
 	Author      string
 	AuthorEmail string
 	AuthorTime  time.Time
-	Message     string
+	// The committer defaults to the author when not set.
+	Committer      string
+	CommitterEmail string
+	CommitTime     time.Time
+	// Signature is the armored GPG or SSH signature of the payload, if the commit is signed.
+	Signature string
+	Message   string
 }
 
 func (c *Commit) Marshal() ([]byte, error) {
+	data := c.marshal(true)
+	header := []byte(fmt.Sprintf("commit %d\x00", len(data)))
+	return join(header, data)
+}
+
+// Payload returns the contents of the commit object without its signature, which is what the
+// signature is computed over.
+func (c *Commit) Payload() []byte {
+	return c.marshal(false)
+}
+
+// marshal returns the contents of the commit object, with its signature if withSignature is set.
+func (c *Commit) marshal(withSignature bool) []byte {
 	// Add the "tree xxx" line
 	b := new(bytes.Buffer)
 	b.WriteString("tree ")
 	b.WriteString(c.Tree)
 	b.WriteString("\n")
 
-	// Add the "parent xxx" line
-	if len(c.Parent) > 0 {
+	// Add the "parent xxx" lines
+	for _, parent := range c.Parents {
 		b.WriteString("parent ")
-		b.WriteString(c.Parent)
+		b.WriteString(parent)
 		b.WriteString("\n")
 	}
 
-	// Find out the author time
-	_, offset := c.AuthorTime.Zone()
-	var sign string
-	if offset > 0 {
-		sign = "+"
-	} else {
-		sign = "-"
+	committer, committerEmail, commitTime := c.Committer, c.CommitterEmail, c.CommitTime
+	if committer == "" {
+		committer, committerEmail, commitTime = c.Author, c.AuthorEmail, c.AuthorTime
 	}
-	fo := int64(math.Abs(float64(offset)))
-	timestamp := int64(math.Abs(float64(c.AuthorTime.Unix())))
-	authorTime := fmt.Sprintf("%d %s%02d%02d", timestamp, sign, fo/3600, (fo/60)%60)
 
 	// Add the "author/commit xxx" line
-	b.WriteString(fmt.Sprintf("author %s <%s> %s", c.Author, c.AuthorEmail, authorTime))
+	b.WriteString(fmt.Sprintf("author %s <%s> %s", c.Author, c.AuthorEmail, formatTime(c.AuthorTime)))
 	b.WriteString("\n")
-	b.WriteString(fmt.Sprintf("committer %s <%s> %s", c.Author, c.AuthorEmail, authorTime))
+	b.WriteString(fmt.Sprintf("committer %s <%s> %s", committer, committerEmail, formatTime(commitTime)))
 	b.WriteString("\n")
+
+	// The signature goes last, with its lines after the first one indented as continuation lines
+	if withSignature && c.Signature != "" {
+		b.WriteString("gpgsig ")
+		b.WriteString(strings.ReplaceAll(strings.TrimSuffix(c.Signature, "\n"), "\n", "\n "))
+		b.WriteString("\n")
+	}
 	b.WriteString("\n")
 	b.WriteString(c.Message)
 	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// ParseCommit creates a Commit out of the contents of a commit object.
+func ParseCommit(data []byte) (*Commit, error) {
+	c := new(Commit)
+	headers, message := splitObjectMessage(data)
+	for _, line := range headers {
+		key, value := splitHeader(line)
+		switch key {
+		case "tree":
+			c.Tree = value
+		case "parent":
+			c.Parents = append(c.Parents, value)
+		case "author":
+			name, email, t, err := parseSignature(value)
+			if err != nil {
+				return nil, err
+			}
+			c.Author, c.AuthorEmail, c.AuthorTime = name, email, t
+		case "committer":
+			name, email, t, err := parseSignature(value)
+			if err != nil {
+				return nil, err
+			}
+			c.Committer, c.CommitterEmail, c.CommitTime = name, email, t
+		case "gpgsig":
+			c.Signature = value + "\n"
+		}
+	}
+	if c.Tree == "" {
+		return nil, fmt.Errorf("commit has no tree")
+	}
+	c.Message = strings.TrimSuffix(message, "\n")
+	return c, nil
+}
+
+// Tag represents an annotated tag object.
+type Tag struct {
+	Object      string
+	Type        string
+	Name        string
+	Tagger      string
+	TaggerEmail string
+	TagTime     time.Time
+	Message     string
+}
+
+func (t *Tag) Marshal() ([]byte, error) {
+	b := new(bytes.Buffer)
+	b.WriteString(fmt.Sprintf("object %s\n", t.Object))
+	b.WriteString(fmt.Sprintf("type %s\n", t.Type))
+	b.WriteString(fmt.Sprintf("tag %s\n", t.Name))
+	b.WriteString(fmt.Sprintf("tagger %s <%s> %s\n", t.Tagger, t.TaggerEmail, formatTime(t.TagTime)))
+	b.WriteString("\n")
+	b.WriteString(t.Message)
+	b.WriteString("\n")
 
 	data := b.Bytes()
-	header := []byte(fmt.Sprintf("commit %d\x00", len(data)))
+	header := []byte(fmt.Sprintf("tag %d\x00", len(data)))
 	return join(header, data)
 }
 
+// ParseTag creates a Tag out of the contents of a tag object.
+func ParseTag(data []byte) (*Tag, error) {
+	t := new(Tag)
+	headers, message := splitObjectMessage(data)
+	for _, line := range headers {
+		key, value := splitHeader(line)
+		switch key {
+		case "object":
+			t.Object = value
+		case "type":
+			t.Type = value
+		case "tag":
+			t.Name = value
+		case "tagger":
+			name, email, when, err := parseSignature(value)
+			if err != nil {
+				return nil, err
+			}
+			t.Tagger, t.TaggerEmail, t.TagTime = name, email, when
+		}
+	}
+	if t.Object == "" || t.Type == "" {
+		return nil, fmt.Errorf("tag has no object")
+	}
+	t.Message = strings.TrimSuffix(message, "\n")
+	return t, nil
+}
+
 // ObjectService allows for storing objects to a given location.
 type ObjectService struct {
-	path string
+	path   string
+	packs  []*packFile
+	fsync  bool
+	cache  *objectCache // nil unless enabled with SetCacheSize
+	logger Logger
 }
 
-// NewObjectService creates a new ObjectService.
-func NewObjectService(root string) *ObjectService {
+// NewObjectService creates a new ObjectService. The logger may be nil.
+func NewObjectService(root string, logger Logger) *ObjectService {
 	return &ObjectService{
-		path: filepath.Join(root, "objects"),
+		path:   filepath.Join(root, "objects"),
+		logger: orNop(logger),
 	}
 }
 
+// SetCacheSize sets how many bytes of recently read objects are kept in memory, so reading
+// them again, e.g. the commits and trees of a long history, doesn't decompress them again. The
+// cache is disabled by default, and a size of 0 disables it. Callers must not modify the
+// contents returned by ReadObject and ReadTypedObject when it is enabled.
+func (o *ObjectService) SetCacheSize(size int64) {
+	o.cache = nil
+	if size > 0 {
+		o.cache = newObjectCache(size)
+	}
+}
+
+// SetFsync sets whether object files are flushed to disk before StoreObject returns, so that
+// they survive a crash or power loss. It is off by default, since it makes writes slower.
+func (o *ObjectService) SetFsync(on bool) {
+	o.fsync = on
+}
+
 func (o *ObjectService) HashObject(m Marshaller) (*Hash, error) {
 	data, err := m.Marshal()
 	if err != nil {
@@ -187,31 +406,320 @@ func (o *ObjectService) StoreObject(m Marshaller) (*Hash, error) {
 
 	// Create a file out of the compressed data
 	obj := filepath.Join(dir, string(hashStr[2:]))
-	return hash, ioutil.WriteFile(obj, zData.Bytes(), 0755)
+	o.logger.Debugf("writing object %s (%d bytes) to %s", hashStr, len(data), obj)
+	if !o.fsync {
+		return hash, ioutil.WriteFile(obj, zData.Bytes(), 0755)
+	}
+	return hash, writeFileSync(obj, zData.Bytes(), 0755)
+}
+
+// writeFileSync writes a file durably: the data is written to a temporary file in the same
+// directory and flushed to disk, then the file is renamed into place and the directory flushed.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, "tmp_obj_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
 }
 
 // ReadObject reads the object from disk, uncompress and returns its contents.
 func (o *ObjectService) ReadObject(hash *Hash) ([]byte, error) {
+	_, contents, err := o.ReadTypedObject(hash)
+	return contents, err
+}
+
+// ReadTypedObject is like ReadObject, but it also returns the object type (e.g. "blob").
+//
+// When an object is both packed and loose, the packed copy wins: packs are checksummed and never
+// rewritten, while a loose copy of a packed object is a leftover that prune-packed would remove.
+// Loose objects are read only when no pack has the object.
+func (o *ObjectService) ReadTypedObject(hash *Hash) (string, []byte, error) {
+	if o.cache == nil {
+		return o.readObject(hash)
+	}
+	if objType, data, ok := o.cache.get(hash.String()); ok {
+		return objType, data, nil
+	}
+	objType, data, err := o.readObject(hash)
+	if err == nil {
+		o.cache.put(hash.String(), objType, data)
+	}
+	return objType, data, err
+}
+
+// readObject reads the object from a pack or, if no pack has it, from its loose file.
+func (o *ObjectService) readObject(hash *Hash) (string, []byte, error) {
+	objType, data, err := o.readPackedObject(hash)
+	if !errors.Is(err, ErrObjectNotFound) {
+		return objType, data, err
+	}
+	return o.readLooseObject(hash)
+}
+
+func (o *ObjectService) readLooseObject(hash *Hash) (string, []byte, error) {
 	hashStr := hash.String()
 	path := filepath.Join(o.path, hashStr[:2], hashStr[2:])
 	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, fmt.Errorf("%w: %s", ErrObjectNotFound, hashStr)
+	}
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	defer f.Close()
 
 	r, err := zlib.NewReader(f)
 	if err != nil {
-		return nil, err
+		return "", nil, fmt.Errorf("%w %s (%s): %s", ErrCorruptObject, hashStr, path, describeZlibError(err))
 	}
 
 	contents, err := ioutil.ReadAll(r)
 	if err != nil {
+		return "", nil, fmt.Errorf("%w %s (%s): %s", ErrCorruptObject, hashStr, path, describeZlibError(err))
+	}
+
+	objType, data, err := parseObjectHeader(contents)
+	if errors.Is(err, ErrUnknownObjectType) {
+		return objType, data, fmt.Errorf("%w %q in %s", ErrUnknownObjectType, objType, hashStr)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%w %s (%s): %v", ErrCorruptObject, hashStr, path, err)
+	}
+	return objType, data, nil
+}
+
+// parseObjectHeader splits a loose object into its type and contents, checking that its header
+// is "<type> <size>\x00" with the size of the contents. If the type isn't one of the four
+// known ones, it returns ErrUnknownObjectType with the type and contents.
+func parseObjectHeader(contents []byte) (string, []byte, error) {
+	nul := bytes.IndexByte(contents, '\x00')
+	if nul < 0 {
+		return "", nil, errors.New("no NUL after the header")
+	}
+	header, data := string(contents[:nul]), contents[nul+1:]
+	sp := strings.IndexByte(header, ' ')
+	if sp < 0 {
+		return "", nil, fmt.Errorf("no space in header %q", header)
+	}
+	objType, sizeStr := header[:sp], header[sp+1:]
+	size, err := strconv.ParseUint(sizeStr, 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid size %q in header", sizeStr)
+	}
+	if size != uint64(len(data)) {
+		return "", nil, fmt.Errorf("header says %d bytes but there are %d", size, len(data))
+	}
+	switch objType {
+	case "blob", "tree", "commit", "tag":
+		return objType, data, nil
+	case "":
+		return "", nil, errors.New("empty type in header")
+	}
+	return objType, data, ErrUnknownObjectType
+}
+
+// Exists returns whether the object is in the store, either loose or packed.
+func (o *ObjectService) Exists(hash *Hash) (bool, error) {
+	hashStr := hash.String()
+	_, err := os.Stat(filepath.Join(o.path, hashStr[:2], hashStr[2:]))
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return o.isPacked(hash)
+}
+
+// loosePath returns the path of the file a loose object is stored in.
+func (o *ObjectService) loosePath(hash string) string {
+	return filepath.Join(o.path, hash[:2], hash[2:])
+}
+
+// Iterate calls fn with the hash of every loose object. Entries of the objects directory that
+// aren't objects, such as the pack and info directories, are skipped, and a missing directory
+// has no objects. If fn returns an error, the iteration stops and Iterate returns it.
+func (o *ObjectService) Iterate(fn func(hash string) error) error {
+	return o.looseObjects(func(hash *Hash, path string) error {
+		return fn(hash.String())
+	})
+}
+
+// ResolvePrefix returns the object whose hash starts with the given prefix of at least 4 hexadecimal
+// characters. It fails if no object or more than one object matches.
+func (o *ObjectService) ResolvePrefix(prefix string) (*Hash, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 || len(prefix) > 40 {
+		return nil, fmt.Errorf("invalid object name %q", prefix)
+	}
+	if _, err := hex.DecodeString(prefix[:len(prefix)/2*2]); err != nil {
+		return nil, fmt.Errorf("invalid object name %q", prefix)
+	}
+
+	matches := make(map[string]*Hash)
+	entries, err := ioutil.ReadDir(filepath.Join(o.path, prefix[:2]))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
+	for _, e := range entries {
+		if strings.HasPrefix(prefix[:2]+e.Name(), prefix) {
+			hash, err := new(Hash).FromString(prefix[:2] + e.Name())
+			if err == nil {
+				matches[hash.String()] = hash
+			}
+		}
+	}
 
-	i := bytes.IndexByte(contents, byte('\x00'))
-	return contents[i+1:], nil
+	packs, err := o.loadPacks()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packs {
+		p.findPrefix(prefix, func(hash *Hash) {
+			matches[hash.String()] = hash
+		})
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, prefix)
+	case 1:
+		for _, hash := range matches {
+			return hash, nil
+		}
+	}
+	return nil, fmt.Errorf("short object name %q is ambiguous", prefix)
+}
+
+// Abbrev returns the shortest prefix of the hash, with at least 7 characters, that is unique among the loose objects.
+func (o *ObjectService) Abbrev(hash *Hash) string {
+	hashStr := hash.String()
+	entries, err := ioutil.ReadDir(filepath.Join(o.path, hashStr[:2]))
+	if err != nil {
+		return hashStr[:7]
+	}
+
+	n := 7
+	for _, e := range entries {
+		other := hashStr[:2] + e.Name()
+		if other == hashStr {
+			continue
+		}
+		for n < len(hashStr) && strings.HasPrefix(other, hashStr[:n]) {
+			n++
+		}
+	}
+	return hashStr[:n]
+}
+
+// describeZlibError explains why a zlib stream could not be read.
+func describeZlibError(err error) string {
+	switch {
+	case errors.Is(err, zlib.ErrHeader):
+		return "not a zlib stream"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "truncated zlib stream"
+	case errors.Is(err, zlib.ErrChecksum):
+		return "zlib checksum mismatch"
+	}
+	return fmt.Sprintf("invalid zlib stream: %v", err)
+}
+
+// formatTime formats a time the way git stores it in objects, e.g. "1136239445 -0700".
+func formatTime(t time.Time) string {
+	_, offset := t.Zone()
+	// UTC is "+0000": git reads "-0000" as an unknown timezone
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+	}
+	if offset < 0 {
+		offset = -offset
+	}
+	return fmt.Sprintf("%d %s%02d%02d", t.Unix(), sign, offset/3600, (offset/60)%60)
+}
+
+// parseSignature parses the value of author, committer and tagger lines, e.g. "Name <email> 1136239445 -0700".
+func parseSignature(s string) (string, string, time.Time, error) {
+	start := strings.IndexByte(s, '<')
+	end := strings.LastIndexByte(s, '>')
+	if start < 0 || end < start {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature %q", s)
+	}
+	name := strings.TrimSpace(s[:start])
+	email := s[start+1 : end]
+
+	fields := strings.Fields(s[end+1:])
+	if len(fields) != 2 {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature time %q", s)
+	}
+	timestamp, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature time %q", s)
+	}
+	tz := fields[1]
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature timezone %q", s)
+	}
+	hours, err1 := strconv.Atoi(tz[1:3])
+	minutes, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature timezone %q", s)
+	}
+	offset := hours*3600 + minutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return name, email, time.Unix(timestamp, 0).In(time.FixedZone("", offset)), nil
+}
+
+// splitObjectMessage splits commit and tag objects into their header lines and message.
+func splitObjectMessage(data []byte) ([]string, string) {
+	text := string(data)
+	var message string
+	if i := strings.Index(text, "\n\n"); i >= 0 {
+		text, message = text[:i], text[i+2:]
+	}
+
+	var headers []string
+	for _, line := range strings.Split(text, "\n") {
+		// Continuation lines (e.g. in signatures) belong to the previous header
+		if strings.HasPrefix(line, " ") && len(headers) > 0 {
+			headers[len(headers)-1] += "\n" + line[1:]
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, message
+}
+
+func splitHeader(line string) (string, string) {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], line[i+1:]
 }
 
 func join(header []byte, data []byte) ([]byte, error) {
@@ -0,0 +1,154 @@
+package mgi
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCorruptLooseObjectErrors(t *testing.T) {
+	dir := t.TempDir()
+	obj := NewObjectService(dir, nil)
+	hash, err := obj.StoreObject(&Blob{Data: []byte(strings.Repeat("some contents\n", 100))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "objects", hash.String()[:2], hash.String()[2:])
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		contents []byte
+		cause    string
+	}{
+		{"not zlib", []byte("plain text"), "not a zlib stream"},
+		{"truncated", data[:len(data)/2], "truncated zlib stream"},
+	}
+	for _, tt := range tests {
+		err := ioutil.WriteFile(path, tt.contents, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = obj.ReadObject(hash)
+		if !errors.Is(err, ErrCorruptObject) {
+			t.Errorf("%s: got error %v, want ErrCorruptObject", tt.name, err)
+			continue
+		}
+		if msg := err.Error(); !strings.Contains(msg, hash.String()) || !strings.Contains(msg, tt.cause) {
+			t.Errorf("%s: error %q doesn't name the object and %q", tt.name, msg, tt.cause)
+		}
+	}
+}
+
+func TestFormatTimeOffsets(t *testing.T) {
+	const unix = 1136239445
+	tests := []struct {
+		zone *time.Location
+		want string
+	}{
+		// git reads "-0000" as an unknown timezone
+		{time.UTC, "1136239445 +0000"},
+		{time.FixedZone("", -7*3600), "1136239445 -0700"},
+		{time.FixedZone("", 5*3600+30*60), "1136239445 +0530"},
+		{time.FixedZone("", -(3*3600 + 30*60)), "1136239445 -0330"},
+	}
+	for _, tt := range tests {
+		if got := formatTime(time.Unix(unix, 0).In(tt.zone)); got != tt.want {
+			t.Errorf("formatTime in %v = %q, want %q", tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTimeExactTimestamps(t *testing.T) {
+	// Timestamps past 2^53 can't be represented exactly as float64
+	for _, unix := range []int64{0, 1<<53 + 1, 1<<62 + 1} {
+		want := strconv.FormatInt(unix, 10) + " +0000"
+		if got := formatTime(time.Unix(unix, 0).UTC()); got != want {
+			t.Errorf("formatTime(%d) = %q, want %q", unix, got, want)
+		}
+	}
+}
+
+// writeLooseObject stores contents, header included, as a loose object named by their hash.
+func writeLooseObject(t *testing.T, dir string, contents string) *Hash {
+	t.Helper()
+	hash, err := new(Hash).FromString(fmt.Sprintf("%x", sha1.Sum([]byte(contents))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(contents))
+	zw.Close()
+	path := filepath.Join(dir, "objects", hash.String()[:2], hash.String()[2:])
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, buf.Bytes(), 0444)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestLooseObjectHeaders(t *testing.T) {
+	dir := t.TempDir()
+	obj := NewObjectService(dir, nil)
+
+	for _, contents := range []string{
+		"blob 3",
+		"blob3\x00abc",
+		"blob x\x00abc",
+		"blob -3\x00abc",
+		"blob 5\x00abc",
+		"blob 2\x00abc",
+		" 3\x00abc",
+	} {
+		hash := writeLooseObject(t, dir, contents)
+		_, _, err := obj.ReadTypedObject(hash)
+		if !errors.Is(err, ErrCorruptObject) {
+			t.Errorf("reading %q: got error %v, want ErrCorruptObject", contents, err)
+		}
+	}
+
+	hash := writeLooseObject(t, dir, "blob 3\x00abc")
+	objType, data, err := obj.ReadTypedObject(hash)
+	if err != nil || objType != "blob" || string(data) != "abc" {
+		t.Errorf("reading a valid blob returned %q, %q, %v", objType, data, err)
+	}
+
+	hash = writeLooseObject(t, dir, "frob 3\x00abc")
+	objType, data, err = obj.ReadTypedObject(hash)
+	if !errors.Is(err, ErrUnknownObjectType) || objType != "frob" || string(data) != "abc" {
+		t.Errorf("reading an object of unknown type returned %q, %q, %v", objType, data, err)
+	}
+}
+
+func TestCatFileAllowUnknownType(t *testing.T) {
+	repo := newTestRepo(t)
+	hash := writeLooseObject(t, repo.CommonDir, "frob 3\x00abc")
+
+	if _, _, _, err := repo.CatFile(hash.String(), false); !errors.Is(err, ErrUnknownObjectType) {
+		t.Errorf("CatFile without allowUnknownType: got error %v, want ErrUnknownObjectType", err)
+	}
+	_, objType, data, err := repo.CatFile(hash.String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objType != "frob" || string(data) != "abc" {
+		t.Errorf("CatFile returned %q, %q, want \"frob\", \"abc\"", objType, data)
+	}
+}
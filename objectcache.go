@@ -0,0 +1,52 @@
+package mgi
+
+import "container/list"
+
+// objectCache keeps the contents of recently read objects, up to a number of bytes, dropping
+// the least recently used ones first.
+type objectCache struct {
+	max, size int64
+	order     *list.List               // of *cachedObject, most recently used first
+	objects   map[string]*list.Element // by hash
+}
+
+type cachedObject struct {
+	hash    string
+	objType string
+	data    []byte
+}
+
+func newObjectCache(max int64) *objectCache {
+	return &objectCache{max: max, order: list.New(), objects: map[string]*list.Element{}}
+}
+
+// get returns the type and contents of the object, if it is cached.
+func (c *objectCache) get(hash string) (string, []byte, bool) {
+	e, ok := c.objects[hash]
+	if !ok {
+		return "", nil, false
+	}
+	c.order.MoveToFront(e)
+	obj := e.Value.(*cachedObject)
+	return obj.objType, obj.data, true
+}
+
+// put adds the object to the cache, dropping the least recently used objects to make room for
+// it. Objects larger than the whole cache are not kept.
+func (c *objectCache) put(hash, objType string, data []byte) {
+	size := int64(len(data))
+	if size > c.max {
+		return
+	}
+	if _, ok := c.objects[hash]; ok {
+		return
+	}
+	for c.size+size > c.max {
+		last := c.order.Back()
+		obj := c.order.Remove(last).(*cachedObject)
+		delete(c.objects, obj.hash)
+		c.size -= int64(len(obj.data))
+	}
+	c.objects[hash] = c.order.PushFront(&cachedObject{hash: hash, objType: objType, data: data})
+	c.size += size
+}
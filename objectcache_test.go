@@ -0,0 +1,92 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestObjectCacheEviction(t *testing.T) {
+	c := newObjectCache(10)
+	c.put("a", "blob", []byte("aaaa"))
+	c.put("b", "blob", []byte("bbbb"))
+	// Reading a makes b the least recently used
+	if _, data, ok := c.get("a"); !ok || string(data) != "aaaa" {
+		t.Fatalf("get(a) = %q, %v", data, ok)
+	}
+	c.put("c", "blob", []byte("cccc"))
+	c.put("big", "blob", []byte("larger than the cache"))
+
+	var cached []string
+	for _, hash := range []string{"a", "b", "c", "big"} {
+		if _, _, ok := c.get(hash); ok {
+			cached = append(cached, hash)
+		}
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(cached, want) {
+		t.Errorf("cached objects %q, want %q", cached, want)
+	}
+	if c.size != 8 {
+		t.Errorf("cache size is %d bytes, want 8", c.size)
+	}
+}
+
+func TestObjectServiceCache(t *testing.T) {
+	dir := t.TempDir()
+	obj := NewObjectService(dir, nil)
+	obj.SetCacheSize(1 << 20)
+	hash, err := obj.StoreObject(&Blob{Data: []byte("cached\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = obj.ReadObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once read, the object comes from the cache
+	path := filepath.Join(dir, "objects", hash.String()[:2], hash.String()[2:])
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := obj.ReadObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "cached\n" {
+		t.Errorf("read %q from the cache, want %q", data, "cached\n")
+	}
+
+	obj.SetCacheSize(0)
+	if _, err := obj.ReadObject(hash); err == nil {
+		t.Error("read the object after disabling the cache")
+	}
+}
+
+func BenchmarkReadObject(b *testing.B) {
+	dir := b.TempDir()
+	obj := NewObjectService(dir, nil)
+	var hashes []*Hash
+	for i := 0; i < 100; i++ {
+		hash, err := obj.StoreObject(&Blob{Data: []byte(fmt.Sprintf("blob %d\n", i))})
+		if err != nil {
+			b.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	for _, size := range []int64{0, 1 << 20} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			obj.SetCacheSize(size)
+			for i := 0; i < b.N; i++ {
+				_, err := obj.ReadObject(hashes[i%len(hashes)])
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package mgi
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bertinatto/mgi/diff"
+)
+
+// Object types as stored in packfiles.
+const (
+	packObjCommit   = 1
+	packObjTree     = 2
+	packObjBlob     = 3
+	packObjTag      = 4
+	packObjOfsDelta = 6
+	packObjRefDelta = 7
+)
+
+var packTypeNames = map[int]string{
+	packObjCommit: "commit",
+	packObjTree:   "tree",
+	packObjBlob:   "blob",
+	packObjTag:    "tag",
+}
+
+// packFile is a packfile (e.g. "objects/pack/pack-xxx.pack") along with its version 2 index.
+type packFile struct {
+	path    string
+	hashes  []byte // sorted SHA-1s, 20 bytes each
+	offsets []uint64
+}
+
+// loadPackIndex reads the .idx file of a packfile.
+func loadPackIndex(idxPath string) (*packFile, error) {
+	data, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4+40 || !bytes.Equal(data[:4], []byte("\xfftOc")) {
+		return nil, fmt.Errorf("%s: not a pack index", idxPath)
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index version %d", idxPath, version)
+	}
+
+	fanout := data[8 : 8+256*4]
+	n := int(binary.BigEndian.Uint32(fanout[255*4:]))
+
+	hashesStart := 8 + 256*4
+	crcStart := hashesStart + n*20
+	offsetsStart := crcStart + n*4
+	largeStart := offsetsStart + n*4
+	if len(data) < largeStart+40 {
+		return nil, fmt.Errorf("%s: truncated pack index", idxPath)
+	}
+
+	p := &packFile{
+		path:    strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		hashes:  data[hashesStart:crcStart],
+		offsets: make([]uint64, n),
+	}
+	for i := 0; i < n; i++ {
+		off := binary.BigEndian.Uint32(data[offsetsStart+i*4:])
+		if off&0x80000000 == 0 {
+			p.offsets[i] = uint64(off)
+			continue
+		}
+		// The offset doesn't fit in 31 bits, so it is stored in the table of 8-byte offsets
+		large := largeStart + int(off&0x7fffffff)*8
+		if large+8 > len(data)-40 {
+			return nil, fmt.Errorf("%s: invalid large offset", idxPath)
+		}
+		p.offsets[i] = binary.BigEndian.Uint64(data[large:])
+	}
+	return p, nil
+}
+
+// find returns the offset of the object in the packfile.
+func (p *packFile) find(hash *Hash) (uint64, bool) {
+	sha := hash.Bytes()
+	n := len(p.offsets)
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(p.hashes[i*20:i*20+20], sha) >= 0
+	})
+	if i < n && bytes.Equal(p.hashes[i*20:i*20+20], sha) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// readAt reads the object stored at the given offset of the packfile, resolving deltas against
+// their bases in the same pack.
+func (p *packFile) readAt(offset uint64) (string, []byte, error) {
+	return p.readDeltaChain(offset, map[uint64]bool{})
+}
+
+// readDeltaChain is readAt for an object that is the base of the deltas at the offsets in seen,
+// which would be a cycle if the object were one of them.
+func (p *packFile) readDeltaChain(offset uint64, seen map[uint64]bool) (string, []byte, error) {
+	if seen[offset] {
+		return "", nil, fmt.Errorf("%w: %s: delta at offset %d is its own base", ErrCorruptObject, p.path, offset)
+	}
+	if len(seen) > maxDeltaDepth {
+		return "", nil, fmt.Errorf("%w: %s: chain of more than %d deltas at offset %d", ErrCorruptObject, p.path, maxDeltaDepth, offset)
+	}
+
+	f, err := os.Open(p.path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(int64(offset), io.SeekStart)
+	if err != nil {
+		return "", nil, err
+	}
+	r := bufio.NewReader(f)
+
+	objType, size, err := readPackObjectHeader(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", p.path, err)
+	}
+
+	var baseOffset uint64
+	switch objType {
+	case packObjOfsDelta:
+		distance, err := readOfsDeltaOffset(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %v", p.path, err)
+		}
+		if distance == 0 || distance > offset {
+			return "", nil, fmt.Errorf("%w: %s: invalid delta base offset at offset %d", ErrCorruptObject, p.path, offset)
+		}
+		baseOffset = offset - distance
+	case packObjRefDelta:
+		sha := make([]byte, 20)
+		_, err := io.ReadFull(r, sha)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %v", p.path, err)
+		}
+		var ok bool
+		baseOffset, ok = p.find(new(Hash).FromSHA1Bytes(sha))
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s: delta at offset %d has its base %x outside the pack", ErrCorruptObject, p.path, offset, sha)
+		}
+	default:
+		if _, ok := packTypeNames[objType]; !ok {
+			return "", nil, fmt.Errorf("%s: unknown object type %d at offset %d", p.path, objType, offset)
+		}
+	}
+
+	data, err := inflate(r, size)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w at offset %d of %s: %s", ErrCorruptObject, offset, p.path, describeZlibError(err))
+	}
+	if objType != packObjOfsDelta && objType != packObjRefDelta {
+		return packTypeNames[objType], data, nil
+	}
+	// The file is closed before reading the base, so long chains don't keep many files open
+	f.Close()
+
+	seen[offset] = true
+	baseType, base, err := p.readDeltaChain(baseOffset, seen)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err = diff.ApplyDelta(base, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w at offset %d of %s: %v", ErrCorruptObject, offset, p.path, err)
+	}
+	return baseType, data, nil
+}
+
+// readPackObjectHeader reads the variable-length type and size that precede every object in a pack.
+func readPackObjectHeader(r io.ByteReader) (int, uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	objType := int(b>>4) & 7
+	size := uint64(b & 0x0f)
+	shift := uint(4)
+	for b&0x80 != 0 {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		size |= uint64(b&0x7f) << shift
+		shift += 7
+	}
+	return objType, size, nil
+}
+
+// inflate decompresses a zlib stream that is expected to produce exactly size bytes.
+// It consumes the whole stream from r.
+func inflate(r io.Reader, size uint64) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(zr, data)
+	if err != nil {
+		return nil, err
+	}
+	// Read up to the end of the stream, so that its checksum is verified and, when reading a
+	// whole pack, the next object starts right after it
+	extra, err := io.Copy(ioutil.Discard, zr)
+	if err != nil {
+		return nil, err
+	}
+	if extra > 0 {
+		return nil, fmt.Errorf("object is larger than its declared size %d", size)
+	}
+	return data, nil
+}
+
+// loadPacks returns the packfiles of the object store. They are only read once.
+func (o *ObjectService) loadPacks() ([]*packFile, error) {
+	if o.packs != nil {
+		return o.packs, nil
+	}
+
+	idxFiles, err := filepath.Glob(filepath.Join(o.path, "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	packs := make([]*packFile, 0, len(idxFiles))
+	for _, idx := range idxFiles {
+		p, err := loadPackIndex(idx)
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, p)
+	}
+	o.packs = packs
+	return packs, nil
+}
+
+// readPackedObject looks for the object in all packfiles.
+func (o *ObjectService) readPackedObject(hash *Hash) (string, []byte, error) {
+	packs, err := o.loadPacks()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, p := range packs {
+		if offset, ok := p.find(hash); ok {
+			return p.readAt(offset)
+		}
+	}
+	return "", nil, fmt.Errorf("%w: %s", ErrObjectNotFound, hash)
+}
+
+// findPrefix calls fn with each hash in the packfile whose hexadecimal form starts with prefix.
+func (p *packFile) findPrefix(prefix string, fn func(hash *Hash)) {
+	lower := prefix
+	if len(lower)%2 == 1 {
+		lower += "0"
+	}
+	start, err := hex.DecodeString(lower)
+	if err != nil {
+		return
+	}
+
+	n := len(p.offsets)
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(p.hashes[i*20:i*20+20], start) >= 0
+	})
+	for ; i < n; i++ {
+		hash := new(Hash).FromSHA1Bytes(p.hashes[i*20 : i*20+20])
+		if !strings.HasPrefix(hash.String(), prefix) {
+			return
+		}
+		fn(hash)
+	}
+}
+
+// packedObjects calls fn with the hash of every object in the packfiles.
+func (o *ObjectService) packedObjects(fn func(hash *Hash) error) error {
+	packs, err := o.loadPacks()
+	if err != nil {
+		return err
+	}
+	for _, p := range packs {
+		for i := range p.offsets {
+			err = fn(new(Hash).FromSHA1Bytes(p.hashes[i*20 : i*20+20]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isPacked returns whether the object is present in any packfile.
+func (o *ObjectService) isPacked(hash *Hash) (bool, error) {
+	packs, err := o.loadPacks()
+	if err != nil {
+		return false, err
+	}
+	for _, p := range packs {
+		if _, ok := p.find(hash); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// looseObjects calls fn for every loose object, along with the path of the object file.
+func (o *ObjectService) looseObjects(fn func(hash *Hash, path string) error) error {
+	dirs, err := ioutil.ReadDir(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dirs {
+		if !d.IsDir() || len(d.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(o.path, d.Name()))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			hash, err := new(Hash).FromString(d.Name() + f.Name())
+			if err != nil || f.IsDir() {
+				continue
+			}
+			err = fn(hash, filepath.Join(o.path, d.Name(), f.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PrunePacked removes the loose objects that are also present in a packfile and returns them.
+// If dryRun is set, the objects are only reported.
+func (o *ObjectService) PrunePacked(dryRun bool) ([]*Hash, error) {
+	var pruned []*Hash
+	err := o.looseObjects(func(hash *Hash, path string) error {
+		packed, err := o.isPacked(hash)
+		if err != nil || !packed {
+			return err
+		}
+		pruned = append(pruned, hash)
+		if dryRun {
+			return nil
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		// Remove the fan-out directory if it's now empty, ignoring errors if it isn't
+		os.Remove(filepath.Dir(path))
+		return nil
+	})
+	return pruned, err
+}
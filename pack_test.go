@@ -0,0 +1,206 @@
+package mgi
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bertinatto/mgi/diff"
+)
+
+func TestReadPackedObjectsAndPrunePacked(t *testing.T) {
+	repo := newTestRepo(t)
+	head := commitTestFiles(t, repo, "first", map[string]string{"a": "1\n", "dir/b": "2\n"})
+	_, _, err := repo.Objects.Repack(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := repo.Objects.PrunePacked(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The commit, two trees and two blobs
+	if len(listed) != 5 {
+		t.Errorf("prune-packed -n listed %d objects, want 5", len(listed))
+	}
+	pruned, err := repo.Objects.PrunePacked(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != len(listed) {
+		t.Errorf("prune-packed removed %d objects, but -n listed %d", len(pruned), len(listed))
+	}
+	left, err := repo.Objects.PrunePacked(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("%d loose objects are left after prune-packed", len(left))
+	}
+
+	// Everything is read from the pack now
+	files, err := repo.commitFiles(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := repo.Objects.ReadObject(files["dir/b"].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blob) != "2\n" {
+		t.Errorf("dir/b = %q, want %q", blob, "2\n")
+	}
+}
+
+// testPackObject is an object to write to a pack by buildTestPack: a blob, or a delta against
+// the object at index ofsBase of the pack or against the object named refBase.
+type testPackObject struct {
+	data    []byte
+	ofsBase int
+	refBase string
+	delta   []byte
+}
+
+// buildTestPack returns a version 2 pack of the objects, with its checksum.
+func buildTestPack(t *testing.T, objects []testPackObject) []byte {
+	t.Helper()
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(len(objects)))
+
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = pack.Len()
+		data := o.data
+		switch {
+		case o.delta == nil:
+			pack.Write(packObjectHeader(packObjBlob, uint64(len(data))))
+		case o.refBase != "":
+			data = o.delta
+			pack.Write(packObjectHeader(packObjRefDelta, uint64(len(data))))
+			sha, err := hex.DecodeString(o.refBase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pack.Write(sha)
+		default:
+			data = o.delta
+			pack.Write(packObjectHeader(packObjOfsDelta, uint64(len(data))))
+			// Each continuation byte of the distance adds one, see readOfsDeltaOffset
+			distance := uint64(offsets[i] - offsets[o.ofsBase])
+			encoded := []byte{byte(distance & 0x7f)}
+			for distance >>= 7; distance > 0; distance >>= 7 {
+				distance--
+				encoded = append([]byte{byte(0x80 | distance&0x7f)}, encoded...)
+			}
+			pack.Write(encoded)
+		}
+		zw := zlib.NewWriter(&pack)
+		zw.Write(data)
+		zw.Close()
+	}
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+	return pack.Bytes()
+}
+
+func TestReadPackDeltas(t *testing.T) {
+	// Numbered lines don't compress well, so the distance to the base takes several bytes
+	var base []byte
+	for i := 0; i < 400; i++ {
+		base = append(base, fmt.Sprintf("line %d\n", i)...)
+	}
+	second := append(append([]byte(nil), base...), "one more line\n"...)
+	third := append([]byte("a first line\n"), second[1000:]...)
+	later := []byte(strings.Repeat("a base that comes after its delta\n", 50))
+	fromLater := append(append([]byte(nil), later...), "the end\n"...)
+	want := [][]byte{base, second, third, fromLater, later}
+
+	pack := buildTestPack(t, []testPackObject{
+		{data: base},
+		// A delta against a delta, by offset and by hash
+		{ofsBase: 0, delta: diff.MakeDelta(base, second)},
+		{refBase: blobHash(second), delta: diff.MakeDelta(second, third)},
+		// A delta against an object further in the pack
+		{refBase: blobHash(later), delta: diff.MakeDelta(later, fromLater)},
+		{data: later},
+	})
+
+	dir := t.TempDir()
+	obj := NewObjectService(dir, nil)
+	packDir := filepath.Join(dir, "objects", "pack")
+	err := os.MkdirAll(packDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(packDir, "pack-test.pack")
+	err = ioutil.WriteFile(path, pack, 0444)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = obj.IndexPack(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read from the pack on disk through its index
+	for i, data := range want {
+		hash, err := new(Hash).FromString(blobHash(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		objType, got, err := obj.ReadTypedObject(hash)
+		if err != nil {
+			t.Fatalf("object %d: %v", i, err)
+		}
+		if objType != "blob" || !bytes.Equal(got, data) {
+			t.Errorf("object %d read as a %s of %d bytes, want a blob of %d bytes", i, objType, len(got), len(data))
+		}
+	}
+
+	// Read from a stream, as in bundles and fetches
+	unpacked, skipped, err := NewObjectService(t.TempDir(), nil).UnpackObjects(bytes.NewReader(pack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unpacked != len(want) || skipped != 0 {
+		t.Errorf("unpacked %d objects and skipped %d, want %d and 0", unpacked, skipped, len(want))
+	}
+}
+
+func TestReadPackDeltaMissingBase(t *testing.T) {
+	base := []byte("not in the pack\n")
+	pack := buildTestPack(t, []testPackObject{
+		{refBase: blobHash(base), delta: diff.MakeDelta(base, []byte("not in the pack either\n"))},
+	})
+
+	_, err := readPackStream(bytes.NewReader(pack), nil, func(e *packEntry) error { return nil })
+	if !errors.Is(err, ErrCorruptObject) {
+		t.Errorf("reading a thin pack without its bases: got error %v, want ErrCorruptObject", err)
+	}
+
+	// Thin packs are read with the bases from the object store
+	obj := NewObjectService(t.TempDir(), nil)
+	_, err = obj.StoreObject(&Blob{Data: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpacked, _, err := obj.UnpackObjects(bytes.NewReader(pack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unpacked != 1 {
+		t.Errorf("unpacked %d objects, want 1", unpacked)
+	}
+}
@@ -0,0 +1,353 @@
+package mgi
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/bertinatto/mgi/diff"
+)
+
+// writePack writes a version 2 packfile containing the given objects to w. Objects are stored
+// whole, without deltas.
+func writePack(o ObjectStore, w io.Writer, hashes []*Hash) error {
+	sum := sha1.New()
+	out := io.MultiWriter(w, sum)
+
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(hashes)))
+	_, err := out.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hashes {
+		objType, data, err := o.ReadTypedObject(h)
+		if err != nil {
+			return err
+		}
+		code, ok := packTypeCodes[objType]
+		if !ok {
+			return fmt.Errorf("cannot pack object %s of type %q", h, objType)
+		}
+
+		_, err = out.Write(packObjectHeader(code, uint64(len(data))))
+		if err != nil {
+			return err
+		}
+		zw := zlib.NewWriter(out)
+		_, err = zw.Write(data)
+		if err != nil {
+			return err
+		}
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(sum.Sum(nil))
+	return err
+}
+
+// packTypeCodes maps object types to their code in packfiles.
+var packTypeCodes = map[string]int{
+	"commit": packObjCommit,
+	"tree":   packObjTree,
+	"blob":   packObjBlob,
+	"tag":    packObjTag,
+}
+
+// packObjectHeader encodes the type and size of an object, as read by readPackObjectHeader.
+func packObjectHeader(objType int, size uint64) []byte {
+	b := byte(objType<<4) | byte(size&0x0f)
+	size >>= 4
+	var header []byte
+	for size > 0 {
+		header = append(header, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(header, b)
+}
+
+// hashingReader computes the SHA-1 of everything read through it, and the CRC-32 of what was
+// read since crc was last reset. It reads byte by byte when asked to, so zlib doesn't consume
+// more than the compressed stream of each object.
+type hashingReader struct {
+	r   *bufio.Reader
+	sum hash.Hash
+	crc hash.Hash32
+	n   uint64 // number of bytes read
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.sum.Write(p[:n])
+	h.crc.Write(p[:n])
+	h.n += uint64(n)
+	return n, err
+}
+
+func (h *hashingReader) ReadByte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == nil {
+		h.sum.Write([]byte{b})
+		h.crc.Write([]byte{b})
+		h.n++
+	}
+	return b, err
+}
+
+// packEntry is an object read from a packfile by readPackStream.
+type packEntry struct {
+	hash    *Hash
+	objType string
+	data    []byte
+	// offset is where the object starts in the pack, and crc the CRC-32 of its compressed form
+	// in the pack, header included, as listed in pack indexes.
+	offset uint64
+	crc    uint32
+	depth  int // the length of the chain of deltas the object is stored as
+}
+
+// readPackStream reads a packfile from r, such as the one in a bundle, and calls fn with each
+// object. The checksum at the end of the pack is verified and returned.
+//
+// Deltas are resolved against their bases, so every object of the pack is kept in memory until
+// the end. Objects are passed to fn in the order of the pack, except for deltas whose base comes
+// later in it, which are passed right after their base. The bases of REF_DELTA objects that are
+// not in the pack, as in thin packs, are read with external, unless it is nil.
+func readPackStream(r io.Reader, external func(hash *Hash) (string, []byte, error), fn func(e *packEntry) error) ([]byte, error) {
+	hr := &hashingReader{r: bufio.NewReader(r), sum: sha1.New(), crc: crc32.NewIEEE()}
+
+	header := make([]byte, 12)
+	_, err := io.ReadFull(hr, header)
+	if err != nil {
+		return nil, fmt.Errorf("reading pack header: %v", err)
+	}
+	if !bytes.Equal(header[:4], []byte("PACK")) {
+		return nil, fmt.Errorf("not a packfile")
+	}
+	if version := binary.BigEndian.Uint32(header[4:]); version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+	count := binary.BigEndian.Uint32(header[8:])
+
+	// A delta waiting for its base, which is e.data until it is resolved
+	type pendingDelta struct {
+		e     *packEntry
+		delta []byte
+	}
+	starts := map[uint64]bool{} // the offsets of the objects read so far
+	byOffset := map[uint64]*packEntry{}
+	byHash := map[string]*packEntry{}
+	// The deltas waiting for their base, which is a delta itself for those waiting by offset
+	waiting := map[string][]*pendingDelta{}
+	waitingOffset := map[uint64][]*pendingDelta{}
+
+	// add records an object and resolves the deltas that were waiting for it
+	var add func(e *packEntry) error
+	resolve := func(d *pendingDelta, base *packEntry) error {
+		data, err := diff.ApplyDelta(base.data, d.delta)
+		if err != nil {
+			return fmt.Errorf("%w: delta at offset %d of the pack: %v", ErrCorruptObject, d.e.offset, err)
+		}
+		d.e.objType, d.e.data, d.e.depth = base.objType, data, base.depth+1
+		if d.e.depth > maxDeltaDepth {
+			return fmt.Errorf("%w: chain of more than %d deltas at offset %d of the pack", ErrCorruptObject, maxDeltaDepth, d.e.offset)
+		}
+		return add(d.e)
+	}
+	add = func(e *packEntry) error {
+		obj, err := (&rawObject{objType: e.objType, data: e.data}).Marshal()
+		if err != nil {
+			return err
+		}
+		e.hash = new(Hash).From(obj)
+		byOffset[e.offset] = e
+		byHash[e.hash.String()] = e
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+		deltas := append(waiting[e.hash.String()], waitingOffset[e.offset]...)
+		delete(waiting, e.hash.String())
+		delete(waitingOffset, e.offset)
+		for _, d := range deltas {
+			err := resolve(d, e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := uint32(0); i < count; i++ {
+		e := &packEntry{offset: hr.n}
+		starts[e.offset] = true
+		hr.crc.Reset()
+		code, size, err := readPackObjectHeader(hr)
+		if err != nil {
+			return nil, fmt.Errorf("reading object %d of the pack: %v", i, err)
+		}
+
+		var base *packEntry
+		var baseHash string
+		var baseOffset uint64
+		switch code {
+		case packObjOfsDelta:
+			distance, err := readOfsDeltaOffset(hr)
+			if err != nil {
+				return nil, fmt.Errorf("reading object %d of the pack: %v", i, err)
+			}
+			if distance == 0 || distance > e.offset || !starts[e.offset-distance] {
+				return nil, fmt.Errorf("%w: object %d of the pack has an invalid delta base offset", ErrCorruptObject, i)
+			}
+			baseOffset = e.offset - distance
+			base = byOffset[baseOffset]
+		case packObjRefDelta:
+			sha := make([]byte, 20)
+			_, err := io.ReadFull(hr, sha)
+			if err != nil {
+				return nil, fmt.Errorf("reading object %d of the pack: %v", i, err)
+			}
+			baseHash = hex.EncodeToString(sha)
+			base = byHash[baseHash]
+		default:
+			objType, ok := packTypeNames[code]
+			if !ok {
+				return nil, fmt.Errorf("unknown object type %d in pack", code)
+			}
+			e.objType = objType
+		}
+
+		data, err := inflate(hr, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: object %d of the pack: %s", ErrCorruptObject, i, describeZlibError(err))
+		}
+		e.crc = hr.crc.Sum32()
+		switch {
+		case e.objType != "":
+			e.data = data
+			err = add(e)
+		case base != nil:
+			err = resolve(&pendingDelta{e: e, delta: data}, base)
+		case code == packObjOfsDelta:
+			// The base is itself a delta whose base isn't known yet
+			waitingOffset[baseOffset] = append(waitingOffset[baseOffset], &pendingDelta{e: e, delta: data})
+		default:
+			waiting[baseHash] = append(waiting[baseHash], &pendingDelta{e: e, delta: data})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	expected := hr.sum.Sum(nil)
+	trailer := make([]byte, sha1.Size)
+	_, err = io.ReadFull(hr.r, trailer)
+	if err != nil {
+		return nil, fmt.Errorf("reading pack checksum: %v", err)
+	}
+	if !bytes.Equal(trailer, expected) {
+		return nil, fmt.Errorf("%w: pack checksum mismatch", ErrCorruptObject)
+	}
+
+	// What is still waiting has its base outside the pack, or is part of a cycle of deltas
+	missing := make([]string, 0, len(waiting))
+	for baseHash := range waiting {
+		missing = append(missing, baseHash)
+	}
+	sort.Strings(missing)
+	for _, baseHash := range missing {
+		deltas, ok := waiting[baseHash]
+		if !ok {
+			continue
+		}
+		if external == nil {
+			return nil, fmt.Errorf("%w: delta base %s is not in the pack", ErrCorruptObject, baseHash)
+		}
+		hash, err := new(Hash).FromString(baseHash)
+		if err != nil {
+			return nil, err
+		}
+		objType, data, err := external(hash)
+		if err != nil {
+			return nil, fmt.Errorf("reading delta base %s: %w", baseHash, err)
+		}
+		delete(waiting, baseHash)
+		base := &packEntry{hash: hash, objType: objType, data: data}
+		for _, d := range deltas {
+			err := resolve(d, base)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(waitingOffset) > 0 {
+		return nil, fmt.Errorf("%w: %d deltas of the pack could not be resolved", ErrCorruptObject, len(waitingOffset))
+	}
+	return trailer, nil
+}
+
+// writePackIndex writes the version 2 index of a pack with the given objects and checksum to w.
+// It is the format read by loadPackIndex.
+func writePackIndex(w io.Writer, entries []*packEntry, packSum []byte) error {
+	entries = append([]*packEntry(nil), entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].hash.Bytes(), entries[j].hash.Bytes()) < 0
+	})
+
+	sum := sha1.New()
+	b := bufio.NewWriter(io.MultiWriter(w, sum))
+	b.WriteString("\xfftOc")
+	binary.Write(b, binary.BigEndian, uint32(2))
+
+	var fanout [256]uint32
+	for _, e := range entries {
+		fanout[e.hash.Bytes()[0]]++
+	}
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	binary.Write(b, binary.BigEndian, fanout)
+
+	for _, e := range entries {
+		b.Write(e.hash.Bytes())
+	}
+	for _, e := range entries {
+		binary.Write(b, binary.BigEndian, e.crc)
+	}
+	// Offsets that don't fit in 31 bits go to a table of 8-byte offsets
+	var large []uint64
+	for _, e := range entries {
+		if e.offset < 0x80000000 {
+			binary.Write(b, binary.BigEndian, uint32(e.offset))
+			continue
+		}
+		binary.Write(b, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, e.offset)
+	}
+	for _, offset := range large {
+		binary.Write(b, binary.BigEndian, offset)
+	}
+	b.Write(packSum)
+
+	err := b.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(sum.Sum(nil))
+	return err
+}
@@ -0,0 +1,204 @@
+// Package pathspec matches the paths given on the command line against the paths of a
+// repository, following git's pathspec rules.
+//
+// A pathspec is either a path, which matches the file itself and everything under it if it is a
+// directory, or a glob pattern. A trailing slash only matches the contents of a directory.
+// Wildcards match across slashes, like in git, and "**/" matches any number of directories,
+// including none. The ":/" prefix makes a pathspec relative to the root of the repository, and
+// the ":!" (or ":^") prefix excludes the paths it matches.
+package pathspec
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Pattern is a single compiled pathspec.
+type Pattern struct {
+	// Original is the pathspec as given by the user.
+	Original string
+
+	path    string
+	dirOnly bool
+	exclude bool
+	glob    *regexp.Regexp
+}
+
+// Pathspec is a set of patterns. A path matches if it matches any of the patterns that include
+// paths and none of the ones that exclude them.
+type Pathspec struct {
+	Patterns []*Pattern
+}
+
+// Compile parses the pathspecs. Paths are relative to the root of the repository.
+func Compile(specs []string) (*Pathspec, error) {
+	ps := &Pathspec{Patterns: make([]*Pattern, 0, len(specs))}
+	for _, spec := range specs {
+		p, err := CompilePattern(spec)
+		if err != nil {
+			return nil, err
+		}
+		ps.Patterns = append(ps.Patterns, p)
+	}
+	return ps, nil
+}
+
+// CompilePattern parses a single pathspec.
+func CompilePattern(spec string) (*Pattern, error) {
+	p := &Pattern{Original: spec}
+
+	s := spec
+	if strings.HasPrefix(s, ":!") || strings.HasPrefix(s, ":^") {
+		p.exclude = true
+		s = s[2:]
+	}
+	// The current directory is always the root, so ":/" makes no difference
+	s = strings.TrimPrefix(s, ":/")
+	if strings.HasPrefix(s, ":") {
+		return nil, fmt.Errorf("unsupported pathspec magic in %q", spec)
+	}
+
+	p.dirOnly = strings.HasSuffix(s, "/") && strings.Trim(s, "/") != ""
+	s = strings.TrimPrefix(path.Clean("/"+s), "/")
+	if s == "" {
+		s = "."
+	}
+	p.path = s
+
+	if IsGlob(s) {
+		re, err := globRegexp(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pathspec %q: %v", spec, err)
+		}
+		p.glob = re
+	}
+	return p, nil
+}
+
+// Match reports whether the path matches the pathspec.
+func (ps *Pathspec) Match(path string) bool {
+	included, hasIncludes := false, false
+	for _, p := range ps.Patterns {
+		if p.exclude {
+			if p.Match(path) {
+				return false
+			}
+			continue
+		}
+		hasIncludes = true
+		if !included && p.Match(path) {
+			included = true
+		}
+	}
+	// Like git, a pathspec with only exclusions starts from every path
+	return included || !hasIncludes
+}
+
+// Match reports whether the path matches the pattern, regardless of whether it excludes it.
+func (p *Pattern) Match(name string) bool {
+	if p.glob != nil {
+		return p.glob.MatchString(name)
+	}
+	if p.path == "." {
+		return true
+	}
+	if name == p.path {
+		return !p.dirOnly
+	}
+	return strings.HasPrefix(name, p.path+"/")
+}
+
+// Path returns the cleaned path of the pattern, e.g. "dir" for "./dir/".
+func (p *Pattern) Path() string {
+	return p.path
+}
+
+// IsGlob returns whether the pattern contains wildcards.
+func (p *Pattern) IsGlob() bool {
+	return p.glob != nil
+}
+
+// Roots returns the paths a walk of the repository must cover to find every path that matches:
+// the paths of the patterns that include paths, cut at the directory of their first wildcard.
+// It returns "." if the whole repository must be walked.
+func (ps *Pathspec) Roots() []string {
+	var roots []string
+	for _, p := range ps.Patterns {
+		if p.exclude {
+			continue
+		}
+		root := p.path
+		if i := strings.IndexAny(root, "*?["); i >= 0 {
+			root = path.Dir(root[:i+1])
+		}
+		if root == "." {
+			return []string{"."}
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		return []string{"."}
+	}
+	return roots
+}
+
+// IsExclude returns whether the pattern excludes the paths it matches.
+func (p *Pattern) IsExclude() bool {
+	return p.exclude
+}
+
+// IsGlob returns whether the pathspec contains wildcards.
+func IsGlob(spec string) bool {
+	return strings.ContainsAny(spec, "*?[")
+}
+
+// globRegexp translates a glob pattern into an anchored regular expression.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/') {
+				// Any number of leading directories, including none
+				re.WriteString("(.*/)?")
+				i += 2
+				continue
+			}
+			re.WriteString(".*")
+			for i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+			}
+		case '?':
+			re.WriteString(".")
+		case '[':
+			// Like in fnmatch, a ']' right after the '[' or "[!" is part of the class
+			start := i + 1
+			negate := start < len(pattern) && (pattern[start] == '!' || pattern[start] == '^')
+			if negate {
+				start++
+			}
+			end := -1
+			if start < len(pattern) {
+				end = strings.IndexByte(pattern[start+1:], ']')
+			}
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			end += start + 1
+			class := strings.NewReplacer(`\`, `\\`, "]", `\]`).Replace(pattern[start:end])
+			if negate {
+				class = "^" + class
+			}
+			re.WriteString("[" + class + "]")
+			i = end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
@@ -0,0 +1,74 @@
+package pathspec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		specs []string
+		path  string
+		want  bool
+	}{
+		{[]string{"dir"}, "dir", true},
+		{[]string{"dir"}, "dir/a", true},
+		{[]string{"dir"}, "dirt", false},
+		{[]string{"./dir/"}, "dir/a", true},
+		{[]string{"dir/"}, "dir", false},
+		{[]string{"*.go"}, "main.go", true},
+		{[]string{"*.go"}, "cmd/main.go", true},
+		{[]string{"*.go"}, "main.c", false},
+		{[]string{"**/x"}, "x", true},
+		{[]string{"**/x"}, "a/b/x", true},
+		{[]string{":/dir"}, "dir/a", true},
+		{[]string{"dir", ":!dir/b"}, "dir/a", true},
+		{[]string{"dir", ":!dir/b"}, "dir/b", false},
+		{[]string{"dir", ":^dir/b"}, "dir/b/c", false},
+		// Both wildcards match slashes
+		{[]string{"a?b"}, "a/b", true},
+		{[]string{"a?b"}, "axb", true},
+		{[]string{"a?b"}, "ab", false},
+		{[]string{"[ab]"}, "b", true},
+		{[]string{"[!ab]"}, "b", false},
+		{[]string{"[!ab]"}, "c", true},
+		// A ']' right after '[' or "[!" is part of the class
+		{[]string{"[]a]"}, "]", true},
+		{[]string{"[]a]"}, "a", true},
+		{[]string{"[!]]x"}, "]x", false},
+		{[]string{"[!]]x"}, "ax", true},
+		{[]string{"[[]"}, "[", true},
+		// An unterminated class is taken literally
+		{[]string{"x[]"}, "x[]", true},
+		{[]string{"x["}, "x[", true},
+	}
+	for _, tt := range tests {
+		ps, err := Compile(tt.specs)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.specs, err)
+		}
+		if got := ps.Match(tt.path); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.specs, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRoots(t *testing.T) {
+	tests := []struct {
+		specs []string
+		want  []string
+	}{
+		{[]string{"a/b", "c"}, []string{"a/b", "c"}},
+		{[]string{"src/*.go"}, []string{"src"}},
+		{[]string{"*.go"}, []string{"."}},
+	}
+	for _, tt := range tests {
+		ps, err := Compile(tt.specs)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.specs, err)
+		}
+		if got := ps.Roots(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("roots of %q = %q, want %q", tt.specs, got, tt.want)
+		}
+	}
+}
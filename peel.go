@@ -0,0 +1,50 @@
+package mgi
+
+import "fmt"
+
+// PeelToCommit follows annotated tags from an object until it reaches a commit.
+func (o *ObjectService) PeelToCommit(hash *Hash) (*Hash, error) {
+	return peel(o, hash, "commit")
+}
+
+// PeelToTree follows annotated tags and commits from an object until it reaches a tree.
+func (o *ObjectService) PeelToTree(hash *Hash) (*Hash, error) {
+	return peel(o, hash, "tree")
+}
+
+// peel follows the links from an object (a tag to its object, a commit to its tree) until it
+// reaches an object of the given type. An empty type stops at the first object that isn't a tag.
+func peel(o ObjectStore, hash *Hash, objType string) (*Hash, error) {
+	for {
+		t, data, err := o.ReadTypedObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		if t == objType || (objType == "" && t != "tag") {
+			return hash, nil
+		}
+
+		var next string
+		switch {
+		case t == "tag":
+			tag, err := ParseTag(data)
+			if err != nil {
+				return nil, err
+			}
+			next = tag.Object
+		case t == "commit" && objType == "tree":
+			c, err := ParseCommit(data)
+			if err != nil {
+				return nil, err
+			}
+			next = c.Tree
+		default:
+			return nil, fmt.Errorf("object %s is a %s and cannot be peeled to a %s", hash, t, objType)
+		}
+
+		hash, err = new(Hash).FromString(next)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package mgi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrettyFormat is how FormatLogEntry describes commits, like git's --pretty option. It is
+// either one of the presets or a custom format with placeholders, which the presets are
+// written in too.
+type PrettyFormat struct {
+	preset string // "oneline", "short", "medium" or "full"; empty for a custom format
+	format string // for custom formats
+	// Date is how %ad and %cd write dates; DateDefault if empty.
+	Date DateFormat
+	// AbbrevCommit makes the presets show abbreviated commit hashes, like git's --abbrev-commit.
+	AbbrevCommit bool
+}
+
+// ParsePrettyFormat parses the value of --pretty: one of the presets "oneline" (the full hash
+// and subject), "short", "medium" and "full", or "format:<format>", "tformat:<format>" or just
+// "<format>" if it has placeholders. The placeholders are:
+//   - %H and %h: the hash of the commit, full and abbreviated;
+//   - %P and %p: the hashes of the parents, full and abbreviated;
+//   - %an, %ae and %ad: the name, email and date of the author, the date as set by Date;
+//   - %cn, %ce and %cd: the name, email and date of the committer;
+//   - %s: the subject, i.e. the first paragraph of the message joined into a single line;
+//   - %b: the body, i.e. the rest of the message; %B: the whole message;
+//   - %N: the note attached to the commit;
+//   - %n: a newline; %%: a "%";
+//   - %w(0,<indent1>,<indent2>): indents what follows it, the first line by indent1 spaces
+//     and the others by indent2. Lines are not wrapped.
+func ParsePrettyFormat(s string) (*PrettyFormat, error) {
+	switch {
+	case s == "oneline" || s == "short" || s == "medium" || s == "full":
+		return &PrettyFormat{preset: s}, nil
+	case strings.HasPrefix(s, "format:"):
+		return &PrettyFormat{format: strings.TrimPrefix(s, "format:")}, nil
+	case strings.HasPrefix(s, "tformat:"):
+		return &PrettyFormat{format: strings.TrimPrefix(s, "tformat:")}, nil
+	case strings.Contains(s, "%"):
+		return &PrettyFormat{format: s}, nil
+	}
+	return nil, fmt.Errorf("invalid --pretty format: %s", s)
+}
+
+// Separated returns whether the descriptions of commits are separated by a blank line, which
+// is the case for the multi-line presets.
+func (f *PrettyFormat) Separated() bool {
+	return f.preset != "" && f.preset != "oneline"
+}
+
+// SeparatesDiff returns whether a blank line separates the description of a commit from its
+// diff, which is the case for every format but oneline.
+func (f *PrettyFormat) SeparatesDiff() bool {
+	return f.preset != "oneline"
+}
+
+// template returns the custom format the commit is described with.
+func (f *PrettyFormat) template(e *LogEntry) string {
+	if f.preset == "" {
+		return f.format
+	}
+	hash := "%H"
+	if f.AbbrevCommit {
+		hash = "%h"
+	}
+	if f.preset == "oneline" {
+		return hash + " %s"
+	}
+
+	t := "commit " + hash + "%n"
+	if len(e.Commit.Parents) > 1 {
+		t += "Merge: %p%n"
+	}
+	t += "Author: %an <%ae>%n"
+	switch f.preset {
+	case "short":
+		return t + "%n%w(0,4,4)%s"
+	case "full":
+		t += "Commit: %cn <%ce>%n"
+	default:
+		t += "Date:   %ad%n"
+	}
+	t += "%n%w(0,4,4)%B"
+	if e.Note != "" {
+		t += "%n%w(0,0,0)%nNotes:%n%w(0,4,4)%N"
+	}
+	return t
+}
+
+// FormatLogEntry returns the lines describing a commit in the given format.
+func (m *MGIService) FormatLogEntry(e *LogEntry, f *PrettyFormat) []string {
+	text := m.expandFormat(f.template(e), f, e)
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// expandFormat replaces the placeholders of a custom format with what they stand for. Unknown
+// placeholders are kept as they are, like in git.
+func (m *MGIService) expandFormat(format string, f *PrettyFormat, e *LogEntry) string {
+	c := e.Commit
+	committer, committerEmail, commitTime := c.Committer, c.CommitterEmail, c.CommitTime
+	if committer == "" {
+		committer, committerEmail, commitTime = c.Author, c.AuthorEmail, c.AuthorTime
+	}
+	message := strings.TrimRight(c.Message, "\n")
+	now := time.Now()
+
+	out := new(strings.Builder)
+	// Text is collected in line and indented when the indentation changes or at the end
+	line := new(strings.Builder)
+	indent1, indent2, first := 0, 0, true
+	flush := func() {
+		lines := strings.Split(line.String(), "\n")
+		for i, l := range lines {
+			n := indent2
+			if first {
+				n = indent1
+			}
+			if i < len(lines)-1 || l != "" {
+				out.WriteString(strings.Repeat(" ", n) + l)
+			}
+			if i < len(lines)-1 {
+				out.WriteString("\n")
+				first = false
+			}
+		}
+		line.Reset()
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			line.WriteByte(format[i])
+			continue
+		}
+		rest := format[i+1:]
+		var value string
+		n := 1 // length of the placeholder after the "%"
+		switch {
+		case strings.HasPrefix(rest, "w("):
+			end := strings.IndexByte(rest, ')')
+			var args []string
+			if end >= 0 {
+				args = strings.Split(rest[2:end], ",")
+			}
+			if len(args) != 3 {
+				line.WriteByte('%')
+				continue
+			}
+			flush()
+			indent1, _ = strconv.Atoi(strings.TrimSpace(args[1]))
+			indent2, _ = strconv.Atoi(strings.TrimSpace(args[2]))
+			first = out.Len() == 0 || strings.HasSuffix(out.String(), "\n")
+			i += end + 1
+			continue
+		case rest[0] == 'H':
+			value = e.Hash
+		case rest[0] == 'h':
+			value = m.abbrev(e.Hash)
+		case rest[0] == 'P':
+			value = strings.Join(c.Parents, " ")
+		case rest[0] == 'p':
+			parents := make([]string, len(c.Parents))
+			for i, p := range c.Parents {
+				parents[i] = m.abbrev(p)
+			}
+			value = strings.Join(parents, " ")
+		case strings.HasPrefix(rest, "an"):
+			value, n = c.Author, 2
+		case strings.HasPrefix(rest, "ae"):
+			value, n = c.AuthorEmail, 2
+		case strings.HasPrefix(rest, "ad"):
+			value, n = formatDate(c.AuthorTime, f.Date, now), 2
+		case strings.HasPrefix(rest, "cn"):
+			value, n = committer, 2
+		case strings.HasPrefix(rest, "ce"):
+			value, n = committerEmail, 2
+		case strings.HasPrefix(rest, "cd"):
+			value, n = formatDate(commitTime, f.Date, now), 2
+		case rest[0] == 's':
+			value = mailSubject(message)
+		case rest[0] == 'b':
+			if body := mailBody(message); body != "" {
+				value = body + "\n"
+			}
+		case rest[0] == 'B':
+			value = message
+		case rest[0] == 'N':
+			value = e.Note
+		case rest[0] == 'n':
+			value = "\n"
+		case rest[0] == '%':
+			value = "%"
+		default:
+			line.WriteByte('%')
+			continue
+		}
+		line.WriteString(value)
+		i += n
+	}
+	flush()
+	return out.String()
+}
@@ -0,0 +1,208 @@
+package mgi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultPruneExpire is how old unreachable objects must be for Prune to remove them unless
+// told otherwise.
+const DefaultPruneExpire = "2.weeks.ago"
+
+// Prune removes the loose objects that are unreachable and whose files were last modified before
+// expire (e.g. "2.weeks.ago", or "now" for every unreachable object), and returns them. Recent
+// objects are kept, since they may belong to a command that is still running. If dryRun is set,
+// the objects are only reported.
+func (m *MGIService) Prune(expire string, dryRun bool) ([]*Hash, error) {
+	cutoff, err := parseDate(expire, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry date %q: %v", expire, err)
+	}
+	objects, err := m.diskObjects()
+	if err != nil {
+		return nil, err
+	}
+	keep, err := m.reachableSet()
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []*Hash
+	err = objects.Iterate(func(hash string) error {
+		if keep[hash] {
+			return nil
+		}
+		path := objects.loosePath(hash)
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !fi.ModTime().Before(cutoff) {
+			return nil
+		}
+
+		h, err := new(Hash).FromString(hash)
+		if err != nil {
+			return err
+		}
+		pruned = append(pruned, h)
+		if dryRun {
+			return nil
+		}
+		m.logger.Debugf("pruning %s", hash)
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		// Remove the fan-out directory if it's now empty, ignoring errors if it isn't
+		os.Remove(filepath.Dir(path))
+		return nil
+	})
+	return pruned, err
+}
+
+// GC expires the reflog entries older than reflogExpire, then prunes the unreachable loose
+// objects older than pruneExpire. The commits that the remaining reflog entries point to are
+// kept, so that they can still be recovered, e.g. after a reset. It returns the pruned objects.
+func (m *MGIService) GC(reflogExpire, pruneExpire string) ([]*Hash, error) {
+	_, err := m.ReflogExpire(nil, true, reflogExpire)
+	if err != nil {
+		return nil, err
+	}
+	return m.Prune(pruneExpire, false)
+}
+
+// DefaultGCAuto is how many loose objects there may be before GCAuto collects garbage, unless
+// told otherwise, as in git.
+const DefaultGCAuto = 6700
+
+// errEnoughObjects stops counting loose objects once there are more than needed.
+var errEnoughObjects = errors.New("enough objects")
+
+// GCAuto runs GC and then packs the remaining loose objects, removing them, but only if there
+// are more than limit loose objects, like git gc --auto. Counting stops as soon as the limit is
+// exceeded, so it's cheap to call after every command. A limit of 0 or less never collects
+// garbage. It returns whether it did.
+func (m *MGIService) GCAuto(limit int64, reflogExpire, pruneExpire string) (bool, error) {
+	if limit <= 0 {
+		return false, nil
+	}
+	objects, err := m.diskObjects()
+	if err != nil {
+		return false, err
+	}
+	var count int64
+	err = objects.Iterate(func(hash string) error {
+		count++
+		if count > limit {
+			return errEnoughObjects
+		}
+		return nil
+	})
+	if err == nil {
+		m.logger.Debugf("%d loose objects, not more than %d", count, limit)
+		return false, nil
+	}
+	if err != errEnoughObjects {
+		return false, err
+	}
+
+	m.logger.Infof("Auto packing the repository for optimum performance.")
+	_, err = m.GC(reflogExpire, pruneExpire)
+	if err != nil {
+		return false, err
+	}
+	_, _, err = objects.Repack(true)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// reachableSet returns the hashes of the objects that must be kept: everything reachable from
+// the refs, the entries of their reflogs and the HEAD of each worktree, and the blobs in their
+// indexes. Reflog entries pointing to objects that are already gone are skipped.
+func (m *MGIService) reachableSet() (map[string]bool, error) {
+	refs, err := m.listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	for _, r := range refs {
+		roots = append(roots, r.Hash)
+	}
+
+	values, err := m.reflogValues()
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range values {
+		h, err := new(Hash).FromString(hash)
+		if err != nil {
+			return nil, err
+		}
+		exists, err := m.obj.Exists(h)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			roots = append(roots, hash)
+		}
+	}
+
+	worktrees, err := m.worktreeServices()
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool)
+	for _, wt := range worktrees {
+		head, err := wt.currentHead()
+		if err != nil {
+			return nil, err
+		}
+		if head != "" {
+			roots = append(roots, head)
+		}
+
+		index, err := wt.index.Read()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range index.Entries {
+			keep[e.Hash.String()] = true
+		}
+	}
+
+	objects, err := m.reachableObjects(roots)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range objects {
+		keep[h.String()] = true
+	}
+	return keep, nil
+}
+
+// worktreeServices returns a service for the repository directory of each worktree, starting
+// with the main one, so that their HEAD and index can be read.
+func (m *MGIService) worktreeServices() ([]*MGIService, error) {
+	gitDirs := []string{m.common}
+	worktrees, err := ioutil.ReadDir(filepath.Join(m.common, "worktrees"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, wt := range worktrees {
+		gitDirs = append(gitDirs, filepath.Join(m.common, "worktrees", wt.Name()))
+	}
+
+	services := make([]*MGIService, 0, len(gitDirs))
+	for _, dir := range gitDirs {
+		services = append(services, NewMGIService(dir, m.obj, NewIndexService(dir, m.logger), m.logger))
+	}
+	return services, nil
+}
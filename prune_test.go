@@ -0,0 +1,53 @@
+package mgi
+
+import "testing"
+
+func TestGCKeepsReflogCommits(t *testing.T) {
+	repo := newTestRepo(t)
+	first := commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	second := commitTestFiles(t, repo, "second", map[string]string{"b": "2\n"})
+	unreachable, err := repo.Objects.StoreObject(&Blob{Data: []byte("unreachable\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.ResetTo(first, ResetHard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(hash string) bool {
+		t.Helper()
+		ok, err := repo.hasObject(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	// The commit reset away can still be recovered through the reflog
+	pruned, err := repo.GC("never", "now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0].String() != unreachable.String() {
+		t.Errorf("GC pruned %v, want only %s", pruned, unreachable)
+	}
+	if !exists(second) {
+		t.Fatalf("GC pruned %s, which is in the reflog", second)
+	}
+
+	// Once the reflog entries expire, it's pruned along with its tree and blob
+	pruned, err = repo.GC("now", "now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 3 {
+		t.Errorf("GC pruned %v, want the second commit, its tree and its blob", pruned)
+	}
+	if exists(second) {
+		t.Errorf("GC kept %s after its reflog entries expired", second)
+	}
+	if !exists(first) {
+		t.Errorf("GC pruned %s, which HEAD points to", first)
+	}
+}
@@ -0,0 +1,126 @@
+package mgi
+
+import (
+	"strings"
+)
+
+// RebaseStep is a line of the todo list of a rebase: what to do with a commit.
+type RebaseStep struct {
+	// Action is "pick" to apply the commit, "squash" to combine it with the previous one, or
+	// "fixup" to do the same but dropping its message.
+	Action  string
+	Hash    string
+	Subject string
+}
+
+// RebaseTodo returns the todo list of a rebase of the current branch onto upstream: the commits
+// reachable from HEAD but not from upstream, oldest first, all picked. Merges are left out, as
+// they would be linearized.
+//
+// With autosquash, the commits made with commit --fixup or --squash are moved right after the
+// commit they fix, in the order they were made, and marked to be combined with it, like git
+// rebase --autosquash. A "fixup! " or "squash! " commit fixes the earlier commit whose subject
+// is the rest of its own subject, after any more prefixes, or else the commit it names by hash,
+// or else the earlier commit whose subject starts with it. Commits that fix nothing in the list
+// are picked where they are.
+func (m *MGIService) RebaseTodo(upstream string, autosquash bool) ([]*RebaseStep, error) {
+	hashes, err := m.RevList([]string{upstream + "..HEAD"}, -1)
+	if err != nil {
+		return nil, err
+	}
+	var steps []*RebaseStep
+	for i := len(hashes) - 1; i >= 0; i-- {
+		c, err := m.readCommit(hashes[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Parents) > 1 {
+			continue
+		}
+		steps = append(steps, &RebaseStep{Action: "pick", Hash: hashes[i], Subject: subject(c.Message)})
+	}
+	if autosquash {
+		steps = m.autosquash(steps)
+	}
+	return steps, nil
+}
+
+// autosquash reorders the steps of a rebase for RebaseTodo. Like git, it chains each fixup after
+// the last step that goes with the commit it fixes, which may be another fixup.
+func (m *MGIService) autosquash(steps []*RebaseStep) []*RebaseStep {
+	bySubject := make(map[string]int) // the first step with each subject that isn't moved
+	byHash := make(map[string]int, len(steps))
+	// next is the step that goes right after each one, and tail the last step of its chain
+	next := make([]int, len(steps))
+	tail := make([]int, len(steps))
+	for i := range steps {
+		next[i], tail[i] = -1, -1
+	}
+
+	for i, s := range steps {
+		target := -1
+		action := ""
+		switch {
+		case strings.HasPrefix(s.Subject, "fixup! "):
+			action = "fixup"
+		case strings.HasPrefix(s.Subject, "squash! "):
+			action = "squash"
+		}
+		if action != "" {
+			rest := s.Subject
+			for strings.HasPrefix(rest, "fixup! ") || strings.HasPrefix(rest, "squash! ") {
+				rest = rest[strings.IndexByte(rest, ' ')+1:]
+			}
+			if j, ok := bySubject[rest]; ok {
+				target = j
+			} else if j, ok := m.stepByName(byHash, rest); ok {
+				target = j
+			} else {
+				for j := 0; j < i; j++ {
+					if strings.HasPrefix(steps[j].Subject, rest) {
+						target = j
+						break
+					}
+				}
+			}
+		}
+
+		if target >= 0 {
+			s.Action = action
+			after := target
+			if tail[target] >= 0 {
+				after = tail[target]
+			}
+			next[i], next[after] = next[after], i
+			tail[target] = i
+		} else if _, ok := bySubject[s.Subject]; !ok {
+			bySubject[s.Subject] = i
+		}
+		byHash[s.Hash] = i
+	}
+
+	todo := make([]*RebaseStep, 0, len(steps))
+	for i, s := range steps {
+		if s.Action != "pick" {
+			continue
+		}
+		for j := i; j >= 0; j = next[j] {
+			todo = append(todo, steps[j])
+		}
+	}
+	return todo
+}
+
+// stepByName returns the step, among the ones in byHash, of the commit named by rev, which
+// must not have spaces.
+func (m *MGIService) stepByName(byHash map[string]int, rev string) (int, bool) {
+	if strings.Contains(rev, " ") {
+		return 0, false
+	}
+	hash, err := m.resolveCommit(rev)
+	if err != nil {
+		return 0, false
+	}
+	i, ok := byHash[hash]
+	return i, ok
+}
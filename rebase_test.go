@@ -0,0 +1,97 @@
+package mgi
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRebaseTodoAutosquash(t *testing.T) {
+	repo := newTestRepo(t)
+	upstream := commitTestFiles(t, repo, "Base", map[string]string{"base": "base\n"})
+	commits := map[string]string{}
+	for i, msg := range []string{
+		"Add a",
+		"Add b",
+		"fixup! Add a",
+		"squash! %s", // names Add b by hash
+		"fixup! fixup! Add a",
+		"Add c",
+		"fixup! Add", // the first commit whose subject starts with it
+		"fixup! Nothing matches",
+	} {
+		if msg == "squash! %s" {
+			msg = fmt.Sprintf(msg, commits["Add b"])
+		}
+		commits[msg] = commitTestFiles(t, repo, msg, map[string]string{"file": fmt.Sprintf("%d\n", i)})
+	}
+
+	for _, tt := range []struct {
+		autosquash bool
+		want       []string
+	}{
+		{false, []string{
+			"pick Add a",
+			"pick Add b",
+			"pick fixup! Add a",
+			"pick squash! " + commits["Add b"],
+			"pick fixup! fixup! Add a",
+			"pick Add c",
+			"pick fixup! Add",
+			"pick fixup! Nothing matches",
+		}},
+		{true, []string{
+			"pick Add a",
+			"fixup fixup! Add a",
+			"fixup fixup! fixup! Add a",
+			"fixup fixup! Add",
+			"pick Add b",
+			"squash squash! " + commits["Add b"],
+			"pick Add c",
+			"pick fixup! Nothing matches",
+		}},
+	} {
+		steps, err := repo.RebaseTodo(upstream, tt.autosquash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range steps {
+			if s.Hash != commits[s.Subject] {
+				t.Errorf("step %q is for %s, want %s", s.Subject, s.Hash, commits[s.Subject])
+			}
+			got = append(got, s.Action+" "+s.Subject)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RebaseTodo with autosquash %v:\n%q\nwant\n%q", tt.autosquash, got, tt.want)
+		}
+	}
+}
+
+func TestFixupMessageAutosquash(t *testing.T) {
+	repo := newTestRepo(t)
+	upstream := commitTestFiles(t, repo, "Base", map[string]string{"base": "base\n"})
+	target := commitTestFiles(t, repo, "Add a\n\nWith a body.", map[string]string{"a": "1\n"})
+	commitTestFiles(t, repo, "Add b", map[string]string{"b": "1\n"})
+
+	// The messages written by commit --fixup and --squash are recognized by autosquash
+	for _, squash := range []bool{false, true} {
+		msg, err := repo.FixupMessage(target, squash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commitTestFiles(t, repo, msg, map[string]string{"a": msg})
+	}
+	steps, err := repo.RebaseTodo(upstream, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range steps {
+		got = append(got, s.Action+" "+s.Subject)
+	}
+	want := []string{"pick Add a", "fixup fixup! Add a", "squash squash! Add a", "pick Add b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RebaseTodo:\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,281 @@
+package mgi
+
+import (
+	"container/heap"
+	"errors"
+)
+
+// RefFilter selects refs by how their commits relate to other commits. Empty fields don't
+// filter anything.
+type RefFilter struct {
+	// Contains keeps the refs that can reach this commit.
+	Contains string
+	// Merged keeps the refs that this commit can reach.
+	Merged string
+	// NoMerged keeps the refs that this commit can't reach.
+	NoMerged string
+}
+
+// filterRefs returns the refs that pass the filter. Refs that don't point to a commit, such as
+// tags of trees, are left out when filtering.
+func (m *MGIService) filterRefs(refs []*Ref, filter *RefFilter) ([]*Ref, error) {
+	if filter == nil || (filter.Contains == "" && filter.Merged == "" && filter.NoMerged == "") {
+		return refs, nil
+	}
+
+	resolve := func(rev string) (string, error) {
+		if rev == "" {
+			return "", nil
+		}
+		return m.resolveCommit(rev)
+	}
+	contains, err := resolve(filter.Contains)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := resolve(filter.Merged)
+	if err != nil {
+		return nil, err
+	}
+	noMerged, err := resolve(filter.NoMerged)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []*Ref
+	for _, r := range refs {
+		commit, err := m.peelCommit(r.Hash)
+		if err != nil {
+			continue
+		}
+		checks := []struct {
+			ancestor, descendant string
+			want                 bool
+		}{
+			{contains, commit, true},
+			{commit, merged, true},
+			{commit, noMerged, false},
+		}
+		keep := true
+		for _, c := range checks {
+			if c.ancestor == "" || c.descendant == "" {
+				continue
+			}
+			ok, err := m.isAncestor(c.ancestor, c.descendant)
+			if err != nil {
+				return nil, err
+			}
+			if ok != c.want {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
+
+// IsAncestor returns whether the commit ancestor can be reached from descendant. A commit is
+// considered an ancestor of itself.
+func (m *MGIService) IsAncestor(ancestor, descendant string) (bool, error) {
+	a, err := m.resolveCommit(ancestor)
+	if err != nil {
+		return false, err
+	}
+	d, err := m.resolveCommit(descendant)
+	if err != nil {
+		return false, err
+	}
+	return m.isAncestor(a, d)
+}
+
+// isAncestor is like IsAncestor, for commit hashes. The walk stops as soon as the ancestor is
+// found and, if generation numbers are known, doesn't go past commits whose generation isn't
+// higher than the ancestor's, which can't descend from it. Commit dates are never used to stop
+// the walk, since clocks can be wrong: without generation numbers, the whole history of
+// descendant may be walked.
+func (m *MGIService) isAncestor(ancestor, descendant string) (bool, error) {
+	a, err := m.CommitGraph().node(ancestor)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	err = m.walkNodes([]string{descendant}, func(n *commitNode) error {
+		if n.Hash == ancestor {
+			found = true
+			return errStopWalk
+		}
+		if a.Generation > 0 && n.Generation > 0 && n.Generation <= a.Generation {
+			return errSkipParents
+		}
+		return nil
+	})
+	return found, err
+}
+
+// MergeBase returns the best common ancestors of two commits: the common ancestors that aren't
+// ancestors of other common ancestors. There is usually only one.
+func (m *MGIService) MergeBase(a, b string) ([]string, error) {
+	a, err := m.resolveCommit(a)
+	if err != nil {
+		return nil, err
+	}
+	b, err = m.resolveCommit(b)
+	if err != nil {
+		return nil, err
+	}
+	return m.mergeBases(a, b)
+}
+
+// mergeBases is like MergeBase, for commit hashes.
+func (m *MGIService) mergeBases(a, b string) ([]string, error) {
+	common, err := m.commonAncestors(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var best []string
+	for i, c := range common {
+		redundant := false
+		for j, other := range common {
+			if i == j {
+				continue
+			}
+			ok, err := m.isAncestor(c, other)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			best = append(best, c)
+		}
+	}
+	return best, nil
+}
+
+// commonAncestors returns common ancestors of two commits, among which are the best ones. If the
+// generation numbers of both commits are known, only the commits down to the common ancestors
+// are walked, with paintCommonAncestors. Otherwise, the whole history of a is walked first.
+func (m *MGIService) commonAncestors(a, b string) ([]string, error) {
+	graph := m.CommitGraph()
+	na, err := graph.node(a)
+	if err != nil {
+		return nil, err
+	}
+	nb, err := graph.node(b)
+	if err != nil {
+		return nil, err
+	}
+	if na.Generation > 0 && nb.Generation > 0 {
+		return m.paintCommonAncestors(na, nb)
+	}
+
+	fromA, err := m.reachable(a)
+	if err != nil {
+		return nil, err
+	}
+	var common []string
+	err = m.walkNodes([]string{b}, func(n *commitNode) error {
+		if fromA[n.Hash] {
+			common = append(common, n.Hash)
+			// Ancestors of a common ancestor are common ancestors too, but never the best ones
+			return errSkipParents
+		}
+		return nil
+	})
+	return common, err
+}
+
+// Flags of the commits walked by paintCommonAncestors.
+const (
+	paintedA      = 1 << iota // reachable from a
+	paintedB                  // reachable from b
+	paintedStale              // reachable from a common ancestor
+	paintedResult             // a common ancestor that was returned
+)
+
+// paintCommonAncestors walks down from both commits at once, highest generation first, marking
+// the commits reachable from each, like git does. A commit reached from both is a common
+// ancestor, and its own ancestors are marked as stale, so that the walk ends once only stale
+// commits are left. Since a commit always has a higher generation than its ancestors, all the
+// commits reachable from a commit are walked after it.
+func (m *MGIService) paintCommonAncestors(a, b *commitNode) ([]string, error) {
+	if a.Hash == b.Hash {
+		return []string{a.Hash}, nil
+	}
+	graph := m.CommitGraph()
+	flags := map[string]int{a.Hash: paintedA, b.Hash: paintedB}
+	queue := &generationQueue{a, b}
+	heap.Init(queue)
+
+	var results []string
+	nonStale := func() bool {
+		for _, n := range *queue {
+			if flags[n.Hash]&paintedStale == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	for nonStale() {
+		n := heap.Pop(queue).(*commitNode)
+		f := flags[n.Hash] & (paintedA | paintedB | paintedStale)
+		if f == paintedA|paintedB {
+			if flags[n.Hash]&paintedResult == 0 {
+				flags[n.Hash] |= paintedResult
+				results = append(results, n.Hash)
+			}
+			f |= paintedStale
+		}
+		for _, p := range n.Parents {
+			if flags[p]&f == f {
+				continue
+			}
+			parent, err := graph.node(p)
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			flags[p] |= f
+			heap.Push(queue, parent)
+		}
+	}
+
+	// Common ancestors found before a better one can be stale by now
+	var common []string
+	for _, hash := range results {
+		if flags[hash]&paintedStale == 0 {
+			common = append(common, hash)
+		}
+	}
+	return common, nil
+}
+
+// generationQueue orders the commits walked by paintCommonAncestors, highest generation first,
+// and then most recent first.
+type generationQueue []*commitNode
+
+func (q generationQueue) Len() int { return len(q) }
+func (q generationQueue) Less(i, j int) bool {
+	if q[i].Generation != q[j].Generation {
+		return q[i].Generation > q[j].Generation
+	}
+	return q[i].CommitTime.After(q[j].CommitTime)
+}
+func (q generationQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *generationQueue) Push(x interface{}) { *q = append(*q, x.(*commitNode)) }
+func (q *generationQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
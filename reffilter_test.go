@@ -0,0 +1,106 @@
+package mgi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// commitAt stores a commit of the empty tree with the given parents, made at the given time.
+func commitAt(t *testing.T, repo *Repo, msg string, when time.Time, parents ...string) string {
+	t.Helper()
+	tree, err := repo.Objects.StoreObject(&Tree{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.writeCommit(&Commit{
+		Parents:     parents,
+		Tree:        tree.String(),
+		Author:      "A U Thor",
+		AuthorEmail: "author@example.com",
+		AuthorTime:  when,
+		Message:     msg + "\n",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// withCommitGraph runs fn on the repository without a commit-graph file, and then on a new
+// instance of it after writing one.
+func withCommitGraph(t *testing.T, repo *Repo, fn func(repo *Repo, graph bool)) {
+	t.Helper()
+	fn(repo, false)
+	_, err := repo.WriteCommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withGraph, err := NewRepo(repo.GitDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(withGraph, true)
+}
+
+func TestIsAncestorWithClockSkew(t *testing.T) {
+	repo := newTestRepo(t)
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	// The clock of whoever made the first commit was ahead by days
+	a := commitAt(t, repo, "a", day(10))
+	b := commitAt(t, repo, "b", day(1), a)
+	c := commitAt(t, repo, "c", day(2), b)
+	err := repo.updateRef("refs/heads/master", "", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withCommitGraph(t, repo, func(repo *Repo, graph bool) {
+		ok, err := repo.IsAncestor(a, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("commit-graph %v: a is not an ancestor of c", graph)
+		}
+		if got := branchNames(t, repo, &RefFilter{Contains: a}); !reflect.DeepEqual(got, []string{"master"}) {
+			t.Errorf("commit-graph %v: branches containing a = %q, want master", graph, got)
+		}
+	})
+}
+
+func TestMergeBaseWithGenerations(t *testing.T) {
+	repo := newTestRepo(t)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := commitAt(t, repo, "base", now)
+	a1 := commitAt(t, repo, "a1", now.Add(time.Hour), base)
+	a2 := commitAt(t, repo, "a2", now.Add(2*time.Hour), a1)
+	b1 := commitAt(t, repo, "b1", now.Add(time.Hour), base)
+	merge := commitAt(t, repo, "merge", now.Add(3*time.Hour), a2, b1)
+	err := repo.updateRef("refs/heads/master", "", merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withCommitGraph(t, repo, func(repo *Repo, graph bool) {
+		for _, tt := range []struct {
+			a, b string
+			want string
+		}{
+			{a2, b1, base},
+			{a1, a2, a1},
+			{merge, b1, b1},
+		} {
+			got, err := repo.MergeBase(tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("commit-graph %v: merge base of %s and %s = %v, want %s", graph, tt.a, tt.b, got, tt.want)
+			}
+		}
+		if ok, err := repo.IsAncestor(b1, a2); err != nil || ok {
+			t.Errorf("commit-graph %v: IsAncestor(b1, a2) = %v, %v, want false", graph, ok, err)
+		}
+	})
+}
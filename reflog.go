@@ -0,0 +1,313 @@
+package mgi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zeroHash is used in reflogs as the old value of a ref that did not exist.
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// ReflogEntry records a single update of a ref.
+type ReflogEntry struct {
+	Old     string
+	New     string
+	Name    string
+	Email   string
+	Time    time.Time
+	Message string
+}
+
+func (e *ReflogEntry) String() string {
+	return fmt.Sprintf("%s %s %s <%s> %s\t%s", e.Old, e.New, e.Name, e.Email, formatTime(e.Time), e.Message)
+}
+
+// readReflog returns the entries of the reflog of a ref, oldest first.
+func (m *MGIService) readReflog(ref string) ([]*ReflogEntry, error) {
+	contents, err := ioutil.ReadFile(m.gitPath("logs/" + ref))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*ReflogEntry
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		e, err := parseReflogEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("reflog of %s: %v", ref, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func parseReflogEntry(line string) (*ReflogEntry, error) {
+	var message string
+	if tab := strings.IndexByte(line, '\t'); tab >= 0 {
+		line, message = line[:tab], line[tab+1:]
+	}
+	if len(line) < 82 || line[40] != ' ' || line[81] != ' ' {
+		return nil, fmt.Errorf("malformed entry %q", line)
+	}
+	name, email, t, err := parseSignature(line[82:])
+	if err != nil {
+		return nil, err
+	}
+	return &ReflogEntry{
+		Old:     line[:40],
+		New:     line[41:81],
+		Name:    name,
+		Email:   email,
+		Time:    t,
+		Message: message,
+	}, nil
+}
+
+// appendReflog records an update of a ref from old to new, dropping the oldest entries if the
+// reflog grows past maxReflogEntries.
+func (m *MGIService) appendReflog(ref, old, new, message string) error {
+	if old == "" {
+		old = zeroHash
+	}
+	name, email := identity()
+	e := &ReflogEntry{
+		Old:     old,
+		New:     new,
+		Name:    name,
+		Email:   email,
+		Time:    time.Now(),
+		Message: message,
+	}
+
+	entries, err := m.readReflog(ref)
+	if err != nil {
+		return err
+	}
+	if len(entries) >= maxReflogEntries {
+		entries = append(entries[len(entries)-maxReflogEntries+1:], e)
+		return m.writeReflog(ref, entries)
+	}
+
+	path := m.gitPath("logs/" + ref)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	_, err = fd.WriteString(e.String() + "\n")
+	return err
+}
+
+// writeReflog replaces the reflog of a ref with the given entries.
+func (m *MGIService) writeReflog(ref string, entries []*ReflogEntry) error {
+	b := new(bytes.Buffer)
+	for _, e := range entries {
+		b.WriteString(e.String())
+		b.WriteString("\n")
+	}
+	return ioutil.WriteFile(m.gitPath("logs/"+ref), b.Bytes(), 0644)
+}
+
+// maxReflogEntries caps the number of entries kept in a reflog. The oldest entries are dropped
+// when a ref is updated past it.
+const maxReflogEntries = 10000
+
+// DefaultReflogExpire is how old reflog entries must be to be pruned by ReflogExpire when no
+// age is configured.
+const DefaultReflogExpire = "90.days.ago"
+
+// Reflog returns a description of each entry in the reflog of a ref (HEAD if empty), most
+// recent first.
+func (m *MGIService) Reflog(name string) ([]string, error) {
+	ref, err := m.reflogRef(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.readReflog(ref)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "HEAD"
+	}
+	list := make([]string, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		list = append(list, fmt.Sprintf("%s %s@{%d}: %s", e.New[:7], name, len(entries)-1-i, e.Message))
+	}
+	return list, nil
+}
+
+// ReflogExpire removes the entries older than expire (e.g. "90.days.ago", "2021-05-01" or
+// "never") from the reflogs of the given refs, or from every reflog if all is set. It returns
+// the number of entries removed.
+func (m *MGIService) ReflogExpire(names []string, all bool, expire string) (int, error) {
+	cutoff, err := parseDate(expire, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("invalid expiry date %q: %v", expire, err)
+	}
+
+	var refs []string
+	if all {
+		refs, err = m.listReflogs()
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, name := range names {
+		ref, err := m.reflogRef(name)
+		if err != nil {
+			return 0, err
+		}
+		refs = append(refs, ref)
+	}
+
+	removed := 0
+	for _, ref := range refs {
+		entries, err := m.readReflog(ref)
+		if err != nil {
+			return removed, err
+		}
+		kept := entries[:0]
+		for _, e := range entries {
+			if !e.Time.Before(cutoff) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(entries) {
+			continue
+		}
+		m.logger.Debugf("expiring %d entries from the reflog of %s", len(entries)-len(kept), ref)
+		err = m.writeReflog(ref, kept)
+		if err != nil {
+			return removed, err
+		}
+		removed += len(entries) - len(kept)
+	}
+	return removed, nil
+}
+
+// reflogValues returns the objects the entries of every reflog point to, before and after each
+// update, since both can be recovered through the reflog.
+func (m *MGIService) reflogValues() ([]string, error) {
+	reflogs, err := m.listReflogs()
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, ref := range reflogs {
+		entries, err := m.readReflog(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			for _, hash := range []string{e.Old, e.New} {
+				if hash != zeroHash && !containsString(values, hash) {
+					values = append(values, hash)
+				}
+			}
+		}
+	}
+	return values, nil
+}
+
+// listReflogs returns the refs that have a reflog.
+func (m *MGIService) listReflogs() ([]string, error) {
+	var refs []string
+	if _, err := os.Stat(m.gitPath("logs/HEAD")); err == nil {
+		refs = append(refs, "HEAD")
+	}
+
+	err := filepath.WalkDir(m.gitPath("logs/refs"), func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, os.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(filepath.Join(m.common, "logs"), path)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, filepath.ToSlash(rel))
+		return nil
+	})
+	return refs, err
+}
+
+// reflogRef returns the ref whose reflog a name refers to: the current branch for an empty name,
+// HEAD, or a ref found the way expandRef does.
+func (m *MGIService) reflogRef(name string) (string, error) {
+	switch name {
+	case "HEAD":
+		return name, nil
+	case "":
+		ref, err := m.headRef()
+		if err != nil {
+			return "", err
+		}
+		if ref == "" {
+			return "HEAD", nil
+		}
+		return ref, nil
+	}
+	ref, err := m.expandRef(name)
+	if err != nil {
+		return "", err
+	}
+	return ref.Name, nil
+}
+
+// resolveReflog resolves a "<ref>@{n}" revision to the value the ref had n updates ago. The
+// second return value is false if rev is not of that form.
+func (m *MGIService) resolveReflog(rev string) (string, bool, error) {
+	if !strings.HasSuffix(rev, "}") {
+		return "", false, nil
+	}
+	at := strings.LastIndex(rev, "@{")
+	if at < 0 {
+		return "", false, nil
+	}
+	name, spec := rev[:at], rev[at+2:len(rev)-1]
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 {
+		return "", true, fmt.Errorf("unsupported reflog selector %q, only @{n} is supported", rev)
+	}
+
+	ref, err := m.reflogRef(name)
+	if err != nil {
+		return "", true, err
+	}
+	entries, err := m.readReflog(ref)
+	if err != nil {
+		return "", true, err
+	}
+	if n >= len(entries) {
+		return "", true, fmt.Errorf("log for %s only has %d entries", ref, len(entries))
+	}
+	return entries[len(entries)-1-n].New, true, nil
+}
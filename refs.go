@@ -0,0 +1,223 @@
+package mgi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ref is a named reference to an object, e.g. "refs/heads/master".
+type Ref struct {
+	Name string
+	Hash string
+}
+
+// headRef returns the branch HEAD points to, e.g. "refs/heads/master".
+// It returns an empty string if HEAD is detached.
+func (m *MGIService) headRef() (string, error) {
+	contents, err := ioutil.ReadFile(m.gitPath("HEAD"))
+	if err != nil {
+		return "", err
+	}
+	head := string(bytes.TrimSpace(contents))
+	if strings.HasPrefix(head, "ref: ") {
+		return strings.TrimPrefix(head, "ref: "), nil
+	}
+	return "", nil
+}
+
+// readRef resolves a ref (e.g. "HEAD" or "refs/tags/v1.0") to the object it points to,
+// following symbolic refs. It returns os.ErrNotExist if the ref does not exist.
+func (m *MGIService) readRef(name string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		contents, err := ioutil.ReadFile(m.gitPath(name))
+		if errors.Is(err, os.ErrNotExist) {
+			return m.readPackedRef(name)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		value := string(bytes.TrimSpace(contents))
+		if !strings.HasPrefix(value, "ref: ") {
+			return value, nil
+		}
+		name = strings.TrimPrefix(value, "ref: ")
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs")
+}
+
+// resolveObject resolves a name (a hash or a unique prefix of one, HEAD, the name of a ref, or
+// "<ref>@{n}") to an object, without peeling it.
+func (m *MGIService) resolveObject(name string) (*Hash, error) {
+	if hash, ok, err := m.resolveReflog(name); ok {
+		if err != nil {
+			return nil, err
+		}
+		return new(Hash).FromString(hash)
+	}
+	for _, ref := range []string{name, "refs/tags/" + name, "refs/heads/" + name} {
+		if name == "" || strings.HasPrefix(name, "/") {
+			break
+		}
+		hash, err := m.readRef(ref)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return new(Hash).FromString(hash)
+	}
+	return m.obj.ResolvePrefix(name)
+}
+
+// resolveCommit resolves a revision (see resolveRevision) to a commit.
+func (m *MGIService) resolveCommit(rev string) (string, error) {
+	hash, err := m.resolveRevision(rev)
+	if err != nil {
+		return "", err
+	}
+	return m.peelCommit(hash)
+}
+
+// updateRef points the ref to the given object, if it still points to old, or doesn't exist if
+// old is empty. The ref is locked before old is checked and until it's updated, so it fails if
+// another process is updating it, and the updates made by others since the caller read the ref
+// aren't lost.
+func (m *MGIService) updateRef(name, old, hash string) error {
+	m.logger.Debugf("updating ref %s from %s to %s", name, old, hash)
+	path := m.gitPath(name)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	lock, err := acquireLock(path)
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	lock.fsync = m.fsync
+
+	current, err := m.readRef(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if current != old {
+		if old == "" {
+			return fmt.Errorf("cannot update ref %s: it already exists", name)
+		}
+		return fmt.Errorf("cannot update ref %s: expected it to point to %s, but it points to %s", name, old, current)
+	}
+
+	_, err = lock.Write([]byte(hash + "\n"))
+	if err != nil {
+		return err
+	}
+	return lock.commit()
+}
+
+// listRefs returns the refs whose names start with prefix (e.g. "refs/tags/"), sorted by name.
+// Loose refs take precedence over the ones in the packed-refs file.
+func (m *MGIService) listRefs(prefix string) ([]*Ref, error) {
+	refs := make(map[string]string)
+
+	packed, err := m.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range packed {
+		if strings.HasPrefix(r.Name, prefix) {
+			refs[r.Name] = r.Hash
+		}
+	}
+
+	refsDir := m.gitPath("refs")
+	err = filepath.WalkDir(refsDir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, os.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		// Lock files of refs being updated are not refs
+		if d.IsDir() || strings.HasSuffix(d.Name(), ".lock") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(m.common, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		hash, err := m.readRef(name)
+		if err != nil {
+			return err
+		}
+		refs[name] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*Ref, 0, len(refs))
+	for name, hash := range refs {
+		list = append(list, &Ref{Name: name, Hash: hash})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func (m *MGIService) readPackedRef(name string) (string, error) {
+	refs, err := m.readPackedRefs()
+	if err != nil {
+		return "", err
+	}
+	for _, r := range refs {
+		if r.Name == name {
+			return r.Hash, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// readPackedRefs parses the packed-refs file, ignoring the peeled ("^") lines.
+func (m *MGIService) readPackedRefs() ([]*Ref, error) {
+	contents, err := ioutil.ReadFile(m.gitPath("packed-refs"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*Ref
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed packed-refs line %q", line)
+		}
+		refs = append(refs, &Ref{Name: fields[1], Hash: fields[0]})
+	}
+	return refs, scanner.Err()
+}
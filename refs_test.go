@@ -0,0 +1,51 @@
+package mgi
+
+import (
+	"testing"
+)
+
+func TestUpdateRefChecksOldValue(t *testing.T) {
+	repo := newTestRepo(t)
+	first := commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	second := commitTestFiles(t, repo, "second", map[string]string{"a": "2\n"})
+
+	err := repo.updateRef("refs/heads/topic", "", first)
+	if err != nil {
+		t.Fatalf("creating a new ref: %v", err)
+	}
+	err = repo.updateRef("refs/heads/topic", "", second)
+	if err == nil {
+		t.Errorf("a ref that already exists was created again")
+	}
+	// Someone else moved the ref after it was read as second
+	err = repo.updateRef("refs/heads/topic", second, second)
+	if err == nil {
+		t.Errorf("a ref was updated from a value it no longer had")
+	}
+	got, err := repo.readRef("refs/heads/topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != first {
+		t.Errorf("refs/heads/topic = %s, want %s", got, first)
+	}
+}
+
+func TestCommitFailsIfBranchMoved(t *testing.T) {
+	repo := newTestRepo(t)
+	first := commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	second := commitTestFiles(t, repo, "second", map[string]string{"a": "2\n"})
+
+	// The branch is moved between reading it and advancing it
+	err := repo.advanceHead(first, second, "commit: stale")
+	if err == nil {
+		t.Fatalf("the branch was advanced from a commit it no longer pointed to")
+	}
+	head, err := repo.currentHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != second {
+		t.Errorf("HEAD = %s, want %s", head, second)
+	}
+}
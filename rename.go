@@ -0,0 +1,98 @@
+package mgi
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bertinatto/mgi/diff"
+)
+
+// DefaultRenameThreshold is the similarity, in percent, above which diff -M pairs a deleted
+// file with an added one, like git.
+const DefaultRenameThreshold = 50
+
+// rename pairs a deleted file with the added file it was renamed to.
+type rename struct {
+	from       string
+	to         string
+	similarity int
+}
+
+// detectRenames pairs the files that were deleted from old with the files added to new. Files
+// with the same contents are paired first, and the remaining ones by the similarity of their
+// contents, best matches first. Binary files are only paired if they are identical. The result is keyed by the new path.
+func detectRenames(old, new map[string]*IndexEntry, threshold int, readBlob func(*IndexEntry) ([]byte, error)) (map[string]*rename, error) {
+	var deleted, added []string
+	for path := range old {
+		if _, ok := new[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	for path := range new {
+		if _, ok := old[path]; !ok {
+			added = append(added, path)
+		}
+	}
+	sort.Strings(deleted)
+	sort.Strings(added)
+
+	renames := make(map[string]*rename)
+	used := make(map[string]bool)
+
+	// Exact renames
+	for _, to := range added {
+		for _, from := range deleted {
+			if !used[from] && old[from].Hash.String() == new[to].Hash.String() {
+				renames[to] = &rename{from: from, to: to, similarity: 100}
+				used[from] = true
+				break
+			}
+		}
+	}
+
+	// Renames with changes
+	var candidates []*rename
+	for _, to := range added {
+		if _, ok := renames[to]; ok {
+			continue
+		}
+		newData, err := readBlob(new[to])
+		if err != nil {
+			return nil, err
+		}
+		for _, from := range deleted {
+			if used[from] {
+				continue
+			}
+			oldData, err := readBlob(old[from])
+			if err != nil {
+				return nil, err
+			}
+			if score := diff.Similarity(oldData, newData); score >= threshold {
+				candidates = append(candidates, &rename{from: from, to: to, similarity: score})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].similarity > candidates[j].similarity
+	})
+	for _, c := range candidates {
+		if _, ok := renames[c.to]; ok || used[c.from] {
+			continue
+		}
+		renames[c.to] = c
+		used[c.from] = true
+	}
+	return renames, nil
+}
+
+// diffRename renders a rename like git does, followed by the changes made to the file, if any,
+// rendered with render.
+func diffRename(r *rename, old, new []byte, render func(oldLabel, newLabel string, a, b []byte) string) string {
+	header := fmt.Sprintf("diff --git a/%s b/%s\nsimilarity index %d%%\nrename from %s\nrename to %s\n",
+		r.from, r.to, r.similarity, r.from, r.to)
+	if r.similarity == 100 {
+		return header
+	}
+	return header + render("a/"+r.from, "b/"+r.to, old, new)
+}
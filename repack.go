@@ -0,0 +1,95 @@
+package mgi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Repack writes every loose object to a new packfile in the object store, like git repack
+// without -a, and returns the checksum of the pack, or an empty string if there are no loose
+// objects. If deleteLoose is set, the loose copies are then removed, each only once it is found
+// in the index of the new pack. It also returns the number of loose objects removed.
+func (o *ObjectService) Repack(deleteLoose bool) (string, int, error) {
+	loose := map[string]string{} // the paths of the loose objects, by hash
+	var hashes []*Hash
+	err := o.looseObjects(func(hash *Hash, path string) error {
+		loose[hash.String()] = path
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	if len(hashes) == 0 {
+		return "", 0, nil
+	}
+
+	packDir := filepath.Join(o.path, "pack")
+	err = os.MkdirAll(packDir, 0755)
+	if err != nil {
+		return "", 0, err
+	}
+	f, err := ioutil.TempFile(packDir, "tmp_pack_*.pack")
+	if err != nil {
+		return "", 0, err
+	}
+	tmp := f.Name()
+	tmpIdx := strings.TrimSuffix(tmp, ".pack") + ".idx"
+	defer os.Remove(tmp)
+	defer os.Remove(tmpIdx)
+
+	o.logger.Debugf("packing %d loose objects to %s", len(hashes), tmp)
+	err = writePack(o, f, hashes)
+	if err == nil && o.fsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	// Reading the pack back checks it before the loose objects can be removed
+	sum, err := o.IndexPack(tmp, false)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// The pack goes first, since packs are found through their index
+	name := filepath.Join(packDir, "pack-"+sum)
+	err = os.Rename(tmp, name+".pack")
+	if err != nil {
+		return "", 0, err
+	}
+	err = os.Rename(tmpIdx, name+".idx")
+	if err != nil {
+		return "", 0, err
+	}
+	o.packs = nil
+	if !deleteLoose {
+		return sum, 0, nil
+	}
+
+	p, err := loadPackIndex(name + ".idx")
+	if err != nil {
+		return "", 0, err
+	}
+	removed := 0
+	for _, hash := range hashes {
+		if _, ok := p.find(hash); !ok {
+			o.logger.Debugf("keeping loose object %s, which is not in the new pack", hash)
+			continue
+		}
+		path := loose[hash.String()]
+		err := os.Remove(path)
+		if err != nil {
+			return "", 0, err
+		}
+		// Remove the fan-out directory if it's now empty, ignoring errors if it isn't
+		os.Remove(filepath.Dir(path))
+		removed++
+	}
+	return sum, removed, nil
+}
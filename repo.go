@@ -0,0 +1,101 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Repo is a repository with its services wired together. The methods of MGIService can be
+// called on it directly.
+type Repo struct {
+	*MGIService
+
+	// GitDir is the repository directory, e.g. "/src/project/.git".
+	GitDir string
+	// CommonDir holds the objects, refs and configuration. It is GitDir except in linked
+	// worktrees, which share them with the main repository.
+	CommonDir string
+
+	Objects *ObjectService
+	Index   *IndexService
+}
+
+// NewRepo wires the services of the repository whose git directory is gitDir, following it if
+// it is a ".git" file, without checking that the repository exists. The logger may be nil.
+// Writes are flushed to disk if core.fsyncObjectFiles is set in the configuration, and up to
+// core.objectCacheSize bytes of the objects read are cached in memory.
+func NewRepo(gitDir string, logger Logger) (*Repo, error) {
+	gitDir, err := ResolveGitDir(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	common := commonDir(gitDir)
+	obj := NewObjectService(common, logger)
+	index := NewIndexService(gitDir, logger)
+	repo := &Repo{
+		MGIService: NewMGIService(gitDir, obj, index, logger),
+		GitDir:     gitDir,
+		CommonDir:  common,
+		Objects:    obj,
+		Index:      index,
+	}
+
+	config, err := NewConfigService(common).Read()
+	if err != nil {
+		return nil, err
+	}
+	fsync, err := config.GetBool("core.fsyncObjectFiles")
+	if err != nil {
+		return nil, err
+	}
+	repo.SetFsync(fsync)
+	cacheSize, err := config.GetSize("core.objectCacheSize")
+	if err != nil {
+		return nil, err
+	}
+	obj.SetCacheSize(cacheSize)
+	return repo, nil
+}
+
+// SetFsync sets whether objects, the index and refs are flushed to disk when they are written,
+// so that a command that succeeded isn't undone by a crash or power loss.
+func (r *Repo) SetFsync(on bool) {
+	r.Objects.SetFsync(on)
+	r.Index.SetFsync(on)
+	r.fsync = on
+}
+
+// OpenRepository opens the repository that contains path, looking for its ".git" directory (or
+// file) in path and its parents. Operations on the working tree still resolve paths relative to
+// the current directory, so the repository is best used for reading objects and refs unless the
+// current directory is its root.
+func OpenRepository(path string) (*Repo, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		gitDir, err := ResolveGitDir(filepath.Join(dir, ".git"))
+		if err != nil {
+			return nil, err
+		}
+		if isGitDir(gitDir) {
+			return NewRepo(gitDir, nil)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("%s is not in a git repository", path)
+		}
+		dir = parent
+	}
+}
+
+// isGitDir returns whether the directory looks like a repository: it has a HEAD and an object store.
+func isGitDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		return false
+	}
+	fi, err := os.Stat(filepath.Join(commonDir(dir), "objects"))
+	return err == nil && fi.IsDir()
+}
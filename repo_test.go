@@ -0,0 +1,112 @@
+package mgi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRepo creates an empty repository in a temporary directory and changes to it, since
+// working tree paths are relative to the current directory. The previous one is restored when
+// the test ends.
+func newTestRepo(t *testing.T) *Repo {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, d := range []string{".git/objects", ".git/refs/heads"} {
+		err := os.MkdirAll(d, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, ".git/HEAD", "ref: refs/heads/master\n")
+	repo, err := NewRepo(".git", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// writeTestFile writes the file, creating its directories.
+func writeTestFile(t *testing.T, path, contents string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commitTestFiles writes and adds the files, and commits them with the message. It returns the
+// new commit.
+func commitTestFiles(t *testing.T, repo *Repo, msg string, files map[string]string) string {
+	t.Helper()
+	var paths []string
+	for path, contents := range files {
+		writeTestFile(t, path, contents)
+		paths = append(paths, path)
+	}
+	err := repo.Add(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Commit(msg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.currentHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return head
+}
+
+func TestOpenRepository(t *testing.T) {
+	repo := newTestRepo(t)
+	head := commitTestFiles(t, repo, "Initial commit", map[string]string{"a/b/file": "contents\n"})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{".", "a", "a/b"} {
+		opened, err := OpenRepository(path)
+		if err != nil {
+			t.Fatalf("OpenRepository(%q): %v", path, err)
+		}
+		gitDir := filepath.Join(wd, ".git")
+		if opened.GitDir != gitDir || opened.CommonDir != gitDir {
+			t.Errorf("OpenRepository(%q) opened %s (common %s), want %s", path, opened.GitDir, opened.CommonDir, gitDir)
+		}
+		got, err := opened.currentHead()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != head {
+			t.Errorf("OpenRepository(%q) has HEAD at %s, want %s", path, got, head)
+		}
+	}
+
+	// A ".git" directory without HEAD and objects isn't a repository
+	other := t.TempDir()
+	err = os.MkdirAll(filepath.Join(other, ".git"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRepository(other); err == nil {
+		t.Errorf("OpenRepository(%q) succeeded outside of a repository", other)
+	}
+}
@@ -0,0 +1,191 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ResetMode is what ResetTo does to the index and the working tree besides moving HEAD.
+type ResetMode int
+
+const (
+	// ResetMixed makes the index match the target commit and leaves the working tree alone.
+	ResetMixed ResetMode = iota
+	// ResetSoft only moves HEAD.
+	ResetSoft
+	// ResetHard makes both the index and the working tree match the target commit, discarding
+	// all the changes to tracked files.
+	ResetHard
+	// ResetMerge makes the index match the target commit and updates the working tree files
+	// that differ between HEAD and the target, keeping the changes that aren't staged. It
+	// aborts if a file with unstaged changes differs between HEAD and the target, or also has
+	// staged changes.
+	ResetMerge
+	// ResetKeep makes the index match the target commit and updates the working tree files
+	// that differ between HEAD and the target, keeping the local changes. It aborts if a file
+	// with local changes, staged or not, differs between HEAD and the target.
+	ResetKeep
+)
+
+// ResetTo moves the current branch, or HEAD if it is detached, to the commit named by rev, and
+// updates the index and the working tree as told by mode, like git reset. The previous commit
+// is saved in ORIG_HEAD. If ResetMerge or ResetKeep would lose local changes, or overwrite
+// untracked files, nothing is changed.
+func (m *MGIService) ResetTo(rev string, mode ResetMode) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	target, err := m.resolveCommit(rev)
+	if err != nil {
+		return err
+	}
+	old, err := m.currentHead()
+	if err != nil {
+		return err
+	}
+	if mode != ResetSoft {
+		err = m.resetFiles(target, mode)
+		if err != nil {
+			return err
+		}
+	}
+	if old != "" {
+		orig, err := m.readRef("ORIG_HEAD")
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		err = m.updateRef("ORIG_HEAD", orig, old)
+		if err != nil {
+			return err
+		}
+	}
+	return m.advanceHead(old, target, "reset: moving to "+rev)
+}
+
+// resetFiles updates the index and the working tree for ResetTo. Every file is checked before
+// any is written, so that a reset that is refused doesn't leave them half updated.
+func (m *MGIService) resetFiles(target string, mode ResetMode) error {
+	headFiles, err := m.headFiles()
+	if err != nil {
+		return err
+	}
+	indexFiles, err := m.indexFiles()
+	if err != nil {
+		return err
+	}
+	workFiles, err := m.workingFiles(indexFiles)
+	if err != nil {
+		return err
+	}
+	targetFiles, err := m.commitFiles(target)
+	if err != nil {
+		return err
+	}
+
+	paths := make(map[string]bool, len(indexFiles))
+	for _, files := range []map[string]*IndexEntry{headFiles, indexFiles, targetFiles} {
+		for path := range files {
+			paths[path] = true
+		}
+	}
+
+	// The working tree files to replace with their version in the target, or to remove
+	checkout := make(map[string]bool)
+	var conflicts, untracked []string
+	for path := range paths {
+		w, i, h, t := workFiles[path], indexFiles[path], headFiles[path], targetFiles[path]
+		switch mode {
+		case ResetHard:
+			checkout[path] = true
+		case ResetMerge:
+			if sameEntry(w, i) {
+				checkout[path] = true
+			} else if !sameEntry(i, h) || !sameEntry(h, t) {
+				conflicts = append(conflicts, path)
+			}
+		case ResetKeep:
+			if sameEntry(w, i) && sameEntry(i, h) {
+				checkout[path] = true
+			} else if !sameEntry(h, t) {
+				conflicts = append(conflicts, path)
+			}
+		}
+		if checkout[path] && mode != ResetHard && i == nil && t != nil {
+			if _, err := os.Lstat(path); err == nil {
+				untracked = append(untracked, path)
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("local changes to %s would be overwritten by reset", strings.Join(conflicts, ", "))
+	}
+	if len(untracked) > 0 {
+		sort.Strings(untracked)
+		return fmt.Errorf("untracked files %s would be overwritten by reset", strings.Join(untracked, ", "))
+	}
+
+	for path := range checkout {
+		t := targetFiles[path]
+		switch {
+		case t == nil:
+			if indexFiles[path] != nil {
+				err = removeFile(path)
+			}
+		case !sameEntry(workFiles[path], t):
+			err = m.checkoutFile(path, t.Hash, t.Mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	index, err := m.index.Read()
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+	var stale []string
+	for _, e := range index.Entries {
+		if _, ok := targetFiles[e.Path]; !ok {
+			stale = append(stale, e.Path)
+		}
+	}
+	for _, path := range stale {
+		err := m.index.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	for path, e := range targetFiles {
+		if i := indexFiles[path]; i != nil && i.Mode == e.Mode && sameEntry(i, e) && !checkout[path] {
+			// Keep its stat data
+			continue
+		}
+		switch {
+		case e.Mode == modeGitlink:
+			m.index.AddGitlink(path, e.Hash)
+		case checkout[path] && e.Mode != 0120000:
+			err := m.index.Add(path, e.Hash)
+			if err != nil {
+				return err
+			}
+		default:
+			// Without stat data, the file is compared by content the next time
+			m.index.AddEntry(&IndexEntry{Mode: e.Mode, Hash: e.Hash, Flags: nameFlags(path), Path: path})
+		}
+	}
+	return m.index.Store()
+}
+
+// sameEntry returns whether two versions of a file have the same contents, where nil stands for
+// a missing file.
+func sameEntry(a, b *IndexEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash.String() == b.Hash.String()
+}
@@ -0,0 +1,273 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/bertinatto/mgi/pathspec"
+)
+
+// Restore overwrites the given working tree files with their version in the index or, if source
+// is set, in that commit (or tree). When staged is set, the index entries are restored from source
+// (HEAD by default) instead, and the working tree is left untouched. Directories restore all files
+// under them.
+func (m *MGIService) Restore(paths []string, source string, staged bool) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	indexFiles, err := m.indexFiles()
+	if err != nil {
+		return err
+	}
+
+	if staged {
+		if source == "" {
+			source = "HEAD"
+		}
+		return m.restoreIndex(paths, source, indexFiles)
+	}
+
+	from := indexFiles
+	if source != "" {
+		tree, err := m.resolveRevision(source)
+		if err != nil {
+			return err
+		}
+		from, err = m.commitFiles(tree)
+		if err != nil {
+			return err
+		}
+	}
+
+	matched, err := matchPaths(paths, from)
+	if err != nil {
+		return err
+	}
+	for path, e := range matched {
+		err := m.checkoutFile(path, e.Hash, e.Mode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckoutPaths overwrites the given files of the working tree and the index with their version
+// in source, a commit or tree, without moving HEAD. Directories check out all files under them,
+// and every path must match a file of source. Without source, the files are only restored from
+// the index, like Restore.
+func (m *MGIService) CheckoutPaths(paths []string, source string) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	if source == "" {
+		return m.Restore(paths, "", false)
+	}
+
+	tree, err := m.resolveRevision(source)
+	if err != nil {
+		return err
+	}
+	sourceFiles, err := m.commitFiles(tree)
+	if err != nil {
+		return err
+	}
+	matched, err := matchPaths(paths, sourceFiles)
+	if err != nil {
+		return fmt.Errorf("%w in %s", err, source)
+	}
+
+	_, err = m.index.Read()
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+	for path, e := range matched {
+		err := m.checkoutFile(path, e.Hash, e.Mode)
+		if err != nil {
+			return err
+		}
+		switch e.Mode {
+		case modeGitlink:
+			m.index.AddGitlink(path, e.Hash)
+		case 0120000:
+			m.index.AddEntry(&IndexEntry{Mode: e.Mode, Hash: e.Hash, Flags: nameFlags(path), Path: path})
+		default:
+			err = m.index.Add(path, e.Hash)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return m.index.Store()
+}
+
+// CheckoutStage resolves the conflicts of the given files by checking out their version of a
+// merge stage, 2 for ours or 3 for theirs, and marking them as merged in the index. Directories
+// check out all files under them. Files without conflicts are restored from the index, like
+// Restore. Every file with conflicts must have a version of that stage, or nothing is changed.
+func (m *MGIService) CheckoutStage(paths []string, stage int) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	side := "our"
+	if stage == 3 {
+		side = "their"
+	}
+	index, err := m.index.Read()
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+	// The entries of the stage, or the merged ones, by path, and all the paths of the index
+	files := make(map[string]*IndexEntry)
+	all := make(map[string]*IndexEntry)
+	for _, e := range index.Entries {
+		if e.Stage() == 0 || e.Stage() == stage {
+			files[e.Path] = e
+		}
+		all[e.Path] = e
+	}
+	matched, err := matchPaths(paths, all)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for path := range matched {
+		if files[path] == nil {
+			missing = append(missing, path)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("path %s does not have %s version", missing[0], side)
+	}
+
+	for path := range matched {
+		e := files[path]
+		err := m.checkoutFile(path, e.Hash, e.Mode)
+		if err != nil {
+			return err
+		}
+		if e.Stage() == 0 {
+			continue
+		}
+		switch e.Mode {
+		case modeGitlink:
+			m.index.AddGitlink(path, e.Hash)
+		case 0120000:
+			m.index.AddEntry(&IndexEntry{Mode: e.Mode, Hash: e.Hash, Flags: nameFlags(path), Path: path})
+		default:
+			err = m.index.Add(path, e.Hash)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return m.index.Store()
+}
+
+// restoreIndex sets the index entries of the given paths to their version in the source commit
+// (or tree).
+// Paths that are not in the commit are removed from the index.
+func (m *MGIService) restoreIndex(paths []string, source string, indexFiles map[string]*IndexEntry) error {
+	tree, err := m.resolveRevision(source)
+	if err != nil {
+		return err
+	}
+	sourceFiles, err := m.commitFiles(tree)
+	if err != nil {
+		return err
+	}
+
+	// Paths may match files in the index, in the commit, or both
+	all := make(map[string]*IndexEntry, len(indexFiles))
+	for path, e := range indexFiles {
+		all[path] = e
+	}
+	for path, e := range sourceFiles {
+		all[path] = e
+	}
+	matched, err := matchPaths(paths, all)
+	if err != nil {
+		return err
+	}
+
+	for path := range matched {
+		e, ok := sourceFiles[path]
+		if !ok {
+			err := m.index.Remove(path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// Without stat data, the file is compared by content the next time
+		m.index.AddEntry(&IndexEntry{
+			Mode:  e.Mode,
+			Hash:  e.Hash,
+			Flags: nameFlags(path),
+			Path:  path,
+		})
+	}
+	return m.index.Store()
+}
+
+// matchPaths returns the files matched by the pathspecs. Every pathspec must match at least one file.
+func matchPaths(paths []string, files map[string]*IndexEntry) (map[string]*IndexEntry, error) {
+	ps, err := pathspec.Compile(paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ps.Patterns {
+		found := p.IsExclude()
+		for path := range files {
+			if !found && p.Match(path) {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("pathspec %q did not match any file known to mgi: %w", p.Original, os.ErrNotExist)
+		}
+	}
+
+	matched := make(map[string]*IndexEntry)
+	for path, e := range files {
+		if ps.Match(path) {
+			matched[path] = e
+		}
+	}
+	return matched, nil
+}
+
+// Reset unstages the given paths by restoring their index entries to HEAD, leaving the working
+// tree untouched. Paths that are not in HEAD are removed from the index. Without paths, the
+// whole index is reset.
+func (m *MGIService) Reset(paths []string) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	head, err := m.currentHead()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("cannot unstage files: there are no commits yet")
+	}
+
+	indexFiles, err := m.indexFiles()
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	return m.restoreIndex(paths, head, indexFiles)
+}
@@ -0,0 +1,193 @@
+package mgi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RevParse resolves a revision to the hash of the object it names, without peeling it.
+func (m *MGIService) RevParse(rev string) (string, error) {
+	return m.resolveRevision(rev)
+}
+
+// resolveRevision resolves a revision to an object. A revision is a name resolveObject
+// understands followed by any number of these operators:
+//
+//	~n      the n-th first-parent ancestor ("~" is "~1")
+//	^n      the n-th parent ("^" is "^1", "^0" is the commit itself)
+//	^{type} the object peeled to a commit, tree, blob or tag
+//	^{}     the object with annotated tags peeled off
+//
+// e.g. "HEAD~3", "master~2^2" or "v1.0^{tree}". A revision followed by ":<path>" names the blob
+// or tree at that path in the tree of the revision, e.g. "HEAD~1:src/main.go", and ":<path>"
+// alone names the blob staged at that path.
+func (m *MGIService) resolveRevision(rev string) (string, error) {
+	if treeish, path, ok := splitTreePath(rev); ok {
+		return m.resolveTreePath(treeish, path)
+	}
+
+	base, ops := splitRevision(rev)
+	h, err := m.resolveObject(base)
+	if err != nil {
+		return "", err
+	}
+	hash := h.String()
+
+	for ops != "" {
+		op := ops[0]
+		ops = ops[1:]
+
+		if op == '^' && strings.HasPrefix(ops, "{") {
+			end := strings.IndexByte(ops, '}')
+			if end < 0 {
+				return "", fmt.Errorf("revision %q: missing '}'", rev)
+			}
+			hash, err = m.peelRevision(hash, ops[1:end])
+			if err != nil {
+				return "", fmt.Errorf("revision %q: %v", rev, err)
+			}
+			ops = ops[end+1:]
+			continue
+		}
+
+		digits := len(ops) - len(strings.TrimLeft(ops, "0123456789"))
+		n := 1
+		if digits > 0 {
+			n, err = strconv.Atoi(ops[:digits])
+			if err != nil {
+				return "", fmt.Errorf("revision %q: %v", rev, err)
+			}
+		}
+		ops = ops[digits:]
+
+		switch op {
+		case '~':
+			hash, err = m.peelCommit(hash)
+			for i := 0; i < n && err == nil; i++ {
+				hash, err = m.nthParent(hash, 1)
+			}
+			if err != nil {
+				return "", fmt.Errorf("revision %q: %v", rev, err)
+			}
+		case '^':
+			if n == 0 {
+				hash, err = m.peelCommit(hash)
+			} else {
+				hash, err = m.nthParent(hash, n)
+			}
+			if err != nil {
+				return "", fmt.Errorf("revision %q: %v", rev, err)
+			}
+		default:
+			return "", fmt.Errorf("revision %q: unexpected %q", rev, op)
+		}
+	}
+	return hash, nil
+}
+
+// splitRevision splits a revision into the name it starts with and its "~" and "^" operators.
+// Operators inside a reflog selector ("@{...}") are part of the name.
+func splitRevision(rev string) (string, string) {
+	inBraces := false
+	for i := 0; i < len(rev); i++ {
+		switch {
+		case inBraces:
+			inBraces = rev[i] != '}'
+		case rev[i] == '{' && i > 0 && rev[i-1] == '@':
+			inBraces = true
+		case rev[i] == '~' || rev[i] == '^':
+			return rev[:i], rev[i:]
+		}
+	}
+	return rev, ""
+}
+
+// splitTreePath splits a "<rev>:<path>" revision at its first colon outside of a reflog
+// selector, which may contain a time (e.g. "HEAD@{2021-05-01 10:00:00}").
+func splitTreePath(rev string) (string, string, bool) {
+	inBraces := false
+	for i := 0; i < len(rev); i++ {
+		switch {
+		case inBraces:
+			inBraces = rev[i] != '}'
+		case rev[i] == '{' && i > 0 && rev[i-1] == '@':
+			inBraces = true
+		case rev[i] == ':':
+			return rev[:i], rev[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// resolveTreePath returns the object at path in the tree of the revision treeish, or the blob
+// staged at path if treeish is empty.
+func (m *MGIService) resolveTreePath(treeish, path string) (string, error) {
+	path = strings.Trim(path, "/")
+	if treeish == "" {
+		files, err := m.indexFiles()
+		if err != nil {
+			return "", err
+		}
+		e, ok := files[path]
+		if !ok {
+			return "", fmt.Errorf("path %q is not in the index", path)
+		}
+		return e.Hash.String(), nil
+	}
+
+	hash, err := m.resolveRevision(treeish)
+	if err != nil {
+		return "", err
+	}
+	tree, err := m.peelRevision(hash, "tree")
+	if err != nil {
+		return "", fmt.Errorf("revision %q: %v", treeish, err)
+	}
+	if path == "" {
+		return tree, nil
+	}
+	e, err := m.lookupPath(tree, path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", treeish, err)
+	}
+	return e.hash.String(), nil
+}
+
+// nthParent returns the n-th parent (starting at 1) of a commit, peeling tags first.
+func (m *MGIService) nthParent(hash string, n int) (string, error) {
+	commit, err := m.peelCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	c, err := m.readCommit(commit)
+	if err != nil {
+		return "", err
+	}
+	if n > len(c.Parents) {
+		if len(c.Parents) == 0 {
+			return "", fmt.Errorf("commit %s has no parents", commit)
+		}
+		return "", fmt.Errorf("commit %s has only %d parents", commit, len(c.Parents))
+	}
+	return c.Parents[n-1], nil
+}
+
+// peelRevision applies a "^{type}" operator to an object. An empty type peels annotated tags.
+func (m *MGIService) peelRevision(hash, objType string) (string, error) {
+	switch objType {
+	case "", "commit", "tree", "blob", "tag":
+	default:
+		return "", fmt.Errorf("unknown object type %q", objType)
+	}
+
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return "", err
+	}
+	h, err = peel(m.obj, h, objType)
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
@@ -0,0 +1,66 @@
+package mgi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveRevisionOperators(t *testing.T) {
+	repo := newTestRepo(t)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := commitAt(t, repo, "first", now)
+	second := commitAt(t, repo, "second", now, first)
+	side := commitAt(t, repo, "side", now, first)
+	merge := commitAt(t, repo, "merge", now, second, side)
+	err := repo.updateRef("refs/heads/master", "", merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.CreateTag("v1", second, "release", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := repo.readRef("refs/tags/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.readCommit(merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rev  string
+		want string
+	}{
+		{"HEAD", merge},
+		{"HEAD~0", merge},
+		{"HEAD^", second},
+		{"HEAD^1", second},
+		{"HEAD^2", side},
+		{"HEAD~2", first},
+		{"master^2~1", first},
+		{"HEAD^^", first},
+		{"v1", tag},
+		{"v1^{}", second},
+		{"v1^{commit}", second},
+		{"v1~1", first},
+		{"HEAD^{tree}", c.Tree},
+	}
+	for _, tt := range tests {
+		got, err := repo.resolveRevision(tt.rev)
+		if err != nil {
+			t.Errorf("%s: %v", tt.rev, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.rev, got, tt.want)
+		}
+	}
+
+	for _, rev := range []string{"HEAD^3", "HEAD~3", "HEAD^{blob}", "HEAD^{nothing}"} {
+		if got, err := repo.resolveRevision(rev); err == nil {
+			t.Errorf("%s = %s, want an error", rev, got)
+		}
+	}
+}
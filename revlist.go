@@ -0,0 +1,88 @@
+package mgi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RevList returns the commits selected by the revisions, like git rev-list, most recent first.
+// Each revision is one of:
+//   - "<rev>", to include the commits reachable from rev;
+//   - "^<rev>", to exclude the commits reachable from rev;
+//   - "<from>..<to>", for the commits reachable from to but not from from;
+//   - "<a>...<b>", for the commits reachable from either a or b but not from both.
+//
+// An empty end of a range means HEAD. If maxCount isn't negative, at most that many commits
+// are returned.
+func (m *MGIService) RevList(revs []string, maxCount int) ([]string, error) {
+	var include, exclude []string
+	for _, rev := range revs {
+		if i := strings.Index(rev, "..."); i >= 0 {
+			a, err := m.resolveCommit(orHead(rev[:i]))
+			if err != nil {
+				return nil, err
+			}
+			b, err := m.resolveCommit(orHead(rev[i+3:]))
+			if err != nil {
+				return nil, err
+			}
+			bases, err := m.mergeBases(a, b)
+			if err != nil {
+				return nil, err
+			}
+			include = append(include, a, b)
+			exclude = append(exclude, bases...)
+			continue
+		}
+		if i := strings.Index(rev, ".."); i >= 0 {
+			from, err := m.resolveCommit(orHead(rev[:i]))
+			if err != nil {
+				return nil, err
+			}
+			to, err := m.resolveCommit(orHead(rev[i+2:]))
+			if err != nil {
+				return nil, err
+			}
+			include = append(include, to)
+			exclude = append(exclude, from)
+			continue
+		}
+		if strings.HasPrefix(rev, "^") {
+			hash, err := m.resolveCommit(rev[1:])
+			if err != nil {
+				return nil, err
+			}
+			exclude = append(exclude, hash)
+			continue
+		}
+		hash, err := m.resolveCommit(rev)
+		if err != nil {
+			return nil, err
+		}
+		include = append(include, hash)
+	}
+	if len(include) == 0 {
+		return nil, fmt.Errorf("no revisions to list")
+	}
+
+	excluded, err := m.reachable(exclude...)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := m.logOrder(include, excluded, false)
+	if err != nil {
+		return nil, err
+	}
+	if maxCount >= 0 && len(hashes) > maxCount {
+		hashes = hashes[:maxCount]
+	}
+	return hashes, nil
+}
+
+// orHead returns rev, or HEAD if it is empty, for the ends of ranges.
+func orHead(rev string) string {
+	if rev == "" {
+		return "HEAD"
+	}
+	return rev
+}
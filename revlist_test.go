@@ -0,0 +1,52 @@
+package mgi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRevList(t *testing.T) {
+	repo := newTestRepo(t)
+	commits := mergeHistory(t, repo)
+	err := repo.updateRef("refs/heads/side", "", commits["C"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		revs     []string
+		maxCount int
+		want     []string
+	}{
+		{[]string{"master"}, -1, []string{"M", "D", "B", "C", "A"}},
+		{[]string{"master"}, 2, []string{"M", "D"}},
+		{[]string{"master"}, 0, nil},
+		{[]string{"side..master"}, -1, []string{"M", "D", "B"}},
+		{[]string{"side.."}, -1, []string{"M", "D", "B"}},
+		{[]string{"master..side"}, -1, nil},
+		{[]string{"master", "^side"}, -1, []string{"M", "D", "B"}},
+		{[]string{"master", "^" + commits["D"]}, -1, []string{"M", "C"}},
+		{[]string{commits["D"] + "...side"}, -1, []string{"D", "B", "C"}},
+		{[]string{"side", commits["B"]}, -1, []string{"B", "C", "A"}},
+	} {
+		hashes, err := repo.RevList(tt.revs, tt.maxCount)
+		if err != nil {
+			t.Fatalf("RevList(%q, %d): %v", tt.revs, tt.maxCount, err)
+		}
+		var got []string
+		for _, hash := range hashes {
+			for subject, c := range commits {
+				if c == hash {
+					got = append(got, subject)
+				}
+			}
+		}
+		if len(got) != len(hashes) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RevList(%q, %d) listed %q, want %q", tt.revs, tt.maxCount, got, tt.want)
+		}
+	}
+
+	if _, err := repo.RevList([]string{"^master"}, -1); err == nil {
+		t.Error("RevList succeeded without commits to include")
+	}
+}
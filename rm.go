@@ -0,0 +1,84 @@
+package mgi
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bertinatto/mgi/pathspec"
+)
+
+// Rm removes the files matched by the pathspecs from the index and, unless cached is set, from
+// the working tree. Pathspecs may be glob patterns, which are expanded against the index, and
+// directories, which require recursive. It returns the removed paths; with dryRun nothing is
+// actually removed.
+func (m *MGIService) Rm(pathspecs []string, cached, recursive, dryRun bool) ([]string, error) {
+	if err := m.index.Lock(); err != nil {
+		return nil, err
+	}
+	defer m.index.Unlock()
+
+	index, err := m.indexFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := expandPathspecs(pathspecs, index, recursive)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return paths, nil
+	}
+
+	for _, p := range paths {
+		err := m.index.Remove(p)
+		if err != nil {
+			return nil, err
+		}
+		if !cached {
+			err := removeFile(p)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return paths, m.index.Store()
+}
+
+// expandPathspecs returns the tracked paths matched by the pathspecs, sorted. Globs only match
+// tracked files, and every pathspec must match at least one of them. Directories are only
+// matched if recursive is set.
+func expandPathspecs(specs []string, files map[string]*IndexEntry, recursive bool) ([]string, error) {
+	ps, err := pathspec.Compile(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range ps.Patterns {
+		if p.IsExclude() {
+			continue
+		}
+		found := false
+		for path := range files {
+			if !p.Match(path) {
+				continue
+			}
+			if !recursive && !p.IsGlob() && path != p.Path() {
+				return nil, fmt.Errorf("not removing %q recursively without -r", p.Original)
+			}
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("pathspec %q did not match any tracked files", p.Original)
+		}
+	}
+
+	var paths []string
+	for path := range files {
+		if ps.Match(path) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,428 @@
+package mgi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The server side of git's smart HTTP protocol (version 0), which lets git clone and fetch
+// from a repository: clients first GET /info/refs?service=git-upload-pack to list its refs,
+// then POST the objects they want, and the ones they have, to /git-upload-pack, which answers
+// with a packfile. Clients may ask for a shallow history of a given depth, as git clone --depth
+// does. Pushing (git-receive-pack) is not supported.
+//
+// Only these two endpoints are served. The files of the repository are never served directly,
+// so nothing outside of its objects and refs can be read through the server.
+
+// uploadPackCapabilities are the capabilities advertised along with the first ref. Objects are
+// sent whole, without deltas, and there is no side band, so the pack follows the
+// acknowledgments directly. Shallow histories can be asked for with "deepen <depth>".
+const uploadPackCapabilities = "shallow agent=mgi"
+
+// HTTPHandler returns a handler serving the repository over git's smart HTTP protocol, for git
+// clone and fetch. The repository can be at any URL prefix, e.g. http://host/repo.git.
+// Requests are handled one at a time, since the service isn't safe for concurrent use.
+func (m *MGIService) HTTPHandler() http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var err error
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info/refs") && r.Method == http.MethodGet:
+			if r.URL.Query().Get("service") != "git-upload-pack" {
+				http.Error(w, "only the smart protocol of git-upload-pack is supported", http.StatusForbidden)
+				return
+			}
+			err = m.advertiseRefs(w)
+		case strings.HasSuffix(r.URL.Path, "/git-upload-pack") && r.Method == http.MethodPost:
+			err = m.uploadPack(w, r)
+		case strings.HasSuffix(r.URL.Path, "/git-receive-pack"):
+			http.Error(w, "pushing is not supported", http.StatusForbidden)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			m.logger.Infof("serve: %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	})
+}
+
+// advertiseRefs lists HEAD and the refs of the repository, with annotated tags followed by the
+// object they point to.
+func (m *MGIService) advertiseRefs(w http.ResponseWriter) error {
+	refs, err := m.advertisedRefs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	capabilities := uploadPackCapabilities
+	if len(refs) > 0 && refs[0].Name == "HEAD" {
+		if ref, err := m.headRef(); err == nil && ref != "" {
+			capabilities = "symref=HEAD:" + ref + " " + capabilities
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	bw := bufio.NewWriter(w)
+	writePktLine(bw, "# service=git-upload-pack\n")
+	writeFlushPkt(bw)
+	if len(refs) == 0 {
+		writePktLine(bw, "%s capabilities^{}\x00%s\n", strings.Repeat("0", 40), capabilities)
+	}
+	for i, ref := range refs {
+		if i == 0 {
+			writePktLine(bw, "%s %s\x00%s\n", ref.Hash, ref.Name, capabilities)
+		} else {
+			writePktLine(bw, "%s %s\n", ref.Hash, ref.Name)
+		}
+	}
+	writeFlushPkt(bw)
+	return bw.Flush()
+}
+
+// advertisedRefs returns HEAD, unless there are no commits yet, and the refs of the repository,
+// each annotated tag followed by the object it points to as "<tag>^{}".
+func (m *MGIService) advertisedRefs() ([]*Ref, error) {
+	refs, err := m.listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	head, err := m.currentHead()
+	if err != nil {
+		return nil, err
+	}
+	var advertised []*Ref
+	if head != "" {
+		advertised = append(advertised, &Ref{Name: "HEAD", Hash: head})
+	}
+	for _, ref := range refs {
+		advertised = append(advertised, ref)
+		if !strings.HasPrefix(ref.Name, "refs/tags/") {
+			continue
+		}
+		h, err := new(Hash).FromString(ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+		peeled, err := peel(m.obj, h, "")
+		if err != nil {
+			return nil, err
+		}
+		if peeled.String() != ref.Hash {
+			advertised = append(advertised, &Ref{Name: ref.Name + "^{}", Hash: peeled.String()})
+		}
+	}
+	return advertised, nil
+}
+
+// checkWants fails unless every wanted object is one of the advertised refs or reachable from
+// them, so that objects that are no longer referenced can't be fetched.
+func (m *MGIService) checkWants(wants []string) error {
+	refs, err := m.advertisedRefs()
+	if err != nil {
+		return err
+	}
+	allowed := make(map[string]bool)
+	var tips []string
+	for _, ref := range refs {
+		allowed[ref.Hash] = true
+		tips = append(tips, ref.Hash)
+	}
+	walked := false
+	for _, want := range wants {
+		if allowed[want] {
+			continue
+		}
+		if !walked {
+			objects, err := m.reachableObjects(tips)
+			if err != nil {
+				return err
+			}
+			for _, h := range objects {
+				allowed[h.String()] = true
+			}
+			walked = true
+		}
+		if !allowed[want] {
+			return fmt.Errorf("not our ref %s", want)
+		}
+	}
+	return nil
+}
+
+// uploadPack answers a request for objects. Until the client says it is done, it only
+// acknowledges that it has none of the objects the client has, so that the client sends all
+// the ones it cares about. It then sends the objects reachable from the wanted ones but not
+// from the ones the client has. Only the advertised refs and the objects reachable from them can
+// be wanted.
+//
+// Clients with a shallow history list the commits at its boundary, whose parents they don't
+// have, with "shallow <commit>", and may ask for only depth commits from the wanted ones with
+// "deepen <depth>". The answer then starts with the commits that become the new boundary
+// ("shallow <commit>") and the ones that no longer are ("unshallow <commit>").
+func (m *MGIService) uploadPack(w http.ResponseWriter, r *http.Request) error {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var wants, haves []string
+	clientShallow := make(map[string]bool)
+	depth := 0
+	// The wants are followed by a flush, and so is each round of haves until the client is done
+	flushes := 0
+	done := false
+	br := bufio.NewReader(body)
+	for !done {
+		line, err := readPktLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			flushes++
+			continue
+		}
+		switch fields[0] {
+		case "want", "have":
+			if len(fields) < 2 {
+				err = fmt.Errorf("malformed line %q", line)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return err
+			}
+			h, err := new(Hash).FromString(fields[1])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return err
+			}
+			if fields[0] == "want" {
+				wants = append(wants, h.String())
+			} else if exists, err := m.obj.Exists(h); err == nil && exists {
+				haves = append(haves, h.String())
+			}
+		case "shallow":
+			if len(fields) < 2 {
+				err = fmt.Errorf("malformed line %q", line)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return err
+			}
+			clientShallow[fields[1]] = true
+		case "deepen":
+			if len(fields) >= 2 {
+				depth, err = strconv.Atoi(fields[1])
+			}
+			if len(fields) < 2 || err != nil || depth <= 0 {
+				err = fmt.Errorf("malformed line %q", line)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return err
+			}
+		case "done":
+			done = true
+		}
+	}
+
+	err := m.checkWants(wants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	// The walks stop at the boundary of the client's history, unless it is deepened
+	stop := clientShallow
+	var shallow, unshallow []string
+	if depth > 0 {
+		reached, boundary, err := m.shallowBoundary(wants, depth)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		stop = make(map[string]bool)
+		for hash := range clientShallow {
+			if reached[hash] && !boundary[hash] {
+				unshallow = append(unshallow, hash)
+			} else {
+				stop[hash] = true
+			}
+		}
+		for hash := range boundary {
+			stop[hash] = true
+			if !clientShallow[hash] {
+				shallow = append(shallow, hash)
+			}
+		}
+		sort.Strings(shallow)
+		sort.Strings(unshallow)
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	bw := bufio.NewWriter(w)
+	if depth > 0 {
+		for _, hash := range shallow {
+			writePktLine(bw, "shallow %s\n", hash)
+		}
+		for _, hash := range unshallow {
+			writePktLine(bw, "unshallow %s\n", hash)
+		}
+		writeFlushPkt(bw)
+	}
+	// A request that ends right after the wants only asks for the new shallow boundary
+	if !done && flushes < 2 {
+		return bw.Flush()
+	}
+	if len(haves) == 0 {
+		writePktLine(bw, "NAK\n")
+	} else {
+		writePktLine(bw, "ACK %s\n", haves[0])
+	}
+	if !done {
+		return bw.Flush()
+	}
+
+	objects, err := m.reachableObjectsUntil(wants, stop)
+	if err != nil {
+		return err
+	}
+	if len(haves) > 0 {
+		common, err := m.reachableObjectsUntil(haves, clientShallow)
+		if err != nil {
+			return err
+		}
+		excluded := make(map[string]bool, len(common))
+		for _, h := range common {
+			excluded[h.String()] = true
+		}
+		kept := objects[:0]
+		for _, h := range objects {
+			if !excluded[h.String()] {
+				kept = append(kept, h)
+			}
+		}
+		objects = kept
+	}
+	m.logger.Debugf("serve: sending %d objects", len(objects))
+	err = writePack(m.obj, bw, objects)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// shallowBoundary walks the history of the wanted objects down to depth commits, counting the
+// wanted ones, and returns the commits it reaches and the ones at its boundary, whose parents
+// are not sent. Commits without parents are never part of the boundary.
+func (m *MGIService) shallowBoundary(wants []string, depth int) (reached, boundary map[string]bool, err error) {
+	shallow, err := m.readShallow()
+	if err != nil {
+		return nil, nil, err
+	}
+	var level []string
+	for _, want := range wants {
+		h, err := new(Hash).FromString(want)
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err = peel(m.obj, h, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		objType, _, err := m.obj.ReadTypedObject(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		if objType == "commit" {
+			level = append(level, h.String())
+		}
+	}
+
+	// Level by level, so that each commit is reached through its shortest path
+	reached, boundary = make(map[string]bool), make(map[string]bool)
+	graph := m.CommitGraph()
+	for d := 1; len(level) > 0; d++ {
+		var next []string
+		for _, hash := range level {
+			if reached[hash] {
+				continue
+			}
+			reached[hash] = true
+			n, err := graph.node(hash)
+			if errors.Is(err, ErrObjectNotFound) && d > 1 {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(n.Parents) == 0 {
+				continue
+			}
+			// The repository may be shallow itself
+			if d >= depth || shallow[hash] {
+				boundary[hash] = true
+				continue
+			}
+			next = append(next, n.Parents...)
+		}
+		level = next
+	}
+	return reached, boundary, nil
+}
+
+// writePktLine writes a line in the pkt-line format: its length, including the 4 bytes of the
+// length itself, in hexadecimal, followed by the line.
+func writePktLine(w io.Writer, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	fmt.Fprintf(w, "%04x%s", len(line)+4, line)
+}
+
+// writeFlushPkt writes the flush packet that ends a section of pkt-lines.
+func writeFlushPkt(w io.Writer) {
+	io.WriteString(w, "0000")
+}
+
+// readPktLine reads a line in the pkt-line format. Flush packets are returned as empty lines.
+func readPktLine(r io.Reader) (string, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("malformed pkt-line length %q", header)
+	}
+	if n == 0 {
+		return "", nil
+	}
+	if n < 4 {
+		return "", fmt.Errorf("malformed pkt-line length %q", header)
+	}
+	data := make([]byte, n-4)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
@@ -0,0 +1,48 @@
+package mgi
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUploadPackOnlySendsReachableObjects(t *testing.T) {
+	repo := newTestRepo(t)
+	first := commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	second := commitTestFiles(t, repo, "second", map[string]string{"a": "2\n"})
+	// The second commit is no longer referenced, but it's still in the object store
+	err := repo.updateRef("refs/heads/master", second, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := repo.readCommit(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(repo.HTTPHandler())
+	defer server.Close()
+	fetch := func(want string) int {
+		t.Helper()
+		var body bytes.Buffer
+		writePktLine(&body, "want %s\n", want)
+		writeFlushPkt(&body)
+		writePktLine(&body, "done\n")
+		resp, err := http.Post(server.URL+"/repo.git/git-upload-pack", "application/x-git-upload-pack-request", &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, want := range []string{first, tree.Tree} {
+		if status := fetch(want); status != http.StatusOK {
+			t.Errorf("wanting %s, reachable from master: status %d", want, status)
+		}
+	}
+	if status := fetch(second); status != http.StatusBadRequest {
+		t.Errorf("wanting %s, which isn't reachable: status %d, want %d", second, status, http.StatusBadRequest)
+	}
+}
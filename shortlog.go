@@ -0,0 +1,60 @@
+package mgi
+
+import (
+	"sort"
+)
+
+// ShortlogAuthor is an author listed by Shortlog, with the subjects of their commits.
+type ShortlogAuthor struct {
+	Name     string
+	Email    string
+	Subjects []string
+}
+
+// Shortlog groups the commits selected by revs, as in RevList, or the history of HEAD if none
+// are given, by author, like git shortlog. Authors are told apart by both their name and email.
+// They are sorted by name, or by number of commits, most first, if byCount is set, and the
+// subjects of the commits of each are listed oldest first. A history without commits has no
+// authors.
+func (m *MGIService) Shortlog(revs []string, byCount bool) ([]*ShortlogAuthor, error) {
+	if len(revs) == 0 {
+		head, err := m.currentHead()
+		if err != nil || head == "" {
+			return nil, err
+		}
+		revs = []string{head}
+	}
+	hashes, err := m.RevList(revs, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	byIdentity := make(map[string]*ShortlogAuthor)
+	var authors []*ShortlogAuthor
+	for i := len(hashes) - 1; i >= 0; i-- {
+		c, err := m.readCommit(hashes[i])
+		if err != nil {
+			return nil, err
+		}
+		identity := c.Author + " <" + c.AuthorEmail + ">"
+		a, ok := byIdentity[identity]
+		if !ok {
+			a = &ShortlogAuthor{Name: c.Author, Email: c.AuthorEmail}
+			byIdentity[identity] = a
+			authors = append(authors, a)
+		}
+		a.Subjects = append(a.Subjects, subject(c.Message))
+	}
+
+	sort.Slice(authors, func(i, j int) bool {
+		a, b := authors[i], authors[j]
+		if byCount && len(a.Subjects) != len(b.Subjects) {
+			return len(a.Subjects) > len(b.Subjects)
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Email < b.Email
+	})
+	return authors, nil
+}
@@ -0,0 +1,96 @@
+package mgi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Show writes the object rev names to w like git show does:
+//   - a blob as it is stored, e.g. for "HEAD~2:src/main.go";
+//   - a tree as the list of its entries, with "/" after subdirectories;
+//   - a commit in the given format, as in the log, followed by its changes unless it is a merge;
+//   - an annotated tag as its tagger and message, followed by the object it points to.
+func (m *MGIService) Show(rev string, pretty *PrettyFormat, w io.Writer) error {
+	hash, err := m.resolveRevision(rev)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	err = m.showObject(rev, hash, pretty, bw)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (m *MGIService) showObject(rev, hash string, pretty *PrettyFormat, w *bufio.Writer) error {
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return err
+	}
+	objType, data, err := m.obj.ReadTypedObject(h)
+	if err != nil {
+		return err
+	}
+
+	switch objType {
+	case "blob":
+		_, err = w.Write(data)
+		return err
+	case "tree":
+		tree, err := ParseTree(data)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "tree %s\n\n", rev)
+		for _, e := range tree.Entries {
+			if e.mode == modeDir {
+				fmt.Fprintf(w, "%s/\n", e.path)
+			} else {
+				fmt.Fprintf(w, "%s\n", e.path)
+			}
+		}
+		return nil
+	case "tag":
+		tag, err := ParseTag(data)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "tag %s\nTagger: %s <%s>\nDate:   %s\n\n%s\n\n", tag.Name, tag.Tagger, tag.TaggerEmail,
+			formatDate(tag.TagTime, pretty.Date, time.Now()), tag.Message)
+		return m.showObject(tag.Object, tag.Object, pretty, w)
+	case "commit":
+		return m.showCommit(hash, pretty, w)
+	}
+	return fmt.Errorf("cannot show object %s of type %q", hash, objType)
+}
+
+// showCommit writes the commit in the given format, followed by the changes it made to its first
+// parent, or to an empty tree if it is a root commit.
+func (m *MGIService) showCommit(hash string, pretty *PrettyFormat, w *bufio.Writer) error {
+	c, err := m.readCommit(hash)
+	if err != nil {
+		return err
+	}
+	e := &LogEntry{Hash: hash, Commit: c}
+	err = m.readLogNotes([]*LogEntry{e})
+	if err != nil {
+		return err
+	}
+	for _, line := range m.FormatLogEntry(e, pretty) {
+		fmt.Fprintf(w, "%s\n", line)
+	}
+	diffs, err := m.CommitDiff(hash, nil)
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 && pretty.SeparatesDiff() {
+		fmt.Fprintf(w, "\n")
+	}
+	for _, d := range diffs {
+		fmt.Fprint(w, d)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package mgi
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// signCommit signs the payload of the commit and sets its signature, which is written to the
+// gpgsig header like git does.
+//
+// The key is user.signingkey. With gpg.format set to "ssh", it is signed with ssh-keygen
+// (gpg.ssh.program), and the key is the path of a key file, or a public key prefixed with
+// "key::" whose private key is in the SSH agent. Otherwise it is signed with gpg (gpg.program),
+// and without a key, gpg picks the one of the committer.
+func (m *MGIService) signCommit(c *Commit) error {
+	config, err := NewConfigService(m.common).Read()
+	if err != nil {
+		return err
+	}
+	key, _ := config.Get("user.signingkey")
+	format, _ := config.Get("gpg.format")
+
+	var signature []byte
+	switch format {
+	case "", "openpgp":
+		program, ok := config.Get("gpg.program")
+		if !ok {
+			program = "gpg"
+		}
+		if key == "" {
+			key = fmt.Sprintf("%s <%s>", c.Author, c.AuthorEmail)
+		}
+		signature, err = runSigner(c.Payload(), program, "--status-fd=2", "-bsau", key)
+	case "ssh":
+		program, ok := config.Get("gpg.ssh.program")
+		if !ok {
+			program = "ssh-keygen"
+		}
+		if key == "" {
+			return fmt.Errorf("user.signingkey needs to be set for ssh signing")
+		}
+		keyFile := key
+		if strings.HasPrefix(key, "key::") {
+			// ssh-keygen reads public keys from files only
+			keyFile, err = writeTempFile(".mgi_signing_key_", []byte(strings.TrimPrefix(key, "key::")+"\n"))
+			if err != nil {
+				return err
+			}
+			defer os.Remove(keyFile)
+		}
+		signature, err = runSigner(c.Payload(), program, "-Y", "sign", "-n", "git", "-f", keyFile)
+	default:
+		return fmt.Errorf("unsupported gpg.format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	c.Signature = string(signature)
+	return nil
+}
+
+// runSigner runs a signing program on the payload and returns the signature it writes to its
+// output.
+func runSigner(payload []byte, program string, args ...string) ([]byte, error) {
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed to sign the data: %v\n%s", program, err, stderr)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s failed to sign the data: no signature", program)
+	}
+	return out, nil
+}
+
+// writeTempFile writes the data to a new temporary file and returns its path. The caller must
+// remove it.
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// VerifyCommit checks the signature of the commit rev names. It returns the report of the
+// verifier, which names the signer, and an error if the commit isn't signed or the signature
+// isn't good. The report is returned along with the error if the verifier ran.
+func (m *MGIService) VerifyCommit(rev string) (string, error) {
+	hash, err := m.resolveCommit(rev)
+	if err != nil {
+		return "", err
+	}
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return "", err
+	}
+	data, err := m.obj.ReadObject(h)
+	if err != nil {
+		return "", err
+	}
+	payload, signature := splitCommitSignature(data)
+	if signature == nil {
+		return "", fmt.Errorf("commit %s has no signature", hash)
+	}
+	return m.verifySignature(payload, signature)
+}
+
+// VerifyTag is like VerifyCommit, for the annotated tag rev names, e.g. "v1.0".
+func (m *MGIService) VerifyTag(rev string) (string, error) {
+	hash, err := m.resolveRevision(rev)
+	if err != nil {
+		return "", err
+	}
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return "", err
+	}
+	objType, data, err := m.obj.ReadTypedObject(h)
+	if err != nil {
+		return "", err
+	}
+	if objType != "tag" {
+		return "", fmt.Errorf("%s: cannot verify a non-tag object of type %s", rev, objType)
+	}
+	payload, signature := splitTagSignature(data)
+	if signature == nil {
+		return "", fmt.Errorf("tag %s has no signature", rev)
+	}
+	return m.verifySignature(payload, signature)
+}
+
+// splitCommitSignature splits the contents of a commit object into the payload that was signed,
+// i.e. the commit without its gpgsig header, and the signature, which is nil if there is none.
+func splitCommitSignature(data []byte) ([]byte, []byte) {
+	var payload, signature []byte
+	inSignature, inHeaders := false, true
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		switch {
+		case !inHeaders:
+		case len(bytes.TrimSuffix(line, []byte("\n"))) == 0:
+			inHeaders = false
+			inSignature = false
+		case bytes.HasPrefix(line, []byte("gpgsig ")):
+			signature = append(signature, line[len("gpgsig "):]...)
+			inSignature = true
+			continue
+		case inSignature && bytes.HasPrefix(line, []byte(" ")):
+			signature = append(signature, line[1:]...)
+			continue
+		default:
+			inSignature = false
+		}
+		payload = append(payload, line...)
+	}
+	return payload, signature
+}
+
+// signatureStarts are the lines that start the signatures appended to the message of tags.
+var signatureStarts = []string{
+	"-----BEGIN PGP SIGNATURE-----",
+	"-----BEGIN PGP MESSAGE-----",
+	"-----BEGIN SSH SIGNATURE-----",
+}
+
+// splitTagSignature splits the contents of a tag object into the payload that was signed and
+// the signature that ends its message, which is nil if there is none.
+func splitTagSignature(data []byte) ([]byte, []byte) {
+	start := -1
+	for _, marker := range signatureStarts {
+		i := bytes.LastIndex(data, []byte("\n"+marker+"\n"))
+		if i >= 0 && i+1 > start {
+			start = i + 1
+		}
+	}
+	if start < 0 {
+		return data, nil
+	}
+	return data[:start], data[start:]
+}
+
+// verifySignature checks the signature of the payload with gpg (gpg.program), or with ssh-keygen
+// (gpg.ssh.program) for SSH signatures. SSH signers must be listed in the file given by
+// gpg.ssh.allowedSignersFile.
+func (m *MGIService) verifySignature(payload, signature []byte) (string, error) {
+	config, err := NewConfigService(m.common).Read()
+	if err != nil {
+		return "", err
+	}
+	sigFile, err := writeTempFile(".mgi_signature_", signature)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(sigFile)
+
+	if !bytes.HasPrefix(signature, []byte("-----BEGIN SSH SIGNATURE-----")) {
+		program, ok := config.Get("gpg.program")
+		if !ok {
+			program = "gpg"
+		}
+		cmd := exec.Command(program, "--keyid-format=long", "--status-fd=1", "--verify", sigFile, "-")
+		cmd.Stdin = bytes.NewReader(payload)
+		stderr := new(bytes.Buffer)
+		cmd.Stderr = stderr
+		status, err := cmd.Output()
+		report := stderr.String()
+		if err != nil {
+			return report, fmt.Errorf("%s could not verify the signature: %v", program, err)
+		}
+		if !bytes.Contains(status, []byte("[GNUPG:] GOODSIG ")) || bytes.Contains(status, []byte("[GNUPG:] BADSIG ")) {
+			return report, fmt.Errorf("the signature is not good")
+		}
+		return report, nil
+	}
+
+	program, ok := config.Get("gpg.ssh.program")
+	if !ok {
+		program = "ssh-keygen"
+	}
+	allowed, ok := config.Get("gpg.ssh.allowedSignersFile")
+	if !ok {
+		return "", fmt.Errorf("gpg.ssh.allowedSignersFile needs to be set to verify ssh signatures")
+	}
+	out, err := exec.Command(program, "-Y", "find-principals", "-f", allowed, "-s", sigFile).Output()
+	principals := strings.Fields(string(out))
+	if err != nil || len(principals) == 0 {
+		return "No principal matched.\n", fmt.Errorf("the signer is not in %s", allowed)
+	}
+	cmd := exec.Command(program, "-Y", "verify", "-f", allowed, "-I", principals[0], "-n", "git", "-s", sigFile)
+	cmd.Stdin = bytes.NewReader(payload)
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s could not verify the signature: %v", program, err)
+	}
+	return string(out), nil
+}
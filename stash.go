@@ -0,0 +1,290 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stashRef points to the most recent stash entry. Older entries are kept in its reflog.
+const stashRef = "refs/stash"
+
+// Stash saves the staged and unstaged changes as a new entry on top of the stash, and then
+// resets the index and the working tree to HEAD. Each entry is stored as a commit whose tree
+// is the working tree, with HEAD and a commit of the index as parents, like git does.
+func (m *MGIService) Stash(message string) error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	head, err := m.currentHead()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+	headCommit, err := m.readCommit(head)
+	if err != nil {
+		return err
+	}
+	headFiles, err := m.flattenTree(headCommit.Tree)
+	if err != nil {
+		return err
+	}
+
+	indexFiles, err := m.indexFiles()
+	if err != nil {
+		return err
+	}
+	indexTree, err := m.writeFilesTree(indexFiles)
+	if err != nil {
+		return err
+	}
+	workFiles, err := m.workingFiles(indexFiles)
+	if err != nil {
+		return err
+	}
+	workTree, err := m.writeFilesTree(workFiles)
+	if err != nil {
+		return err
+	}
+
+	if indexTree == headCommit.Tree && workTree == headCommit.Tree {
+		return fmt.Errorf("no local changes to save")
+	}
+
+	branch, err := m.headRef()
+	if err != nil {
+		return err
+	}
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+	if branch == "" {
+		branch = "(no branch)"
+	}
+	headHash, err := new(Hash).FromString(head)
+	if err != nil {
+		return err
+	}
+	desc := fmt.Sprintf("%s: %s %s", branch, m.obj.Abbrev(headHash), subject(headCommit.Message))
+
+	indexCommit, err := m.storeCommit(indexTree, []string{head}, "index on "+desc, false)
+	if err != nil {
+		return err
+	}
+	if message == "" {
+		message = "WIP on " + desc
+	} else {
+		message = fmt.Sprintf("On %s: %s", branch, message)
+	}
+	stashCommit, err := m.storeCommit(workTree, []string{head, indexCommit}, message, false)
+	if err != nil {
+		return err
+	}
+
+	old, err := m.readRef(stashRef)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = m.updateRef(stashRef, old, stashCommit)
+	if err != nil {
+		return err
+	}
+	err = m.appendReflog(stashRef, old, stashCommit, message)
+	if err != nil {
+		return err
+	}
+
+	// Go back to HEAD, removing the files that were added since then
+	for path := range indexFiles {
+		if _, ok := headFiles[path]; !ok {
+			err := removeFile(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for path, e := range headFiles {
+		if w, ok := workFiles[path]; ok && w.Hash.String() == e.Hash.String() {
+			continue
+		}
+		err := m.checkoutFile(path, e.Hash, e.Mode)
+		if err != nil {
+			return err
+		}
+	}
+	return m.resetIndex(headFiles)
+}
+
+// StashPop restores the most recent stash entry and removes it from the stash.
+// It refuses to run if there are local changes, since they could be overwritten.
+func (m *MGIService) StashPop() error {
+	if err := m.index.Lock(); err != nil {
+		return err
+	}
+	defer m.index.Unlock()
+
+	entry, err := m.stashEntry(0)
+	if err != nil {
+		return err
+	}
+	stash, err := m.readCommit(entry.New)
+	if err != nil {
+		return err
+	}
+	if len(stash.Parents) < 2 {
+		return fmt.Errorf("%s is not a stash commit", entry.New)
+	}
+
+	headFiles, err := m.headFiles()
+	if err != nil {
+		return err
+	}
+	indexFiles, err := m.indexFiles()
+	if err != nil {
+		return err
+	}
+	workFiles, err := m.workingFiles(indexFiles)
+	if err != nil {
+		return err
+	}
+	if !sameFiles(headFiles, indexFiles) || !sameFiles(indexFiles, workFiles) {
+		return fmt.Errorf("local changes would be overwritten, commit or stash them first")
+	}
+
+	stashWork, err := m.flattenTree(stash.Tree)
+	if err != nil {
+		return err
+	}
+	stashIndex, err := m.commitFiles(stash.Parents[1])
+	if err != nil {
+		return err
+	}
+
+	for path := range indexFiles {
+		if _, ok := stashWork[path]; !ok {
+			err := removeFile(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for path, e := range stashWork {
+		if w, ok := workFiles[path]; ok && w.Hash.String() == e.Hash.String() {
+			continue
+		}
+		err := m.checkoutFile(path, e.Hash, e.Mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Staged files that were later removed from the working tree can't be stat'ed, so they are dropped
+	for path := range stashIndex {
+		if _, err := os.Lstat(path); err != nil {
+			delete(stashIndex, path)
+		}
+	}
+	err = m.resetIndex(stashIndex)
+	if err != nil {
+		return err
+	}
+
+	return m.StashDrop(0)
+}
+
+// StashList returns a description of each stash entry, most recent first.
+func (m *MGIService) StashList() ([]string, error) {
+	entries, err := m.readReflog(stashRef)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		list = append(list, fmt.Sprintf("stash@{%d}: %s", len(entries)-1-i, entries[i].Message))
+	}
+	return list, nil
+}
+
+// StashShow returns the diff between the n-th stash entry and the commit it was created on.
+func (m *MGIService) StashShow(n int) ([]string, error) {
+	entry, err := m.stashEntry(n)
+	if err != nil {
+		return nil, err
+	}
+	stash, err := m.readCommit(entry.New)
+	if err != nil {
+		return nil, err
+	}
+	if len(stash.Parents) == 0 {
+		return nil, fmt.Errorf("%s is not a stash commit", entry.New)
+	}
+
+	base, err := m.commitFiles(stash.Parents[0])
+	if err != nil {
+		return nil, err
+	}
+	files, err := m.flattenTree(stash.Tree)
+	if err != nil {
+		return nil, err
+	}
+	return m.diffFiles(base, files, DiffOptions{}, nil)
+}
+
+// StashDrop removes the n-th stash entry.
+func (m *MGIService) StashDrop(n int) error {
+	entries, err := m.readReflog(stashRef)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(entries) {
+		return fmt.Errorf("stash@{%d} does not exist", n)
+	}
+
+	top := entries[len(entries)-1].New
+	i := len(entries) - 1 - n
+	entries = append(entries[:i], entries[i+1:]...)
+	if len(entries) == 0 {
+		err := os.Remove(m.gitPath(stashRef))
+		if err != nil {
+			return err
+		}
+		return os.Remove(m.gitPath("logs/" + stashRef))
+	}
+
+	err = m.writeReflog(stashRef, entries)
+	if err != nil {
+		return err
+	}
+	return m.updateRef(stashRef, top, entries[len(entries)-1].New)
+}
+
+// stashEntry returns the reflog entry of the n-th stash, where 0 is the most recent.
+func (m *MGIService) stashEntry(n int) (*ReflogEntry, error) {
+	entries, err := m.readReflog(stashRef)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no stash entries found")
+	}
+	if n < 0 || n >= len(entries) {
+		return nil, fmt.Errorf("stash@{%d} does not exist", n)
+	}
+	return entries[len(entries)-1-n], nil
+}
+
+// sameFiles returns whether both sets have the same paths with the same contents.
+func sameFiles(a, b map[string]*IndexEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, e := range a {
+		other, ok := b[path]
+		if !ok || other.Hash.String() != e.Hash.String() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package mgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStatusSkipsOnlyTheGitDirectory(t *testing.T) {
+	repo := newTestRepo(t)
+	commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	writeTestFile(t, "my.git/notes", "notes\n")
+	writeTestFile(t, ".gitx", "x\n")
+	writeTestFile(t, "sub/.git/config", "not the repository\n")
+
+	untracked, modified, _, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(untracked)
+	if want := []string{".gitx", "my.git/notes", "sub/"}; !reflect.DeepEqual(untracked, want) {
+		t.Errorf("untracked files %q, want %q", untracked, want)
+	}
+	if len(modified) != 0 {
+		t.Errorf("modified files %q, want none", modified)
+	}
+}
+
+func TestStatusSkipsIgnoredFiles(t *testing.T) {
+	repo := newTestRepo(t)
+	commitTestFiles(t, repo, "first", map[string]string{
+		".gitignore":           "node_modules/\n*.log\n!keep.log\n",
+		"node_modules/tracked": "1\n",
+		"src/main.go":          "package main\n",
+	})
+	writeTestFile(t, "node_modules/tracked", "2\n")
+	writeTestFile(t, "node_modules/pkg/index.js", "\n")
+	writeTestFile(t, "src/debug.log", "\n")
+	writeTestFile(t, "src/keep.log", "\n")
+	writeTestFile(t, "src/new.go", "package main\n")
+
+	untracked, modified, _, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(untracked)
+	if want := []string{"src/keep.log", "src/new.go"}; !reflect.DeepEqual(untracked, want) {
+		t.Errorf("untracked files %q, want %q", untracked, want)
+	}
+	// Tracked files are reported even inside ignored directories
+	if want := []string{"node_modules/tracked"}; !reflect.DeepEqual(modified, want) {
+		t.Errorf("modified files %q, want %q", modified, want)
+	}
+}
+
+func BenchmarkStatusIgnoredTree(b *testing.B) {
+	dir := b.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, d := range []string{".git/objects", ".git/refs/heads"} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			b.Fatal(err)
+		}
+	}
+	files := map[string]string{".git/HEAD": "ref: refs/heads/master\n", ".gitignore": "node_modules/\n"}
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 100; j++ {
+			files[fmt.Sprintf("node_modules/pkg%d/file%d.js", i, j)] = "\n"
+		}
+	}
+	for path, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	repo, err := NewRepo(".git", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		untracked, _, _, err := repo.Status()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(untracked) != 1 {
+			b.Fatalf("untracked files %q, want only .gitignore", untracked)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package mgi
+
+import "fmt"
+
+// ObjectStore is where the objects of a repository are kept. ObjectService stores them on disk,
+// and MemoryObjectStore in memory.
+type ObjectStore interface {
+	// HashObject returns the hash the object has once stored, without storing it.
+	HashObject(m Marshaller) (*Hash, error)
+	// StoreObject stores the object and returns its hash.
+	StoreObject(m Marshaller) (*Hash, error)
+	// ReadObject returns the contents of the object, without the header.
+	ReadObject(hash *Hash) ([]byte, error)
+	// ReadTypedObject is like ReadObject, but it also returns the object type (e.g. "blob").
+	ReadTypedObject(hash *Hash) (string, []byte, error)
+	// Exists returns whether the object is in the store.
+	Exists(hash *Hash) (bool, error)
+	// Iterate calls fn with the hash of every loose object, stopping at the first error.
+	Iterate(fn func(hash string) error) error
+	// ResolvePrefix returns the only object whose hash starts with the prefix.
+	ResolvePrefix(prefix string) (*Hash, error)
+	// Abbrev returns the shortest unique prefix of the hash, with at least 7 characters.
+	Abbrev(hash *Hash) string
+	// PeelToCommit follows annotated tags from an object until it reaches a commit.
+	PeelToCommit(hash *Hash) (*Hash, error)
+	// PeelToTree follows annotated tags and commits from an object until it reaches a tree.
+	PeelToTree(hash *Hash) (*Hash, error)
+}
+
+// IndexStore holds the index of a working tree. IndexService keeps it in the index file, and
+// MemoryIndexStore in memory. Entries are changed in memory and only saved by Store.
+type IndexStore interface {
+	// Read loads the index and returns it.
+	Read() (*Index, error)
+	// Store saves the index.
+	Store() error
+	// Add adds the file at path with the given contents, taking its metadata from the file.
+	Add(path string, hash *Hash) error
+	// AddEntry adds the entry, replacing the existing entry for the same path, if any.
+	AddEntry(entry *IndexEntry)
+	// AddGitlink adds an entry recording that the nested repository at path has the commit checked out.
+	AddGitlink(path string, commit *Hash)
+	// AddIntentToAdd records that the file will be added later, without staging its contents.
+	AddIntentToAdd(path string) error
+	// Remove removes the entry for the path. It returns os.ErrNotExist if there is no such entry.
+	Remove(path string) error
+	// Rename moves the entry for oldPath to newPath. It returns os.ErrNotExist if there is no
+	// entry for oldPath.
+	Rename(oldPath, newPath string) error
+	// SetAssumeUnchanged sets or clears the assume-unchanged bit of the entry for the path.
+	SetAssumeUnchanged(path string, value bool) error
+	// Lock keeps other processes from changing the index until Unlock is called. Calls may be
+	// nested.
+	Lock() error
+	// Unlock releases the lock taken by Lock.
+	Unlock()
+}
+
+var (
+	_ ObjectStore = (*ObjectService)(nil)
+	_ ObjectStore = (*MemoryObjectStore)(nil)
+	_ IndexStore  = (*IndexService)(nil)
+	_ IndexStore  = (*MemoryIndexStore)(nil)
+)
+
+// diskObjects returns the object store as an ObjectService, for the operations that work on the
+// object files themselves, such as pruning.
+func (m *MGIService) diskObjects() (*ObjectService, error) {
+	o, ok := m.obj.(*ObjectService)
+	if !ok {
+		return nil, fmt.Errorf("the object store is not on disk")
+	}
+	return o, nil
+}
+
+// allObjects calls fn with the hash of every object in the store, loose or packed.
+func (m *MGIService) allObjects(fn func(hash *Hash) error) error {
+	o, ok := m.obj.(*ObjectService)
+	if !ok {
+		return m.obj.Iterate(func(hash string) error {
+			h, err := new(Hash).FromString(hash)
+			if err != nil {
+				return err
+			}
+			return fn(h)
+		})
+	}
+	err := o.looseObjects(func(hash *Hash, path string) error {
+		return fn(hash)
+	})
+	if err != nil {
+		return err
+	}
+	return o.packedObjects(fn)
+}
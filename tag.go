@@ -0,0 +1,116 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ListTags returns the names of the tags that match the glob pattern, if any, and pass the filter.
+func (m *MGIService) ListTags(pattern string, filter *RefFilter) ([]string, error) {
+	refs, err := m.listRefs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	refs, err = m.filterRefs(refs, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, r := range refs {
+		name := strings.TrimPrefix(r.Name, "refs/tags/")
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, name); !ok {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// CreateTag tags the target (HEAD if empty). With a message, an annotated tag object is created;
+// otherwise the tag is lightweight and points to the target directly. Existing tags are only
+// replaced if force is set.
+func (m *MGIService) CreateTag(name, target, message string, force bool) error {
+	ref := "refs/tags/" + name
+	if !validRefName(ref) {
+		return fmt.Errorf("%q is not a valid tag name", name)
+	}
+	old, err := m.readRef(ref)
+	if err == nil && !force {
+		return fmt.Errorf("tag %q already exists", name)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if target == "" {
+		target = "HEAD"
+	}
+	rev, err := m.resolveRevision(target)
+	if err != nil {
+		return err
+	}
+	hash, err := new(Hash).FromString(rev)
+	if err != nil {
+		return err
+	}
+	if message == "" {
+		return m.updateRef(ref, old, hash.String())
+	}
+
+	objType, _, err := m.obj.ReadTypedObject(hash)
+	if err != nil {
+		return err
+	}
+	tagger, email := identity()
+	tag, err := m.obj.StoreObject(&Tag{
+		Object:      hash.String(),
+		Type:        objType,
+		Name:        name,
+		Tagger:      tagger,
+		TaggerEmail: email,
+		TagTime:     time.Now(),
+		Message:     message,
+	})
+	if err != nil {
+		return err
+	}
+	return m.updateRef(ref, old, tag.String())
+}
+
+// DeleteTag removes the tag.
+func (m *MGIService) DeleteTag(name string) error {
+	err := os.Remove(m.gitPath("refs/tags/" + name))
+	if os.IsNotExist(err) {
+		if _, err := m.readPackedRef("refs/tags/" + name); err == nil {
+			return fmt.Errorf("tag %q is in packed-refs, which can't be edited yet", name)
+		}
+		return fmt.Errorf("tag %q not found", name)
+	}
+	return err
+}
+
+// validRefName checks the rules of git check-ref-format that matter for names typed by users.
+func validRefName(name string) bool {
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".lock") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,437 @@
+package mgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readTree reads and parses the tree with the given hash.
+func (m *MGIService) readTree(hash string) (*Tree, error) {
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return nil, err
+	}
+	objType, data, err := m.obj.ReadTypedObject(h)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "tree" {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", hash, objType)
+	}
+	return ParseTree(data)
+}
+
+// lookupPath returns the entry at the given slash-separated path under a tree, or a
+// *TreePathError, which satisfies errors.Is(err, os.ErrNotExist), if there is none.
+func (m *MGIService) lookupPath(tree, path string) (*TreeEntry, error) {
+	var entry *TreeEntry
+	names := strings.Split(path, "/")
+	for i, name := range names {
+		if entry != nil {
+			if entry.mode != modeDir {
+				return nil, &TreePathError{Path: path, Reason: strings.Join(names[:i], "/") + " is not a directory"}
+			}
+			tree = entry.hash.String()
+		}
+		t, err := m.readTree(tree)
+		if err != nil {
+			return nil, err
+		}
+		entry = nil
+		for _, e := range t.Entries {
+			if e.path == name {
+				entry = e
+				break
+			}
+		}
+		if entry == nil {
+			return nil, &TreePathError{Path: path}
+		}
+	}
+	return entry, nil
+}
+
+// TreePathError reports a path that cannot be found in a tree.
+type TreePathError struct {
+	Path   string
+	Reason string // why it doesn't, e.g. "a/b is not a directory", if not simply missing
+}
+
+func (e *TreePathError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("path %q does not exist", e.Path)
+	}
+	return fmt.Sprintf("path %q does not exist: %s", e.Path, e.Reason)
+}
+
+func (e *TreePathError) Is(target error) bool {
+	return target == os.ErrNotExist
+}
+
+// flattenTree returns the files under a tree, recursively, keyed by their path relative to the tree.
+func (m *MGIService) flattenTree(hash string) (map[string]*IndexEntry, error) {
+	files := make(map[string]*IndexEntry)
+	err := m.flattenSubTree(hash, "", files)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (m *MGIService) flattenSubTree(hash, prefix string, files map[string]*IndexEntry) error {
+	tree, err := m.readTree(hash)
+	if err != nil {
+		return err
+	}
+	for _, e := range tree.Entries {
+		path := prefix + e.path
+		if e.mode == modeDir {
+			err := m.flattenSubTree(e.hash.String(), path+"/", files)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		files[path] = &IndexEntry{Mode: e.mode, Hash: e.hash, Path: path}
+	}
+	return nil
+}
+
+// commitFiles returns the files of the tree of the given commit. Tags and trees are accepted too.
+func (m *MGIService) commitFiles(commit string) (map[string]*IndexEntry, error) {
+	h, err := new(Hash).FromString(commit)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := m.obj.PeelToTree(h)
+	if err != nil {
+		return nil, err
+	}
+	return m.flattenTree(tree.String())
+}
+
+// LsTree lists the entries of the tree named by rev, like git ls-tree: the mode, type and hash
+// of each entry, followed by a tab and its path. If recursive is set, the files in the
+// subdirectories are listed instead of the subdirectories themselves.
+func (m *MGIService) LsTree(rev string, recursive bool) ([]string, error) {
+	hash, err := m.resolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := m.peelRevision(hash, "tree")
+	if err != nil {
+		return nil, fmt.Errorf("revision %q: %v", rev, err)
+	}
+
+	var lines []string
+	var list func(tree, prefix string) error
+	list = func(tree, prefix string) error {
+		t, err := m.readTree(tree)
+		if err != nil {
+			return err
+		}
+		for _, e := range t.Entries {
+			if recursive && e.mode == modeDir {
+				err := list(e.hash.String(), prefix+e.path+"/")
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			objType := "blob"
+			switch e.mode {
+			case modeDir:
+				objType = "tree"
+			case modeGitlink:
+				objType = "commit"
+			}
+			lines = append(lines, fmt.Sprintf("%06o %s %s\t%s", e.mode, objType, e.hash, prefix+e.path))
+		}
+		return nil
+	}
+	return lines, list(tree, "")
+}
+
+// headFiles returns the files of the tree HEAD points to, or nothing if there are no commits yet.
+func (m *MGIService) headFiles() (map[string]*IndexEntry, error) {
+	head, err := m.currentHead()
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		return map[string]*IndexEntry{}, nil
+	}
+	return m.commitFiles(head)
+}
+
+// writeFilesTree stores a tree object for the given files, as writeTree does for the index.
+func (m *MGIService) writeFilesTree(files map[string]*IndexEntry) (string, error) {
+	entries := make([]*IndexEntry, 0, len(files))
+	for _, e := range files {
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		hash, err := m.obj.StoreObject(&Tree{})
+		if err != nil {
+			return "", err
+		}
+		return hash.String(), nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	hash, err := m.writeSubTree(".", entries)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// indexFiles returns the files in the index, keyed by their path.
+func (m *MGIService) indexFiles() (map[string]*IndexEntry, error) {
+	index, err := m.index.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file: %v", err)
+	}
+	files := make(map[string]*IndexEntry, len(index.Entries))
+	for _, e := range index.Entries {
+		files[e.Path] = &IndexEntry{Mode: e.Mode, Hash: e.Hash, Path: e.Path}
+	}
+	return files, nil
+}
+
+// workingFiles stores the working tree contents of the given files as blobs and returns them.
+// Files that were removed from the working tree are left out.
+func (m *MGIService) workingFiles(tracked map[string]*IndexEntry) (map[string]*IndexEntry, error) {
+	files := make(map[string]*IndexEntry, len(tracked))
+	for path, e := range tracked {
+		if e.Mode == modeGitlink {
+			if !isNestedRepo(path) {
+				continue
+			}
+			head, err := m.gitlinkHead(path)
+			if err != nil {
+				return nil, err
+			}
+			files[path] = &IndexEntry{Mode: e.Mode, Hash: head, Path: path}
+			continue
+		}
+
+		data, err := m.readWorkingFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hash, err := m.obj.StoreObject(&Blob{Data: data})
+		if err != nil {
+			return nil, err
+		}
+		files[path] = &IndexEntry{Mode: e.Mode, Hash: hash, Path: path}
+	}
+	return files, nil
+}
+
+// diffFiles renders a unified diff for each file that differs between two sets of files, with
+// the given options, or a line in opts.Format if it isn't DiffPatch. Blobs are read from the object store unless they are in blobs, which holds
+// contents that aren't stored yet (e.g. of working tree files), keyed by hash; it may be nil.
+func (m *MGIService) diffFiles(old, new map[string]*IndexEntry, opts DiffOptions, blobs map[string][]byte) ([]string, error) {
+	readBlob := func(e *IndexEntry) ([]byte, error) {
+		if e == nil {
+			return nil, nil
+		}
+		if e.Mode == modeGitlink {
+			return gitlinkContents(e.Hash), nil
+		}
+		if data, ok := blobs[e.Hash.String()]; ok {
+			return data, nil
+		}
+		return m.obj.ReadObject(e.Hash)
+	}
+
+	changes, err := changedFiles(old, new, opts.RenameThreshold, readBlob)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Format != DiffPatch {
+		lines := make([]string, 0, len(changes))
+		for _, c := range changes {
+			lines = append(lines, formatChange(c, opts.Format))
+		}
+		return lines, nil
+	}
+
+	var diffs []string
+	for _, c := range changes {
+		oldData, err := readBlob(c.old)
+		if err != nil {
+			return nil, err
+		}
+		newData, err := readBlob(c.new)
+		if err != nil {
+			return nil, err
+		}
+
+		render, err := m.diffRenderer(c.path, opts.Render)
+		if err != nil {
+			return nil, err
+		}
+		if c.rename != nil {
+			diffs = append(diffs, diffRename(c.rename, oldData, newData, render))
+			continue
+		}
+		if c.old != nil && oldData == nil {
+			oldData = []byte{}
+		}
+		if c.new != nil && newData == nil {
+			newData = []byte{}
+		}
+		var header string
+		switch {
+		case c.old == nil:
+			header = fmt.Sprintf("new file mode %06o\n", c.new.Mode)
+		case c.new == nil:
+			header = fmt.Sprintf("deleted file mode %06o\n", c.old.Mode)
+		case c.old.Mode != c.new.Mode:
+			header = fmt.Sprintf("old mode %06o\nnew mode %06o\n", c.old.Mode, c.new.Mode)
+		}
+		if d := diffContents(c.path, header, oldData, newData, render); d != "" {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+// diffContents renders a unified diff between two versions of a file with render, which is
+// usually diff.Unified, after the given extended header lines (e.g. "new file mode 100644").
+// A nil version means the file doesn't exist on that side. It returns an empty string if both
+// versions exist, there is no header and render finds no differences, e.g. because whitespace
+// is ignored.
+func diffContents(path, header string, old, new []byte, render func(oldLabel, newLabel string, a, b []byte) string) string {
+	oldLabel, newLabel := "a/"+path, "b/"+path
+	if old == nil {
+		oldLabel = "/dev/null"
+	}
+	if new == nil {
+		newLabel = "/dev/null"
+	}
+	rendered := render(oldLabel, newLabel, old, new)
+	if rendered == "" && header == "" && old != nil && new != nil {
+		return ""
+	}
+	// Binary patches have no context, so the old contents are identified by their full hash
+	if strings.HasPrefix(rendered, "GIT binary patch\n") {
+		header += fmt.Sprintf("index %s..%s\n", blobHash(old), blobHash(new))
+	}
+	return fmt.Sprintf("diff --git a/%s b/%s\n%s%s", path, path, header, rendered)
+}
+
+// blobHash returns the hash of a blob with the given contents, or the null hash if they are nil.
+func blobHash(data []byte) string {
+	if data == nil {
+		return strings.Repeat("0", 40)
+	}
+	obj, _ := (&Blob{Data: data}).Marshal()
+	return new(Hash).From(obj).String()
+}
+
+// checkoutFile writes the blob to the working tree at path, with the permissions given by mode.
+// The content filters are applied to regular files.
+func (m *MGIService) checkoutFile(path string, hash *Hash, mode uint32) error {
+	// Submodules are not cloned, only their directory is created
+	if mode == modeGitlink {
+		return os.MkdirAll(path, 0755)
+	}
+
+	var data []byte
+	var err error
+	if mode == 0120000 {
+		data, err = m.obj.ReadObject(hash)
+	} else {
+		data, err = m.smudgeBlob(path, hash)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Remove whatever is there first, so the mode (or a symlink) is not carried over
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if mode == 0120000 {
+		return os.Symlink(string(data), path)
+	}
+	perm := os.FileMode(0644)
+	if mode == 0100755 {
+		perm = 0755
+	}
+	// The attributes files read so far may be out of date now
+	if filepath.Base(path) == ".gitattributes" {
+		m.filter = nil
+	}
+	return ioutil.WriteFile(path, data, perm)
+}
+
+// removeFile removes a file from the working tree, along with any directories left empty.
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// resetIndex makes the index contain exactly the given files. The files must exist in the working tree.
+func (m *MGIService) resetIndex(files map[string]*IndexEntry) error {
+	index, err := m.index.Read()
+	if err != nil {
+		return fmt.Errorf("error reading index file: %v", err)
+	}
+
+	var stale []string
+	for _, e := range index.Entries {
+		if _, ok := files[e.Path]; !ok {
+			stale = append(stale, e.Path)
+		}
+	}
+	for _, path := range stale {
+		err := m.index.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for path, e := range files {
+		if e.Mode == modeGitlink {
+			m.index.AddGitlink(path, e.Hash)
+			continue
+		}
+		err := m.index.Add(path, e.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	return m.index.Store()
+}
+
+// subject returns the first line of a commit message.
+func subject(message string) string {
+	return strings.SplitN(message, "\n", 2)[0]
+}
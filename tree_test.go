@@ -0,0 +1,122 @@
+package mgi
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEmptyObjectHashes(t *testing.T) {
+	obj := NewObjectService(t.TempDir(), nil)
+	tests := []struct {
+		name string
+		obj  Marshaller
+		want string
+	}{
+		{"empty tree", &Tree{}, "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+		{"empty blob", &Blob{}, "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"},
+	}
+	for _, tt := range tests {
+		hash, err := obj.HashObject(tt.obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash.String() != tt.want {
+			t.Errorf("%s: hash %s, want %s", tt.name, hash, tt.want)
+		}
+	}
+}
+
+func TestWriteTreeMatchesGit(t *testing.T) {
+	repo := newTestRepo(t)
+	writeTestFile(t, "a", "hello\n")
+	writeTestFile(t, "dir/sub/x", "x\n")
+	writeTestFile(t, "run", "#!/bin/sh\n")
+	err := os.Chmod("run", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Add([]string{"a", "dir/sub/x", "run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hash git write-tree gives for the same files
+	const want = "6d3a3c958e398ff47a3d819bf469166662b26bc6"
+	got, err := repo.writeTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("writeTree() = %s, want %s", got, want)
+	}
+}
+
+func TestTreeEntriesSortedLikeGit(t *testing.T) {
+	repo := newTestRepo(t)
+	// git sorts directories as if their names ended with a slash, so "a" goes after "a.b"
+	// but before "a0"
+	writeTestFile(t, "a/f", "in a\n")
+	writeTestFile(t, "a.b", "a.b\n")
+	writeTestFile(t, "a0", "a0\n")
+	err := repo.Add([]string{"a/f", "a.b", "a0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hash git write-tree gives for the same files
+	const want = "ed2cc6d1fafde2f41b9bc3fd414505fa138ce5f5"
+	got, err := repo.writeTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("writeTree() = %s, want %s", got, want)
+	}
+	tree, err := repo.readTree(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range tree.Entries {
+		names = append(names, e.path)
+	}
+	if want := []string{"a.b", "a", "a0"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("tree entries %q, want %q", names, want)
+	}
+}
+
+func TestCommitLeavesOutIntentToAdd(t *testing.T) {
+	repo := newTestRepo(t)
+	commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	writeTestFile(t, "b", "not staged\n")
+	writeTestFile(t, "dir/c", "not staged either\n")
+	err := repo.AddIntentToAdd([]string{"b", "dir/c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := commitTestFiles(t, repo, "second", map[string]string{"a": "2\n"})
+
+	files, err := repo.commitFiles(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	if !reflect.DeepEqual(paths, []string{"a"}) {
+		t.Errorf("the commit has %q, want only a", paths)
+	}
+
+	// They are still intended to be added
+	_, _, intentToAdd, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(intentToAdd)
+	if want := []string{"b", "dir/c"}; !reflect.DeepEqual(intentToAdd, want) {
+		t.Errorf("files intended to be added %q, want %q", intentToAdd, want)
+	}
+}
@@ -0,0 +1,103 @@
+package mgi
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TrackingStatus compares the current branch with its upstream.
+type TrackingStatus struct {
+	// Upstream is the short name of the upstream branch, e.g. "origin/main".
+	Upstream string
+	// Gone is set if the upstream branch doesn't exist (anymore), in which case Ahead and Behind
+	// are 0.
+	Gone bool
+	// Ahead is the number of commits of the branch that the upstream doesn't have, and Behind
+	// the number of commits of the upstream that the branch doesn't have.
+	Ahead, Behind int
+}
+
+// TrackingStatus returns how the current branch compares with its upstream, which is set by
+// branch.<name>.remote and branch.<name>.merge in the configuration, like in git. The upstream of
+// a branch whose remote is "origin" and merges "refs/heads/main" is "refs/remotes/origin/main",
+// and with the remote ".", it is the local branch "refs/heads/main". It returns nil if HEAD is
+// detached or the branch has no upstream.
+func (m *MGIService) TrackingStatus() (*TrackingStatus, error) {
+	ref, err := m.headRef()
+	if err != nil || !strings.HasPrefix(ref, "refs/heads/") {
+		return nil, err
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+
+	config, err := NewConfigService(m.common).Read()
+	if err != nil {
+		return nil, err
+	}
+	remote, ok := config.Get("branch." + branch + ".remote")
+	if !ok {
+		return nil, nil
+	}
+	merge, ok := config.Get("branch." + branch + ".merge")
+	if !ok {
+		return nil, nil
+	}
+
+	name := strings.TrimPrefix(merge, "refs/heads/")
+	upstream, short := merge, name
+	if remote != "." {
+		upstream, short = "refs/remotes/"+remote+"/"+name, remote+"/"+name
+	}
+
+	status := &TrackingStatus{Upstream: short}
+	theirs, err := m.readRef(upstream)
+	if os.IsNotExist(err) {
+		status.Gone = true
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ours, err := m.currentHead()
+	if err != nil {
+		return nil, err
+	}
+	if ours == "" {
+		return status, nil
+	}
+
+	ahead, err := m.RevList([]string{theirs + ".." + ours}, -1)
+	if err != nil {
+		return nil, err
+	}
+	behind, err := m.RevList([]string{ours + ".." + theirs}, -1)
+	if err != nil {
+		return nil, err
+	}
+	status.Ahead, status.Behind = len(ahead), len(behind)
+	return status, nil
+}
+
+// Message describes the tracking status the way git status does.
+func (t *TrackingStatus) Message() string {
+	switch {
+	case t.Gone:
+		return fmt.Sprintf("Your branch is based on '%s', but the upstream is gone.", t.Upstream)
+	case t.Ahead > 0 && t.Behind > 0:
+		return fmt.Sprintf("Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.",
+			t.Upstream, t.Ahead, t.Behind)
+	case t.Ahead > 0:
+		return fmt.Sprintf("Your branch is ahead of '%s' by %s.", t.Upstream, commitCount(t.Ahead))
+	case t.Behind > 0:
+		return fmt.Sprintf("Your branch is behind '%s' by %s, and can be fast-forwarded.", t.Upstream, commitCount(t.Behind))
+	}
+	return fmt.Sprintf("Your branch is up to date with '%s'.", t.Upstream)
+}
+
+// commitCount returns "1 commit" or "<n> commits".
+func commitCount(n int) string {
+	if n == 1 {
+		return "1 commit"
+	}
+	return fmt.Sprintf("%d commits", n)
+}
@@ -0,0 +1,55 @@
+package mgi
+
+import "fmt"
+
+// VerifyIndex checks every entry of the index beyond what its digest guarantees: entries must be
+// sorted by path without duplicates, record the length of their path in their flags, have a
+// valid mode and refer to blobs that exist. It returns a description of each problem found,
+// prefixed with the path of the offending entry.
+func (m *MGIService) VerifyIndex() ([]string, error) {
+	index, err := m.index.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	report := func(e *IndexEntry, format string, args ...interface{}) {
+		problems = append(problems, e.Path+": "+fmt.Sprintf(format, args...))
+	}
+
+	for n, e := range index.Entries {
+		if n > 0 {
+			prev := index.Entries[n-1]
+			stage, prevStage := e.Flags&indexFlagStageMask, prev.Flags&indexFlagStageMask
+			switch {
+			case prev.Path > e.Path || (prev.Path == e.Path && prevStage > stage):
+				report(e, "out of order, after %q", prev.Path)
+			case prev.Path == e.Path && prevStage == stage:
+				report(e, "duplicate entry")
+			}
+		}
+
+		if got, want := e.Flags&indexFlagNameMask, nameFlags(e.Path); got != want {
+			report(e, "flags record a name length of %d, expected %d", got, want)
+		}
+
+		if !validFileMode(e.Mode) {
+			report(e, "invalid mode %o", e.Mode)
+			continue
+		}
+
+		// Submodule commits live in another repository, and intent-to-add entries have no
+		// contents yet
+		if e.Mode == modeGitlink || e.IntentToAdd() {
+			continue
+		}
+		ok, err := m.obj.Exists(e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			report(e, "missing blob %s", e.Hash)
+		}
+	}
+	return problems, nil
+}
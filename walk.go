@@ -0,0 +1,128 @@
+package mgi
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// errStopWalk can be returned by the walkCommits callback to end the walk early.
+var errStopWalk = errors.New("stop walk")
+
+// errSkipParents can be returned by the walkCommits callback to not walk past the commit.
+// Its parents are still visited if they can be reached from other commits.
+var errSkipParents = errors.New("skip parents")
+
+// readCommit reads and parses the commit with the given hash, through the commit graph so that
+// it is only parsed once.
+func (m *MGIService) readCommit(hash string) (*Commit, error) {
+	return m.CommitGraph().Commit(hash)
+}
+
+// peelCommit follows annotated tags until it finds the commit they point to.
+func (m *MGIService) peelCommit(hash string) (string, error) {
+	h, err := new(Hash).FromString(hash)
+	if err != nil {
+		return "", err
+	}
+	h, err = m.obj.PeelToCommit(h)
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+// walkCommits calls fn for each commit reachable from the start commits, in breadth-first order.
+// Each commit is visited only once. If fn returns errStopWalk the walk ends without an error.
+// The parents of shallow commits are not visited, since they are not expected to be present.
+// Parents missing from the object store are treated as the end of history as well.
+func (m *MGIService) walkCommits(start []string, fn func(hash string, c *Commit) error) error {
+	return m.walkNodes(start, func(n *commitNode) error {
+		c, err := m.readCommit(n.Hash)
+		if err != nil {
+			return err
+		}
+		return fn(n.Hash, c)
+	})
+}
+
+// walkNodes is like walkCommits, for the walks that only need the parents and dates of commits,
+// which are read from the commit-graph file when possible.
+func (m *MGIService) walkNodes(start []string, fn func(n *commitNode) error) error {
+	shallow, err := m.readShallow()
+	if err != nil {
+		return err
+	}
+
+	isStart := make(map[string]bool)
+	for _, hash := range start {
+		isStart[hash] = true
+	}
+
+	graph := m.CommitGraph()
+	visited := make(map[string]bool)
+	queue := append([]string(nil), start...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if visited[hash] {
+			continue
+		}
+		visited[hash] = true
+
+		n, err := graph.node(hash)
+		if errors.Is(err, ErrObjectNotFound) && !isStart[hash] {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = fn(n)
+		if errors.Is(err, errStopWalk) {
+			return nil
+		}
+		if errors.Is(err, errSkipParents) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !shallow[hash] {
+			queue = append(queue, n.Parents...)
+		}
+	}
+	return nil
+}
+
+// readShallow returns the set of commits listed in the shallow file. These commits are the
+// boundary of a shallow history: their parents were not fetched.
+func (m *MGIService) readShallow() (map[string]bool, error) {
+	contents, err := ioutil.ReadFile(m.gitPath("shallow"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	shallow := make(map[string]bool)
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			shallow[line] = true
+		}
+	}
+	return shallow, nil
+}
+
+// reachable returns the set of commits reachable from the start commits, including themselves.
+func (m *MGIService) reachable(start ...string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	err := m.walkNodes(start, func(n *commitNode) error {
+		set[n.Hash] = true
+		return nil
+	})
+	return set, err
+}
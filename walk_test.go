@@ -0,0 +1,44 @@
+package mgi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// removeTestObject deletes the loose object from the repository.
+func removeTestObject(t *testing.T, hash string) {
+	t.Helper()
+	err := os.Remove(filepath.Join(".git", "objects", hash[:2], hash[2:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkCommitsStopsAtMissingParent(t *testing.T) {
+	repo := newTestRepo(t)
+	first := commitTestFiles(t, repo, "first", map[string]string{"a": "1\n"})
+	second := commitTestFiles(t, repo, "second", map[string]string{"a": "2\n"})
+	third := commitTestFiles(t, repo, "third", map[string]string{"a": "3\n"})
+	removeTestObject(t, first)
+
+	var got []string
+	err := repo.walkCommits([]string{third}, func(hash string, c *Commit) error {
+		got = append(got, hash)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking past a missing parent: %v", err)
+	}
+	if want := []string{third, second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+
+	// Only parents may be missing
+	err = repo.walkCommits([]string{first}, func(hash string, c *Commit) error { return nil })
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("walking from a missing commit: got error %v, want ErrObjectNotFound", err)
+	}
+}
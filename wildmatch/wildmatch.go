@@ -0,0 +1,59 @@
+// Package wildmatch compiles the patterns of .gitignore and .gitattributes files, whose
+// wildcards follow different rules than the ones of pathspecs.
+package wildmatch
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compile translates a pattern into an anchored regular expression. Wildcards don't match
+// slashes, except in "**/" (any number of leading directories), "/**/" (any number of directories
+// in between) and "/**" (everything inside). A backslash escapes the next character.
+func Compile(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			atStart := i == 0 || pattern[i-1] == '/'
+			if atStart && strings.HasPrefix(pattern[i:], "**/") {
+				re.WriteString("(.*/)?")
+				i += 2
+				continue
+			}
+			if atStart && pattern[i:] == "**" {
+				re.WriteString(".*")
+				i++
+				continue
+			}
+			re.WriteString("[^/]*")
+			for i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
@@ -0,0 +1,112 @@
+package mgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// WorktreeAdd checks out a branch into a new working tree at path. The new working tree shares
+// the object store and refs of this repository: its ".git" is a file pointing to a per-worktree
+// directory under "worktrees/", which holds its own HEAD and index, like git does.
+func (m *MGIService) WorktreeAdd(path, branch string) error {
+	ref := "refs/heads/" + branch
+	commit, err := m.readRef(ref)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("invalid branch %q", branch)
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := m.headRef()
+	if err != nil {
+		return err
+	}
+	if current == ref {
+		return fmt.Errorf("branch %q is already checked out", branch)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	absRoot, err := filepath.Abs(m.common)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(absPath)
+	if err == nil && len(entries) > 0 {
+		return fmt.Errorf("%q already exists and is not empty", path)
+	}
+
+	name := filepath.Base(absPath)
+	gitDir := filepath.Join(absRoot, "worktrees", name)
+	if _, err := os.Stat(gitDir); err == nil {
+		return fmt.Errorf("worktree %q already exists", name)
+	}
+	err = os.MkdirAll(gitDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// Link the worktree and its git directory both ways
+	files := map[string]string{
+		filepath.Join(gitDir, "HEAD"):      "ref: " + ref + "\n",
+		filepath.Join(gitDir, "commondir"): "../..\n",
+		filepath.Join(gitDir, "gitdir"):    filepath.Join(absPath, ".git") + "\n",
+		filepath.Join(absPath, ".git"):     "gitdir: " + gitDir + "\n",
+	}
+	err = os.MkdirAll(absPath, 0755)
+	if err != nil {
+		return err
+	}
+	for file, contents := range files {
+		err := ioutil.WriteFile(file, []byte(contents), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Materialize the branch, reading objects from the shared store
+	tree, err := m.commitFiles(commit)
+	if err != nil {
+		return err
+	}
+	// Files are checked out with the attributes of the new working tree, so the attributes
+	// files go first
+	paths := make([]string, 0, len(tree))
+	for p := range tree {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		iAttrs := filepath.Base(paths[i]) == ".gitattributes"
+		jAttrs := filepath.Base(paths[j]) == ".gitattributes"
+		if iAttrs != jAttrs {
+			return iAttrs
+		}
+		return paths[i] < paths[j]
+	})
+	index := NewIndexService(gitDir, m.logger)
+	wt := NewMGIService(gitDir, m.obj, index, m.logger)
+	for _, p := range paths {
+		e := tree[p]
+		file := filepath.Join(absPath, p)
+		err := wt.checkoutFile(file, e.Hash, e.Mode)
+		if err != nil {
+			return err
+		}
+		if e.Mode == modeGitlink {
+			index.AddGitlink(p, e.Hash)
+			continue
+		}
+		err = index.AddFile(file, p, e.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	return index.Store()
+}